@@ -0,0 +1,91 @@
+package main
+
+import (
+	"math"
+	"strconv"
+
+	// d2
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2target"
+)
+
+// constants for the high-contrast mode
+const (
+	highContrastFontScale   = 1.25 // fonts are enlarged by this ratio
+	highContrastStrokeWidth = 3    // minimum width of strokes
+
+	highContrastForeground = "#000000"
+	highContrastBackground = "#FFFFFF"
+)
+
+// theme colors of the high-contrast mode: black strokes and texts on white fills and background, regardless of theme
+var highContrastOverrides = &d2target.ThemeOverrides{
+	N1:  toPointer(highContrastForeground),
+	N2:  toPointer(highContrastForeground),
+	N3:  toPointer(highContrastForeground),
+	N4:  toPointer(highContrastBackground),
+	N5:  toPointer(highContrastBackground),
+	N6:  toPointer(highContrastBackground),
+	N7:  toPointer(highContrastBackground),
+	B1:  toPointer(highContrastForeground),
+	B2:  toPointer(highContrastForeground),
+	B3:  toPointer(highContrastForeground),
+	B4:  toPointer(highContrastBackground),
+	B5:  toPointer(highContrastBackground),
+	B6:  toPointer(highContrastBackground),
+	AA2: toPointer(highContrastForeground),
+	AA4: toPointer(highContrastBackground),
+	AA5: toPointer(highContrastBackground),
+	AB4: toPointer(highContrastBackground),
+	AB5: toPointer(highContrastBackground),
+}
+
+// enlarges fonts of all shapes and connections in `graph` for the high-contrast mode
+//
+// NOTE: should be called before the graph is measured (ie. `SetDimensions`), so that the layout fits enlarged labels.
+func enlargeFonts(graph *d2graph.Graph) {
+	for _, obj := range graph.Objects {
+		size := obj.Text().FontSize
+		if obj.Class != nil || obj.SQLTable != nil {
+			size -= d2target.HeaderFontAdd // NOTE: added again to headers of classes and tables
+		}
+		obj.Style.FontSize = enlargedFontSize(size)
+	}
+	for _, edge := range graph.Edges {
+		edge.Style.FontSize = enlargedFontSize(edge.Text().FontSize)
+	}
+}
+
+// returns given font size enlarged for the high-contrast mode
+func enlargedFontSize(size int) *d2graph.Scalar {
+	return &d2graph.Scalar{Value: strconv.Itoa(int(math.Round(float64(size) * highContrastFontScale)))}
+}
+
+// applies high-contrast colors and thicker strokes to all shapes and connections in `diagram`,
+// overriding their own styles (which are resolved with `highContrastOverrides` when rendered)
+func applyHighContrast(diagram *d2target.Diagram) {
+	for i := range diagram.Shapes {
+		shape := &diagram.Shapes[i]
+
+		shape.Opacity = 1
+		shape.Color = "N1"
+		if shape.Type == d2target.ShapeText || shape.Type == d2target.ShapeImage {
+			continue
+		}
+		shape.Stroke = "N1"
+		shape.StrokeWidth = max(shape.StrokeWidth, highContrastStrokeWidth)
+
+		// NOTE: headers of classes and tables are filled with `N1` and labeled with `N7`, which are already contrasting
+		if shape.Type != d2target.ShapeClass && shape.Type != d2target.ShapeSQLTable && shape.Fill != "transparent" {
+			shape.Fill = "N7"
+		}
+	}
+	for i := range diagram.Connections {
+		conn := &diagram.Connections[i]
+
+		conn.Opacity = 1
+		conn.Color = "N1"
+		conn.Stroke = "N1"
+		conn.StrokeWidth = max(conn.StrokeWidth, highContrastStrokeWidth)
+	}
+}
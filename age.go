@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// constants for age
+const (
+	envAgePath         = "AGE_PATH"          // path of the age binary (= age in $PATH for default)
+	envAgeIdentityFile = "AGE_IDENTITY_FILE" // path of an age identity file (eg. ~/.config/age/key.txt)
+	envAgeSecretKey    = "AGE_SECRET_KEY"    // or an age secret key itself (eg. AGE-SECRET-KEY-1...)
+	defaultAgePath     = "age"
+
+	ageBinaryHeader  = "age-encryption.org/v1\n"
+	ageArmoredHeader = "-----BEGIN AGE ENCRYPTED FILE-----"
+)
+
+// checks if given bytes are of an age-encrypted file (binary or armored)
+func isAgeEncrypted(bs []byte) bool {
+	return bytes.HasPrefix(bs, []byte(ageBinaryHeader)) ||
+		bytes.HasPrefix(bytes.TrimSpace(bs), []byte(ageArmoredHeader))
+}
+
+// decrypts given age-encrypted bytes in memory with the age binary,
+// using an identity file at `AGE_IDENTITY_FILE` or a secret key in `AGE_SECRET_KEY`
+func decryptAge(bs []byte) ([]byte, error) {
+	agePath := os.Getenv(envAgePath)
+	if agePath == "" {
+		agePath = defaultAgePath
+	}
+
+	cmd := exec.Command(agePath, "--decrypt")
+	if identityFile := os.Getenv(envAgeIdentityFile); identityFile != "" {
+		cmd.Args = append(cmd.Args, "--identity", identityFile)
+	} else if secretKey := os.Getenv(envAgeSecretKey); secretKey != "" {
+		// NOTE: pass the secret key through a pipe, not to leave it on disk
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+
+		go func() {
+			defer w.Close()
+			_, _ = w.WriteString(strings.TrimSpace(secretKey) + "\n")
+		}()
+
+		cmd.ExtraFiles = []*os.File{r}
+		cmd.Args = append(cmd.Args, "--identity", "/dev/fd/3")
+	} else {
+		return nil, fmt.Errorf("config is encrypted with age, but neither %s nor %s is set", envAgeIdentityFile, envAgeSecretKey)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = bytes.NewReader(bs)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("failed to decrypt config with age: %s (%s)", err, msg)
+		}
+		return nil, fmt.Errorf("failed to decrypt config with age: %s", err)
+	}
+
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+
+	// d2
+	"oss.terrastruct.com/d2/d2parser"
+)
+
+// kinds of failures for alerts
+const (
+	failureRender  = "render"  // render failures (except errors in users' D2 sources)
+	failureSend    = "send"    // failures of sending rendered files to telegram
+	failureBrowser = "browser" // failures of the browser (for converting .svg files)
+)
+
+// constants for alerts
+const (
+	defaultAlertThreshold = 5
+	defaultAlertWindow    = 5 * 60  // 5 minutes
+	defaultAlertCooldown  = 30 * 60 // 30 minutes
+
+	messageAlert = "⚠️ %d %s failure(s) in the last %s (threshold: %d).\n\nLast error: %s"
+)
+
+// alert settings
+type alertsConfig struct {
+	ChatID    int64 `json:"chat_id"`             // id of the (admin) chat where alerts are sent
+	Threshold int   `json:"threshold,omitempty"` // number of failures of a kind in the window for an alert
+	Window    int   `json:"window,omitempty"`    // in seconds
+	Cooldown  int   `json:"cooldown,omitempty"`  // in seconds, between alerts of the same kind
+}
+
+// counts failures in a time window, and alerts the admin chat when they cross the threshold
+type failureAlerter struct {
+	sync.Mutex
+
+	bot  *tg.Bot
+	conf alertsConfig
+
+	failures  map[string][]time.Time // kind => times of failures in the window (oldest first)
+	alertedAt map[string]time.Time   // kind => time of the last alert
+}
+
+// alerter of failures (nil if alerts are not configured)
+var alerter *failureAlerter
+
+// returns a new alerter with given settings (or nil if `conf` is nil)
+func newFailureAlerter(bot *tg.Bot, conf *alertsConfig) *failureAlerter {
+	if conf == nil || conf.ChatID == 0 {
+		return nil
+	}
+
+	c := *conf
+	if c.Threshold <= 0 {
+		c.Threshold = defaultAlertThreshold
+	}
+	if c.Window <= 0 {
+		c.Window = defaultAlertWindow
+	}
+	if c.Cooldown <= 0 {
+		c.Cooldown = defaultAlertCooldown
+	}
+
+	return &failureAlerter{
+		bot:       bot,
+		conf:      c,
+		failures:  map[string][]time.Time{},
+		alertedAt: map[string]time.Time{},
+	}
+}
+
+// records a failure of `kind` with `err`, and sends an alert if the threshold is crossed (not in the cooldown)
+func (a *failureAlerter) record(kind string, err error) {
+	if a == nil || err == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+
+	now := time.Now()
+	window := time.Duration(a.conf.Window) * time.Second
+
+	a.Lock()
+	failures := append(a.failures[kind], now)
+	for len(failures) > 0 && now.Sub(failures[0]) >= window {
+		failures = failures[1:]
+	}
+	a.failures[kind] = failures
+
+	count := len(failures)
+	alert := count >= a.conf.Threshold && now.Sub(a.alertedAt[kind]) >= time.Duration(a.conf.Cooldown)*time.Second
+	if alert {
+		a.alertedAt[kind] = now
+	}
+	a.Unlock()
+
+	if alert {
+		text := fmt.Sprintf(messageAlert, count, kind, window, a.conf.Threshold, err)
+		if sent := a.bot.SendMessage(a.conf.ChatID, text, tg.OptionsSendMessage{}); !sent.Ok {
+			log.Printf("failed to send alert: %s", *sent.Description)
+		}
+	}
+}
+
+// checks if given render error is caused by the user's D2 source (not worth alerting)
+func isSourceError(err error) bool {
+	var parseErr *d2parser.ParseError
+	return errors.As(err, &parseErr)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// splitArgs splits given command arguments with whitespaces,
+// keeping double-quoted strings (eg. `key="some value"`) together.
+func splitArgs(args string) (tokens []string) {
+	var token strings.Builder
+	inQuotes, hasToken := false, false
+
+	for _, r := range args {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			hasToken = true
+		case unicode.IsSpace(r) && !inQuotes:
+			if hasToken {
+				tokens = append(tokens, token.String())
+				token.Reset()
+				hasToken = false
+			}
+		default:
+			token.WriteRune(r)
+			hasToken = true
+		}
+	}
+	if hasToken {
+		tokens = append(tokens, token.String())
+	}
+
+	return tokens
+}
+
+// parseKeyValues separates `key=value` pairs from other tokens.
+func parseKeyValues(tokens []string) (values map[string]string, others []string) {
+	values = map[string]string{}
+
+	for _, token := range tokens {
+		if key, value, found := strings.Cut(token, "="); found && key != "" {
+			values[key] = value
+		} else {
+			others = append(others, token)
+		}
+	}
+
+	return values, others
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// actions and outcomes in the audit log
+const (
+	auditActionRender = "render"
+
+	auditOutcomeHandled     = "handled"
+	auditOutcomeDenied      = "denied"
+	auditOutcomeRateLimited = "rate_limited"
+	auditOutcomeOutOfHours  = "out_of_hours"
+	auditOutcomePanicked    = "panicked"
+)
+
+// an entry of the audit log
+type auditEntry struct {
+	Timestamp  time.Time `json:"timestamp"`
+	RequestID  string    `json:"request_id,omitempty"`
+	UserID     int64     `json:"user_id,omitempty"`
+	Username   string    `json:"username,omitempty"`
+	ChatID     int64     `json:"chat_id,omitempty"`
+	Action     string    `json:"action"`
+	SourceHash string    `json:"source_hash,omitempty"` // sha256 of the rendered d2 source
+	Outcome    string    `json:"outcome"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// append-only audit log, written as JSON lines
+type auditLog struct {
+	sync.Mutex
+
+	file *os.File
+}
+
+// audit log of this bot (initialized on start, nil if not configured)
+var auditor *auditLog
+
+// openAuditLog opens (or creates) an audit log file at given `path` for appending.
+//
+// It returns nil without an error if `path` is empty.
+func openAuditLog(path string) (*auditLog, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log file '%s': %w", path, err)
+	}
+
+	return &auditLog{file: file}, nil
+}
+
+// appends given entry to the audit log (does nothing if the audit log is not configured)
+func (a *auditLog) write(entry auditEntry) {
+	if a == nil {
+		return
+	}
+
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+
+	bytes, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("failed to encode audit log entry: %s", err)
+		return
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	if _, err = a.file.Write(append(bytes, '\n')); err != nil {
+		log.Printf("failed to write audit log entry: %s", err)
+	}
+}
+
+// appends an entry of `action` for given update to the audit log
+func (a *auditLog) writeUpdate(ctx context.Context, update tg.Update, action, outcome string) {
+	entry := auditEntry{
+		RequestID: requestIDOf(ctx),
+		Action:    action,
+		Outcome:   outcome,
+	}
+	if from := update.GetFrom(); from != nil {
+		entry.UserID = from.ID
+		if from.Username != nil {
+			entry.Username = *from.Username
+		}
+	}
+	if message, _ := update.GetMessage(); message != nil {
+		entry.ChatID = message.Chat.ID
+	} else if update.HasCallbackQuery() && update.CallbackQuery.Message != nil {
+		entry.ChatID = update.CallbackQuery.Message.Chat.ID
+	}
+
+	a.write(entry)
+}
+
+// returns the hex-encoded sha256 hash of given d2 source
+func hashSource(source string) string {
+	hash := sha256.Sum256([]byte(source))
+	return hex.EncodeToString(hash[:])
+}
+
+// returns a middleware which writes an audit log entry for each update handled by `next`
+func auditMiddleware(router *updateRouter) middleware {
+	return func(next updateHandlerFunc) updateHandlerFunc {
+		if auditor == nil { // not configured
+			return next
+		}
+
+		return func(ctx context.Context, b *tg.Bot, update tg.Update) {
+			outcome := auditOutcomePanicked // NOTE: kept if `next` panics
+			defer func() {
+				auditor.writeUpdate(ctx, update, kindOfUpdate(router, update), outcome)
+			}()
+
+			next(ctx, b, update)
+
+			outcome = auditOutcomeHandled
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	// aws
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+)
+
+// constants for AWS
+const (
+	awsServiceSecretsManager = "secretsmanager"
+	awsServiceSSM            = "ssm"
+
+	awsRequestTimeout = 10 * time.Second
+)
+
+// AWS settings (credentials are loaded with the standard AWS credential chain: env, shared files, ECS/EC2 roles, ...)
+type awsConfig struct {
+	Region string `json:"region,omitempty"` // (= region of the standard AWS config for default)
+
+	BotTokenSecretID  string `json:"bot_token_secret_id,omitempty"` // id of a Secrets Manager secret (eg. `telegram/d2-bot#BOT_TOKEN` for a key in a JSON secret)
+	BotTokenParameter string `json:"bot_token_parameter,omitempty"` // or name of a SSM parameter (eg. `/telegram/d2-bot/token`)
+}
+
+// a session with AWS, which signs requests with credentials from the standard credential chain
+type awsSession struct {
+	sync.Mutex
+
+	conf   awsConfig
+	client *http.Client
+
+	cfg *aws.Config // loaded lazily
+}
+
+// cached sessions with AWS (region => session)
+var awsSessions = struct {
+	sync.Mutex
+	sessions map[string]*awsSession
+}{
+	sessions: map[string]*awsSession{},
+}
+
+// returns a cached session for given AWS settings (or a new one if there is none)
+func awsSessionFor(conf awsConfig) *awsSession {
+	awsSessions.Lock()
+	defer awsSessions.Unlock()
+
+	if session, exists := awsSessions.sessions[conf.Region]; exists {
+		return session
+	}
+
+	session := &awsSession{
+		conf:   conf,
+		client: &http.Client{Timeout: awsRequestTimeout},
+	}
+	awsSessions.sessions[conf.Region] = session
+
+	return session
+}
+
+// loads the standard AWS config (should be called while locked)
+func (s *awsSession) config(ctx context.Context) (aws.Config, error) {
+	if s.cfg != nil {
+		return *s.cfg, nil
+	}
+
+	opts := []func(*awsconfig.LoadOptions) error{}
+	if s.conf.Region != "" {
+		opts = append(opts, awsconfig.WithRegion(s.conf.Region))
+	}
+
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("failed to load AWS config: %s", err)
+	}
+	if cfg.Region == "" {
+		return aws.Config{}, fmt.Errorf("AWS region is not configured")
+	}
+	s.cfg = &cfg
+
+	return cfg, nil
+}
+
+// calls `target` (eg. `secretsmanager.GetSecretValue`) of an AWS JSON api `service` with `body`, and decodes its response into `result`
+func (s *awsSession) call(service, target string, body, result any) error {
+	s.Lock()
+	defer s.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), awsRequestTimeout)
+	defer cancel()
+
+	cfg, err := s.config(ctx)
+	if err != nil {
+		return err
+	}
+
+	var creds aws.Credentials
+	if creds, err = cfg.Credentials.Retrieve(ctx); err != nil {
+		return fmt.Errorf("failed to retrieve AWS credentials: %s", err)
+	}
+
+	var bs []byte
+	if bs, err = json.Marshal(body); err != nil {
+		return err
+	}
+
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("https://%s.%s.amazonaws.com/", service, cfg.Region), bytes.NewReader(bs)); err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", target)
+
+	hash := sha256.Sum256(bs)
+	if err = v4.NewSigner().SignHTTP(ctx, creds, req, hex.EncodeToString(hash[:]), service, cfg.Region, time.Now()); err != nil {
+		return fmt.Errorf("failed to sign AWS request: %s", err)
+	}
+
+	var res *http.Response
+	if res, err = s.client.Do(req); err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		var e struct {
+			Type         string `json:"__type"`
+			Message      string `json:"message"`
+			MessageUpper string `json:"Message"`
+		}
+		_ = json.NewDecoder(res.Body).Decode(&e)
+		return fmt.Errorf("%s failed with %d: %s %s%s", target, res.StatusCode, e.Type, e.Message, e.MessageUpper)
+	}
+
+	return json.NewDecoder(res.Body).Decode(result)
+}
+
+// retrieves the value of a Secrets Manager secret with `keyPath`
+// (eg. `secret-id`, or `secret-id#KEY` for a key in a JSON secret)
+func (s *awsSession) retrieveSecret(keyPath string) (string, error) {
+	secretID, key, hasKey := strings.Cut(keyPath, "#")
+
+	var res struct {
+		SecretString *string `json:"SecretString"`
+	}
+	if err := s.call(awsServiceSecretsManager, "secretsmanager.GetSecretValue", map[string]string{
+		"SecretId": secretID,
+	}, &res); err != nil {
+		return "", fmt.Errorf("failed to get secret '%s' from AWS Secrets Manager: %s", secretID, err)
+	}
+	if res.SecretString == nil {
+		return "", fmt.Errorf("secret '%s' from AWS Secrets Manager is not a string", secretID)
+	}
+	if !hasKey {
+		return *res.SecretString, nil
+	}
+
+	var values map[string]any
+	if err := json.Unmarshal([]byte(*res.SecretString), &values); err != nil {
+		return "", fmt.Errorf("secret '%s' from AWS Secrets Manager is not a JSON object: %s", secretID, err)
+	}
+	switch v := values[key].(type) {
+	case string:
+		return v, nil
+	case nil:
+		return "", fmt.Errorf("there is no key '%s' in secret '%s' from AWS Secrets Manager", key, secretID)
+	default:
+		return fmt.Sprintf("%v", v), nil
+	}
+}
+
+// retrieves the (decrypted) value of a SSM parameter with `name`
+func (s *awsSession) retrieveParameter(name string) (string, error) {
+	var res struct {
+		Parameter struct {
+			Value string `json:"Value"`
+		} `json:"Parameter"`
+	}
+	if err := s.call(awsServiceSSM, "AmazonSSM.GetParameter", map[string]any{
+		"Name":           name,
+		"WithDecryption": true,
+	}, &res); err != nil {
+		return "", fmt.Errorf("failed to get parameter '%s' from AWS SSM: %s", name, err)
+	}
+
+	return res.Parameter.Value, nil
+}
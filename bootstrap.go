@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// constants for bootstrapping without a config file
+const (
+	envBotToken   = "BOT_TOKEN"
+	envAllowedIDs = "ALLOWED_IDS" // comma-separated telegram usernames
+)
+
+// bot token piped via stdin (read once, as stdin cannot be read again on reloads)
+var stdinBotToken string
+
+// checks if the bot can be bootstrapped without a config file
+// (with `BOT_TOKEN` in environment, a systemd credential, or a token piped via stdin)
+func canBootstrap() bool {
+	if os.Getenv(envBotToken) != "" || secretProviders[secretProviderCredential].botTokenKey(config{}) != "" {
+		return true
+	}
+
+	if stat, err := os.Stdin.Stat(); err == nil && stat.Mode()&os.ModeCharDevice == 0 {
+		if line, err := bufio.NewReader(os.Stdin).ReadString('\n'); err == nil || line != "" {
+			stdinBotToken = strings.TrimSpace(line)
+		}
+	}
+	return stdinBotToken != ""
+}
+
+// returns a config with given bot token and allowed ids from environment, and defaults for all other settings
+func configFromEnv() (conf config, err error) {
+	conf.BotToken = os.Getenv(envBotToken)
+	if conf.BotToken == "" {
+		if name := secretProviders[secretProviderCredential].botTokenKey(conf); name != "" {
+			if conf.BotToken, err = retrieveCredential(name); err != nil {
+				return config{}, err
+			}
+		}
+	}
+	if conf.BotToken == "" {
+		conf.BotToken = stdinBotToken
+	}
+	if conf.BotToken == "" {
+		return config{}, fmt.Errorf("no bot token in $%s, systemd credentials, or stdin", envBotToken)
+	}
+
+	for _, id := range strings.Split(os.Getenv(envAllowedIDs), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			conf.AllowedIDs = append(conf.AllowedIDs, id)
+		}
+	}
+
+	return conf, nil
+}
@@ -2,336 +2,922 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"syscall"
+	"time"
 
 	// telegram bot
 	tg "github.com/meinside/telegram-bot-go"
 
 	// version string
 	"github.com/meinside/version-go"
-
-	// infisical
-	infisical "github.com/infisical/go-sdk"
-	"github.com/infisical/go-sdk/packages/models"
-
-	// d2
-	"oss.terrastruct.com/d2/d2compiler"
-	"oss.terrastruct.com/d2/d2exporter"
-	"oss.terrastruct.com/d2/d2graph"
-	"oss.terrastruct.com/d2/d2layouts/d2dagrelayout"
-	"oss.terrastruct.com/d2/d2renderers/d2svg"
-	"oss.terrastruct.com/d2/d2target"
-	"oss.terrastruct.com/d2/lib/png"
-	"oss.terrastruct.com/d2/lib/textmeasure"
-
-	// others
-	"github.com/tailscale/hujson"
 )
 
 // constants
 const (
 	defaultPollingInterval = 5
 
+	allowedIDEveryone = "*" // in `allowed_ids`, for allowing everyone
+
 	commandStart   = "/start"
 	commandHelp    = "/help"
 	commandPrivacy = "/privacy"
+	commandCancel  = "/cancel"
 
-	messageHelp = `This is a [Telegram Bot](https://github\.com/meinside/telegram\-d2\-bot) which replies to your messages with [D2](https://github\.com/terrastruct/d2)\-generated \.svg files in \.png format\.
+	messageHelp = `This is a [Telegram Bot](https://github\.com/meinside/telegram\-d2\-bot) which replies to your messages with [D2](https://github\.com/terrastruct/d2)\-generated \.svg files in \.png format \(or \.svg, \.pdf with /format\)\.
 `
 	messagePrivacy           = `[Privacy Policy](https://github\.com/meinside/telegram\-d2\-bot/raw/master/PRIVACY\.md)`
 	messageNotSupported      = "This type of message is not supported (yet)."
 	messageNoMatchingCommand = "Not a supported command: %s"
+	messageCanceled          = "Canceled %d render(s) in progress."
+	messageNothingToCancel   = "There is no render in progress."
+	messageSessionEnded      = "Ended the session in progress."
+	messageNoSource          = "No D2 source was found in the message."
+	messageTooManyDiagrams   = "Too many diagrams in the message: %d (max: %d)"
 
 	renderPadding int64 = 40
+
+	defaultMaxDiagramsPerMessage = 20
+
+	maxAlbumSize           = 10   // max number of items in a media group
+	maxSourceMessageLength = 4000 // max length of a source to be sent as a message (4096 - some room for formatting)
 )
 
-// struct for configuration
-type config struct {
-	// configurations
-	AllowedIDs      []string `json:"allowed_ids"`
-	MonitorInterval int      `json:"monitor_interval"`
-
-	// d2 rendering style
-	ThemeID int64 `json:"theme_id,omitempty"` // NOTE: pick `ID` from https://github.com/terrastruct/d2/tree/master/d2themes/d2themescatalog
-	Sketch  bool  `json:"sketch,omitempty"`
-
-	// logging
-	IsVerbose bool `json:"is_verbose,omitempty"`
-
-	// Bot API token
-	BotToken string `json:"bot_token,omitempty"`
-
-	// or Infisical settings
-	Infisical *struct {
-		ClientID     string `json:"client_id"`
-		ClientSecret string `json:"client_secret"`
-
-		ProjectID   string `json:"project_id"`
-		Environment string `json:"environment"`
-		SecretType  string `json:"secret_type"`
-
-		BotTokenKeyPath string `json:"bot_token_key_path"`
-	} `json:"infisical,omitempty"`
-}
-
-// read config file
-func loadConfig(filepath string) (conf config, err error) {
-	var bytes []byte
-	if bytes, err = os.ReadFile(filepath); err == nil {
-		if bytes, err = standardizeJSON(bytes); err == nil {
-			if err = json.Unmarshal(bytes, &conf); err == nil {
-				if conf.BotToken == "" && conf.Infisical != nil {
-					// read bot token from infisical
-					client := infisical.NewInfisicalClient(context.TODO(), infisical.Config{
-						SiteUrl: "https://app.infisical.com",
-					})
-
-					_, err = client.Auth().UniversalAuthLogin(conf.Infisical.ClientID, conf.Infisical.ClientSecret)
-					if err != nil {
-						return config{}, fmt.Errorf("failed to authenticate with Infisical: %s", err)
-					}
+// convert any value to a pointer
+func toPointer[T any](v T) *T {
+	val := v
+	return &val
+}
 
-					keyPath := conf.Infisical.BotTokenKeyPath
-
-					var secret models.Secret
-					secret, err = client.Secrets().Retrieve(infisical.RetrieveSecretOptions{
-						ProjectID:   conf.Infisical.ProjectID,
-						Type:        conf.Infisical.SecretType,
-						Environment: conf.Infisical.Environment,
-						SecretPath:  path.Dir(keyPath),
-						SecretKey:   path.Base(keyPath),
-					})
-					if err != nil {
-						return config{}, fmt.Errorf("failed to retrieve telegram bot token from Infisical: %s", err)
-					}
+// checks if given username matches an entry of `allowed_ids`
+// (a username, a wildcard pattern like `*_mycompany`, or a regular expression like `/^dev_.+$/`)
+func matchesAllowedID(allowedID, username string) bool {
+	if len(allowedID) > 2 && strings.HasPrefix(allowedID, "/") && strings.HasSuffix(allowedID, "/") {
+		if re, err := regexp.Compile(allowedID[1 : len(allowedID)-1]); err == nil {
+			return re.MatchString(username)
+		} else {
+			log.Printf("invalid regular expression in allowed_ids: %s", err)
+			return false
+		}
+	}
+	if strings.ContainsAny(allowedID, "*?[") {
+		matched, _ := path.Match(allowedID, username)
+		return matched
+	}
+
+	return allowedID == username
+}
+
+// constants for replies
+const (
+	messageRenderWarnings = "Rendered with warnings (the source may not be compatible with future versions of d2):\n\n⚠️ %s"
+
+	maxCaptionSourceLength = 900 // max length of a source in a caption (1024 - some room for numbering and ellipsis)
+)
+
+// returns a caption (in HTML) of a rendered file, with an optional `prefix` (eg. `1/3`), `note`,
+// and its d2 `source` (truncated if it is too long) in an expandable blockquote
+//
+// (returns nil if all of them are empty)
+func renderedCaption(prefix, note, source string) *string {
+	lines := []string{}
+	if prefix != "" {
+		lines = append(lines, html.EscapeString(prefix))
+	}
+	if note != "" {
+		lines = append(lines, html.EscapeString(note))
+	}
+	if source = strings.TrimSpace(source); source != "" {
+		if runes := []rune(source); len(runes) > maxCaptionSourceLength {
+			source = string(runes[:maxCaptionSourceLength]) + "…"
+		}
+		lines = append(lines, fmt.Sprintf("<blockquote expandable>%s</blockquote>", html.EscapeString(source)))
+	}
+
+	if len(lines) == 0 {
+		return nil
+	}
+	return toPointer(strings.Join(lines, "\n"))
+}
+
+// renders given `sources` with the options of `userID` and reply to `messageId` with them.
+func replyRendered(ctx context.Context, bot *tg.Bot, conf config, userID, chatID, messageID int64, sources []string) {
+	replyRenderedWithOptions(ctx, bot, conf, userID, chatID, messageID, sources, renderOptionsForChat(conf, userID, chatID), "")
+}
+
+// renders given `sources` with `opts` and reply to `messageId` with them.
+//
+// Multiple sources are sent together as a numbered album.
+// The render can be aborted with /cancel command by the user with `userID`.
+// If `quotable` (the text of the message with `messageID`) is given, locations of compile errors are quoted from it.
+func replyRenderedWithOptions(ctx context.Context, bot *tg.Bot, conf config, userID, chatID, messageID int64, sources []string, opts renderOptions, quotable string) {
+	finish, admitted := admitRenderJob(ctx, bot, conf, journaledJob{
+		UserID:    userID,
+		ChatID:    chatID,
+		MessageID: messageID,
+		Sources:   sources,
+		Options:   opts,
+		Quotable:  quotable,
+		QueuedAt:  time.Now(),
+	})
+	if !admitted {
+		return
+	}
+	defer finish()
+
+	ctx, done := jobs.start(ctx, userID)
+	defer done()
+
+	// typing...
+	_ = bot.SendChatAction(chatID, tg.ChatActionTyping, nil)
+
+	// report progress of long renders
+	progress := startProgress(ctx, bot, conf, chatID, messageID)
+
+	// echo sources in captions of rendered files (if the user set so)
+	var captioned []string
+	if storage.loadSettings(userID).SourceCaption {
+		captioned = sources
+	}
+
+	// render sources into .svg and convert them
+	results := newRenderResults()
+	for i, source := range sources {
+		if err := renderSourceOfMessage(ctx, bot, conf, chatID, messageID, sources, i, opts, captioned, progress, results); errors.Is(err, context.Canceled) {
+			if conf.IsVerbose {
+				logf(ctx, "render was canceled by user: %d", userID)
+			}
+
+			progress.finish()
+			recordHistory(ctx, userID, chatID, sources, nil, results.failed)
+			return
+		} else if err != nil {
+			logf(ctx, "failed to render message: %s", err)
+
+			if !isSourceError(err) {
+				alerter.record(failureRender, err)
+			}
+
+			results.failed[i] = err
+			if len(sources) > 1 {
+				results.errs = append(results.errs, fmt.Sprintf("#%d: %s", i+1, explainError(source, err)))
+			} else {
+				results.errs = append(results.errs, explainError(source, err))
+			}
+		}
+	}
+	progress.finish()
+
+	deliverRendered(ctx, bot, chatID, messageID, sources, opts.Format, captioned, results)
+
+	recordHistory(ctx, userID, chatID, sources, results.sent, results.failed)
 
-					conf.BotToken = secret.SecretValue
+	if len(results.sent) > 0 && len(results.warnings) > 0 {
+		replyText(bot, chatID, messageID, fmt.Sprintf(messageRenderWarnings, strings.Join(results.warnings, "\n⚠️ ")))
+	}
+
+	if len(results.errs) > 0 {
+		text := fmt.Sprintf("Failed to render message: %s", strings.Join(results.errs, "\n"))
+
+		// quote the location of the first compile error, if possible
+		for i, source := range sources {
+			if err, exists := results.failed[i]; exists && quotable != "" {
+				if quote, position, ok := errorQuote(quotable, source, err); ok {
+					replyErrorQuoting(bot, chatID, messageID, text, quote, position)
+					return
 				}
 			}
 		}
+		replyError(bot, chatID, messageID, text)
 	}
+}
+
+// results of rendering sources of a message (keyed by indices of the sources)
+type renderResults struct {
+	notes     map[int]string
+	rendered  map[int][]byte
+	fallbacks map[int]outputFormat // renders in other formats than the requested one, for fitting in upload limits
+	sent      map[int]int64        // ids of sent messages
+	failed    map[int]error
 
-	return conf, err
+	errs     []string
+	warnings []string
+}
+
+// returns new (empty) render results
+func newRenderResults() *renderResults {
+	return &renderResults{
+		notes:     map[int]string{},
+		rendered:  map[int][]byte{},
+		fallbacks: map[int]outputFormat{},
+		sent:      map[int]int64{},
+		failed:    map[int]error{},
+	}
+}
+
+// checks if the render `job` can be started now, and journals it until returned `finish` is called.
+//
+// Jobs with too many diagrams are refused, and ones out of access hours are refused (or deferred).
+func admitRenderJob(ctx context.Context, bot *tg.Bot, conf config, job journaledJob) (finish func(), admitted bool) {
+	maxDiagrams := conf.MaxDiagramsPerMessage
+	if maxDiagrams <= 0 {
+		maxDiagrams = defaultMaxDiagramsPerMessage
+	}
+	if len(job.Sources) > maxDiagrams {
+		replyError(bot, job.ChatID, job.MessageID, fmt.Sprintf(messageTooManyDiagrams, len(job.Sources), maxDiagrams))
+		return nil, false
+	}
+
+	// refuse (or defer) renders out of access hours
+	if at, deferrable, out := outOfAccessHours(ctx); out {
+		refuseOrDeferRender(ctx, bot, conf, job, at, deferrable)
+		return nil, false
+	}
+
+	// journal the job until it is finished, so that it can be resumed after a crash or a restart
+	journalID := newJournalID(job.ChatID, job.MessageID)
+	if err := jobsJournal.journal(journalID, job); err != nil {
+		logf(ctx, "failed to journal render job: %s", err)
+	}
+	return func() {
+		if err := jobsJournal.finish(journalID); err != nil {
+			logf(ctx, "failed to finish journaled render job: %s", err)
+		}
+	}, true
 }
 
-// standardize given JSON (JWCC) bytes
-func standardizeJSON(b []byte) ([]byte, error) {
-	ast, err := hujson.Parse(b)
+// renders the source at index `i` of `sources` (retrying transient failures),
+// and puts the render (or the id of its already sent preview) with its notes and warnings in `results`
+func renderSourceOfMessage(ctx context.Context, bot *tg.Bot, conf config, chatID, messageID int64, sources []string, i int, opts renderOptions, captioned []string, progress *progressReporter, results *renderResults) error {
+	source := sources[i]
+
+	report := progress.report
+	if len(sources) > 1 {
+		report = func(stage string) {
+			progress.report(fmt.Sprintf("%s (%d/%d)", stage, i+1, len(sources)))
+		}
+	}
+
+	// choose the orientation of the diagram (if needed)
+	sourceOpts := opts
+	if direction, chosen := autoOrientation(ctx, conf, source, opts); chosen {
+		sourceOpts.Direction = direction
+		results.notes[i] = fmt.Sprintf(messageOrientationChosen, direction)
+	}
+
+	// host the interactive .svg file on the web viewer (if configured)
+	if link, err := viewer.host(ctx, source, sourceOpts); err == nil && link != "" {
+		results.notes[i] = strings.TrimSpace(results.notes[i] + "\n" + fmt.Sprintf(messageViewerLink, link))
+	}
+
+	// retry transient failures (eg. crashes of the browser), showing the status message while retrying
+	retrying := func(retry, max int) {
+		report(fmt.Sprintf(stageRetrying, retry, max))
+		progress.show()
+	}
+
+	var bs []byte
+	var warned []string
+	err := retries.do(ctx, retrying, func() (err error) {
+		if shouldPreview(conf, sources, sourceOpts) {
+			// send a quick preview first, and replace it with the full-quality render
+			var previewID int64
+			var echoed string
+			if captioned != nil {
+				echoed = source
+			}
+			if bs, previewID, warned, err = renderWithPreview(ctx, bot, chatID, messageID, source, sourceOpts, renderedCaption("", results.notes[i], echoed), report); err == nil && previewID != 0 {
+				results.sent[i] = previewID
+			}
+		} else {
+			bs, warned, err = renderDiagram(ctx, source, sourceOpts, report)
+		}
+		return err
+	})
+
+	// fit the render in upload limits of telegram
+	if _, alreadySent := results.sent[i]; err == nil && !alreadySent {
+		var format outputFormat
+		var note string
+		if bs, format, note, err = fitUploadLimits(ctx, source, sourceOpts, bs); err == nil && note != "" {
+			results.notes[i] = strings.TrimSpace(results.notes[i] + "\n" + note)
+			if format != opts.Format {
+				results.fallbacks[i] = format
+			}
+		}
+	}
+
+	// note which layout engine produced the render, if it fell back to another one
+	var layoutNotes []string
+	if layoutNotes, warned = splitLayoutFallbackNotes(warned); len(layoutNotes) > 0 {
+		results.notes[i] = strings.TrimSpace(results.notes[i] + "\n" + strings.Join(layoutNotes, "\n"))
+	}
+
 	if err != nil {
-		return b, err
+		return err
 	}
-	ast.Standardize()
 
-	return ast.Pack(), nil
+	if _, alreadySent := results.sent[i]; !alreadySent {
+		results.rendered[i] = bs
+	}
+	for _, warning := range warned {
+		if len(sources) > 1 {
+			results.warnings = append(results.warnings, fmt.Sprintf("#%d: %s", i+1, warning))
+		} else {
+			results.warnings = append(results.warnings, warning)
+		}
+	}
+
+	return nil
 }
 
-// convert any value to a pointer
-func toPointer[T any](v T) *T {
-	val := v
-	return &val
+// sends rendered files in `results` (in `format`, or their fallback formats) as replies to `messageID`,
+// remembers sources of the sent ones, and reacts to `messageID` if all of them were sent
+func deliverRendered(ctx context.Context, bot *tg.Bot, chatID, messageID int64, sources []string, format outputFormat, captioned []string, results *renderResults) {
+	if len(results.rendered) > 0 {
+		// send renders in other formats one by one
+		for i, fallback := range results.fallbacks {
+			var prefix, echoed string
+			if len(sources) > 1 {
+				prefix = fmt.Sprintf("%d/%d", i+1, len(sources))
+			}
+			if captioned != nil {
+				echoed = captioned[i]
+			}
+			if sentID, ok := sendRenderedFile(ctx, bot, chatID, messageID, results.rendered[i], fallback, renderedCaption(prefix, results.notes[i], echoed)); ok {
+				results.sent[i] = sentID
+			}
+		}
+
+		others := map[int][]byte{}
+		for i, bs := range results.rendered {
+			if _, exists := results.fallbacks[i]; !exists {
+				others[i] = bs
+			}
+		}
+		if len(others) > 0 {
+			for i, sentID := range sendRendered(ctx, bot, chatID, messageID, others, len(sources), format, results.notes, captioned) {
+				results.sent[i] = sentID
+			}
+		}
+		for i := range results.rendered {
+			if _, exists := results.sent[i]; !exists {
+				results.failed[i] = fmt.Errorf("failed to send rendered file")
+
+				alerter.record(failureSend, results.failed[i])
+			}
+		}
+	}
+	if len(results.sent) > 0 {
+		// remember sources of sent messages, so that they can be re-rendered later
+		renders := map[int64]string{}
+		for i, sentID := range results.sent {
+			renders[sentID] = sources[i]
+		}
+		if err := storage.saveRenders(chatID, messageID, renders); err != nil {
+			logf(ctx, "failed to save sources of renders: %s", err)
+		}
+
+		if len(results.failed) == 0 {
+			if reactioned := bot.SetMessageReaction(chatID, messageID, tg.NewMessageReactionWithEmoji("👌")); !reactioned.Ok {
+				logf(ctx, "failed to set reaction: %s", *reactioned.Description)
+			}
+		}
+	}
+}
+
+// records render requests of `sources` in `chatID` to the history of `userID` and the audit log:
+// ones in `sent` succeeded, ones in `failed` failed, and the others were canceled.
+func recordHistory(ctx context.Context, userID, chatID int64, sources []string, sent map[int]int64, failed map[int]error) {
+	now := time.Now()
+	entries := []historyEntry{}
+	for i, source := range sources {
+		entry := historyEntry{
+			Source:      source,
+			Status:      historyStatusCanceled,
+			RequestedAt: now,
+		}
+		if _, exists := sent[i]; exists {
+			entry.Status = historyStatusSucceeded
+		} else if err, exists := failed[i]; exists {
+			entry.Status = historyStatusFailed
+			entry.Error = err.Error()
+		}
+		entries = append(entries, entry)
+
+		auditor.write(auditEntry{
+			Timestamp:  now,
+			RequestID:  requestIDOf(ctx),
+			UserID:     userID,
+			ChatID:     chatID,
+			Action:     auditActionRender,
+			SourceHash: hashSource(source),
+			Outcome:    entry.Status,
+			Error:      entry.Error,
+		})
+	}
+
+	if err := storage.addHistory(userID, entries); err != nil {
+		logf(ctx, "failed to save history: %s", err)
+	}
 }
 
-// renderDiagram returns a bytes array of the rendered svg diagram in .png format.
-func renderDiagram(conf config, str string) (bs []byte, err error) {
-	var graph *d2graph.Graph
-	if graph, _, err = d2compiler.Compile("", strings.NewReader(str), &d2compiler.CompileOptions{UTF16Pos: true}); err == nil {
-		var ruler *textmeasure.Ruler
-		if ruler, err = textmeasure.NewRuler(); err == nil {
-			if err = graph.SetDimensions(nil, ruler, nil); err == nil { // fontFamily = nil: use default
-				ctx := context.Background()
-				defer ctx.Done()
-
-				if err = d2dagrelayout.Layout(ctx, graph, nil); err == nil { // opts = nil: use default
-					var diagram *d2target.Diagram
-					if diagram, err = d2exporter.Export(ctx, graph, nil); err == nil { // fontFamily = nil: use default
-						if bs, err = d2svg.Render(diagram, &d2svg.RenderOpts{
-							Pad:         toPointer(renderPadding),
-							Sketch:      toPointer(conf.Sketch),
-							ThemeID:     toPointer(conf.ThemeID),
-							DarkThemeID: d2svg.DEFAULT_DARK_THEME,
-							Scale:       toPointer(1.0), // 1:1
-						}); err == nil { // opts = nil: use default
-							var pw png.Playwright
-							if pw, err = png.InitPlaywright(); err == nil {
-								defer func() {
-									e := pw.Cleanup()
-									if err == nil {
-										err = e
-									}
-								}()
-
-								if bs, err = png.ConvertSVG(pw.Page, bs); err == nil {
-									return bs, nil
-								}
-							}
-						}
+// sends rendered files as a reply to `messageID`, and returns ids of successfully sent messages.
+//
+// `rendered` and returned ids are keyed by the index of each diagram,
+// and multiple diagrams are sent as numbered albums.
+// `notes` (keyed by the index of each diagram) are added to captions of the rendered files,
+// and if `sources` are given, they are echoed in the captions too.
+func sendRendered(ctx context.Context, bot *tg.Bot, chatID, messageID int64, rendered map[int][]byte, total int, format outputFormat, notes map[int]string, sources []string) (sent map[int]int64) {
+	sent = map[int]int64{}
+
+	sourceOf := func(i int) string {
+		if i < len(sources) {
+			return sources[i]
+		}
+		return ""
+	}
+
+	if total == 1 {
+		if sentID, ok := sendRenderedFile(ctx, bot, chatID, messageID, rendered[0], format, renderedCaption("", notes[0], sourceOf(0))); ok {
+			sent[0] = sentID
+		}
+		return sent
+	}
+
+	// send in albums of up to `maxAlbumSize` items
+	indices := []int{}
+	for i := 0; i < total; i++ {
+		if _, exists := rendered[i]; exists {
+			indices = append(indices, i)
+		}
+	}
+	for len(indices) > 0 {
+		n := min(len(indices), maxAlbumSize)
+		if len(indices)-n == 1 {
+			n-- // NOTE: an album needs at least 2 items, so leave one more for the last album
+		}
+
+		if n <= 1 { // a single item cannot be sent as an album
+			i := indices[0]
+			indices = indices[1:]
+
+			if sentID, ok := sendRenderedFile(ctx, bot, chatID, messageID, rendered[i], format, renderedCaption(fmt.Sprintf("%d/%d", i+1, total), notes[i], sourceOf(i))); ok {
+				sent[i] = sentID
+			}
+			continue
+		}
+
+		mediaType := tg.InputMediaDocument
+		if format == formatPhoto {
+			mediaType = tg.InputMediaPhoto
+		}
+
+		album := indices[:n]
+		indices = indices[n:]
+
+		keys := map[int]string{}
+		for _, i := range album {
+			keys[i] = fileIDKey(rendered[i], format)
+		}
+
+		// NOTE: identical files uploaded before are sent with their file ids,
+		// and if they fail (eg. expired), the album is sent again with all of its files uploaded
+		for _, reuse := range []bool{true, false} {
+			files := map[string][]byte{}
+			media := []tg.InputMedia{}
+			reused := []string{}
+			for _, i := range album {
+				var item tg.InputMedia
+				if fileID, exists := storage.loadFileID(keys[i]); reuse && exists {
+					item = tg.NewInputMedia(mediaType, fileID)
+					reused = append(reused, keys[i])
+				} else {
+					filename := fmt.Sprintf("diagram%d.%s", i+1, format.extension())
+					files[filename] = rendered[i]
+
+					item = tg.NewInputMedia(mediaType, "attach://"+attachmentName(filename))
+				}
+				item.SetCaption(*renderedCaption(fmt.Sprintf("%d/%d", i+1, total), notes[i], sourceOf(i)))
+				media = append(media, item.SetParseMode(tg.ParseModeHTML))
+			}
+
+			var res tg.APIResponse[[]tg.Message]
+			if err := withNamedFiles(files, func(inputs map[string]tg.InputFile) {
+				options := tg.OptionsSendMediaGroup{}.
+					SetReplyParameters(tg.NewReplyParameters(messageID))
+				for filename, input := range inputs {
+					options[attachmentName(filename)] = input
+				}
+
+				_ = retries.do(ctx, nil, func() error {
+					if res = bot.SendMediaGroup(chatID, media, options); res.Ok {
+						return nil
 					}
+					return sendError(res.Description, res.Parameters)
+				})
+			}); err != nil {
+				logf(ctx, "failed to prepare rendered files: %s", err)
+				break
+			}
+
+			if res.Ok {
+				for j, message := range *res.Result {
+					sent[album[j]] = message.MessageID
+
+					rememberFileID(ctx, keys[album[j]], message)
+				}
+				break
+			}
+			logf(ctx, "failed to send rendered files: %s", *res.Description)
+
+			if len(reused) == 0 {
+				break
+			}
+			for _, key := range reused {
+				if err := storage.deleteFileID(key); err != nil {
+					logf(ctx, "failed to delete file id: %s", err)
 				}
 			}
 		}
 	}
-	return nil, err
+
+	return sent
+}
+
+// returns the name of an attachment for given filename (eg. `diagram1.png` => `diagram1`)
+func attachmentName(filename string) string {
+	return strings.TrimSuffix(filename, filepath.Ext(filename))
 }
 
-// checks if given username is allowed.
-func isUsernameAllowed(conf config, username *string) bool {
-	if username == nil {
-		return false
+// sends a rendered file as a reply to `messageID` (or not as a reply if it is 0) with an optional `caption` (in HTML), and returns the id of the sent message.
+//
+// .png files sent as documents are sent with their thumbnails.
+func sendRenderedFile(ctx context.Context, bot *tg.Bot, chatID, messageID int64, bs []byte, format outputFormat, caption *string) (sentID int64, success bool) {
+	// send the identical file uploaded before with its file id
+	key := fileIDKey(bs, format)
+	if message, sent := sendWithFileID(ctx, bot, chatID, messageID, key, format, caption); sent {
+		return message.MessageID, true
 	}
 
-	for _, v := range conf.AllowedIDs {
-		if v == *username {
-			return true
+	filename := "diagram." + format.extension()
+	files := map[string][]byte{filename: bs}
+	if format == formatPNG {
+		if thumbnail, err := pngThumbnail(bs); err == nil {
+			files[thumbnailFilename] = thumbnail
+		} else {
+			logf(ctx, "failed to generate thumbnail: %s", err)
+		}
+	}
+
+	if err := withNamedFiles(files, func(inputs map[string]tg.InputFile) {
+		file := inputs[filename]
+
+		// NOTE: sends are retried on hiccups of telegram (eg. timeouts, or too many requests)
+		var sent tg.APIResponse[tg.Message]
+		_ = retries.do(ctx, nil, func() error {
+			if sent = sendFile(bot, chatID, messageID, file, inputs, format, caption); sent.Ok {
+				return nil
+			}
+			return sendError(sent.Description, sent.Parameters)
+		})
+
+		if sent.Ok {
+			sentID, success = sent.Result.MessageID, true
+
+			rememberFileID(ctx, key, *sent.Result)
+		} else {
+			logf(ctx, "failed to send rendered file: %s", *sent.Description)
 		}
+	}); err != nil {
+		logf(ctx, "failed to prepare rendered file: %s", err)
 	}
 
-	return false
+	return sentID, success
 }
 
-// checks if given update is allowed.
-func isUpdateAllowed(conf config, update tg.Update) bool {
-	if from := update.GetFrom(); from != nil {
-		return isUsernameAllowed(conf, from.Username)
+// sends a file as a photo or a document (with its thumbnail in `inputs`, if any)
+func sendFile(bot *tg.Bot, chatID, messageID int64, file tg.InputFile, inputs map[string]tg.InputFile, format outputFormat, caption *string) (sent tg.APIResponse[tg.Message]) {
+	if format == formatPhoto {
+		options := tg.OptionsSendPhoto{}
+		if messageID != 0 {
+			options = options.SetReplyParameters(tg.NewReplyParameters(messageID))
+		}
+		if caption != nil {
+			options = options.SetCaption(*caption).
+				SetParseMode(tg.ParseModeHTML)
+		}
+		sent = bot.SendPhoto(chatID, file, options)
+	} else {
+		options := tg.OptionsSendDocument{}
+		if messageID != 0 {
+			options = options.SetReplyParameters(tg.NewReplyParameters(messageID))
+		}
+		if caption != nil {
+			options = options.SetCaption(*caption).
+				SetParseMode(tg.ParseModeHTML)
+		}
+		if thumbnail, exists := inputs[thumbnailFilename]; exists {
+			options = options.SetThumbnail(thumbnail)
+		}
+		sent = bot.SendDocument(chatID, file, options)
 	}
+	return sent
+}
 
-	return false
+// replaces the file of message `messageID` with a rendered file with an optional `caption` (in HTML), and returns if it succeeded.
+func editRenderedFile(ctx context.Context, bot *tg.Bot, chatID, messageID int64, bs []byte, format outputFormat, caption *string) (success bool) {
+	filename := "diagram." + format.extension()
+	if err := withNamedFile(filename, bs, func(file tg.InputFile) {
+		options := tg.OptionsEditMessageMedia{}.
+			SetIDs(chatID, messageID)
+		options[attachmentName(filename)] = file
+
+		mediaType := tg.InputMediaDocument
+		if format == formatPhoto {
+			mediaType = tg.InputMediaPhoto
+		}
+		media := tg.NewInputMedia(mediaType, "attach://"+attachmentName(filename))
+		if caption != nil {
+			media.SetCaption(*caption)
+			media = media.SetParseMode(tg.ParseModeHTML)
+		}
+
+		if edited := bot.EditMessageMedia(media, options); edited.Ok {
+			success = true
+		} else {
+			logf(ctx, "failed to replace rendered file: %s", *edited.Description)
+		}
+	}); err != nil {
+		logf(ctx, "failed to prepare rendered file: %s", err)
+	}
+
+	return success
 }
 
-// renders a .png file with given `text` and reply to `messageId` with it.
-func replyRendered(bot *tg.Bot, conf config, chatID, messageID int64, text string) {
-	// typing...
-	_ = bot.SendChatAction(chatID, tg.ChatActionTyping, nil)
+// replies to `messageID` with given d2 `source`,
+// as a code block (or a .d2 file if it is too long for a message).
+func replySource(bot *tg.Bot, chatID, messageID int64, source string) {
+	if len(source) > maxSourceMessageLength {
+		sendSourceFile(bot, chatID, messageID, source)
+	} else {
+		sendSourceMessage(bot, chatID, messageID, source)
+	}
+}
 
-	// render text into .svg and convert it to .png bytes
-	if bs, err := renderDiagram(conf, text); err == nil {
+// replies to `messageID` with given d2 `source` as a code block
+func sendSourceMessage(bot *tg.Bot, chatID, messageID int64, source string) {
+	if sent := bot.SendMessage(
+		chatID,
+		fmt.Sprintf(`<pre><code class="language-d2">%s</code></pre>`, html.EscapeString(source)),
+		tg.OptionsSendMessage{}.
+			SetParseMode(tg.ParseModeHTML).
+			SetReplyParameters(tg.NewReplyParameters(messageID))); !sent.Ok {
+		log.Printf("failed to send source: %s", *sent.Description)
+	}
+}
+
+// replies to `messageID` with given d2 `source` as a .d2 file
+func sendSourceFile(bot *tg.Bot, chatID, messageID int64, source string) {
+	if err := withNamedFile("diagram.d2", []byte(source), func(file tg.InputFile) {
 		if sent := bot.SendDocument(
 			chatID,
-			tg.NewInputFileFromBytes(bs),
+			file,
 			tg.OptionsSendDocument{}.
 				SetReplyParameters(tg.NewReplyParameters(messageID))); !sent.Ok {
-			log.Printf("failed to send rendered image: %s", *sent.Description)
-		} else {
-			if reactioned := bot.SetMessageReaction(chatID, messageID, tg.NewMessageReactionWithEmoji("👌")); !reactioned.Ok {
-				log.Printf("failed to set reaction: %s", *reactioned.Description)
-			}
+			log.Printf("failed to send source: %s", *sent.Description)
 		}
-	} else {
-		log.Printf("failed to render message: %s", err)
+	}); err != nil {
+		log.Printf("failed to prepare source: %s", err)
+	}
+}
+
+// calls `fn` with an input file which will be uploaded with given `filename`
+func withNamedFile(filename string, bs []byte, fn func(file tg.InputFile)) error {
+	return withNamedFiles(map[string][]byte{filename: bs}, func(inputs map[string]tg.InputFile) {
+		fn(inputs[filename])
+	})
+}
 
-		replyError(bot, chatID, messageID, fmt.Sprintf("Failed to render message: %s", err))
+// calls `fn` with input files which will be uploaded with their filenames
+func withNamedFiles(files map[string][]byte, fn func(inputs map[string]tg.InputFile)) (err error) {
+	var dir string
+	if dir, err = os.MkdirTemp(dirs.temp, "upload-"); err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	inputs := map[string]tg.InputFile{}
+	for filename, bs := range files {
+		path := filepath.Join(dir, filename)
+		if err = os.WriteFile(path, bs, 0600); err != nil {
+			return err
+		}
+		inputs[filename] = tg.NewInputFileFromFilepath(path)
 	}
+
+	fn(inputs)
+
+	return nil
 }
 
 // replies to `messageId` with `text`.
 func replyError(bot *tg.Bot, chatID, messageID int64, text string) {
+	replyText(bot, chatID, messageID, text)
+}
+
+// replies to `messageId` with `text`, quoting `quote` at `position` (in UTF-16 code units) of the message.
+//
+// Falls back to a reply without the quote if it fails.
+func replyErrorQuoting(bot *tg.Bot, chatID, messageID int64, text, quote string, position int) {
+	if sent := bot.SendMessage(
+		chatID,
+		text,
+		tg.OptionsSendMessage{}.
+			SetReplyParameters(tg.NewReplyParameters(messageID).
+				SetQuote(quote).
+				SetQuotePosition(position))); !sent.Ok {
+		log.Printf("failed to send message with quote: %s", *sent.Description)
+
+		replyError(bot, chatID, messageID, text)
+	}
+}
+
+// replies to `messageId` with `text`.
+//
+// Texts which are too long for a message are paginated with buttons.
+func replyText(bot *tg.Bot, chatID, messageID int64, text string) {
+	if pages := splitPages(text); len(pages) > 1 {
+		replyPages(bot, chatID, messageID, pages)
+		return
+	}
+
 	if sent := bot.SendMessage(
 		chatID,
 		text,
 		tg.OptionsSendMessage{}.
 			SetReplyParameters(tg.NewReplyParameters(messageID))); !sent.Ok {
-		log.Printf("failed to send rendered image: %s", *sent.Description)
+		log.Printf("failed to send message: %s", *sent.Description)
 	}
 }
 
 // handles a text message
-func handleMessage(bot *tg.Bot, conf config, message tg.Message) {
-	username := message.From.Username
+func handleMessage(ctx context.Context, bot *tg.Bot, conf config, message tg.Message, edited bool) {
+	// messages in sessions are handled by their handlers
+	if handleSessionMessage(ctx, bot, conf, message) {
+		return
+	}
 
-	if isUsernameAllowed(conf, username) {
-		txt := *message.Text
-		chatID := message.Chat.ID
-		messageID := message.MessageID
+	txt := *message.Text
+	chatID := message.Chat.ID
+	messageID := message.MessageID
 
-		replyRendered(bot, conf, chatID, messageID, txt)
-	} else {
-		if conf.IsVerbose {
-			log.Printf("message not allowed: %+v", message)
+	sources := extractDiagrams(txt, message.Entities)
+
+	// reply before/after images for edited messages, if the user wants
+	if edited && len(sources) == 1 && storage.loadSettings(message.From.ID).CompareEdits {
+		if previous, exists := storage.loadRenderOfRequest(chatID, messageID); exists && previous != sources[0] {
+			opts := renderOptionsForChat(conf, message.From.ID, chatID)
+			replyCompared(ctx, bot, conf, message.From.ID, chatID, messageID, [2]string{previous, sources[0]}, [2]renderOptions{opts, opts}, labelsBeforeAfter)
+			return
 		}
 	}
+
+	// replace previous renders of edited messages with new ones
+	opts := renderOptionsForChat(conf, message.From.ID, chatID)
+	if edited && replaceRendersOfEdited(ctx, bot, conf, message.From.ID, chatID, messageID, sources, opts) {
+		return
+	}
+
+	replyRenderedWithOptions(ctx, bot, conf, message.From.ID, chatID, messageID, sources, opts, txt)
 }
 
 // handles a document message
-func handleDocument(bot *tg.Bot, conf config, message tg.Message) {
-	username := message.From.Username
+func handleDocument(ctx context.Context, bot *tg.Bot, conf config, message tg.Message) {
+	document := *message.Document
+	chatID := message.Chat.ID
+	messageID := message.MessageID
 
-	if isUsernameAllowed(conf, username) {
-		document := *message.Document
-		chatID := message.Chat.ID
-		messageID := message.MessageID
+	if document.FileName != nil && isZipFile(*document.FileName) && message.Caption != nil && strings.HasPrefix(*message.Caption, commandImport) {
+		// zip file with `/import` caption
+		importZipDocument(ctx, bot, message.From.ID, chatID, messageID, &document, strings.TrimPrefix(*message.Caption, commandImport))
+	} else if document.FileName != nil && (isD2File(uncompressedFilename(*document.FileName)) || isMarkdownFile(uncompressedFilename(*document.FileName))) {
+		if sources, err := sourcesOfMessage(bot, &message); err == nil {
+			replyRendered(ctx, bot, conf, message.From.ID, chatID, messageID, sources)
+		} else {
+			logf(ctx, "failed to read document: %s", err)
 
-		if document.FileName != nil && strings.HasSuffix(*document.FileName, ".d2") {
-			if file := bot.GetFile(document.FileID); file.Ok {
-				url := bot.GetFileURL(*file.Result)
-				if content, err := getURL(url); err == nil {
-					message := string(content)
+			replyError(bot, chatID, messageID, err.Error())
+		}
+	} else {
+		if document.FileName != nil {
+			replyError(bot, chatID, messageID, fmt.Sprintf("'%s' does not seem to be a .d2 or markdown file.", *document.FileName))
+		}
+	}
+}
 
-					replyRendered(bot, conf, chatID, messageID, message)
-				} else {
-					log.Printf("failed to fetch '%s': %s", url, err)
-				}
-			} else {
-				log.Printf("failed to fetch file with id: %s", document.FileID)
+// returns d2 sources of given message (text, or a .d2/markdown document which can be gzip-compressed)
+func sourcesOfMessage(bot *tg.Bot, message *tg.Message) (sources []string, err error) {
+	if message.HasText() {
+		return extractDiagrams(*message.Text, message.Entities), nil
+	}
+
+	if message.HasDocument() && message.Document.FileName != nil {
+		filename := uncompressedFilename(*message.Document.FileName)
+
+		if isD2File(filename) || isMarkdownFile(filename) {
+			var content []byte
+			if content, err = fetchFile(bot, message.Document.FileID); err != nil {
+				return nil, err
 			}
-		} else {
-			if document.FileName != nil {
-				replyError(bot, chatID, messageID, fmt.Sprintf("'%s' does not seem to be a .d2 file.", *document.FileName))
+			if content, err = decompressIfGzipped(content); err != nil {
+				return nil, err
 			}
+
+			if isD2File(filename) {
+				return []string{string(content)}, nil
+			} else if sources = extractFencedBlocks(string(content)); len(sources) > 0 {
+				return sources, nil
+			}
+			return nil, fmt.Errorf("'%s' does not have any d2 code block.", filename)
 		}
-	} else {
-		if conf.IsVerbose {
-			log.Printf("document not allowed: %+v", message)
-		}
 	}
+
+	return nil, fmt.Errorf("%s", messageNoSource)
+}
+
+// returns d2 sources of given replied `message`:
+// the cached source if it is a render sent by this bot, or sources in the message itself.
+func sourcesOfRepliedMessage(bot *tg.Bot, message *tg.Message) (sources []string, err error) {
+	if source, exists := storage.loadRender(message.Chat.ID, message.MessageID); exists {
+		return []string{source}, nil
+	}
+	return sourcesOfMessage(bot, message)
+}
+
+// fetches the content of a file with given `fileID`
+func fetchFile(bot *tg.Bot, fileID string) (content []byte, err error) {
+	file := bot.GetFile(fileID)
+	if !file.Ok {
+		return nil, fmt.Errorf("failed to fetch file with id: %s", fileID)
+	}
+
+	if content, err = getURL(bot.GetFileURL(*file.Result)); err != nil {
+		return nil, fmt.Errorf("failed to fetch file: %w", err)
+	}
+	return content, nil
+}
+
+// checks if given filename is of a .d2 file.
+func isD2File(filename string) bool {
+	return strings.HasSuffix(filename, ".d2")
+}
+
+// checks if given filename is of a markdown file.
+func isMarkdownFile(filename string) bool {
+	return strings.HasSuffix(filename, ".md") || strings.HasSuffix(filename, ".markdown")
 }
 
 // handles a non-supported message
-func handleNoSupport(bot *tg.Bot, conf config, update tg.Update) {
-	if isUpdateAllowed(conf, update) {
-		if message, _ := update.GetMessage(); message != nil {
-			chatID := message.Chat.ID
-			messageID := message.MessageID
+func handleNoSupport(ctx context.Context, bot *tg.Bot, conf config, update tg.Update) {
+	if message, _ := update.GetMessage(); message != nil {
+		chatID := message.Chat.ID
+		messageID := message.MessageID
 
-			replyError(bot, chatID, messageID, messageNotSupported)
-		} else {
-			log.Printf("no usabale message: %+v", update)
-		}
+		replyError(bot, chatID, messageID, messageNotSupported)
 	} else {
-		if conf.IsVerbose {
-			log.Printf("update not allowed: %+v", update)
-		}
+		logf(ctx, "no usabale message: %+v", update)
 	}
 }
 
 // handle help command
-func handleHelpCommand(b *tg.Bot, conf config, update tg.Update) {
-	if isUpdateAllowed(conf, update) {
-		if message, _ := update.GetMessage(); message != nil {
-			chatID := message.Chat.ID
-
-			if sent := b.SendMessage(
-				chatID,
-				messageHelp,
-				tg.OptionsSendMessage{}.
-					SetParseMode(tg.ParseModeMarkdownV2)); !sent.Ok {
-				log.Printf("failed to send help message: %s", *sent.Description)
-			}
-		}
-	} else {
-		if conf.IsVerbose {
-			log.Printf("update not allowed: %+v", update)
+func handleHelpCommand(ctx context.Context, b *tg.Bot, conf config, update tg.Update) {
+	if message, _ := update.GetMessage(); message != nil {
+		chatID := message.Chat.ID
+
+		if sent := b.SendMessage(
+			chatID,
+			messageHelp,
+			tg.OptionsSendMessage{}.
+				SetParseMode(tg.ParseModeMarkdownV2)); !sent.Ok {
+			logf(ctx, "failed to send help message: %s", *sent.Description)
 		}
 	}
 }
 
 // handle privacy command
-func handlePrivacyCommand(b *tg.Bot, update tg.Update) {
+func handlePrivacyCommand(ctx context.Context, b *tg.Bot, update tg.Update) {
 	if message, _ := update.GetMessage(); message != nil {
 		chatID := message.Chat.ID
 
@@ -340,28 +926,79 @@ func handlePrivacyCommand(b *tg.Bot, update tg.Update) {
 			messagePrivacy,
 			tg.OptionsSendMessage{}.
 				SetParseMode(tg.ParseModeMarkdownV2)); !sent.Ok {
-			log.Printf("failed to send privacy policy: %s", *sent.Description)
+			logf(ctx, "failed to send privacy policy: %s", *sent.Description)
 		}
 	}
 }
 
-// handle no matching command
-func handleNoMatchingCommand(b *tg.Bot, conf config, update tg.Update, cmd string) {
-	if isUpdateAllowed(conf, update) {
-		if message, _ := update.GetMessage(); message != nil {
-			chatID := message.Chat.ID
-
-			if sent := b.SendMessage(
-				chatID,
-				fmt.Sprintf(messageNoMatchingCommand, cmd),
-				tg.OptionsSendMessage{}.
-					SetParseMode(tg.ParseModeMarkdownV2)); !sent.Ok {
-				log.Printf("failed to send no-matching-command message: %s", *sent.Description)
-			}
+// handle cancel command
+func handleCancelCommand(ctx context.Context, b *tg.Bot, conf config, update tg.Update) {
+	if message, _ := update.GetMessage(); message != nil {
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		var text string
+		if canceled := jobs.cancel(message.From.ID); canceled > 0 {
+			text = fmt.Sprintf(messageCanceled, canceled)
+		} else if sessions.end(chatID, message.From.ID) {
+			text = messageSessionEnded
+		} else {
+			text = messageNothingToCancel
+		}
+
+		if sent := b.SendMessage(
+			chatID,
+			text,
+			tg.OptionsSendMessage{}.
+				SetReplyParameters(tg.NewReplyParameters(messageID))); !sent.Ok {
+			logf(ctx, "failed to send cancel result: %s", *sent.Description)
 		}
+	}
+}
+
+// handle callback queries from inline keyboards
+func handleCallbackQuery(ctx context.Context, b *tg.Bot, conf config, update tg.Update, query tg.CallbackQuery) {
+	if query.Data == nil {
+		answerCallbackQuery(b, query, nil)
+		return
+	}
+
+	if data, found := strings.CutPrefix(*query.Data, callbackPrefixHistory); found {
+		handleHistoryCallback(ctx, b, conf, query, data)
+	} else if name, found := strings.CutPrefix(*query.Data, callbackPrefixLibrary); found {
+		handleLibraryCallback(ctx, b, conf, query, name)
+	} else if data, found := strings.CutPrefix(*query.Data, callbackPrefixTheme); found {
+		handleThemeCallback(ctx, b, conf, query, data)
+	} else if data, found := strings.CutPrefix(*query.Data, callbackPrefixPage); found {
+		handlePageCallback(b, query, data)
 	} else {
-		if conf.IsVerbose {
-			log.Printf("update not allowed: %+v", update)
+		answerCallbackQuery(b, query, nil)
+	}
+}
+
+// answers given callback query with an optional `text`
+func answerCallbackQuery(bot *tg.Bot, query tg.CallbackQuery, text *string) {
+	options := tg.OptionsAnswerCallbackQuery{}
+	if text != nil {
+		options = options.SetText(*text)
+	}
+
+	if answered := bot.AnswerCallbackQuery(query.ID, options); !answered.Ok {
+		log.Printf("failed to answer callback query: %s", *answered.Description)
+	}
+}
+
+// handle no matching command
+func handleNoMatchingCommand(ctx context.Context, b *tg.Bot, conf config, update tg.Update, cmd string) {
+	if message, _ := update.GetMessage(); message != nil {
+		chatID := message.Chat.ID
+
+		if sent := b.SendMessage(
+			chatID,
+			fmt.Sprintf(messageNoMatchingCommand, cmd),
+			tg.OptionsSendMessage{}.
+				SetParseMode(tg.ParseModeMarkdownV2)); !sent.Ok {
+			logf(ctx, "failed to send no-matching-command message: %s", *sent.Description)
 		}
 	}
 }
@@ -384,59 +1021,276 @@ func getURL(url string) (content []byte, err error) {
 }
 
 // runs the bot with config file's path
+//
+// The bot is restarted with the reloaded config on reload requests (`/reload` command, SIGHUP, or a rotated bot token).
 func runBot(confFilepath string) {
 	if conf, err := loadConfig(confFilepath); err != nil {
 		panic(err)
 	} else {
-		client := tg.NewClient(conf.BotToken)
-		client.Verbose = conf.IsVerbose
+		if dirs, err = prepareDataDirs(conf); err != nil {
+			panic(err)
+		}
+		if err = setupLogFile(conf.LogFile); err != nil {
+			panic(err)
+		}
+		if err = prepareBrowser(browserConfigOf(conf)); err != nil {
+			log.Printf("%s", err)
+			return
+		}
+		defer closeBrowser()
+		if storage, err = openStore(storeFilepath(conf, confFilepath)); err != nil {
+			panic(err)
+		}
+		if updateIDs, err = openProcessedUpdates(resolvePath(dirs.state, processedUpdatesFilename)); err != nil {
+			panic(err)
+		}
+		if jobsJournal, err = openJobJournal(resolvePath(dirs.state, journalFilename)); err != nil {
+			panic(err)
+		}
+		if auditor, err = openAuditLog(resolvePath(dirs.state, conf.AuditLogFilepath)); err != nil {
+			panic(err)
+		}
+		renders = newRenderCache(conf.Cache)
+		inlineFiles = newInlineFileCache(conf.Inline)
+		importFS = newImportFS(conf.Imports)
+		if fontFallbacks, err = loadFallbackFonts(conf.Fonts); err != nil {
+			panic(err)
+		}
+		if conversions, renderFiles, err = newDiskCaches(conf.Cache); err != nil {
+			panic(err)
+		}
+		if viewer, err = startViewer(conf.Viewer); err != nil {
+			panic(err)
+		}
+
+		hangups := make(chan os.Signal, 1)
+		signal.Notify(hangups, syscall.SIGHUP)
+
+		var offset int64
+		for {
+			ctx, cancel := context.WithCancel(context.Background())
+			go watchReloads(ctx, cancel, conf, confFilepath, hangups)
+
+			var served bool
+			offset, served = serveBot(ctx, conf, offset)
+			cancel()
+			if !served {
+				return
+			}
 
-		if me := client.GetMe(); me.Ok {
-			if deleted := client.DeleteWebhook(false); deleted.Ok {
-				log.Printf("starting bot %s: @%s (%s)", version.Minimum(), *me.Result.Username, me.Result.FirstName)
+			if reloaded, err := loadConfig(confFilepath); err == nil {
+				log.Printf("reloaded config")
 
-				interval := conf.MonitorInterval
-				if interval <= 0 {
-					interval = defaultPollingInterval
+				if _, restartRequired := splitRestartRequiredKeys(changedConfigKeys(conf, reloaded)); len(restartRequired) > 0 {
+					log.Printf("changes of %s are not applied until the process is restarted", strings.Join(restartRequired, ", "))
 				}
 
-				// set update handlers
-				client.SetMessageHandler(func(b *tg.Bot, update tg.Update, message tg.Message, edited bool) {
-					if message.HasText() {
-						handleMessage(b, conf, message)
-					} else if message.HasDocument() {
-						handleDocument(b, conf, message)
-					}
-				})
+				conf = reloaded
+			} else {
+				log.Printf("failed to reload config, keeping the current one: %s", err)
+			}
+		}
+	}
+}
 
-				// set command handlers
-				client.AddCommandHandler(commandStart, func(b *tg.Bot, update tg.Update, args string) {
-					handleHelpCommand(b, conf, update)
-				})
-				client.AddCommandHandler(commandHelp, func(b *tg.Bot, update tg.Update, args string) {
-					handleHelpCommand(b, conf, update)
-				})
-				client.AddCommandHandler(commandPrivacy, func(b *tg.Bot, update tg.Update, args string) {
-					handlePrivacyCommand(b, update)
-				})
-				client.SetNoMatchingCommandHandler(func(b *tg.Bot, update tg.Update, cmd, args string) {
-					handleNoMatchingCommand(b, conf, update, cmd)
-				})
+// serves the bot with `conf` from update `offset` until `ctx` is canceled,
+// and returns the offset for the next polling (`served` is false if the bot could not be started).
+func serveBot(ctx context.Context, conf config, offset int64) (nextOffset int64, served bool) {
+	client := newClient(conf.BotToken)
+	client.Verbose = conf.IsVerbose
 
-				// start polling
-				client.StartPollingUpdates(0, interval, func(b *tg.Bot, update tg.Update, err error) {
-					if err != nil {
-						log.Printf("failed to poll updates: %s", err.Error())
-					} else {
-						// do nothing (messages are handled by specified update handler)
-						handleNoSupport(b, conf, update)
-					}
+	alerter = newFailureAlerter(client, conf.Alerts)
+	retries = newRetrier(conf.Retries)
+	layoutFallbacks = newLayoutFallback(conf.LayoutFallback)
+	renderWorkers = newRenderQueue(conf.RenderQueue)
+	checkDefaultOutputFormat(conf)
+	checkDefaultLayoutEngine(conf)
+
+	if me := client.GetMe(); me.Ok {
+		if deleted := client.DeleteWebhook(false); deleted.Ok {
+			botUsername := *me.Result.Username
+
+			log.Printf("starting bot %s: @%s (%s)", version.Minimum(), botUsername, me.Result.FirstName)
+
+			interval := conf.MonitorInterval
+			if interval <= 0 {
+				interval = defaultPollingInterval
+			}
+
+			// set update handlers
+			router := newUpdateRouter(botUsername)
+			router.setMessageHandler(func(ctx context.Context, b *tg.Bot, update tg.Update, message tg.Message, edited bool) {
+				if message.HasText() {
+					handleMessage(ctx, b, conf, message, edited)
+				} else if message.HasDocument() {
+					handleDocument(ctx, b, conf, message)
+				}
+			})
+
+			router.setChannelPostHandler(func(ctx context.Context, b *tg.Bot, update tg.Update, post tg.Message, edited bool) {
+				handleSharedChannelPost(ctx, conf, post)
+			})
+
+			router.setCallbackQueryHandler(func(ctx context.Context, b *tg.Bot, update tg.Update, query tg.CallbackQuery) {
+				handleCallbackQuery(ctx, b, conf, update, query)
+			})
+
+			router.setInlineQueryHandler(func(ctx context.Context, b *tg.Bot, update tg.Update, query tg.InlineQuery) {
+				handleInlineQuery(ctx, b, conf, query)
+			})
+
+			// set command handlers
+			router.addCommandHandler(commandStart, func(ctx context.Context, b *tg.Bot, update tg.Update, args string) {
+				if name, found := strings.CutPrefix(args, deepLinkPrefixLibrary); found {
+					handleLibraryDeepLink(ctx, b, conf, update, name)
+				} else if strings.HasPrefix(strings.TrimSpace(args), deepLinkPrefixInvite) {
+					handleInvitationRedemption(ctx, b, conf, update, strings.TrimSpace(args))
+				} else {
+					handleHelpCommand(ctx, b, conf, update)
+				}
+			})
+			router.addCommandHandler(commandHelp, func(ctx context.Context, b *tg.Bot, update tg.Update, args string) {
+				handleHelpCommand(ctx, b, conf, update)
+			})
+			router.addCommandHandler(commandPrivacy, func(ctx context.Context, b *tg.Bot, update tg.Update, args string) {
+				handlePrivacyCommand(ctx, b, update)
+			})
+			router.addCommandHandler(commandVersion, func(ctx context.Context, b *tg.Bot, update tg.Update, args string) {
+				handleVersionCommand(b, conf, update)
+			})
+			router.addCommandHandler(commandCancel, func(ctx context.Context, b *tg.Bot, update tg.Update, args string) {
+				handleCancelCommand(ctx, b, conf, update)
+			})
+			router.addCommandHandler(commandReload, func(ctx context.Context, b *tg.Bot, update tg.Update, args string) {
+				handleReloadCommand(b, conf, update)
+			})
+			router.addCommandHandler(commandStats, func(ctx context.Context, b *tg.Bot, update tg.Update, args string) {
+				handleStatsCommand(b, update)
+			})
+			router.addCommandHandler(commandPurgeCache, func(ctx context.Context, b *tg.Bot, update tg.Update, args string) {
+				handlePurgeCacheCommand(b, update)
+			})
+			router.addCommandHandler(commandInvite, func(ctx context.Context, b *tg.Bot, update tg.Update, args string) {
+				handleInviteCommand(b, update, args)
+			})
+			router.addCommandHandler(commandRole, func(ctx context.Context, b *tg.Bot, update tg.Update, args string) {
+				handleRoleCommand(b, conf, update, args)
+			})
+			router.addCommandHandler(commandTutorial, func(ctx context.Context, b *tg.Bot, update tg.Update, args string) {
+				handleTutorialCommand(ctx, b, conf, update)
+			})
+			router.addCommandHandler(commandSyntax, func(ctx context.Context, b *tg.Bot, update tg.Update, args string) {
+				handleSyntaxCommand(ctx, b, conf, update, args)
+			})
+			router.addCommandHandler(commandSave, func(ctx context.Context, b *tg.Bot, update tg.Update, args string) {
+				handleSaveCommand(ctx, b, conf, update, botUsername, args)
+			})
+			router.addCommandHandler(commandLibrary, func(ctx context.Context, b *tg.Bot, update tg.Update, args string) {
+				handleLibraryCommand(ctx, b, conf, update, botUsername)
+			})
+			router.addCommandHandler(commandDelete, func(ctx context.Context, b *tg.Bot, update tg.Update, args string) {
+				handleDeleteCommand(ctx, b, conf, update, args)
+			})
+			router.addCommandHandler(commandFind, func(ctx context.Context, b *tg.Bot, update tg.Update, args string) {
+				handleFindCommand(b, conf, update, args)
+			})
+			router.addCommandHandler(commandTemplate, func(ctx context.Context, b *tg.Bot, update tg.Update, args string) {
+				handleTemplateCommand(ctx, b, conf, update, args)
+			})
+			router.addCommandHandler(commandPublish, func(ctx context.Context, b *tg.Bot, update tg.Update, args string) {
+				handlePublishCommand(ctx, b, conf, update, args)
+			})
+			router.addCommandHandler(commandGet, func(ctx context.Context, b *tg.Bot, update tg.Update, args string) {
+				handleGetCommand(ctx, b, conf, update, args)
+			})
+			router.addCommandHandler(commandExport, func(ctx context.Context, b *tg.Bot, update tg.Update, args string) {
+				handleExportCommand(ctx, b, conf, update, args)
+			})
+			router.addCommandHandler(commandImport, func(ctx context.Context, b *tg.Bot, update tg.Update, args string) {
+				handleImportCommand(ctx, b, conf, update, args)
+			})
+			router.addCommandHandler(commandFormat, func(ctx context.Context, b *tg.Bot, update tg.Update, args string) {
+				handleFormatCommand(ctx, b, conf, update, args)
+			})
+			router.addCommandHandler(commandSettings, func(ctx context.Context, b *tg.Bot, update tg.Update, args string) {
+				handleSettingsCommand(ctx, b, conf, update, args)
+			})
+			router.addCommandHandler(commandNightMode, func(ctx context.Context, b *tg.Bot, update tg.Update, args string) {
+				handleNightModeCommand(b, conf, update, args)
+			})
+			router.addCommandHandler(commandSource, func(ctx context.Context, b *tg.Bot, update tg.Update, args string) {
+				handleSourceCommand(b, update)
+			})
+			router.addCommandHandler(commandSticker, func(ctx context.Context, b *tg.Bot, update tg.Update, args string) {
+				handleStickerCommand(ctx, b, conf, update, args)
+			})
+			router.addCommandHandler(commandWatch, func(ctx context.Context, b *tg.Bot, update tg.Update, args string) {
+				handleWatchCommand(ctx, b, conf, update, args)
+			})
+			router.addCommandHandler(commandUnwatch, func(ctx context.Context, b *tg.Bot, update tg.Update, args string) {
+				handleUnwatchCommand(ctx, b, update, args)
+			})
+			router.addCommandHandler(commandHistory, func(ctx context.Context, b *tg.Bot, update tg.Update, args string) {
+				handleHistoryCommand(ctx, b, conf, update)
+			})
+			router.addCommandHandler(commandLast, func(ctx context.Context, b *tg.Bot, update tg.Update, args string) {
+				handleLastCommand(ctx, b, conf, update, args)
+			})
+			router.addCommandHandler(commandDiff, func(ctx context.Context, b *tg.Bot, update tg.Update, args string) {
+				handleDiffCommand(ctx, b, conf, update, args)
+			})
+			router.addCommandHandler(commandCompare, func(ctx context.Context, b *tg.Bot, update tg.Update, args string) {
+				handleCompareCommand(ctx, b, conf, update, args)
+			})
+			router.addCommandHandler(commandTranslate, func(ctx context.Context, b *tg.Bot, update tg.Update, args string) {
+				handleTranslateCommand(ctx, b, conf, update, args)
+			})
+			router.addCommandHandler(commandTheme, func(ctx context.Context, b *tg.Bot, update tg.Update, args string) {
+				handleThemeCommand(ctx, b, conf, update, args)
+			})
+			for command, format := range conversionCommands {
+				router.addCommandHandler(command, func(ctx context.Context, b *tg.Bot, update tg.Update, args string) {
+					handleConversionCommand(ctx, b, conf, update, format, args)
 				})
-			} else {
-				log.Printf("failed to delete webhook: %s", *deleted.Description)
 			}
+			router.setNoMatchingCommandHandler(func(ctx context.Context, b *tg.Bot, update tg.Update, cmd, args string) {
+				handleNoMatchingCommand(ctx, b, conf, update, cmd)
+			})
+
+			router.setUpdateHandler(func(ctx context.Context, b *tg.Bot, update tg.Update) {
+				handleNoSupport(ctx, b, conf, update)
+			})
+
+			// handle updates with middlewares: request id => auth => group policy => access hours => rate limit => metrics => panic recovery => audit => routing
+			handler := chainMiddlewares(
+				router.route,
+				requestIDMiddleware(conf),
+				authMiddleware(conf, router),
+				groupPolicyMiddleware(conf, router),
+				accessHoursMiddleware(conf),
+				rateLimitMiddleware(conf, router),
+				metricsMiddleware(router),
+				recoverMiddleware(),
+				auditMiddleware(router),
+			)
+
+			// resume render jobs interrupted by the previous run
+			resumeUnfinishedJobs(client, conf)
+
+			// watch local files for live previews
+			go watchFiles(ctx, client, conf)
+
+			// poll remote urls watched in chats
+			go pollURLWatches(ctx, client, conf)
+
+			// start polling
+			return pollUpdates(ctx, client, conf, handler, interval, offset), true
 		} else {
-			log.Printf("failed to get bot information: %s", *me.Description)
+			log.Printf("failed to delete webhook: %s", *deleted.Description)
 		}
+	} else {
+		log.Printf("failed to get bot information: %s", *me.Description)
 	}
+
+	return offset, false
 }
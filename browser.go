@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+)
+
+// error returned for conversions which need a browser, in a build without it (with `nobrowser` tag)
+var errNoBrowser = errors.New("this bot was built without a browser (with `nobrowser` tag), so only .svg files can be rendered")
+
+// settings of the headless browser for .png (and .pdf) conversions
+type browserConfig struct {
+	BrowsersPath string `json:"browsers_path,omitempty"` // directory where browsers are installed (= `$PLAYWRIGHT_BROWSERS_PATH` or playwright's default)
+	DriverPath   string `json:"driver_path,omitempty"`   // directory where the playwright driver is installed (= `$PLAYWRIGHT_DRIVER_PATH` or playwright's default)
+	SkipInstall  bool   `json:"skip_install,omitempty"`  // whether to skip installing the driver and browsers on startup
+
+	// system browser (instead of a playwright-managed one)
+	Channel        string `json:"channel,omitempty"`         // eg. `chrome`, `chrome-beta`, `msedge`, or `chromium`
+	ExecutablePath string `json:"executable_path,omitempty"` // eg. `/usr/bin/chromium`
+
+	// launch options (eg. for minimal docker images)
+	NoSandbox          bool                `json:"no_sandbox,omitempty"`
+	DisableDevShmUsage bool                `json:"disable_dev_shm_usage,omitempty"`
+	Proxy              *browserProxyConfig `json:"proxy,omitempty"`
+	UserDataDir        string              `json:"user_data_dir,omitempty"` // directory of the browser's persistent profile
+	Args               []string            `json:"args,omitempty"`          // other command line arguments of the browser
+}
+
+// proxy settings of the browser
+type browserProxyConfig struct {
+	Server   string `json:"server"` // eg. `http://myproxy.com:3128` or `socks5://myproxy.com:3128`
+	Bypass   string `json:"bypass,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// checks if a system browser is used
+func (c browserConfig) usesSystemBrowser() bool {
+	return c.Channel != "" || c.ExecutablePath != ""
+}
+
+// browser settings overridden with command line flags
+var browserFlags browserConfig
+
+// returns browser settings of `conf`, overridden with command line flags
+func browserConfigOf(conf config) (c browserConfig) {
+	if conf.Browser != nil {
+		c = *conf.Browser
+	}
+	if browserFlags.BrowsersPath != "" {
+		c.BrowsersPath = browserFlags.BrowsersPath
+	}
+	if browserFlags.DriverPath != "" {
+		c.DriverPath = browserFlags.DriverPath
+	}
+	if browserFlags.SkipInstall {
+		c.SkipInstall = true
+	}
+	if browserFlags.Channel != "" {
+		c.Channel = browserFlags.Channel
+	}
+	if browserFlags.ExecutablePath != "" {
+		c.ExecutablePath = browserFlags.ExecutablePath
+	}
+	return c
+}
+
+// checks if a browser is needed for rendering diagrams in the output format
+func (f outputFormat) needsBrowser() bool {
+	return f != formatSVG
+}
@@ -0,0 +1,37 @@
+//go:build nobrowser
+
+package main
+
+import (
+	"context"
+	"log"
+)
+
+// whether a browser is available in this build
+const browserAvailable = false
+
+// does nothing but logging, as there is no browser in this build
+func prepareBrowser(conf browserConfig) error {
+	log.Printf("built without a browser, so diagrams will be rendered as .svg files only")
+
+	return nil
+}
+
+// does nothing, as there is no browser in this build
+func closeBrowser() {
+}
+
+// returns the version of the browser (none in this build)
+func browserVersion() string {
+	return "none"
+}
+
+// always fails, as there is no browser for converting svg into .png format in this build
+func rasterizeSVG(ctx context.Context, svg []byte) ([]byte, error) {
+	return nil, errNoBrowser
+}
+
+// always fails, as there is no browser for converting svg into .pdf format in this build
+func convertSVGToPDF(ctx context.Context, pages []renderedBoard) ([]byte, error) {
+	return nil, errNoBrowser
+}
@@ -0,0 +1,402 @@
+//go:build !nobrowser
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	// playwright
+	"github.com/playwright-community/playwright-go"
+
+	// d2
+	"oss.terrastruct.com/d2/lib/png"
+)
+
+// constants for browsers
+const (
+	envPlaywrightBrowsersPath = "PLAYWRIGHT_BROWSERS_PATH"
+
+	browserChromium = "chromium"
+
+	browserArgNoSandbox          = "--no-sandbox"
+	browserArgDisableDevShmUsage = "--disable-dev-shm-usage"
+)
+
+// whether a browser is available in this build
+const browserAvailable = true
+
+// browser settings in use (set on startup)
+var browserSettings browserConfig
+
+// returns options for running playwright with `conf`
+func playwrightRunOptions(conf browserConfig) *playwright.RunOptions {
+	return &playwright.RunOptions{
+		DriverDirectory:     conf.DriverPath,
+		SkipInstallBrowsers: conf.usesSystemBrowser(), // NOTE: only the driver is needed for a system browser
+		Browsers:            []string{browserChromium},
+		Verbose:             false,
+	}
+}
+
+// returns command line arguments of the browser with `conf`
+func browserArgs(conf browserConfig) (args []string) {
+	if conf.NoSandbox {
+		args = append(args, browserArgNoSandbox)
+	}
+	if conf.DisableDevShmUsage {
+		args = append(args, browserArgDisableDevShmUsage)
+	}
+	return append(args, conf.Args...)
+}
+
+// returns proxy settings of the browser with `conf` (nil if not configured)
+func browserProxy(conf browserConfig) (proxy *playwright.Proxy) {
+	if conf.Proxy == nil || conf.Proxy.Server == "" {
+		return nil
+	}
+
+	proxy = &playwright.Proxy{Server: conf.Proxy.Server}
+	if conf.Proxy.Bypass != "" {
+		proxy.Bypass = toPointer(conf.Proxy.Bypass)
+	}
+	if conf.Proxy.Username != "" {
+		proxy.Username = toPointer(conf.Proxy.Username)
+		proxy.Password = toPointer(conf.Proxy.Password)
+	}
+	return proxy
+}
+
+// returns options for launching a browser with `conf`
+func browserLaunchOptions(conf browserConfig) playwright.BrowserTypeLaunchOptions {
+	options := playwright.BrowserTypeLaunchOptions{
+		Args:  browserArgs(conf),
+		Proxy: browserProxy(conf),
+	}
+	if conf.NoSandbox {
+		options.ChromiumSandbox = toPointer(false)
+	}
+	if conf.Channel != "" {
+		options.Channel = toPointer(conf.Channel)
+	}
+	if conf.ExecutablePath != "" {
+		options.ExecutablePath = toPointer(conf.ExecutablePath)
+	}
+	return options
+}
+
+// returns options for launching a browser with a persistent context (`user_data_dir`) with `conf`
+func browserLaunchPersistentContextOptions(conf browserConfig) playwright.BrowserTypeLaunchPersistentContextOptions {
+	options := browserLaunchOptions(conf)
+
+	return playwright.BrowserTypeLaunchPersistentContextOptions{
+		Args:            options.Args,
+		Proxy:           options.Proxy,
+		ChromiumSandbox: options.ChromiumSandbox,
+		Channel:         options.Channel,
+		ExecutablePath:  options.ExecutablePath,
+	}
+}
+
+// prepares the browser with given settings:
+// installs the driver and browser (when not installed yet), or checks if they are installed (when `skip_install` is set)
+//
+// A system browser (`channel` or `executable_path`) is not installed, but always checked if it can be launched.
+func prepareBrowser(conf browserConfig) error {
+	browserSettings = conf
+
+	if conf.BrowsersPath != "" {
+		// NOTE: playwright reads the browsers' path from environment only
+		if err := os.Setenv(envPlaywrightBrowsersPath, conf.BrowsersPath); err != nil {
+			return err
+		}
+	}
+
+	if !conf.SkipInstall {
+		if err := playwright.Install(playwrightRunOptions(conf)); err != nil {
+			return fmt.Errorf("failed to install playwright browsers: %w", err)
+		}
+		if !conf.usesSystemBrowser() {
+			return nil
+		}
+	}
+
+	// fail fast if the browser is missing (it is kept running for conversions)
+	if version, err := browsers.version(); err == nil {
+		log.Printf("using browser: %s", version)
+	} else if conf.usesSystemBrowser() {
+		return fmt.Errorf("failed to launch the system browser (check `channel` or `executable_path`): %w", err)
+	} else {
+		return fmt.Errorf("browser is not installed (install it with `go run github.com/playwright-community/playwright-go/cmd/playwright install --with-deps %s`, or unset `skip_install`): %w", browserChromium, err)
+	}
+
+	return nil
+}
+
+// closes the browser (if launched) and stops playwright
+func closeBrowser() {
+	browsers.close()
+}
+
+// a launched browser
+type browserSession struct {
+	pw      *playwright.Playwright
+	browser playwright.Browser        // nil when launched with a persistent context
+	context playwright.BrowserContext // persistent context (launched with `user_data_dir`)
+}
+
+// runs playwright and launches a new browser with current settings
+//
+// (replaces `png.InitPlaywright`, which installs browsers on every call)
+func initPlaywright() (session browserSession, err error) {
+	if session.pw, err = playwright.Run(playwrightRunOptions(browserSettings)); err != nil {
+		return browserSession{}, fmt.Errorf("failed to run playwright: %w", err)
+	}
+
+	if browserSettings.UserDataDir != "" {
+		session.context, err = session.pw.Chromium.LaunchPersistentContext(browserSettings.UserDataDir, browserLaunchPersistentContextOptions(browserSettings))
+	} else {
+		session.browser, err = session.pw.Chromium.Launch(browserLaunchOptions(browserSettings))
+	}
+	if err != nil {
+		_ = session.close()
+		return browserSession{}, fmt.Errorf("failed to launch browser: %w", err)
+	}
+
+	return session, nil
+}
+
+// opens a new page of the browser
+func (s browserSession) newPage() (playwright.Page, error) {
+	if s.context != nil {
+		return s.context.NewPage()
+	}
+	return s.browser.NewPage()
+}
+
+// checks if the browser is still running
+//
+// (a persistent context cannot be checked, but it fails to open new pages when its browser is gone)
+func (s browserSession) connected() bool {
+	if s.browser != nil {
+		return s.browser.IsConnected()
+	}
+	return true
+}
+
+// closes the browser and stops playwright
+func (s browserSession) close() (err error) {
+	if s.context != nil {
+		err = s.context.Close()
+	} else if s.browser != nil {
+		err = s.browser.Close()
+	}
+	if e := s.pw.Stop(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// returns the version of the browser
+func (s browserSession) version() string {
+	if s.browser != nil {
+		return s.browser.Version()
+	}
+	return unknownVersion
+}
+
+// a long-lived browser shared by conversions, with a pool of its idle pages
+//
+// (launching a browser takes seconds, so it is launched once and relaunched only when it is gone, eg. after a crash)
+type browserPool struct {
+	sync.Mutex
+
+	session *browserSession // nil when not launched yet
+	idle    []playwright.Page
+}
+
+// browser of this bot
+var browsers = &browserPool{}
+
+// returns an idle page (or a new one) of the browser, launching the browser if it is not running
+func (p *browserPool) acquire() (page playwright.Page, err error) {
+	p.Lock()
+	defer p.Unlock()
+
+	for len(p.idle) > 0 {
+		page, p.idle = p.idle[len(p.idle)-1], p.idle[:len(p.idle)-1]
+		if !page.IsClosed() {
+			return page, nil
+		}
+	}
+
+	if p.session != nil && p.session.connected() {
+		if page, err = p.session.newPage(); err == nil {
+			return page, nil
+		}
+		log.Printf("failed to open a browser page, relaunching the browser: %s", err)
+	}
+	if err = p.relaunch(); err != nil {
+		return nil, err
+	}
+	return p.session.newPage()
+}
+
+// returns `page` to the pool if it is `reusable` (eg. its conversion succeeded), or closes it
+//
+// (idle pages are kept up to the max number of render workers, as conversions are done in them)
+func (p *browserPool) release(page playwright.Page, reusable bool) {
+	p.Lock()
+	defer p.Unlock()
+
+	if reusable && !page.IsClosed() && len(p.idle) < cap(renderWorkers.workers) {
+		p.idle = append(p.idle, page)
+		return
+	}
+	_ = page.Close()
+}
+
+// returns the version of the browser, launching it if it is not running
+func (p *browserPool) version() (string, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.session == nil || !p.session.connected() {
+		if err := p.relaunch(); err != nil {
+			return "", err
+		}
+	}
+	return p.session.version(), nil
+}
+
+// closes the browser
+func (p *browserPool) close() {
+	p.Lock()
+	defer p.Unlock()
+
+	p.closeSession()
+}
+
+// closes the running browser (if any) and launches a new one (should be called with the lock held)
+func (p *browserPool) relaunch() error {
+	p.closeSession()
+
+	session, err := initPlaywright()
+	if err != nil {
+		return err
+	}
+	p.session = &session
+
+	return nil
+}
+
+// closes idle pages and the browser (should be called with the lock held)
+func (p *browserPool) closeSession() {
+	for _, page := range p.idle {
+		_ = page.Close()
+	}
+	p.idle = nil
+
+	if p.session != nil {
+		if err := p.session.close(); err != nil {
+			log.Printf("failed to close browser: %s", err)
+		}
+		p.session = nil
+	}
+}
+
+// version of the browser (fetched once, on demand)
+var browserVersion = sync.OnceValue(func() string {
+	version, err := browsers.version()
+	if err != nil {
+		log.Printf("failed to launch browser for its version: %s", err)
+		return unknownVersion
+	}
+	return version
+})
+
+// result of a svg conversion
+type conversion struct {
+	bs  []byte
+	err error
+}
+
+// rasterizeSVG converts given svg bytes into .png format with playwright.
+//
+// When `ctx` is canceled in the middle of the conversion, its page is closed immediately.
+func rasterizeSVG(ctx context.Context, svg []byte) ([]byte, error) {
+	return withBrowserPage(ctx, func(page playwright.Page) ([]byte, error) {
+		return png.ConvertSVG(page, svg)
+	})
+}
+
+// convertSVGToPDF converts given rendered boards into a .pdf file with playwright, each of them as a page of its size.
+//
+// When `ctx` is canceled in the middle of the conversion, its page is closed immediately.
+func convertSVGToPDF(ctx context.Context, pages []renderedBoard) ([]byte, error) {
+	styles, bodies := []string{}, []string{}
+	for i, page := range pages {
+		styles = append(styles, fmt.Sprintf(`@page board%[1]d { size: %[2]dpx %[3]dpx; } .board%[1]d { page: board%[1]d; }`, i, page.width, page.height))
+		bodies = append(bodies, fmt.Sprintf(`<div class="board board%d">%s</div>`, i, page.svg))
+	}
+
+	return withBrowserPage(ctx, func(page playwright.Page) (bs []byte, err error) {
+		html := fmt.Sprintf(`<!DOCTYPE html>
+<html><head><style>@page { margin: 0; } html, body { margin: 0; padding: 0; } .board { break-after: page; } .board:last-child { break-after: auto; }
+%s</style></head>
+<body>%s</body></html>`, strings.Join(styles, "\n"), strings.Join(bodies, ""))
+
+		if err = page.SetContent(html); err == nil {
+			return page.PDF(playwright.PagePdfOptions{
+				PrintBackground:   toPointer(true),
+				PreferCSSPageSize: toPointer(true),
+			})
+		}
+		return nil, err
+	})
+}
+
+// runs `fn` with a page of the long-lived browser, and returns the page to the pool after.
+//
+// When `ctx` is canceled in the middle of `fn`, the page is closed immediately (not returned to the pool).
+func withBrowserPage(ctx context.Context, fn func(page playwright.Page) ([]byte, error)) (bs []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var page playwright.Page
+	if page, err = browsers.acquire(); err != nil {
+		alerter.record(failureBrowser, err)
+
+		return nil, err
+	}
+
+	converted := make(chan conversion, 1)
+	go func() {
+		bs, err := fn(page)
+		converted <- conversion{bs: bs, err: err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		// close the page, so that the conversion fails fast
+		browsers.release(page, false)
+		<-converted
+
+		return nil, ctx.Err()
+	case res := <-converted:
+		// NOTE: a page of a failed conversion (eg. crashed) is not reused
+		browsers.release(page, res.err == nil)
+
+		if res.err != nil {
+			alerter.record(failureBrowser, res.err)
+
+			return nil, res.err
+		}
+		return res.bs, nil
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+)
+
+// constants for the render cache
+const (
+	defaultCacheMaxEntries = 100
+	defaultCacheMaxSizeMB  = 64
+)
+
+// render cache settings
+type cacheConfig struct {
+	MaxEntries int  `json:"max_entries,omitempty"` // max number of cached renders (= 100 for default)
+	MaxSizeMB  int  `json:"max_size_mb,omitempty"` // max total size of cached renders in megabytes (= 64 for default)
+	Disabled   bool `json:"disabled,omitempty"`
+
+	DiskDir   string `json:"disk_dir,omitempty"`    // directory for caching .svg => .png conversions on disk (not cached if empty)
+	MaxDiskMB int    `json:"max_disk_mb,omitempty"` // max total size of cached conversions on disk in megabytes (= 256 for default)
+}
+
+// a cached render
+type cachedRender struct {
+	key      string
+	bs       []byte
+	warnings []string
+}
+
+// statistics of a cache
+type cacheStats struct {
+	Entries   int
+	Size      int64
+	Hits      int64
+	Misses    int64
+	Evictions int64
+}
+
+// an in-memory cache of renders with LRU eviction
+type renderCache struct {
+	sync.Mutex
+
+	maxEntries int
+	maxSize    int64
+
+	entries map[string]*list.Element // key => element (of *cachedRender) in `lru`
+	lru     *list.List               // most recently used first
+
+	stats cacheStats
+}
+
+// cache of renders (nil if disabled)
+var renders *renderCache
+
+// returns a new render cache with given settings (or nil if it is disabled)
+func newRenderCache(conf *cacheConfig) *renderCache {
+	c := cacheConfig{}
+	if conf != nil {
+		c = *conf
+	}
+	if c.Disabled {
+		return nil
+	}
+	if c.MaxEntries <= 0 {
+		c.MaxEntries = defaultCacheMaxEntries
+	}
+	if c.MaxSizeMB <= 0 {
+		c.MaxSizeMB = defaultCacheMaxSizeMB
+	}
+
+	return &renderCache{
+		maxEntries: c.MaxEntries,
+		maxSize:    int64(c.MaxSizeMB) * 1024 * 1024,
+		entries:    map[string]*list.Element{},
+		lru:        list.New(),
+	}
+}
+
+// returns the cache key of given d2 source rendered with `opts`
+func renderCacheKey(source string, opts renderOptions) string {
+	bs, _ := json.Marshal(opts)
+	hash := sha256.Sum256(append(bs, source...))
+	return hex.EncodeToString(hash[:])
+}
+
+// returns the cached render of `key`
+func (c *renderCache) get(key string) (bs []byte, warnings []string, exists bool) {
+	if c == nil {
+		return nil, nil, false
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if elem, exists := c.entries[key]; exists {
+		c.lru.MoveToFront(elem)
+		c.stats.Hits++
+
+		cached := elem.Value.(*cachedRender)
+		return cached.bs, cached.warnings, true
+	}
+	c.stats.Misses++
+
+	return nil, nil, false
+}
+
+// caches a render with `key`, evicting least recently used ones over the limits
+func (c *renderCache) put(key string, bs []byte, warnings []string) {
+	if c == nil || int64(len(bs)) > c.maxSize {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if elem, exists := c.entries[key]; exists {
+		c.removeElement(elem)
+	}
+	c.entries[key] = c.lru.PushFront(&cachedRender{key: key, bs: bs, warnings: warnings})
+	c.stats.Entries++
+	c.stats.Size += int64(len(bs))
+
+	for c.stats.Entries > c.maxEntries || c.stats.Size > c.maxSize {
+		c.removeElement(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// removes given element (should be called while locked)
+func (c *renderCache) removeElement(elem *list.Element) {
+	cached := c.lru.Remove(elem).(*cachedRender)
+	delete(c.entries, cached.key)
+
+	c.stats.Entries--
+	c.stats.Size -= int64(len(cached.bs))
+}
+
+// removes all cached renders, and returns the number of removed ones
+func (c *renderCache) purge() (purged int) {
+	if c == nil {
+		return 0
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	purged = c.stats.Entries
+	c.entries = map[string]*list.Element{}
+	c.lru.Init()
+	c.stats.Entries, c.stats.Size = 0, 0
+
+	return purged
+}
+
+// returns the statistics of the cache
+func (c *renderCache) statistics() cacheStats {
+	if c == nil {
+		return cacheStats{}
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	return c.stats
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"log"
+	"os"
+	"unicode"
+
+	// fonts
+	"golang.org/x/image/font/sfnt"
+)
+
+// font families of Noto Sans CJK, for each script
+const (
+	cjkFamilyKorean   = "Noto Sans CJK KR"
+	cjkFamilyJapanese = "Noto Sans CJK JP"
+	cjkFamilyChinese  = "Noto Sans CJK SC"
+)
+
+// paths where Noto Sans CJK is installed by package managers (eg. `fonts-noto-cjk` of Debian/Ubuntu)
+var defaultCJKFontPaths = []string{
+	"/usr/share/fonts/opentype/noto/NotoSansCJK-Regular.ttc",     // debian, ubuntu
+	"/usr/share/fonts/noto-cjk/NotoSansCJK-Regular.ttc",          // arch
+	"/usr/share/fonts/google-noto-cjk/NotoSansCJK-Regular.ttc",   // fedora
+	"/usr/share/fonts/truetype/noto/NotoSansCJK-Regular.ttc",     // others
+	"/usr/local/share/fonts/noto/NotoSansCJK-Regular.ttc",        // manually installed
+	"/opt/homebrew/share/fonts/noto-cjk/NotoSansCJK-Regular.ttc", // homebrew
+}
+
+// a font for CJK (chinese, japanese, and korean) characters in labels
+type cjkFontConfig struct {
+	Family string `json:"family,omitempty"` // name of the font family installed on the host (= Noto Sans CJK of the script of labels for default, eg. `Noto Sans CJK KR`)
+	Path   string `json:"path,omitempty"`   // path of its font file (.ttf, .otf, or .ttc), for measuring labels (= installed Noto Sans CJK for default)
+}
+
+// loads the font for CJK characters with given settings (or the installed Noto Sans CJK, if it is not configured)
+//
+// (returns a nil font if there is none)
+func loadCJKFont(conf *cjkFontConfig) (family string, font *sfnt.Font, err error) {
+	if conf != nil {
+		family = conf.Family
+
+		if conf.Path != "" {
+			font, err = loadFontFile(conf.Path)
+			return family, font, err
+		}
+	}
+
+	for _, path := range defaultCJKFontPaths {
+		if _, e := os.Stat(path); e != nil {
+			continue
+		}
+		if font, e := loadFontFile(path); e == nil {
+			return family, font, nil
+		} else {
+			log.Printf("failed to load cjk font '%s': %s", path, e)
+		}
+	}
+	return family, nil, nil
+}
+
+// checks if given rune is a CJK character (or a full-width punctuation or form)
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hangul, unicode.Hiragana, unicode.Katakana) ||
+		(r >= 0x3000 && r <= 0x303F) || // CJK symbols and punctuation
+		(r >= 0xFF00 && r <= 0xFFEF) // half-width and full-width forms
+}
+
+// returns the font family for CJK characters in given text (or an empty string if it has none)
+//
+// (as glyphs of han characters differ in each language, Noto Sans CJK is chosen by scripts in the text: korean for hangul, japanese for kana, and chinese for others)
+func (f *fallbackFonts) cjkFamilyFor(text string) string {
+	hasCJK, hasHangul, hasKana := false, false, false
+	for _, r := range text {
+		if !isCJK(r) {
+			continue
+		}
+		hasCJK = true
+		if unicode.Is(unicode.Hangul, r) {
+			hasHangul = true
+		} else if unicode.In(r, unicode.Hiragana, unicode.Katakana) {
+			hasKana = true
+		}
+	}
+
+	switch {
+	case !hasCJK:
+		return ""
+	case f != nil && f.cjkFamily != "":
+		return f.cjkFamily
+	case hasHangul:
+		return cjkFamilyKorean
+	case hasKana:
+		return cjkFamilyJapanese
+	default:
+		return cjkFamilyChinese
+	}
+}
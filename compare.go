@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// constants for comparisons
+const (
+	commandCompare = "/compare"
+
+	compareGap = 20 // gap between compared images in pixels
+
+	messageCompareUsage = "Usage: reply to a rendered diagram (or a D2 message) with /compare (sketch vs normal), or /compare <theme id> <theme id> (two themes).\n\n(Your last source is used when not replying.)"
+	messageCompareLabel = "left: %s / right: %s"
+)
+
+// labels for comparing previous and new versions of a diagram
+var labelsBeforeAfter = [2]string{"before", "after"}
+
+// handle compare command
+func handleCompareCommand(ctx context.Context, b *tg.Bot, conf config, update tg.Update, args string) {
+	if message, _ := update.GetMessage(); message != nil {
+		userID := message.From.ID
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		// options of both sides: sketch vs normal (default), or two themes
+		left, right := renderOptionsForChat(conf, userID, chatID), renderOptionsForChat(conf, userID, chatID)
+		left.Sketch, right.Sketch = false, true
+		labels := [2]string{"normal", "sketch"}
+
+		switch tokens := splitArgs(args); len(tokens) {
+		case 0:
+		case 2:
+			var err error
+			if left.ThemeID, err = parseThemeID(tokens[0]); err != nil {
+				replyError(b, chatID, messageID, err.Error())
+				return
+			}
+			if right.ThemeID, err = parseThemeID(tokens[1]); err != nil {
+				replyError(b, chatID, messageID, err.Error())
+				return
+			}
+			right.Sketch = left.Sketch
+			labels = [2]string{"theme " + tokens[0], "theme " + tokens[1]}
+		default:
+			replyError(b, chatID, messageID, messageCompareUsage)
+			return
+		}
+
+		// source from the replied message, or the last one
+		var source string
+		if message.ReplyToMessage != nil {
+			sources, err := sourcesOfRepliedMessage(b, message.ReplyToMessage)
+			if err != nil {
+				replyError(b, chatID, messageID, err.Error())
+				return
+			}
+			if len(sources) > 1 {
+				replyError(b, chatID, messageID, messageMultipleSources)
+				return
+			}
+			source = sources[0]
+		} else if entries := storage.recentHistory(userID, 1); len(entries) > 0 {
+			source = entries[0].Source
+		} else {
+			replyError(b, chatID, messageID, messageCompareUsage)
+			return
+		}
+
+		replyCompared(ctx, b, conf, userID, chatID, messageID, [2]string{source, source}, [2]renderOptions{left, right}, labels)
+	}
+}
+
+// renders `sources` with `opts` respectively, and replies to `messageID` with them stitched side by side
+func replyCompared(ctx context.Context, b *tg.Bot, conf config, userID, chatID, messageID int64, sources [2]string, opts [2]renderOptions, labels [2]string) {
+	// NOTE: comparisons are not deferred out of access hours, but refused
+	if err := checkAccessHours(ctx); err != nil {
+		replyError(b, chatID, messageID, err.Error())
+		return
+	}
+
+	ctx, done := jobs.start(ctx, userID)
+	defer done()
+
+	_ = b.SendChatAction(chatID, tg.ChatActionUploadPhoto, nil)
+
+	progress := startProgress(ctx, b, conf, chatID, messageID)
+
+	rendered := [][]byte{}
+	for i, o := range opts {
+		o.Format = formatPNG
+
+		bs, _, err := renderDiagram(ctx, sources[i], o, func(stage string) {
+			progress.report(fmt.Sprintf("%s (%d/%d)", stage, i+1, len(opts)))
+		})
+		if err != nil {
+			progress.finish()
+
+			if !errors.Is(err, context.Canceled) {
+				logf(ctx, "failed to render for comparison: %s", err)
+
+				replyError(b, chatID, messageID, fmt.Sprintf("Failed to render message: %s", explainError(sources[i], err)))
+			}
+			return
+		}
+		rendered = append(rendered, bs)
+	}
+	progress.finish()
+
+	stitched, err := stitchSideBySide(rendered...)
+	if err != nil {
+		logf(ctx, "failed to stitch images: %s", err)
+
+		replyError(b, chatID, messageID, fmt.Sprintf("Failed to compare renders: %s", err))
+		return
+	}
+
+	format := formatPNG
+	if opts[0].Format == formatPhoto {
+		format = formatPhoto
+	}
+	if sentID, ok := sendRenderedFile(ctx, b, chatID, messageID, stitched, format, toPointer(html.EscapeString(fmt.Sprintf(messageCompareLabel, labels[0], labels[1])))); ok {
+		// remember the (right-side) source of the comparison, so that it can be re-rendered later
+		if err := storage.saveRenders(chatID, messageID, map[int64]string{sentID: sources[1]}); err != nil {
+			logf(ctx, "failed to save source of comparison: %s", err)
+		}
+	}
+}
+
+// stitches given .png images side by side (from left to right) on a white background, and returns it as a .png image
+func stitchSideBySide(pngs ...[]byte) ([]byte, error) {
+	images := []image.Image{}
+	width, height := 0, 0
+	for i, bs := range pngs {
+		img, err := png.Decode(bytes.NewReader(bs))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode image #%d: %w", i+1, err)
+		}
+		images = append(images, img)
+
+		if i > 0 {
+			width += compareGap
+		}
+		width += img.Bounds().Dx()
+		height = max(height, img.Bounds().Dy())
+	}
+
+	stitched := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(stitched, stitched.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+
+	x := 0
+	for _, img := range images {
+		bounds := img.Bounds()
+		draw.Draw(stitched, image.Rect(x, 0, x+bounds.Dx(), bounds.Dy()), img, bounds.Min, draw.Over)
+		x += bounds.Dx() + compareGap
+	}
+
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, stitched); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
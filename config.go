@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	// others
+	"github.com/tailscale/hujson"
+)
+
+// struct for configuration
+type config struct {
+	// configurations
+	AllowedIDs      []string            `json:"allowed_ids"`
+	AdminIDs        []string            `json:"admin_ids,omitempty"`    // allowed users who can also manage the bot (eg. /reload)
+	ReadonlyIDs     []string            `json:"readonly_ids,omitempty"` // users who can only fetch diagrams from the shared library
+	Permissions     map[string][]string `json:"permissions,omitempty"`  // command (or `render`) => roles, usernames, or patterns permitted to use it
+	MonitorInterval int                 `json:"monitor_interval"`
+
+	// seconds to wait before sending a status message for long renders
+	ProgressThreshold int `json:"progress_threshold,omitempty"`
+
+	// directory for data, caches, and logs of this bot (= XDG base directories for default, eg. `~/.local/share/telegram-d2-bot`)
+	DataDir string `json:"data_dir,omitempty"`
+
+	// filepath of the persistent store (saved diagrams, ...)
+	StoreFilepath string `json:"store_filepath,omitempty"`
+
+	// id of a channel (administered by this bot) for sharing diagrams
+	SharedChannelID int64 `json:"shared_channel_id,omitempty"`
+
+	// max number of diagrams in a message or a file
+	MaxDiagramsPerMessage int `json:"max_diagrams_per_message,omitempty"`
+
+	// parameters of polling updates (`polling_timeout` > 0 for long polling)
+	PollingTimeout int `json:"polling_timeout,omitempty"`
+	PollingLimit   int `json:"polling_limit,omitempty"`
+
+	// filepath of the append-only audit log (JSON lines, not written if empty)
+	AuditLogFilepath string `json:"audit_log_filepath,omitempty"`
+
+	// seconds between checks of a rotated bot token in the secret backend (0 for no checks)
+	SecretPollInterval int `json:"secret_poll_interval,omitempty"`
+
+	// seconds between refreshes of a remote config (or values from secret backends), applied when changed (0 for no refreshes)
+	ConfigRefreshInterval int `json:"config_refresh_interval,omitempty"`
+
+	// who can trigger renders in groups: `everyone` (default) or `admins_only` (administrators of the groups)
+	GroupPolicy string `json:"group_policy,omitempty"`
+
+	// hours when renders are (not) available for users or chats
+	AccessHours []accessHoursConfig `json:"access_hours,omitempty"`
+
+	// retries of renders (and sends) failed with transient errors, eg. crashes of the browser or hiccups of telegram
+	Retries *retriesConfig `json:"retries,omitempty"`
+
+	// alerts of failures to an admin chat
+	Alerts *alertsConfig `json:"alerts,omitempty"`
+
+	// max number of requests of each user in a minute (0 for unlimited)
+	RateLimit int `json:"rate_limit,omitempty"`
+
+	// concurrent renders (eg. for not running out of memory with many conversions in the browser at once)
+	RenderQueue *renderQueueConfig `json:"render_queue,omitempty"`
+
+	// cache of renders
+	Cache *cacheConfig `json:"cache,omitempty"`
+
+	// headless browser for .png (and .pdf) conversions
+	Browser *browserConfig `json:"browser,omitempty"`
+
+	// inline mode (eg. `@bot a -> b` in any chat)
+	Inline *inlineConfig `json:"inline,omitempty"`
+
+	// min number of shapes of a diagram for sending a quick .svg preview before its .png render (0 for no previews)
+	PreviewMinShapes int `json:"preview_min_shapes,omitempty"`
+
+	// default output format of renders (`png`, `photo`, `svg`, or `pdf`; = `png` for default)
+	Format string `json:"format,omitempty"`
+
+	// d2 rendering style
+	ThemeID int64  `json:"theme_id,omitempty"` // NOTE: pick `ID` from https://github.com/terrastruct/d2/tree/master/d2themes/d2themescatalog
+	Sketch  bool   `json:"sketch,omitempty"`
+	Palette string `json:"palette,omitempty"` // color-blind-safe palette (`deuteranopia` or `protanopia`)
+
+	// default layout engine of renders (`dagre`, `elk`, or `tala` if it is installed; = `dagre` for default)
+	Layout string `json:"layout,omitempty"`
+
+	// layout engine to fall back to, when the layout engine fails (or exceeds its time budget) on a diagram
+	LayoutFallback *layoutFallbackConfig `json:"layout_fallback,omitempty"`
+
+	// whether to reject diagrams with LaTeX blocks (eg. for saving resources, as each block is rendered with MathJax)
+	DisableLaTeX bool `json:"disable_latex,omitempty"`
+
+	// choosing orientations of diagrams to fit phone screens
+	Orientation *orientationConfig `json:"orientation,omitempty"`
+
+	// night mode: rendering with a dark theme at night (or when toggled in chats)
+	NightMode *nightModeConfig `json:"night_mode,omitempty"`
+
+	// local .d2 files to watch, and chats where their renders are posted when they are changed
+	Watches       []watchConfig `json:"watches,omitempty"`
+	WatchInterval int           `json:"watch_interval,omitempty"` // seconds between checks of watched files (= 2 for default)
+
+	// maximum number of remote urls watched (with /watch) in each chat (= 5 for default)
+	MaxURLWatchesPerChat int `json:"max_url_watches_per_chat,omitempty"`
+
+	// fallback fonts for glyphs (eg. emoji and symbols) missing in the default font
+	Fonts *fontsConfig `json:"fonts,omitempty"`
+
+	// base url for resolving imports in d2 sources
+	Imports *importsConfig `json:"imports,omitempty"`
+
+	// web viewer for interactive .svg files of renders
+	Viewer *viewerConfig `json:"viewer,omitempty"`
+
+	// telegraph account for publishing renders as articles
+	Telegraph *telegraphConfig `json:"telegraph,omitempty"`
+
+	// backend for translating labels of diagrams (with /translate)
+	Translation *translationConfig `json:"translation,omitempty"`
+
+	// d2 templates with `${placeholders}`
+	Templates map[string]templateConfig `json:"templates,omitempty"`
+
+	// logging
+	IsVerbose bool           `json:"is_verbose,omitempty"`
+	LogFile   *logFileConfig `json:"log_file,omitempty"` // log to a rotated file instead of standard error
+
+	// Bot API token
+	BotToken string `json:"bot_token,omitempty"`
+
+	// or a secret provider for the bot token (inferred from the settings below if empty)
+	SecretProvider string `json:"secret_provider,omitempty"`
+
+	// or name of an environment variable with the bot token
+	BotTokenEnv string `json:"bot_token_env,omitempty"`
+
+	// or path of a file with the bot token
+	BotTokenFile string `json:"bot_token_file,omitempty"`
+
+	// or Infisical settings
+	Infisical *infisicalConfig `json:"infisical,omitempty"`
+
+	// or HashiCorp Vault settings
+	Vault *vaultConfig `json:"vault,omitempty"`
+
+	// or AWS Secrets Manager / SSM Parameter Store settings
+	AWS *awsConfig `json:"aws,omitempty"`
+
+	// or 1Password Connect settings
+	OnePassword *onePasswordConfig `json:"onepassword,omitempty"`
+
+	hasSecretReferences bool // whether the config has references to secrets (eg. `${infisical:/path/to/KEY}`), set on load
+}
+
+// read config file
+// (or fetch it when `filepath` is an https URL, or bootstrap one from environment when it is empty)
+func loadConfig(filepath string) (conf config, err error) {
+	if filepath == "" {
+		return configFromEnv()
+	}
+
+	var bytes []byte
+	if isRemoteConfig(filepath) {
+		bytes, err = fetchRemoteConfig(filepath)
+	} else {
+		bytes, err = os.ReadFile(filepath)
+	}
+	if err == nil {
+		// decrypt age-encrypted config in memory
+		if isAgeEncrypted(bytes) {
+			if bytes, err = decryptAge(bytes); err != nil {
+				return config{}, err
+			}
+		}
+
+		if bytes, err = standardizeJSON(bytes); err == nil {
+			// decrypt SOPS-encrypted config in memory
+			if isSOPSEncrypted(bytes) {
+				if bytes, err = decryptSOPS(bytes); err != nil {
+					return config{}, err
+				}
+			}
+
+			hasSecretReferences := secretReference.Match(bytes)
+
+			// resolve local secret references (eg. `${file:/run/secrets/KEY}`) in settings of secret providers
+			if bytes, err = resolveSecretReferences(bytes, localSecretResolvers(), true); err != nil {
+				return config{}, fmt.Errorf("failed to resolve secret references in secret provider settings: %s", err)
+			}
+
+			if err = json.Unmarshal(bytes, &conf); err == nil {
+				// resolve secret references (eg. `${infisical:/path/to/KEY}`) in other values
+				if bytes, err = resolveSecretReferences(bytes, secretResolvers(conf), false); err != nil {
+					return config{}, fmt.Errorf("failed to resolve secret references: %s", err)
+				}
+				if err = json.Unmarshal(bytes, &conf); err != nil {
+					return config{}, err
+				}
+				conf.hasSecretReferences = hasSecretReferences
+
+				// read bot token from the secret provider
+				if conf.BotToken, err = botTokenFromSecretProvider(conf); err != nil {
+					return config{}, fmt.Errorf("failed to retrieve telegram bot token: %s", err)
+				}
+			}
+		}
+	}
+
+	return conf, err
+}
+
+// standardize given JSON (JWCC) bytes
+func standardizeJSON(b []byte) ([]byte, error) {
+	ast, err := hujson.Parse(b)
+	if err != nil {
+		return b, err
+	}
+	ast.Standardize()
+
+	return ast.Pack(), nil
+}
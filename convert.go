@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// constants for conversion commands
+const (
+	commandSVG = "/svg"
+	commandPNG = "/png"
+	commandPDF = "/pdf"
+
+	messageConversionUsage = "Usage: reply to a rendered diagram (or a D2 message) with /%s [scale=N] [preset=print] [direction=right|down|left|up|auto]"
+)
+
+// conversion commands and their output formats
+var conversionCommands = map[string]outputFormat{
+	commandSVG: formatSVG,
+	commandPNG: formatPNG,
+	commandPDF: formatPDF,
+}
+
+// handle conversion commands (/svg, /png, /pdf)
+func handleConversionCommand(ctx context.Context, b *tg.Bot, conf config, update tg.Update, format outputFormat, args string) {
+	if message, _ := update.GetMessage(); message != nil {
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		if message.ReplyToMessage == nil {
+			replyError(b, chatID, messageID, fmt.Sprintf(messageConversionUsage, format))
+			return
+		}
+
+		values, others := parseKeyValues(splitArgs(args))
+		if len(others) > 0 {
+			replyError(b, chatID, messageID, fmt.Sprintf(messageConversionUsage, format))
+			return
+		}
+
+		opts := renderOptionsForChat(conf, message.From.ID, chatID)
+		opts.Format = format
+
+		var err error
+		if opts, err = applyRenderArgs(opts, values); err != nil {
+			replyError(b, chatID, messageID, err.Error())
+			return
+		}
+
+		if sources, err := sourcesOfRepliedMessage(b, message.ReplyToMessage); err == nil {
+			replyRenderedWithOptions(ctx, b, conf, message.From.ID, chatID, messageID, sources, opts, "")
+		} else {
+			replyError(b, chatID, messageID, err.Error())
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// environment variables of the d2 cli, used as defaults of render options
+const (
+	envD2Theme  = "D2_THEME"
+	envD2Layout = "D2_LAYOUT"
+	envD2Pad    = "D2_PAD"
+	envD2Sketch = "D2_SKETCH"
+)
+
+// returns default render options from environment variables of the d2 cli
+// (`D2_THEME`, `D2_LAYOUT`, `D2_PAD`, and `D2_SKETCH`), which are overridden by the config and options of each message
+//
+// (invalid values are logged and ignored)
+var d2EnvDefaults = sync.OnceValue(func() (opts renderOptions) {
+	opts.Layout = layoutDagre
+
+	for env, option := range map[string]string{
+		envD2Theme:  renderOptionTheme,
+		envD2Layout: renderOptionLayout,
+		envD2Sketch: renderOptionSketch,
+	} {
+		if value, exists := os.LookupEnv(env); exists && value != "" {
+			if applied, err := applyRenderArgs(opts, map[string]string{option: value}); err == nil {
+				opts = applied
+			} else {
+				log.Printf("ignoring $%s: %s", env, err)
+			}
+		}
+	}
+
+	if value, exists := os.LookupEnv(envD2Pad); exists && value != "" {
+		if padding, err := strconv.ParseInt(value, 10, 64); err == nil && padding >= 0 {
+			opts.Padding = toPointer(padding)
+		} else {
+			log.Printf("ignoring $%s: not a valid padding: '%s'", envD2Pad, value)
+		}
+	}
+
+	return opts
+})
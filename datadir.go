@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// constants for directories of this bot
+const (
+	appDirName = "telegram-d2-bot"
+
+	tempDirName = "tmp"
+)
+
+// directories for files of this bot
+type dataDirs struct {
+	data  string // persistent data (eg. the store file)
+	cache string // caches which can be removed anytime (eg. converted .png files)
+	state string // state which is not worth backing up (eg. logs)
+	temp  string // temporary files (eg. files being uploaded), cleared on start
+}
+
+// directories of this bot (prepared on start)
+var dirs dataDirs
+
+// returns directories for `data_dir` in the config (or XDG base directories, if it is not configured)
+//
+// https://specifications.freedesktop.org/basedir-spec/latest/
+func dataDirsOf(conf config) (d dataDirs, err error) {
+	if conf.DataDir != "" {
+		d = dataDirs{
+			data:  conf.DataDir,
+			cache: filepath.Join(conf.DataDir, "cache"),
+			state: filepath.Join(conf.DataDir, "state"),
+		}
+	} else {
+		var home string
+		if home, err = os.UserHomeDir(); err != nil {
+			return dataDirs{}, fmt.Errorf("failed to find home directory (set `data_dir` in the config instead): %w", err)
+		}
+		d = dataDirs{
+			data:  xdgDir("XDG_DATA_HOME", filepath.Join(home, ".local", "share")),
+			cache: xdgDir("XDG_CACHE_HOME", filepath.Join(home, ".cache")),
+			state: xdgDir("XDG_STATE_HOME", filepath.Join(home, ".local", "state")),
+		}
+	}
+	d.temp = filepath.Join(d.cache, tempDirName)
+
+	return d, nil
+}
+
+// returns the directory of this bot in the XDG base directory of given environment variable (or `fallback` if it is not set)
+//
+// (relative paths in the variable are ignored, as the spec says)
+func xdgDir(env, fallback string) string {
+	if dir := os.Getenv(env); filepath.IsAbs(dir) {
+		return filepath.Join(dir, appDirName)
+	}
+	return filepath.Join(fallback, appDirName)
+}
+
+// creates directories (clearing the temporary one) and checks if they are writable
+func prepareDataDirs(conf config) (d dataDirs, err error) {
+	if d, err = dataDirsOf(conf); err != nil {
+		return dataDirs{}, err
+	}
+
+	// NOTE: temporary files left by a crash are removed
+	if err = os.RemoveAll(d.temp); err != nil {
+		return dataDirs{}, fmt.Errorf("failed to clear temporary directory '%s': %w", d.temp, err)
+	}
+
+	for _, dir := range []string{d.data, d.cache, d.state, d.temp} {
+		if err = os.MkdirAll(dir, 0700); err != nil {
+			return dataDirs{}, fmt.Errorf("failed to create directory '%s': %w", dir, err)
+		}
+		if err = checkWritable(dir); err != nil {
+			return dataDirs{}, err
+		}
+	}
+	return d, nil
+}
+
+// checks if a file can be written in given directory
+func checkWritable(dir string) error {
+	file, err := os.CreateTemp(dir, ".check-")
+	if err != nil {
+		return fmt.Errorf("directory '%s' is not writable: %w", dir, err)
+	}
+	_ = file.Close()
+
+	return os.Remove(file.Name())
+}
+
+// returns given path as it is if it is empty or absolute, or joined with `dir` if it is relative
+func resolvePath(dir, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+
+	// d2
+	"oss.terrastruct.com/d2/d2graph"
+)
+
+// constants for diffs
+const (
+	commandDiff = "/diff"
+
+	diffFlagVisual = "--visual"
+
+	// colors for highlighting changes
+	diffColorAdded    = "#2e7d32"
+	diffColorRemoved  = "#c62828"
+	diffColorModified = "#ef6c00"
+
+	messageDiffUsage     = "Usage: reply to a D2 message (or a rendered diagram) with /diff [--visual] <name>, or send it followed by a D2 source in the next lines, to compare it with the saved diagram of given name."
+	messageDiffNoChanges = "No changes from '%s'."
+	messageDiffHeader    = "Changes from '%s':\n\n"
+)
+
+// keys of d2 which do not need quotes
+var unquotedKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// changes between two versions of a diagram
+type diagramDiff struct {
+	Added, Removed, Modified                []*d2graph.Object
+	AddedEdges, RemovedEdges, ModifiedEdges []*d2graph.Edge
+}
+
+// returns true if there is no change
+func (d diagramDiff) empty() bool {
+	return len(d.Added)+len(d.Removed)+len(d.Modified)+len(d.AddedEdges)+len(d.RemovedEdges)+len(d.ModifiedEdges) == 0
+}
+
+// returns a summary of the changes
+func (d diagramDiff) summary() string {
+	lines := []string{}
+	for _, group := range []struct {
+		label   string
+		objects []*d2graph.Object
+		edges   []*d2graph.Edge
+	}{
+		{"➕ added", d.Added, d.AddedEdges},
+		{"➖ removed", d.Removed, d.RemovedEdges},
+		{"✏️ modified", d.Modified, d.ModifiedEdges},
+	} {
+		ids := []string{}
+		for _, obj := range group.objects {
+			ids = append(ids, obj.AbsID())
+		}
+		for _, edge := range group.edges {
+			ids = append(ids, edge.AbsID())
+		}
+		if len(ids) > 0 {
+			lines = append(lines, fmt.Sprintf("%s: %s", group.label, strings.Join(ids, ", ")))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// returns a comparable signature of the attributes of an object or an edge
+func attributesSignature(attrs d2graph.Attributes) string {
+	signature := attrs.Label.Value + "\x00" + attrs.Shape.Value
+	for _, style := range []*d2graph.Scalar{attrs.Style.Stroke, attrs.Style.Fill, attrs.Style.StrokeWidth, attrs.Style.StrokeDash, attrs.Style.FontSize} {
+		signature += "\x00"
+		if style != nil {
+			signature += style.Value
+		}
+	}
+	return signature
+}
+
+// diffGraphs computes added, removed, and modified objects and edges from `before` to `after`.
+func diffGraphs(before, after *d2graph.Graph) (diff diagramDiff) {
+	objects := map[string]*d2graph.Object{}
+	for _, obj := range before.Objects {
+		objects[obj.AbsID()] = obj
+	}
+	for _, obj := range after.Objects {
+		if prev, exists := objects[obj.AbsID()]; !exists {
+			diff.Added = append(diff.Added, obj)
+		} else {
+			if attributesSignature(prev.Attributes) != attributesSignature(obj.Attributes) {
+				diff.Modified = append(diff.Modified, obj)
+			}
+			delete(objects, obj.AbsID())
+		}
+	}
+	for _, obj := range objects {
+		diff.Removed = append(diff.Removed, obj)
+	}
+	sort.Slice(diff.Removed, func(i, j int) bool { return diff.Removed[i].AbsID() < diff.Removed[j].AbsID() })
+
+	edges := map[string]*d2graph.Edge{}
+	for _, edge := range before.Edges {
+		edges[edge.AbsID()] = edge
+	}
+	for _, edge := range after.Edges {
+		if prev, exists := edges[edge.AbsID()]; !exists {
+			diff.AddedEdges = append(diff.AddedEdges, edge)
+		} else {
+			if attributesSignature(prev.Attributes) != attributesSignature(edge.Attributes) {
+				diff.ModifiedEdges = append(diff.ModifiedEdges, edge)
+			}
+			delete(edges, edge.AbsID())
+		}
+	}
+	for _, edge := range edges {
+		diff.RemovedEdges = append(diff.RemovedEdges, edge)
+	}
+	sort.Slice(diff.RemovedEdges, func(i, j int) bool { return diff.RemovedEdges[i].AbsID() < diff.RemovedEdges[j].AbsID() })
+
+	return diff
+}
+
+// returns the key of given object for referencing it in a d2 source (eg. `cloud."my server"`)
+func objectKey(obj *d2graph.Object) string {
+	keys := []string{}
+	for ; obj != nil && obj.Parent != nil; obj = obj.Parent { // (root has no parent)
+		key := obj.IDVal
+		if !unquotedKey.MatchString(key) {
+			key = fmt.Sprintf("%q", key)
+		}
+		keys = append([]string{key}, keys...)
+	}
+	return strings.Join(keys, ".")
+}
+
+// returns the key of given edge for referencing it in a d2 source (eg. `(a -> b)[0]`)
+func edgeKey(edge *d2graph.Edge) string {
+	return fmt.Sprintf("(%s %s %s)[%d]", objectKey(edge.Src), edgeArrow(edge), objectKey(edge.Dst), edge.Index)
+}
+
+// returns the arrow of given edge (eg. `->`)
+func edgeArrow(edge *d2graph.Edge) string {
+	switch {
+	case edge.SrcArrow && edge.DstArrow:
+		return "<->"
+	case edge.SrcArrow:
+		return "<-"
+	case edge.DstArrow:
+		return "->"
+	default:
+		return "--"
+	}
+}
+
+// returns the `after` source with its changes highlighted:
+// added ones in green, modified ones in orange, and removed ones in dashed red.
+func highlightDiff(after string, diff diagramDiff) string {
+	lines := []string{after, "", "# highlighted changes"}
+
+	for _, obj := range diff.Added {
+		lines = append(lines, fmt.Sprintf("%s.style: {stroke: %q; stroke-width: 4}", objectKey(obj), diffColorAdded))
+	}
+	for _, obj := range diff.Modified {
+		lines = append(lines, fmt.Sprintf("%s.style: {stroke: %q; stroke-width: 4}", objectKey(obj), diffColorModified))
+	}
+	for _, obj := range diff.Removed {
+		lines = append(lines, fmt.Sprintf("%s.style: {stroke: %q; stroke-dash: 3; opacity: 0.5}", objectKey(obj), diffColorRemoved))
+	}
+	for _, edge := range diff.AddedEdges {
+		lines = append(lines, fmt.Sprintf("%s.style: {stroke: %q; stroke-width: 4}", edgeKey(edge), diffColorAdded))
+	}
+	for _, edge := range diff.ModifiedEdges {
+		lines = append(lines, fmt.Sprintf("%s.style: {stroke: %q; stroke-width: 4}", edgeKey(edge), diffColorModified))
+	}
+	for _, edge := range diff.RemovedEdges {
+		// (re-add removed edges as new ones)
+		lines = append(lines, fmt.Sprintf("%s %s %s: {style: {stroke: %q; stroke-dash: 3; opacity: 0.5}}", objectKey(edge.Src), edgeArrow(edge), objectKey(edge.Dst), diffColorRemoved))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// handle diff command
+func handleDiffCommand(ctx context.Context, b *tg.Bot, conf config, update tg.Update, args string) {
+	if message, _ := update.GetMessage(); message != nil {
+		userID := message.From.ID
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		// parse arguments: [--visual] <name>, with an optional source in the following lines
+		firstLine, source, _ := strings.Cut(args, "\n")
+		source = strings.TrimSpace(source)
+		visual, name := false, ""
+		for _, token := range splitArgs(firstLine) {
+			if token == diffFlagVisual {
+				visual = true
+			} else if name == "" {
+				name = token
+			} else {
+				replyError(b, chatID, messageID, messageDiffUsage)
+				return
+			}
+		}
+		if name == "" {
+			replyError(b, chatID, messageID, messageDiffUsage)
+			return
+		}
+
+		entry, exists := storage.loadFromLibrary(userID, name)
+		if !exists {
+			replyError(b, chatID, messageID, fmt.Sprintf(messageNoSuchDiagram, name))
+			return
+		}
+
+		if source == "" {
+			if message.ReplyToMessage == nil {
+				replyError(b, chatID, messageID, messageDiffUsage)
+				return
+			}
+
+			sources, err := sourcesOfRepliedMessage(b, message.ReplyToMessage)
+			if err != nil {
+				replyError(b, chatID, messageID, err.Error())
+				return
+			}
+			if len(sources) > 1 {
+				replyError(b, chatID, messageID, messageMultipleSources)
+				return
+			}
+			source = sources[0]
+		}
+
+		before, err := compileGraph(entry.Source)
+		if err != nil {
+			replyError(b, chatID, messageID, fmt.Sprintf("Failed to compile '%s': %s", name, err))
+			return
+		}
+		after, err := compileGraph(source)
+		if err != nil {
+			replyError(b, chatID, messageID, fmt.Sprintf("Failed to compile the new source: %s", explainError(source, err)))
+			return
+		}
+
+		diff := diffGraphs(before, after)
+		if diff.empty() {
+			replyText(b, chatID, messageID, fmt.Sprintf(messageDiffNoChanges, name))
+			return
+		}
+
+		replyText(b, chatID, messageID, fmt.Sprintf(messageDiffHeader, name)+diff.summary())
+
+		if visual {
+			replyRendered(ctx, b, conf, userID, chatID, messageID, []string{highlightDiff(source, diff)})
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// constants for the disk cache
+const (
+	defaultDiskCacheMaxSizeMB = 256
+
+	conversionCacheExtension = ".png"
+	renderCacheExtension     = ".render"
+	renderCacheDirName       = "renders"
+)
+
+// an on-disk cache of files keyed by hashes, which survives restarts
+type diskCache struct {
+	sync.Mutex
+
+	dir       string
+	extension string
+	maxSize   int64
+
+	hits, misses, evictions int64
+}
+
+// cache of svg => png conversions (nil if not configured)
+var conversions *diskCache
+
+// cache of renders keyed by their sources and options (nil if not configured)
+var renderFiles *diskCache
+
+// returns new disk caches of conversions and renders with given settings (or nil if `disk_dir` is not configured)
+//
+// (renders are cached in a subdirectory of `disk_dir`, and each cache has its own size limit)
+func newDiskCaches(conf *cacheConfig) (converted, rendered *diskCache, err error) {
+	if conf == nil || conf.DiskDir == "" {
+		return nil, nil, nil
+	}
+
+	dir := resolvePath(dirs.cache, conf.DiskDir)
+	if converted, err = newDiskCache(dir, conversionCacheExtension, conf.MaxDiskMB); err == nil {
+		rendered, err = newDiskCache(filepath.Join(dir, renderCacheDirName), renderCacheExtension, conf.MaxDiskMB)
+	}
+	return converted, rendered, err
+}
+
+// returns a new disk cache of files with `extension` in `dir`
+func newDiskCache(dir, extension string, maxSizeMB int) (*diskCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	if maxSizeMB <= 0 {
+		maxSizeMB = defaultDiskCacheMaxSizeMB
+	}
+
+	return &diskCache{
+		dir:       dir,
+		extension: extension,
+		maxSize:   int64(maxSizeMB) * 1024 * 1024,
+	}, nil
+}
+
+// returns the hash of given bytes (eg. svg of a conversion) as a key of disk caches
+func hashKey(bs []byte) string {
+	hash := sha256.Sum256(bs)
+	return hex.EncodeToString(hash[:])
+}
+
+// returns the filepath of the cached file of `key`
+func (c *diskCache) filepathOf(key string) string {
+	return filepath.Join(c.dir, key+c.extension)
+}
+
+// returns the cached file of `key`
+func (c *diskCache) get(key string) (bs []byte, exists bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	path := c.filepathOf(key)
+
+	c.Lock()
+	defer c.Unlock()
+
+	var err error
+	if bs, err = os.ReadFile(path); err == nil {
+		c.hits++
+
+		// NOTE: touch the file for LRU eviction
+		now := time.Now()
+		_ = os.Chtimes(path, now, now)
+
+		return bs, true
+	}
+	c.misses++
+
+	return nil, false
+}
+
+// caches `bs` with `key`, evicting least recently used ones over the size limit
+func (c *diskCache) put(key string, bs []byte) {
+	if c == nil || int64(len(bs)) > c.maxSize {
+		return
+	}
+
+	path := c.filepathOf(key)
+
+	c.Lock()
+	defer c.Unlock()
+
+	// write to a temporary file first, then rename it
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, bs, 0600); err != nil {
+		log.Printf("failed to write disk cache: %s", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		log.Printf("failed to write disk cache: %s", err)
+		return
+	}
+
+	c.evict()
+}
+
+// cached files (should be called while locked)
+func (c *diskCache) files() (files []os.FileInfo, size int64) {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		log.Printf("failed to read disk cache: %s", err)
+		return nil, 0
+	}
+
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != c.extension {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			files = append(files, info)
+			size += info.Size()
+		}
+	}
+	return files, size
+}
+
+// removes least recently used files over the size limit (should be called while locked)
+func (c *diskCache) evict() {
+	files, size := c.files()
+	if size <= c.maxSize {
+		return
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+	for _, file := range files {
+		if size <= c.maxSize {
+			break
+		}
+		if err := os.Remove(filepath.Join(c.dir, file.Name())); err == nil {
+			size -= file.Size()
+			c.evictions++
+		}
+	}
+}
+
+// removes all cached files, and returns the number of removed ones
+func (c *diskCache) purge() (purged int) {
+	if c == nil {
+		return 0
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	files, _ := c.files()
+	for _, file := range files {
+		if err := os.Remove(filepath.Join(c.dir, file.Name())); err == nil {
+			purged++
+		}
+	}
+	return purged
+}
+
+// returns the statistics of the cache
+func (c *diskCache) statistics() cacheStats {
+	if c == nil {
+		return cacheStats{}
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	files, size := c.files()
+	return cacheStats{
+		Entries:   len(files),
+		Size:      size,
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+	}
+}
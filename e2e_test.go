@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// constants for the end-to-end test
+const (
+	envE2EBotToken = "E2E_BOT_TOKEN" // token of a bot created in the test environment
+	envE2EChatID   = "E2E_CHAT_ID"   // id of a chat (in the test environment) where the bot can send messages
+
+	e2eSource  = "harness -> telegram: poll\nharness -> d2: render\nd2 -> telegram: send"
+	e2eTimeout = 2 * time.Minute
+)
+
+// tests the bot layer end-to-end against the test environment of telegram:
+// polling updates, rendering a diagram, and sending it to a chat
+//
+// (skipped without `E2E_BOT_TOKEN` and `E2E_CHAT_ID`)
+func TestE2E(t *testing.T) {
+	token, chatIDStr := os.Getenv(envE2EBotToken), os.Getenv(envE2EChatID)
+	if token == "" || chatIDStr == "" {
+		t.Skipf("$%s and $%s are not set", envE2EBotToken, envE2EChatID)
+	}
+	chatID, err := strconv.ParseInt(chatIDStr, 10, 64)
+	if err != nil {
+		t.Fatalf("$%s is not a chat id: %s", envE2EChatID, err)
+	}
+
+	useTestDC = true
+	t.Cleanup(func() { useTestDC = false })
+
+	// NOTE: sends save file ids of rendered files in the store
+	if storage, err = openStore(filepath.Join(t.TempDir(), defaultStoreFilename)); err != nil {
+		t.Fatalf("failed to open store: %s", err)
+	}
+	t.Cleanup(func() { storage = nil })
+
+	ctx, cancel := context.WithTimeout(context.Background(), e2eTimeout)
+	defer cancel()
+	defer closeBrowser()
+
+	client := newClient(token)
+
+	opts := d2EnvDefaults()
+	opts.Format = formatPNG
+	opts.Scale = 1.0
+	if !browserAvailable {
+		opts.Format = formatSVG
+	}
+
+	var rendered []byte
+	var sentID int64
+	steps := []struct {
+		name string
+		run  func() error
+	}{
+		{"get bot", func() error {
+			if me := client.GetMe(); !me.Ok {
+				return fmt.Errorf("%s", *me.Description)
+			}
+			return nil
+		}},
+		{"delete webhook", func() error {
+			if deleted := client.DeleteWebhook(false); !deleted.Ok {
+				return fmt.Errorf("%s", *deleted.Description)
+			}
+			return nil
+		}},
+		{"poll updates", func() (err error) {
+			_, err = getUpdates(ctx, &http.Client{Timeout: pollingRequestMargin}, apiToken(token), pollingParams{Limit: 1})
+			return err
+		}},
+		{"prepare browser", func() error {
+			if opts.Format.needsBrowser() {
+				return prepareBrowser(browserConfigOf(config{}))
+			}
+			return nil
+		}},
+		{"render " + string(opts.Format), func() (err error) {
+			rendered, _, err = renderDiagram(ctx, e2eSource, opts, nil)
+			return err
+		}},
+		{"send rendered file", func() error {
+			var sent bool
+			if sentID, sent = sendRenderedFile(ctx, client, chatID, 0, rendered, opts.Format, toPointer("end-to-end test")); !sent {
+				return fmt.Errorf("failed to send (see logs)")
+			}
+			return nil
+		}},
+		{"delete sent message", func() error {
+			if deleted := client.DeleteMessage(chatID, sentID); !deleted.Ok {
+				return fmt.Errorf("%s", *deleted.Description)
+			}
+			return nil
+		}},
+	}
+
+	// NOTE: steps depend on the preceding ones, so the test stops at the first failure
+	for _, step := range steps {
+		if !t.Run(step.name, func(t *testing.T) {
+			if err := step.run(); err != nil {
+				t.Fatal(err)
+			}
+		}) {
+			t.FailNow()
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// re-renders sources of an edited message with `messageID`, and replaces its previously sent renders with new ones (by editing them)
+//
+// Returns false if there are no previous renders to edit (or their number differs from `sources`),
+// so that the sources should be rendered and sent as new messages.
+func replaceRendersOfEdited(ctx context.Context, bot *tg.Bot, conf config, userID, chatID, messageID int64, sources []string, opts renderOptions) (replaced bool) {
+	// NOTE: out of access hours, edited messages are refused (or deferred) like new ones
+	if _, _, out := outOfAccessHours(ctx); out {
+		return false
+	}
+
+	previous := storage.rendersOfRequest(chatID, messageID)
+	if len(previous) == 0 || len(previous) != len(sources) {
+		return false
+	}
+
+	ctx, done := jobs.start(ctx, userID)
+	defer done()
+
+	// echo sources in captions of rendered files (if the user set so)
+	echo := storage.loadSettings(userID).SourceCaption
+
+	progress := startProgress(ctx, bot, conf, chatID, messageID)
+
+	edited := map[int]int64{}
+	failed := map[int]error{}
+	errs := []string{}
+	warnings := []string{}
+
+	// records re-renders of the changed sources only
+	record := func() {
+		changed, changedEdited, changedFailed := []string{}, map[int]int64{}, map[int]error{}
+		for i, source := range sources {
+			if previous[i].Source == source {
+				continue
+			}
+			if sentID, exists := edited[i]; exists {
+				changedEdited[len(changed)] = sentID
+			}
+			if err, exists := failed[i]; exists {
+				changedFailed[len(changed)] = err
+			}
+			changed = append(changed, source)
+		}
+		recordHistory(ctx, userID, chatID, changed, changedEdited, changedFailed)
+	}
+
+	for i, source := range sources {
+		if previous[i].Source == source { // NOTE: telegram fails to edit a message with the same content
+			continue
+		}
+
+		report := progress.report
+		var prefix string
+		if len(sources) > 1 {
+			prefix = fmt.Sprintf("%d/%d", i+1, len(sources))
+			report = func(stage string) {
+				progress.report(fmt.Sprintf("%s (%d/%d)", stage, i+1, len(sources)))
+			}
+		}
+
+		// choose the orientation of the diagram (if needed)
+		var notes []string
+		sourceOpts := opts
+		if direction, chosen := autoOrientation(ctx, conf, source, opts); chosen {
+			sourceOpts.Direction = direction
+			notes = append(notes, fmt.Sprintf(messageOrientationChosen, direction))
+		}
+
+		// host the interactive .svg file on the web viewer (if configured)
+		if link, err := viewer.host(ctx, source, sourceOpts); err == nil && link != "" {
+			notes = append(notes, fmt.Sprintf(messageViewerLink, link))
+		}
+
+		retrying := func(retry, max int) {
+			report(fmt.Sprintf(stageRetrying, retry, max))
+			progress.show()
+		}
+
+		var bs []byte
+		var warned []string
+		err := retries.do(ctx, retrying, func() (err error) {
+			bs, warned, err = renderDiagram(ctx, source, sourceOpts, report)
+			return err
+		})
+
+		// fit the render in upload limits of telegram
+		format := sourceOpts.Format
+		if err == nil {
+			var note string
+			if bs, format, note, err = fitUploadLimits(ctx, source, sourceOpts, bs); err == nil && note != "" {
+				notes = append(notes, note)
+			}
+		}
+
+		if errors.Is(err, context.Canceled) {
+			if conf.IsVerbose {
+				logf(ctx, "re-render was canceled by user: %d", userID)
+			}
+
+			progress.finish()
+			record()
+			return true
+		} else if err != nil {
+			logf(ctx, "failed to re-render edited message: %s", err)
+
+			if !isSourceError(err) {
+				alerter.record(failureRender, err)
+			}
+
+			failed[i] = err
+			if len(sources) > 1 {
+				errs = append(errs, fmt.Sprintf("#%d: %s", i+1, explainError(source, err)))
+			} else {
+				errs = append(errs, explainError(source, err))
+			}
+			continue
+		}
+
+		// note which layout engine produced the render, if it fell back to another one
+		var layoutNotes []string
+		if layoutNotes, warned = splitLayoutFallbackNotes(warned); len(layoutNotes) > 0 {
+			notes = append(notes, layoutNotes...)
+		}
+		for _, warning := range warned {
+			if len(sources) > 1 {
+				warnings = append(warnings, fmt.Sprintf("#%d: %s", i+1, warning))
+			} else {
+				warnings = append(warnings, warning)
+			}
+		}
+
+		var echoed string
+		if echo {
+			echoed = source
+		}
+		if editRenderedFile(ctx, bot, chatID, previous[i].MessageID, bs, format, renderedCaption(prefix, strings.Join(notes, "\n"), echoed)) {
+			edited[i] = previous[i].MessageID
+
+			// remember the new source of the edited message, so that it can be re-rendered later
+			if err := storage.updateRender(chatID, previous[i].MessageID, source); err != nil {
+				logf(ctx, "failed to save source of render: %s", err)
+			}
+		} else {
+			failed[i] = fmt.Errorf("failed to replace rendered file")
+
+			alerter.record(failureSend, failed[i])
+		}
+	}
+	progress.finish()
+
+	record()
+
+	if len(edited) > 0 && len(warnings) > 0 {
+		replyText(bot, chatID, messageID, fmt.Sprintf(messageRenderWarnings, strings.Join(warnings, "\n⚠️ ")))
+	}
+	if len(errs) > 0 {
+		replyError(bot, chatID, messageID, fmt.Sprintf("Failed to render edited message: %s", strings.Join(errs, "\n")))
+	}
+
+	return true
+}
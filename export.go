@@ -0,0 +1,153 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// constants for exporting libraries
+const (
+	commandExport = "/export"
+
+	exportFilename = "library.zip"
+
+	messageExported               = "Exported %d diagram(s) from your library."
+	messageExportedWithoutRenders = "Following diagram(s) were exported without renders:\n\n%s"
+)
+
+// handle export command
+func handleExportCommand(ctx context.Context, b *tg.Bot, conf config, update tg.Update, args string) {
+	if message, _ := update.GetMessage(); message != nil {
+		userID := message.From.ID
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		// export a diagram for whiteboard tools (eg. `/export excalidraw`)
+		switch tool := strings.ToLower(strings.TrimSpace(args)); tool {
+		case "":
+		case whiteboardExcalidraw, whiteboardDrawIO, "draw.io":
+			handleWhiteboardExportCommand(ctx, b, conf, update, strings.ReplaceAll(tool, ".", ""))
+			return
+		default:
+			replyError(b, chatID, messageID, messageWhiteboardUsage)
+			return
+		}
+
+		names := storage.libraryNames(userID)
+		if len(names) == 0 {
+			replyText(b, chatID, messageID, messageLibraryEmpty)
+			return
+		}
+
+		ctx, done := jobs.start(ctx, userID)
+		defer done()
+
+		_ = b.SendChatAction(chatID, tg.ChatActionUploadDocument, nil)
+
+		progress := startProgress(ctx, b, conf, chatID, messageID)
+		archived, failed, err := archiveLibrary(ctx, conf, userID, names, progress.report)
+		progress.finish()
+
+		if err != nil {
+			if !errors.Is(err, context.Canceled) {
+				logf(ctx, "failed to export library: %s", err)
+
+				replyError(b, chatID, messageID, fmt.Sprintf("Failed to export library: %s", err))
+			}
+			return
+		}
+
+		if err = withNamedFile(exportFilename, archived, func(file tg.InputFile) {
+			if sent := b.SendDocument(
+				chatID,
+				file,
+				tg.OptionsSendDocument{}.
+					SetCaption(fmt.Sprintf(messageExported, len(names))).
+					SetReplyParameters(tg.NewReplyParameters(messageID))); !sent.Ok {
+				logf(ctx, "failed to send exported library: %s", *sent.Description)
+			}
+		}); err != nil {
+			logf(ctx, "failed to prepare exported library: %s", err)
+
+			replyError(b, chatID, messageID, fmt.Sprintf("Failed to export library: %s", err))
+			return
+		}
+
+		// report diagrams which failed to render
+		if len(failed) > 0 {
+			lines := []string{}
+			for _, name := range names {
+				if err, exists := failed[name]; exists {
+					lines = append(lines, fmt.Sprintf("• %s: %s", name, err))
+				}
+			}
+			replyError(b, chatID, messageID, fmt.Sprintf(messageExportedWithoutRenders, strings.Join(lines, "\n")))
+		}
+	}
+}
+
+// archives saved diagrams with `names` of `userID` into a zip file:
+// each diagram is stored as `<name>.d2` with its freshly rendered file in the user's output format (eg. `<name>.png`).
+//
+// Returns errors of diagrams which failed to render (keyed by names), which are archived without renders.
+func archiveLibrary(ctx context.Context, conf config, userID int64, names []string, progress func(stage string)) (archived []byte, failed map[string]error, err error) {
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+
+	// NOTE: falls back to .svg files in a build without a browser
+	opts := renderOptionsForUser(conf, userID)
+
+	for i, name := range names {
+		entry, exists := storage.loadFromLibrary(userID, name)
+		if !exists {
+			continue
+		}
+
+		if f, err := w.Create(name + ".d2"); err == nil {
+			if _, err = f.Write([]byte(entry.Source)); err != nil {
+				return nil, nil, err
+			}
+		} else {
+			return nil, nil, err
+		}
+
+		rendered, _, err := renderDiagram(ctx, entry.Source, opts, func(stage string) {
+			progress(fmt.Sprintf("%s (%d/%d)", stage, i+1, len(names)))
+		})
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return nil, nil, err
+			}
+
+			// NOTE: sources which fail to render are exported without renders
+			logf(ctx, "failed to render '%s' for export: %s", name, err)
+
+			if failed == nil {
+				failed = map[string]error{}
+			}
+			failed[name] = err
+			continue
+		}
+
+		if f, err := w.Create(name + "." + opts.Format.extension()); err == nil {
+			if _, err = f.Write(rendered); err != nil {
+				return nil, nil, err
+			}
+		} else {
+			return nil, nil, err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	return buf.Bytes(), failed, nil
+}
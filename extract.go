@@ -0,0 +1,71 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf16"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// language of d2 code blocks
+const d2Language = "d2"
+
+// regular expression for fenced d2 code blocks in markdown
+var fencedD2Block = regexp.MustCompile("(?ms)^[ \t]*```[ \t]*d2\\b[^\n]*\n(.*?)^[ \t]*```[ \t]*$")
+
+// extractDiagrams extracts d2 sources from given text of a message.
+//
+// If the message has d2 code blocks (as `pre` entities or fenced code blocks),
+// each of them is returned as a separate source. Otherwise, the whole text is returned.
+func extractDiagrams(text string, entities []tg.MessageEntity) (sources []string) {
+	for _, entity := range entities {
+		if entity.Type == tg.MessageEntityTypePre &&
+			entity.Language != nil &&
+			strings.EqualFold(*entity.Language, d2Language) {
+			sources = append(sources, utf16Substring(text, entity.Offset, entity.Length))
+		}
+	}
+	if len(sources) > 0 {
+		return sources
+	}
+
+	if sources = extractFencedBlocks(text); len(sources) > 0 {
+		return sources
+	}
+
+	return []string{text}
+}
+
+// extractFencedBlocks extracts fenced d2 code blocks from given markdown text.
+func extractFencedBlocks(markdown string) (sources []string) {
+	for _, match := range fencedD2Block.FindAllStringSubmatch(markdown, -1) {
+		if source := strings.TrimSpace(match[1]); source != "" {
+			sources = append(sources, source)
+		}
+	}
+
+	return sources
+}
+
+// returns the length of given `text` in UTF-16 code units
+func utf16Length(text string) int {
+	return len(utf16.Encode([]rune(text)))
+}
+
+// returns a substring of given `text` with offset and length in UTF-16 code units
+// (which are used in telegram message entities)
+func utf16Substring(text string, offset, length int) string {
+	encoded := utf16.Encode([]rune(text))
+
+	if offset < 0 || offset > len(encoded) {
+		return ""
+	}
+	end := offset + length
+	if end > len(encoded) {
+		end = len(encoded)
+	}
+
+	return string(utf16.Decode(encoded[offset:end]))
+}
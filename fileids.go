@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// returns the key of the file id of a rendered file `bs` sent in `format`
+//
+// (renders of the same source and options are identical, as they are cached with the hash of both)
+func fileIDKey(bs []byte, format outputFormat) string {
+	return hashKey(bs) + "." + string(format)
+}
+
+// returns the file id of the photo (in its largest size) or the document in given message
+func fileIDOfMessage(message tg.Message) string {
+	if len(message.Photo) > 0 {
+		return message.Photo[len(message.Photo)-1].FileID
+	}
+	if message.Document != nil {
+		return message.Document.FileID
+	}
+	return ""
+}
+
+// saves the file id of a rendered file in `message` with `key`, so that identical renders are sent without uploading them again
+func rememberFileID(ctx context.Context, key string, message tg.Message) {
+	if fileID := fileIDOfMessage(message); fileID != "" {
+		if err := storage.saveFileID(key, fileID); err != nil {
+			logf(ctx, "failed to save file id: %s", err)
+		}
+	}
+}
+
+// sends a rendered file with its saved file id (if any), and returns the sent message
+//
+// (an invalid file id is deleted, and `sent` is false so that the file is uploaded instead)
+func sendWithFileID(ctx context.Context, bot *tg.Bot, chatID, messageID int64, key string, format outputFormat, caption *string) (message tg.Message, sent bool) {
+	fileID, exists := storage.loadFileID(key)
+	if !exists {
+		return tg.Message{}, false
+	}
+
+	if res := sendFile(bot, chatID, messageID, tg.NewInputFileFromFileID(fileID), nil, format, caption); res.Ok {
+		return *res.Result, true
+	} else {
+		logf(ctx, "failed to send rendered file with its file id, uploading it again: %s", *res.Description)
+
+		if err := storage.deleteFileID(key); err != nil {
+			logf(ctx, "failed to delete file id: %s", err)
+		}
+	}
+	return tg.Message{}, false
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	// d2
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2renderers/d2fonts"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/lib/textmeasure"
+
+	// fonts
+	"github.com/rivo/uniseg"
+	xfont "golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// default fallback font families for emoji, symbols, and right-to-left scripts (used when they are installed on the host)
+var defaultFallbackFamilies = []string{
+	"Noto Color Emoji",
+	"Apple Color Emoji",
+	"Segoe UI Emoji",
+	"Noto Sans Symbols",
+	"Noto Sans Symbols 2",
+	"Symbola",
+	"Noto Sans Arabic",
+	"Noto Sans Hebrew",
+}
+
+// settings of fonts
+type fontsConfig struct {
+	Fallbacks []fallbackFontConfig `json:"fallbacks,omitempty"` // fallback fonts for glyphs missing in the default font, in order
+	CJK       *cjkFontConfig       `json:"cjk,omitempty"`       // font for CJK characters
+}
+
+// a fallback font
+type fallbackFontConfig struct {
+	Family string `json:"family"`         // name of the font family installed on the host (eg. `Noto Color Emoji`), for rendering
+	Path   string `json:"path,omitempty"` // path of its font file (.ttf, .otf, or .ttc), for measuring labels with its glyphs
+}
+
+// fallback fonts for glyphs missing in the default font
+type fallbackFonts struct {
+	families []string     // for rendering (in css of svg files)
+	fonts    []*sfnt.Font // for measuring labels
+
+	cjkFamily string     // font family for CJK characters (chosen by scripts of labels if empty)
+	cjk       *sfnt.Font // font for measuring CJK characters (nil if there is none)
+}
+
+// fallback fonts (initialized on start)
+var fontFallbacks *fallbackFonts
+
+// loads fallback fonts with given settings (or the default families if they are not configured)
+func loadFallbackFonts(conf *fontsConfig) (f *fallbackFonts, err error) {
+	if conf == nil {
+		conf = &fontsConfig{}
+	}
+
+	f = &fallbackFonts{}
+	if f.cjkFamily, f.cjk, err = loadCJKFont(conf.CJK); err != nil {
+		return nil, fmt.Errorf("failed to load cjk font: %w", err)
+	}
+
+	if len(conf.Fallbacks) == 0 {
+		f.families = defaultFallbackFamilies
+		return f, nil
+	}
+	for _, fallback := range conf.Fallbacks {
+		if fallback.Family != "" {
+			f.families = append(f.families, fallback.Family)
+		}
+		if fallback.Path != "" {
+			var font *sfnt.Font
+			if font, err = loadFontFile(fallback.Path); err != nil {
+				return nil, fmt.Errorf("failed to load fallback font '%s': %w", fallback.Path, err)
+			}
+			f.fonts = append(f.fonts, font)
+		}
+	}
+	return f, nil
+}
+
+// loads a font file (the first font of it, if it is a collection)
+func loadFontFile(path string) (font *sfnt.Font, err error) {
+	var bs []byte
+	if bs, err = os.ReadFile(path); err == nil {
+		var collection *sfnt.Collection
+		if collection, err = sfnt.ParseCollection(bs); err == nil {
+			return collection.Font(0)
+		}
+	}
+	return nil, err
+}
+
+// font-family declarations in css of svg files rendered by d2
+var fontFamilyDeclaration = regexp.MustCompile(`font-family: ?"([^"]+)";`)
+
+// appends fallback font families to font-family declarations in given svg file,
+// so that glyphs missing in the default font (eg. emoji or CJK characters) are rendered with them instead of boxes
+func (f *fallbackFonts) apply(svg []byte) []byte {
+	families := defaultFallbackFamilies
+	if f != nil {
+		families = f.families
+	}
+	if cjkFamily := f.cjkFamilyFor(string(svg)); cjkFamily != "" {
+		families = append([]string{cjkFamily}, families...)
+	}
+	if len(families) == 0 {
+		return svg
+	}
+
+	quoted := []string{}
+	for _, family := range families {
+		quoted = append(quoted, strconv.Quote(family))
+	}
+	return fontFamilyDeclaration.ReplaceAll(svg, []byte(`font-family: "$1", `+strings.Join(quoted, ", ")+`;`))
+}
+
+// returns runes which are measured by the ruler of d2 (others are measured as replacement characters)
+var measurableRunes = sync.OnceValue(func() map[rune]bool {
+	runes := map[rune]bool{}
+	for _, r := range textmeasure.Runes {
+		runes[r] = true
+	}
+	return runes
+})
+
+// checks if given text has any rune which is not measured by the ruler
+func hasUnmeasurableRunes(text string) bool {
+	runes := measurableRunes()
+	for _, r := range text {
+		if !runes[r] {
+			return true
+		}
+	}
+	return false
+}
+
+// returns dimensions of labels in `graph` which have glyphs missing in the default font, measured with fallback fonts
+//
+// (passed to `SetDimensions` so that the layout fits them; other labels are measured by the ruler as they are)
+func (f *fallbackFonts) measure(graph *d2graph.Graph, ruler *textmeasure.Ruler) (mtexts []*d2target.MText) {
+	if f == nil {
+		return nil
+	}
+
+	texts := []*d2target.MText{}
+	for _, obj := range graph.Objects {
+		if obj.Class == nil && obj.SQLTable == nil { // NOTE: fields of classes and tables are measured separately
+			texts = append(texts, obj.Text())
+		}
+	}
+	for _, edge := range graph.Edges {
+		texts = append(texts, edge.Text())
+	}
+
+	for _, text := range texts {
+		if text.Text == "" || text.Language != "" || !hasUnmeasurableRunes(text.Text) {
+			continue
+		}
+
+		style := d2fonts.FONT_STYLE_REGULAR
+		if text.IsBold {
+			style = d2fonts.FONT_STYLE_BOLD
+		} else if text.IsItalic {
+			style = d2fonts.FONT_STYLE_ITALIC
+		}
+		font := d2fonts.SourceSansPro.Font(text.FontSize, style)
+
+		if width, ok := f.measureWidth(ruler, font, text.Text); ok {
+			_, height := ruler.Measure(font, text.Text)
+			text.Dimensions = *d2target.NewTextDimensions(width, height)
+			mtexts = append(mtexts, text)
+		}
+	}
+	return mtexts
+}
+
+// measures the width of given text, with glyphs missing in the default font measured with fallback fonts
+//
+// (returns false if any of them is missing in fallback fonts too)
+func (f *fallbackFonts) measureWidth(ruler *textmeasure.Ruler, font d2fonts.Font, text string) (width int, ok bool) {
+	runes := measurableRunes()
+	var buf sfnt.Buffer
+
+	var maxWidth float64
+	for _, line := range strings.Split(text, "\n") {
+		var measurable strings.Builder
+		var fallbackWidth float64
+
+		graphemes := uniseg.NewGraphemes(line)
+		for graphemes.Next() {
+			cluster := graphemes.Runes()
+			if runes[cluster[0]] && len(cluster) == 1 {
+				measurable.WriteRune(cluster[0])
+				continue
+			}
+
+			// NOTE: a cluster (eg. an emoji with modifiers or joiners) is rendered as a glyph of its first rune
+			advance, found := f.advance(&buf, cluster[0], font.Size)
+			if !found {
+				return 0, false
+			}
+			fallbackWidth += advance
+		}
+
+		lineWidth := fallbackWidth
+		if strings.TrimSpace(measurable.String()) != "" {
+			w, _ := ruler.MeasurePrecise(font, measurable.String())
+			lineWidth += w
+		}
+		maxWidth = max(maxWidth, lineWidth)
+	}
+	return int(maxWidth + 0.5), true
+}
+
+// returns the advance width (in pixels) of given rune in the first fallback font (or the CJK font) which has its glyph
+func (f *fallbackFonts) advance(buf *sfnt.Buffer, r rune, size int) (advance float64, found bool) {
+	fonts := f.fonts
+	if f.cjk != nil {
+		fonts = append(fonts[:len(fonts):len(fonts)], f.cjk)
+	}
+	for _, font := range fonts {
+		if index, err := font.GlyphIndex(buf, r); err == nil && index != 0 {
+			if adv, err := font.GlyphAdvance(buf, index, fixed.I(size), xfont.HintingNone); err == nil {
+				return float64(adv) / 64, true
+			}
+		}
+	}
+
+	// NOTE: CJK characters are mostly full-width (1em), even without any font for measuring them
+	if isCJK(r) {
+		return float64(size), true
+	}
+	return 0, false
+}
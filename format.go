@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// constants for choosing output formats
+const (
+	commandFormat = "/format"
+
+	formatDefault = "default"
+
+	messageFormatUsage = "Usage: /format <png|photo|svg|pdf|default>\n\n• png: .png file (as a document)\n• photo: .png file (as a photo)\n• svg: raw .svg file, for scaling without losing quality\n• pdf: .pdf file with all boards, for printing"
+	messageFormat      = "Your output format: %s\n\n%s"
+)
+
+// returns the default output format in the config (.png files if it is not set, or not valid)
+func defaultOutputFormat(conf config) outputFormat {
+	if conf.Format != "" {
+		if format, err := parseOutputFormat(conf.Format); err == nil {
+			return format
+		}
+	}
+	return formatPNG
+}
+
+// checks the default output format in the config, and logs it if it is not valid
+func checkDefaultOutputFormat(conf config) {
+	if conf.Format != "" {
+		if _, err := parseOutputFormat(conf.Format); err != nil {
+			log.Printf("ignoring `format` in the config: %s", err)
+		}
+	}
+}
+
+// handle format command (same as `/settings format`)
+func handleFormatCommand(ctx context.Context, b *tg.Bot, conf config, update tg.Update, args string) {
+	if message, _ := update.GetMessage(); message != nil {
+		userID := message.From.ID
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		// show current format
+		args = strings.ToLower(strings.TrimSpace(args))
+		if args == "" {
+			replyText(b, chatID, messageID, fmt.Sprintf(messageFormat, renderOptionsForUser(conf, userID).Format, messageFormatUsage))
+			return
+		}
+
+		saveSetting(ctx, b, conf, userID, chatID, messageID, settingFormat, args)
+	}
+}
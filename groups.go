@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// constants for groups
+const (
+	groupPolicyEveryone   = "everyone"    // everyone (permitted) in groups can trigger renders
+	groupPolicyAdminsOnly = "admins_only" // only administrators of groups can trigger renders
+
+	chatTypeSupergroup tg.ChatType = "supergroup"
+
+	chatAdminCacheTTL = 10 * time.Minute
+)
+
+// cached results of `getChatMember` (whether users are administrators of groups)
+type chatAdminCache struct {
+	sync.Mutex
+
+	entries map[[2]int64]chatAdminEntry // [chat id, user id] => entry
+	sweptAt time.Time                   // when expired entries were evicted last time
+}
+
+// a cached result of `getChatMember`
+type chatAdminEntry struct {
+	isAdmin   bool
+	checkedAt time.Time
+}
+
+// cached results of `getChatMember`
+var chatAdmins = &chatAdminCache{
+	entries: map[[2]int64]chatAdminEntry{},
+}
+
+// checks if `userID` is an administrator (or the creator) of `chatID`, with cached results
+func (c *chatAdminCache) isAdmin(b *tg.Bot, chatID, userID int64) bool {
+	key := [2]int64{chatID, userID}
+
+	c.Lock()
+	// NOTE: expired entries are evicted once in a ttl, so that the cache doesn't grow with every group ever seen
+	if now := time.Now(); now.Sub(c.sweptAt) >= chatAdminCacheTTL {
+		for k, e := range c.entries {
+			if now.Sub(e.checkedAt) >= chatAdminCacheTTL {
+				delete(c.entries, k)
+			}
+		}
+		c.sweptAt = now
+	}
+	entry, exists := c.entries[key]
+	c.Unlock()
+	if exists && time.Since(entry.checkedAt) < chatAdminCacheTTL {
+		return entry.isAdmin
+	}
+
+	member := b.GetChatMember(chatID, userID)
+	if !member.Ok || member.Result == nil {
+		return false // NOTE: don't cache failures
+	}
+	isAdmin := member.Result.Status == tg.ChatMemberStatusCreator || member.Result.Status == tg.ChatMemberStatusAdministrator
+
+	c.Lock()
+	c.entries[key] = chatAdminEntry{isAdmin: isAdmin, checkedAt: time.Now()}
+	c.Unlock()
+
+	return isAdmin
+}
+
+// checks if given chat type is of a group
+func isGroupChat(chatType tg.ChatType) bool {
+	return chatType == tg.ChatTypeGroup || chatType == chatTypeSupergroup
+}
+
+// returns the group chat where given update was sent (nil if not from a group)
+func groupChatOf(update tg.Update) *tg.Chat {
+	if message, _ := update.GetMessage(); message != nil {
+		if isGroupChat(message.Chat.Type) {
+			return &message.Chat
+		}
+	} else if update.HasCallbackQuery() && update.CallbackQuery.Message != nil {
+		if isGroupChat(update.CallbackQuery.Message.Chat.Type) {
+			return &update.CallbackQuery.Message.Chat
+		}
+	}
+	return nil
+}
+
+// returns a middleware which drops updates in groups from users who are not administrators of the groups,
+// when `group_policy` is `admins_only` (silently, not to flood large public groups with replies)
+func groupPolicyMiddleware(conf config, router *updateRouter) middleware {
+	return func(next updateHandlerFunc) updateHandlerFunc {
+		if conf.GroupPolicy != groupPolicyAdminsOnly {
+			return next
+		}
+
+		return func(ctx context.Context, b *tg.Bot, update tg.Update) {
+			chat, from := groupChatOf(update), update.GetFrom()
+			if chat == nil || from == nil {
+				next(ctx, b, update)
+				return
+			}
+			if command, _, isCommand := router.commandOf(update); isCommand && slices.Contains(publicCommands, command) {
+				next(ctx, b, update)
+				return
+			}
+
+			if chatAdmins.isAdmin(b, chat.ID, from.ID) {
+				next(ctx, b, update)
+			} else {
+				auditor.writeUpdate(ctx, update, kindOfUpdate(router, update), auditOutcomeDenied)
+
+				if conf.IsVerbose {
+					logf(ctx, "update from a non-admin of group %d dropped: %+v", chat.ID, update)
+				}
+			}
+		}
+	}
+}
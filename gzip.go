@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// constants for gzip-compressed documents
+const (
+	gzipExtension = ".gz"
+
+	maxDecompressedSourceSize = 10 * 1024 * 1024 // 10MB
+)
+
+// magic bytes of gzip-compressed files
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// returns given filename without the `.gz` extension (eg. `diagram.d2.gz` => `diagram.d2`)
+func uncompressedFilename(filename string) string {
+	return strings.TrimSuffix(filename, gzipExtension)
+}
+
+// checks if given bytes are gzip-compressed
+func isGzipped(bs []byte) bool {
+	return bytes.HasPrefix(bs, gzipMagic)
+}
+
+// decompresses given bytes if they are gzip-compressed (regardless of their filename), or returns them as they are
+func decompressIfGzipped(bs []byte) ([]byte, error) {
+	if !isGzipped(bs) {
+		return bs, nil
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(bs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress gzip file: %w", err)
+	}
+	defer r.Close()
+
+	// NOTE: read one more byte for checking if it exceeds the limit
+	var decompressed []byte
+	if decompressed, err = io.ReadAll(io.LimitReader(r, maxDecompressedSourceSize+1)); err != nil {
+		return nil, fmt.Errorf("failed to decompress gzip file: %w", err)
+	}
+	if len(decompressed) > maxDecompressedSourceSize {
+		return nil, fmt.Errorf("decompressed file is too large (over %d MB)", maxDecompressedSourceSize/1024/1024)
+	}
+	return decompressed, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	// syntax highlighting
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+)
+
+// styles for highlighting code blocks (same as the ones d2 uses for code shapes)
+const (
+	highlightStyleLight = "github"
+	highlightStyleDark  = "catppuccin-mocha"
+)
+
+// fenced code blocks with languages in markdown labels rendered by d2 (eg. "```go")
+var markdownCodeBlock = regexp.MustCompile(`<pre><code class="language-([A-Za-z0-9_+#.-]+)">([^<]*)</code></pre>`)
+
+// highlights syntax of fenced code blocks in markdown labels of given svg file
+//
+// (d2 highlights code shapes, eg. `|go ...|`, but not code blocks in markdown labels, eg. "|md ```go ...```|")
+func highlightMarkdownCode(svg []byte, dark bool) []byte {
+	if !markdownCodeBlock.Match(svg) {
+		return svg
+	}
+
+	style := styles.Get(highlightStyleLight)
+	if dark {
+		style = styles.Get(highlightStyleDark)
+	}
+
+	return markdownCodeBlock.ReplaceAllFunc(svg, func(match []byte) []byte {
+		groups := markdownCodeBlock.FindSubmatch(match)
+		language, code := string(groups[1]), html.UnescapeString(string(groups[2]))
+
+		lexer := lexers.Get(language)
+		if lexer == nil {
+			return match
+		}
+		iterator, err := chroma.Coalesce(lexer).Tokenise(nil, code)
+		if err != nil {
+			return match
+		}
+
+		// NOTE: only colors are applied, so that code blocks keep the sizes which d2 measured
+		var highlighted strings.Builder
+		for _, token := range iterator.Tokens() {
+			value := html.EscapeString(token.Value)
+			if entry := style.Get(token.Type); entry.Colour.IsSet() {
+				fmt.Fprintf(&highlighted, `<span style="color:%s">%s</span>`, entry.Colour.String(), value)
+			} else {
+				highlighted.WriteString(value)
+			}
+		}
+		return []byte(fmt.Sprintf(`<pre><code class="language-%s">%s</code></pre>`, language, highlighted.String()))
+	})
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// constants for render history
+const (
+	commandHistory = "/history"
+
+	callbackPrefixHistory = "history:"
+	historyActionRender   = "render"
+	historyActionSource   = "source"
+
+	maxHistoryListed     = 10
+	maxHistorySummaryLen = 40
+
+	messageHistoryEmpty  = "You have not rendered any diagram yet."
+	messageHistoryHeader = "Your recent renders:\n\n"
+	messageNoSuchHistory = "The render is not in your history anymore."
+)
+
+// emojis for statuses of render requests
+var historyStatusEmojis = map[string]string{
+	historyStatusSucceeded: "✅",
+	historyStatusFailed:    "❌",
+	historyStatusCanceled:  "🚫",
+}
+
+// handle history command
+func handleHistoryCommand(ctx context.Context, b *tg.Bot, conf config, update tg.Update) {
+	if message, _ := update.GetMessage(); message != nil {
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		entries := storage.recentHistory(message.From.ID, maxHistoryListed)
+		if len(entries) == 0 {
+			replyText(b, chatID, messageID, messageHistoryEmpty)
+			return
+		}
+
+		lines := []string{}
+		keyboard := [][]tg.InlineKeyboardButton{}
+		for _, entry := range entries {
+			lines = append(lines, fmt.Sprintf("#%d %s %s %s",
+				entry.ID,
+				historyStatusEmojis[entry.Status],
+				entry.RequestedAt.Format("2006-01-02 15:04"),
+				summarizeSource(entry.Source)))
+
+			keyboard = append(keyboard, []tg.InlineKeyboardButton{
+				{
+					Text:         fmt.Sprintf("🔁 Render #%d", entry.ID),
+					CallbackData: toPointer(historyCallbackData(historyActionRender, entry.ID)),
+				},
+				{
+					Text:         fmt.Sprintf("📄 Source #%d", entry.ID),
+					CallbackData: toPointer(historyCallbackData(historyActionSource, entry.ID)),
+				},
+			})
+		}
+
+		if sent := b.SendMessage(
+			chatID,
+			messageHistoryHeader+strings.Join(lines, "\n"),
+			tg.OptionsSendMessage{}.
+				SetReplyParameters(tg.NewReplyParameters(messageID)).
+				SetReplyMarkup(tg.NewInlineKeyboardMarkup(keyboard))); !sent.Ok {
+			logf(ctx, "failed to send history: %s", *sent.Description)
+		}
+	}
+}
+
+// returns callback data for given history action and id (eg. `history:render:12`)
+func historyCallbackData(action string, id int64) string {
+	return fmt.Sprintf("%s%s:%d", callbackPrefixHistory, action, id)
+}
+
+// handles a callback query on the history list with `data` (without the prefix)
+func handleHistoryCallback(ctx context.Context, b *tg.Bot, conf config, query tg.CallbackQuery, data string) {
+	action, idStr, _ := strings.Cut(data, ":")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil || query.Message == nil {
+		answerCallbackQuery(b, query, nil)
+		return
+	}
+
+	chatID := query.Message.Chat.ID
+	messageID := query.Message.MessageID
+
+	entry, exists := storage.loadHistory(query.From.ID, id)
+	if !exists {
+		answerCallbackQuery(b, query, toPointer(messageNoSuchHistory))
+		return
+	}
+	answerCallbackQuery(b, query, nil)
+
+	switch action {
+	case historyActionRender:
+		replyRendered(ctx, b, conf, query.From.ID, chatID, messageID, []string{entry.Source})
+	case historyActionSource:
+		replySource(b, chatID, messageID, entry.Source)
+	}
+}
+
+// returns the first non-empty line of given `source` for summarizing it
+func summarizeSource(source string) string {
+	for _, line := range strings.Split(source, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			if runes := []rune(line); len(runes) > maxHistorySummaryLen {
+				return string(runes[:maxHistorySummaryLen]) + "…"
+			}
+			return line
+		}
+	}
+	return "(empty)"
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// constants for access hours
+const (
+	messageOutOfHours = "Renders are not available now: please try again after %s."
+	messageDeferred   = "Renders are not available now: your request will be handled at %s."
+)
+
+// active (or quiet) hours of users or chats
+type accessHoursConfig struct {
+	Usernames []string `json:"usernames,omitempty"` // usernames (or their patterns) of users, and/or
+	ChatIDs   []int64  `json:"chat_ids,omitempty"`  // ids of chats where the hours are applied (applied to everyone if both are empty)
+
+	ActiveHours string `json:"active_hours,omitempty"` // eg. 09:00-18:00 (renders are available only in these hours), or
+	QuietHours  string `json:"quiet_hours,omitempty"`  // eg. 22:00-07:00 (renders are not available in these hours)
+	Timezone    string `json:"timezone,omitempty"`     // eg. Asia/Seoul (= local timezone for default)
+
+	Defer bool `json:"defer,omitempty"` // defer renders until available, instead of refusing them
+}
+
+// a daily time window in minutes since midnight (`from` inclusive, `to` exclusive; wraps around midnight if `from` > `to`)
+type timeWindow struct {
+	from, to int
+}
+
+// parses a time window like `09:00-18:00`
+func parseTimeWindow(str string) (w timeWindow, err error) {
+	from, to, found := strings.Cut(str, "-")
+	if !found {
+		return timeWindow{}, fmt.Errorf("not a valid time window: '%s' (should be like '09:00-18:00')", str)
+	}
+
+	var f, t time.Time
+	if f, err = time.Parse("15:04", strings.TrimSpace(from)); err != nil {
+		return timeWindow{}, fmt.Errorf("not a valid time window: '%s' (%s)", str, err)
+	}
+	if t, err = time.Parse("15:04", strings.TrimSpace(to)); err != nil {
+		return timeWindow{}, fmt.Errorf("not a valid time window: '%s' (%s)", str, err)
+	}
+
+	return timeWindow{from: f.Hour()*60 + f.Minute(), to: t.Hour()*60 + t.Minute()}, nil
+}
+
+// checks if given time is in the window
+func (w timeWindow) contains(t time.Time) bool {
+	minutes := t.Hour()*60 + t.Minute()
+	if w.from <= w.to {
+		return w.from <= minutes && minutes < w.to
+	}
+	return minutes >= w.from || minutes < w.to
+}
+
+// returns the next time after `t` when the window starts (`start` true) or ends
+func (w timeWindow) next(t time.Time, start bool) time.Time {
+	minutes := w.to
+	if start {
+		minutes = w.from
+	}
+
+	next := time.Date(t.Year(), t.Month(), t.Day(), minutes/60, minutes%60, 0, 0, t.Location())
+	if !next.After(t) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// checks if the hours are applied to given user and chat
+func (h accessHoursConfig) appliesTo(username *string, chatID int64) bool {
+	if len(h.Usernames) == 0 && len(h.ChatIDs) == 0 {
+		return true
+	}
+	return slices.Contains(h.ChatIDs, chatID) || (len(h.Usernames) > 0 && matchesAnyAllowedID(h.Usernames, username))
+}
+
+// checks if renders are available at `now`, and returns when they will be available if not
+func (h accessHoursConfig) availableAt(now time.Time) (available bool, at time.Time, err error) {
+	loc := time.Local
+	if h.Timezone != "" {
+		if loc, err = time.LoadLocation(h.Timezone); err != nil {
+			return true, now, err
+		}
+	}
+	now = now.In(loc)
+
+	var w timeWindow
+	if h.ActiveHours != "" {
+		if w, err = parseTimeWindow(h.ActiveHours); err != nil {
+			return true, now, err
+		}
+		if w.contains(now) {
+			return true, now, nil
+		}
+		return false, w.next(now, true), nil
+	} else if h.QuietHours != "" {
+		if w, err = parseTimeWindow(h.QuietHours); err != nil {
+			return true, now, err
+		}
+		if !w.contains(now) {
+			return true, now, nil
+		}
+		return false, w.next(now, false), nil
+	}
+
+	return true, now, nil
+}
+
+// key for access hours applied to the sender (and the chat) of an update in contexts
+type accessHoursKey struct{}
+
+// error of renders requested out of access hours
+type outOfHoursError struct {
+	at time.Time // when renders will be available
+}
+
+func (e outOfHoursError) Error() string {
+	return fmt.Sprintf(messageOutOfHours, e.at.Format("15:04 MST"))
+}
+
+// returns a middleware which attaches `access_hours` applied to the sender (and the chat) of each update to its context,
+// so that all renders requested with the update (eg. with messages, commands, buttons, or inline queries) are refused or deferred out of the hours
+func accessHoursMiddleware(conf config) middleware {
+	return func(next updateHandlerFunc) updateHandlerFunc {
+		if len(conf.AccessHours) == 0 {
+			return next
+		}
+
+		return func(ctx context.Context, b *tg.Bot, update tg.Update) {
+			if from := update.GetFrom(); from != nil {
+				var chatID int64
+				if message, _ := update.GetMessage(); message != nil {
+					chatID = message.Chat.ID
+				} else if update.HasCallbackQuery() && update.CallbackQuery.Message != nil {
+					chatID = update.CallbackQuery.Message.Chat.ID
+				}
+
+				applied := []accessHoursConfig{}
+				for _, hours := range conf.AccessHours {
+					if hours.appliesTo(from.Username, chatID) {
+						applied = append(applied, hours)
+					}
+				}
+				if len(applied) > 0 {
+					ctx = context.WithValue(ctx, accessHoursKey{}, applied)
+				}
+			}
+
+			next(ctx, b, update)
+		}
+	}
+}
+
+// checks if renders are not available now with access hours in `ctx`,
+// and returns when they will be available (and whether they can be deferred until then)
+//
+// (renders without access hours in their contexts, eg. resumed or deferred ones, are always available)
+func outOfAccessHours(ctx context.Context) (at time.Time, deferrable, out bool) {
+	hours, _ := ctx.Value(accessHoursKey{}).([]accessHoursConfig)
+	for _, h := range hours {
+		available, at, err := h.availableAt(time.Now())
+		if err != nil {
+			log.Printf("invalid access hours in config: %s", err)
+			continue
+		}
+		if !available {
+			return at, h.Defer, true
+		}
+	}
+	return time.Time{}, false, false
+}
+
+// returns an error if renders are not available now with access hours in `ctx`
+func checkAccessHours(ctx context.Context) error {
+	if at, _, out := outOfAccessHours(ctx); out {
+		return outOfHoursError{at: at}
+	}
+	return nil
+}
+
+// refuses a render job out of access hours, or defers it until `at` if `deferrable`
+func refuseOrDeferRender(ctx context.Context, b *tg.Bot, conf config, job journaledJob, at time.Time, deferrable bool) {
+	if deferrable {
+		deferRender(ctx, b, conf, job, at)
+		return
+	}
+
+	for _, source := range job.Sources {
+		auditor.write(auditEntry{
+			RequestID:  requestIDOf(ctx),
+			UserID:     job.UserID,
+			ChatID:     job.ChatID,
+			Action:     auditActionRender,
+			SourceHash: hashSource(source),
+			Outcome:    auditOutcomeOutOfHours,
+		})
+	}
+
+	replyError(b, job.ChatID, job.MessageID, outOfHoursError{at: at}.Error())
+}
+
+// journals a render job with its due time `at` (when renders are available again), and schedules it
+//
+// (deferred jobs are scheduled again when the bot restarts before they are due)
+func deferRender(ctx context.Context, b *tg.Bot, conf config, job journaledJob, at time.Time) {
+	job.DueAt = &at
+
+	id := newJournalID(job.ChatID, job.MessageID)
+	if err := jobsJournal.journal(id, job); err != nil {
+		logf(ctx, "failed to journal deferred render job: %s", err)
+	}
+
+	logf(ctx, "deferring render until %s", at)
+
+	replyText(b, job.ChatID, job.MessageID, fmt.Sprintf(messageDeferred, at.Format("2006-01-02 15:04 MST")))
+
+	deferredJobs.schedule(b, conf, id, job)
+}
+
+// deferred render jobs, grouped by their due times
+type deferredQueue struct {
+	sync.Mutex
+
+	due map[int64][]func() // due time (in unix nanoseconds) => functions dispatching jobs (in the order they were deferred)
+}
+
+// deferred render jobs of this bot
+var deferredJobs = &deferredQueue{
+	due: map[int64][]func(){},
+}
+
+// schedules a deferred render job with `id` (journaled) to be dispatched to the queue of its chat when it is due
+//
+// (jobs due at the same time are dispatched in the order they were scheduled)
+func (q *deferredQueue) schedule(b *tg.Bot, conf config, id string, job journaledJob) {
+	q.Lock()
+	defer q.Unlock()
+
+	dispatch := func() {
+		chatQueue.dispatch(job.ChatID, func() {
+			ctx := withRequestID(context.Background(), newRequestID())
+
+			// NOTE: remove it first, as it will be journaled again as a new job
+			if err := jobsJournal.finish(id); err != nil {
+				logf(ctx, "failed to remove deferred job: %s", err)
+			}
+
+			replyRenderedWithOptions(ctx, b, conf, job.UserID, job.ChatID, job.MessageID, job.Sources, job.Options, job.Quotable)
+		})
+	}
+
+	key := job.DueAt.UnixNano()
+	if _, exists := q.due[key]; !exists {
+		time.AfterFunc(time.Until(*job.DueAt), func() {
+			q.Lock()
+			dispatches := q.due[key]
+			delete(q.due, key)
+			q.Unlock()
+
+			for _, dispatch := range dispatches {
+				dispatch()
+			}
+		})
+	}
+	q.due[key] = append(q.due[key], dispatch)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// constants for importing libraries
+const (
+	commandImport = "/import"
+
+	importModeSkip      = "skip"
+	importModeOverwrite = "overwrite"
+	importModeRename    = "rename"
+
+	maxImportedSourceSize = 1024 * 1024 // 1MB per .d2 file
+
+	messageImportUsage = "Usage: reply to a .zip file with /import [skip|overwrite|rename], or upload a .zip file with the command as its caption.\n\n(Diagrams with conflicting names are skipped by default.)"
+	messageImported    = "Imported %d diagram(s) to your library."
+	messageSkipped     = "Skipped: %s"
+	messageRenamed     = "Renamed: %s"
+	messageInvalid     = "Invalid: %s"
+)
+
+// checks if given filename is of a .zip file.
+func isZipFile(filename string) bool {
+	return strings.HasSuffix(filename, ".zip")
+}
+
+// handle import command
+func handleImportCommand(ctx context.Context, b *tg.Bot, conf config, update tg.Update, args string) {
+	if message, _ := update.GetMessage(); message != nil {
+		if message.ReplyToMessage != nil {
+			importZipDocument(ctx, b, message.From.ID, message.Chat.ID, message.MessageID, message.ReplyToMessage.Document, args)
+		} else {
+			replyError(b, message.Chat.ID, message.MessageID, messageImportUsage)
+		}
+	}
+}
+
+// imports .d2 files in a zip `document` into the library of `userID`, and replies the result to `messageID`
+func importZipDocument(ctx context.Context, b *tg.Bot, userID, chatID, messageID int64, document *tg.Document, args string) {
+	if document == nil || document.FileName == nil || !isZipFile(*document.FileName) {
+		replyError(b, chatID, messageID, messageImportUsage)
+		return
+	}
+
+	mode := strings.TrimSpace(args)
+	switch mode {
+	case "":
+		mode = importModeSkip
+	case importModeSkip, importModeOverwrite, importModeRename:
+	default:
+		replyError(b, chatID, messageID, messageImportUsage)
+		return
+	}
+
+	_ = b.SendChatAction(chatID, tg.ChatActionTyping, nil)
+
+	content, err := fetchFile(b, document.FileID)
+	if err != nil {
+		logf(ctx, "failed to fetch zip file: %s", err)
+
+		replyError(b, chatID, messageID, err.Error())
+		return
+	}
+
+	sources, invalid, err := readZippedSources(content)
+	if err != nil {
+		logf(ctx, "failed to read zip file: %s", err)
+
+		replyError(b, chatID, messageID, fmt.Sprintf("Failed to read zip file: %s", err))
+		return
+	}
+
+	// resolve conflicts with existing diagrams
+	existing := map[string]bool{}
+	for _, name := range storage.libraryNames(userID) {
+		existing[name] = true
+	}
+	imported := map[string]string{}
+	skipped, renamed := []string{}, []string{}
+	for name, source := range sources {
+		if existing[name] {
+			switch mode {
+			case importModeSkip:
+				skipped = append(skipped, name)
+				continue
+			case importModeRename:
+				newName := uniqueLibraryName(name, existing, sources)
+				renamed = append(renamed, fmt.Sprintf("%s → %s", name, newName))
+				name = newName
+			}
+		}
+		imported[name] = source
+		existing[name] = true
+	}
+
+	if err = storage.saveAllToLibrary(userID, imported); err != nil {
+		logf(ctx, "failed to import diagrams: %s", err)
+
+		replyError(b, chatID, messageID, fmt.Sprintf("Failed to import diagrams: %s", err))
+		return
+	}
+
+	lines := []string{fmt.Sprintf(messageImported, len(imported))}
+	if len(skipped) > 0 {
+		lines = append(lines, fmt.Sprintf(messageSkipped, strings.Join(skipped, ", ")))
+	}
+	if len(renamed) > 0 {
+		lines = append(lines, fmt.Sprintf(messageRenamed, strings.Join(renamed, ", ")))
+	}
+	if len(invalid) > 0 {
+		lines = append(lines, fmt.Sprintf(messageInvalid, strings.Join(invalid, ", ")))
+	}
+	replyText(b, chatID, messageID, strings.Join(lines, "\n"))
+}
+
+// reads .d2 files from given zip file bytes, and returns sources keyed by their names
+// (files with names which are not valid for the library are returned as `invalid`)
+func readZippedSources(bs []byte) (sources map[string]string, invalid []string, err error) {
+	var r *zip.Reader
+	if r, err = zip.NewReader(bytes.NewReader(bs), int64(len(bs))); err != nil {
+		return nil, nil, err
+	}
+
+	sources = map[string]string{}
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() || !isD2File(f.Name) {
+			continue
+		}
+
+		name := strings.TrimSuffix(path.Base(f.Name), ".d2")
+		if !libraryName.MatchString(name) {
+			invalid = append(invalid, f.Name)
+			continue
+		}
+
+		var rc io.ReadCloser
+		if rc, err = f.Open(); err != nil {
+			return nil, nil, err
+		}
+		source, err := io.ReadAll(io.LimitReader(rc, maxImportedSourceSize))
+		rc.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		sources[name] = string(source)
+	}
+
+	return sources, invalid, nil
+}
+
+// returns a name like `name-2` which does not conflict with `existing` or `importing` ones
+func uniqueLibraryName(name string, existing map[string]bool, importing map[string]string) string {
+	for i := 2; ; i++ {
+		suffix := fmt.Sprintf("-%d", i)
+		candidate := name
+		if len(candidate)+len(suffix) > 60 {
+			candidate = candidate[:60-len(suffix)]
+		}
+		candidate += suffix
+
+		if _, conflicts := importing[candidate]; !existing[candidate] && !conflicts {
+			return candidate
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// constants for resolving imports
+const (
+	defaultImportsCacheMinutes = 10
+	importsRequestTimeout      = 10 * time.Second
+)
+
+// settings of resolving `@import`s (and `...@file`s) in d2 sources against a base url
+type importsConfig struct {
+	BaseURL      string `json:"base_url"`                // eg. `https://raw.githubusercontent.com/user/repo/main/d2/`
+	CacheMinutes int    `json:"cache_minutes,omitempty"` // minutes to cache fetched files (= 10 for default)
+}
+
+// file system for resolving imports in d2 sources (nil if not configured)
+var importFS fs.FS
+
+// a cached file fetched from the base url
+type fetchedImport struct {
+	bs        []byte
+	fetchedAt time.Time
+}
+
+// a read-only file system which fetches files from a base url over http, with caching
+type remoteFS struct {
+	sync.Mutex
+
+	baseURL string
+	ttl     time.Duration
+	client  *http.Client
+
+	fetched map[string]fetchedImport // name => file
+}
+
+// returns a new file system for resolving imports with given settings (or nil if it is not configured)
+func newImportFS(conf *importsConfig) fs.FS {
+	if conf == nil || conf.BaseURL == "" {
+		return nil
+	}
+
+	cacheMinutes := conf.CacheMinutes
+	if cacheMinutes <= 0 {
+		cacheMinutes = defaultImportsCacheMinutes
+	}
+
+	return &remoteFS{
+		baseURL: strings.TrimSuffix(conf.BaseURL, "/") + "/",
+		ttl:     time.Duration(cacheMinutes) * time.Minute,
+		client:  &http.Client{Timeout: importsRequestTimeout},
+		fetched: map[string]fetchedImport{},
+	}
+}
+
+// Open fetches the file with `name` (relative to the base url), or returns the cached one.
+//
+// (implements fs.FS)
+func (f *remoteFS) Open(name string) (fs.File, error) {
+	// NOTE: do not allow escaping the base url (eg. `../secrets.d2` or `/etc/passwd`)
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	f.Lock()
+	cached, exists := f.fetched[name]
+	f.Unlock()
+
+	if !exists || time.Since(cached.fetchedAt) > f.ttl {
+		bs, err := f.fetch(name)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		cached = fetchedImport{bs: bs, fetchedAt: time.Now()}
+
+		f.Lock()
+		f.fetched[name] = cached
+		f.Unlock()
+	}
+
+	return &remoteFile{
+		Reader:  bytes.NewReader(cached.bs),
+		name:    name,
+		modTime: cached.fetchedAt,
+	}, nil
+}
+
+// fetches the file with `name` from the base url
+func (f *remoteFS) fetch(name string) (bs []byte, err error) {
+	var res *http.Response
+	if res, err = f.client.Get(f.baseURL + name); err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		if res.StatusCode == http.StatusNotFound {
+			return nil, fs.ErrNotExist
+		}
+		return nil, fmt.Errorf("server responded with %d", res.StatusCode)
+	}
+
+	// NOTE: read one more byte for checking if it exceeds the limit
+	if bs, err = io.ReadAll(io.LimitReader(res.Body, maxImportedSourceSize+1)); err != nil {
+		return nil, err
+	}
+	if len(bs) > maxImportedSourceSize {
+		return nil, fmt.Errorf("file is too large (over %d KB)", maxImportedSourceSize/1024)
+	}
+	return bs, nil
+}
+
+// a file fetched from the base url
+type remoteFile struct {
+	*bytes.Reader
+
+	name    string
+	modTime time.Time
+}
+
+// Stat returns the info of the file. (implements fs.File)
+func (f *remoteFile) Stat() (fs.FileInfo, error) {
+	return f, nil
+}
+
+// Close does nothing. (implements fs.File)
+func (f *remoteFile) Close() error {
+	return nil
+}
+
+// Name returns the base name of the file. (implements fs.FileInfo)
+func (f *remoteFile) Name() string {
+	return f.name[strings.LastIndex(f.name, "/")+1:]
+}
+
+// Size returns the size of the file. (implements fs.FileInfo)
+func (f *remoteFile) Size() int64 {
+	return f.Reader.Size()
+}
+
+// Mode returns the file mode (read-only). (implements fs.FileInfo)
+func (f *remoteFile) Mode() fs.FileMode {
+	return 0444
+}
+
+// ModTime returns the time when the file was fetched. (implements fs.FileInfo)
+func (f *remoteFile) ModTime() time.Time {
+	return f.modTime
+}
+
+// IsDir returns false, as only files are fetched. (implements fs.FileInfo)
+func (f *remoteFile) IsDir() bool {
+	return false
+}
+
+// Sys returns nil. (implements fs.FileInfo)
+func (f *remoteFile) Sys() any {
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	// infisical
+	infisical "github.com/infisical/go-sdk"
+	"github.com/infisical/go-sdk/packages/models"
+)
+
+// constants for Infisical
+const (
+	defaultInfisicalSiteURL = "https://app.infisical.com"
+
+	infisicalTokenExpiryMargin = 30 * time.Second // re-login a bit earlier than the access token expires
+)
+
+// Infisical settings
+type infisicalConfig struct {
+	SiteURL string `json:"site_url,omitempty"` // for self-hosted instances (= https://app.infisical.com for default)
+
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+
+	ProjectID   string `json:"project_id"`
+	Environment string `json:"environment"`
+	SecretType  string `json:"secret_type"`
+
+	BotTokenKeyPath string `json:"bot_token_key_path"`
+}
+
+// an authenticated session with Infisical, which re-authenticates itself when needed
+type infisicalSession struct {
+	sync.Mutex
+
+	conf infisicalConfig
+
+	client    infisical.InfisicalClientInterface
+	expiresAt time.Time // when the access token expires (zero if it does not)
+}
+
+// cached sessions with Infisical (site url + client id => session)
+var infisicalSessions = struct {
+	sync.Mutex
+	sessions map[string]*infisicalSession
+}{
+	sessions: map[string]*infisicalSession{},
+}
+
+// returns a cached session for given Infisical settings (or a new one if there is none)
+func infisicalSessionFor(conf infisicalConfig) *infisicalSession {
+	if conf.SiteURL == "" {
+		conf.SiteURL = defaultInfisicalSiteURL
+	}
+
+	infisicalSessions.Lock()
+	defer infisicalSessions.Unlock()
+
+	key := conf.SiteURL + "\n" + conf.ClientID
+	if session, exists := infisicalSessions.sessions[key]; exists && session.conf == conf {
+		return session
+	}
+
+	session := &infisicalSession{conf: conf}
+	infisicalSessions.sessions[key] = session
+
+	return session
+}
+
+// authenticates with Infisical (should be called while locked)
+func (s *infisicalSession) login() error {
+	client := infisical.NewInfisicalClient(context.TODO(), infisical.Config{
+		SiteUrl: s.conf.SiteURL,
+	})
+
+	credential, err := client.Auth().UniversalAuthLogin(s.conf.ClientID, s.conf.ClientSecret)
+	if err != nil {
+		s.client = nil
+		return fmt.Errorf("failed to authenticate with Infisical: %s", err)
+	}
+
+	s.client = client
+	s.expiresAt = time.Time{}
+	if credential.ExpiresIn > 0 {
+		s.expiresAt = time.Now().Add(time.Duration(credential.ExpiresIn)*time.Second - infisicalTokenExpiryMargin)
+	}
+
+	return nil
+}
+
+// checks if the session needs to (re-)authenticate (should be called while locked)
+func (s *infisicalSession) needsLogin() bool {
+	return s.client == nil || (!s.expiresAt.IsZero() && time.Now().After(s.expiresAt))
+}
+
+// retrieves the value of a secret at `keyPath` (eg. `/path/to/KEY`),
+// logging in again and retrying once if the access token was expired or revoked.
+func (s *infisicalSession) retrieve(keyPath string) (value string, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.needsLogin() {
+		if err = s.login(); err != nil {
+			return "", err
+		}
+	}
+
+	var secret models.Secret
+	if secret, err = s.retrieveSecret(keyPath); err != nil && isInfisicalAuthError(err) {
+		if err = s.login(); err != nil {
+			return "", err
+		}
+		secret, err = s.retrieveSecret(keyPath)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve '%s' from Infisical: %s", keyPath, err)
+	}
+
+	return secret.SecretValue, nil
+}
+
+// retrieves a secret at `keyPath` with the current client (should be called while locked)
+func (s *infisicalSession) retrieveSecret(keyPath string) (models.Secret, error) {
+	return s.client.Secrets().Retrieve(infisical.RetrieveSecretOptions{
+		ProjectID:   s.conf.ProjectID,
+		Type:        s.conf.SecretType,
+		Environment: s.conf.Environment,
+		SecretPath:  path.Dir(keyPath),
+		SecretKey:   path.Base(keyPath),
+	})
+}
+
+// checks if given error from Infisical is caused by an invalid (eg. expired) access token
+func isInfisicalAuthError(err error) bool {
+	var apiErr *infisical.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden
+	}
+	return false
+}
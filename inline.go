@@ -0,0 +1,272 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// constants for inline mode
+const (
+	defaultInlineCacheTime      = 300 // in seconds
+	defaultInlineMaxCachedFiles = 500
+
+	inlineRenderTimeout     = 10 * time.Second
+	inlineStartParameter    = "inline"
+	maxInlineButtonTextSize = 64
+
+	inlineResultTitle = "Rendered diagram"
+
+	messageInlineHint        = "Type a D2 source (eg. a -> b; b -> c)"
+	messageInlineUnavailable = "Inline mode is not configured in this bot"
+	messageInlineFailed      = "⚠️ %s"
+)
+
+// settings of inline mode (eg. `@bot a -> b` in any chat)
+type inlineConfig struct {
+	CacheChatID    int64 `json:"cache_chat_id"`              // id of a chat where renders are uploaded for their file ids
+	CacheTime      int   `json:"cache_time,omitempty"`       // in seconds, how long telegram caches results of a query (= 300 for default)
+	MaxCachedFiles int   `json:"max_cached_files,omitempty"` // max number of file ids of uploaded renders kept in memory (= 500 for default)
+}
+
+// a file id of an uploaded render
+type inlineFile struct {
+	key    string
+	fileID string
+}
+
+// an in-memory cache of file ids of uploaded renders with LRU eviction
+type inlineFileCache struct {
+	sync.Mutex
+
+	maxEntries int
+
+	entries map[string]*list.Element // key => element (of *inlineFile) in `lru`
+	lru     *list.List               // most recently used first
+}
+
+// cache of file ids of renders uploaded for inline queries
+var inlineFiles = newInlineFileCache(nil)
+
+// returns a new cache of file ids with given settings
+func newInlineFileCache(conf *inlineConfig) *inlineFileCache {
+	maxEntries := defaultInlineMaxCachedFiles
+	if conf != nil && conf.MaxCachedFiles > 0 {
+		maxEntries = conf.MaxCachedFiles
+	}
+
+	return &inlineFileCache{
+		maxEntries: maxEntries,
+		entries:    map[string]*list.Element{},
+		lru:        list.New(),
+	}
+}
+
+// returns the cached file id of `key`
+func (c *inlineFileCache) get(key string) (fileID string, exists bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	if elem, exists := c.entries[key]; exists {
+		c.lru.MoveToFront(elem)
+
+		return elem.Value.(*inlineFile).fileID, true
+	}
+	return "", false
+}
+
+// caches a file id with `key`, evicting least recently used ones over the limit
+func (c *inlineFileCache) put(key, fileID string) {
+	c.Lock()
+	defer c.Unlock()
+
+	if elem, exists := c.entries[key]; exists {
+		c.lru.Remove(elem)
+	}
+	c.entries[key] = c.lru.PushFront(&inlineFile{key: key, fileID: fileID})
+
+	for c.lru.Len() > c.maxEntries {
+		evicted := c.lru.Remove(c.lru.Back()).(*inlineFile)
+		delete(c.entries, evicted.key)
+	}
+}
+
+// inline queries in progress (one for each user)
+type inlineQueries struct {
+	sync.Mutex
+
+	cancels map[int64]*context.CancelFunc // user id => cancel function of the query in progress
+}
+
+// inline queries in progress of this bot
+var pendingInlineQueries = &inlineQueries{
+	cancels: map[int64]*context.CancelFunc{},
+}
+
+// starts a new inline query (derived from `parent`) of `userID`, canceling the previous one in progress
+//
+// (as telegram sends a query on each keystroke, only the last one is worth rendering)
+//
+// Returned `done` function should be called when the query is answered.
+func (q *inlineQueries) start(parent context.Context, userID int64) (ctx context.Context, done func()) {
+	q.Lock()
+	defer q.Unlock()
+
+	if cancel, exists := q.cancels[userID]; exists {
+		(*cancel)()
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	q.cancels[userID] = &cancel
+
+	return ctx, func() {
+		q.Lock()
+		defer q.Unlock()
+
+		cancel()
+
+		if q.cancels[userID] == &cancel {
+			delete(q.cancels, userID)
+		}
+	}
+}
+
+// handle inline query (eg. `@bot a -> b`)
+func handleInlineQuery(ctx context.Context, b *tg.Bot, conf config, query tg.InlineQuery) {
+	if conf.Inline == nil || conf.Inline.CacheChatID == 0 {
+		answerInlineQuery(ctx, b, conf, query.ID, nil, messageInlineUnavailable)
+		return
+	}
+
+	source := strings.TrimSpace(query.Query)
+	if source == "" {
+		answerInlineQuery(ctx, b, conf, query.ID, nil, messageInlineHint)
+		return
+	}
+
+	if err := checkAccessHours(ctx); err != nil {
+		answerInlineQuery(ctx, b, conf, query.ID, nil, fmt.Sprintf(messageInlineFailed, err))
+		return
+	}
+
+	ctx, done := pendingInlineQueries.start(ctx, query.From.ID)
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, inlineRenderTimeout)
+	defer cancel()
+
+	// NOTE: .png files are sent as photos, or .svg files as documents in a build without a browser
+	opts := renderOptionsForUser(conf, query.From.ID)
+	opts.Format = formatPhoto
+	if !browserAvailable {
+		opts.Format = formatSVG
+	}
+
+	fileID, err := inlineFileID(ctx, b, conf.Inline.CacheChatID, source, opts)
+	if err != nil {
+		if errors.Is(err, context.Canceled) { // NOTE: superseded by a newer query of the user
+			return
+		}
+
+		logf(ctx, "failed to render inline query: %s", err)
+
+		answerInlineQuery(ctx, b, conf, query.ID, nil, fmt.Sprintf(messageInlineFailed, explainError(source, err)))
+		return
+	}
+
+	var result any
+	if opts.Format == formatPhoto {
+		photo, _ := tg.NewInlineQueryResultCachedPhoto(fileID)
+		result = photo.SetTitle(inlineResultTitle).SetDescription(source)
+	} else {
+		document, _ := tg.NewInlineQueryResultCachedDocument(inlineResultTitle, fileID)
+		result = document.SetDescription(source)
+	}
+	answerInlineQuery(ctx, b, conf, query.ID, []any{result}, "")
+}
+
+// returns the file id of given d2 source rendered with `opts`,
+// rendering and uploading it to `chatID` if it is not cached yet
+//
+// (inline results can only have files of public urls or file ids)
+func inlineFileID(ctx context.Context, b *tg.Bot, chatID int64, source string, opts renderOptions) (fileID string, err error) {
+	key := renderCacheKey(source, opts)
+	if fileID, exists := inlineFiles.get(key); exists {
+		return fileID, nil
+	}
+
+	var bs []byte
+	if bs, _, err = renderDiagram(ctx, source, opts, nil); err != nil {
+		return "", err
+	}
+
+	// NOTE: identical renders (eg. sent in chats) are not uploaded again
+	fileKey := fileIDKey(bs, opts.Format)
+	if fileID, exists := storage.loadFileID(fileKey); exists {
+		inlineFiles.put(key, fileID)
+		return fileID, nil
+	}
+
+	if e := withNamedFile("diagram."+opts.Format.extension(), bs, func(file tg.InputFile) {
+		var sent tg.APIResponse[tg.Message]
+		if err = retries.do(ctx, nil, func() error {
+			if sent = sendFile(b, chatID, 0, file, nil, opts.Format, nil); sent.Ok {
+				return nil
+			}
+			return sendError(sent.Description, sent.Parameters)
+		}); err == nil {
+			fileID = fileIDOfMessage(*sent.Result)
+		}
+	}); e != nil {
+		return "", e
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to upload render: %w", err)
+	}
+	if fileID == "" {
+		return "", errors.New("no file in the uploaded message")
+	}
+
+	inlineFiles.put(key, fileID)
+	if err = storage.saveFileID(fileKey, fileID); err != nil {
+		logf(ctx, "failed to save file id: %s", err)
+	}
+
+	return fileID, nil
+}
+
+// answers an inline query with `results`, or with a button of `text` (eg. a hint, or an error) linking to this bot
+func answerInlineQuery(ctx context.Context, b *tg.Bot, conf config, queryID string, results []any, text string) {
+	cacheTime := defaultInlineCacheTime
+	if conf.Inline != nil && conf.Inline.CacheTime > 0 {
+		cacheTime = conf.Inline.CacheTime
+	}
+
+	// NOTE: results are personal, as they are rendered with settings of each user
+	options := tg.OptionsAnswerInlineQuery{}.
+		SetIsPersonal(true)
+	if results == nil {
+		results = []any{}
+	} else {
+		options = options.SetCacheTime(cacheTime)
+	}
+	if text != "" {
+		if runes := []rune(strings.Join(strings.Fields(text), " ")); len(runes) > maxInlineButtonTextSize {
+			text = string(runes[:maxInlineButtonTextSize-1]) + "…"
+		} else {
+			text = string(runes)
+		}
+		options = options.SetButton(tg.NewInlineQueryResultsButton(text).SetStartParameter(inlineStartParameter))
+	}
+
+	if answered := b.AnswerInlineQuery(queryID, results, options); !answered.Ok {
+		logf(ctx, "failed to answer inline query: %s", *answered.Description)
+	}
+}
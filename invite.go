@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// constants for invitations
+const (
+	commandInvite = "/invite"
+
+	deepLinkPrefixInvite = "inv_"
+
+	invitationCodeLength = 8 // in bytes (16 hex characters)
+	invitationTTL        = 7 * 24 * time.Hour
+
+	messageUsageInvite       = "Usage: /invite [user|readonly]"
+	messageInvitation        = "Share this one-time invitation (as %s, valid until %s):\n\n%s"
+	messageNoUsername        = "You need a telegram username to redeem an invitation."
+	messageInvitationInvalid = "This invitation is not valid (already used, or expired)."
+	messageInvitationWelcome = "Welcome! You were invited as %s."
+)
+
+// a one-time invitation
+type invitation struct {
+	Role      role      `json:"role"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// returns a new (random) invitation code
+func newInvitationCode() (string, error) {
+	bs := make([]byte, invitationCodeLength)
+	if _, err := rand.Read(bs); err != nil {
+		return "", err
+	}
+	return deepLinkPrefixInvite + hex.EncodeToString(bs), nil
+}
+
+// returns a deep link for redeeming the invitation with `code`
+func invitationDeepLink(b *tg.Bot, code string) string {
+	if me := b.GetMe(); me.Ok && me.Result.Username != nil {
+		return fmt.Sprintf("https://t.me/%s?start=%s", *me.Result.Username, code)
+	}
+	return fmt.Sprintf("%s %s", commandStart, code)
+}
+
+// checks if given update is a `/start` command with an invitation code
+func isInvitationRedemption(router *updateRouter, update tg.Update) bool {
+	command, args, isCommand := router.commandOf(update)
+	return isCommand && command == commandStart && strings.HasPrefix(strings.TrimSpace(args), deepLinkPrefixInvite)
+}
+
+// handle invite command
+func handleInviteCommand(b *tg.Bot, update tg.Update, args string) {
+	if message, _ := update.GetMessage(); message != nil {
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		r := roleUser
+		if args = strings.TrimSpace(args); args != "" {
+			r = role(args)
+		}
+		if !slices.Contains([]role{roleUser, roleReadonly}, r) {
+			replyError(b, chatID, messageID, messageUsageInvite)
+			return
+		}
+
+		code, err := newInvitationCode()
+		if err != nil {
+			replyError(b, chatID, messageID, err.Error())
+			return
+		}
+
+		var createdBy string
+		if message.From.Username != nil {
+			createdBy = *message.From.Username
+		}
+		inv := invitation{
+			Role:      r,
+			CreatedBy: createdBy,
+			ExpiresAt: time.Now().Add(invitationTTL),
+		}
+		if err := storage.saveInvitation(code, inv); err != nil {
+			replyError(b, chatID, messageID, err.Error())
+			return
+		}
+
+		replyText(b, chatID, messageID, fmt.Sprintf(messageInvitation, r, inv.ExpiresAt.Format(time.RFC3339), invitationDeepLink(b, code)))
+	}
+}
+
+// handle `/start <code>` for redeeming an invitation
+func handleInvitationRedemption(ctx context.Context, b *tg.Bot, conf config, update tg.Update, code string) {
+	if message, _ := update.GetMessage(); message != nil {
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		if message.From.Username == nil {
+			replyError(b, chatID, messageID, messageNoUsername)
+			return
+		}
+		username := *message.From.Username
+
+		r, err := storage.redeemInvitation(code, username)
+		if err != nil {
+			logf(ctx, "failed to redeem invitation: %s", err)
+
+			replyError(b, chatID, messageID, messageInvitationInvalid)
+			return
+		}
+
+		logf(ctx, "@%s redeemed an invitation as %s", username, r)
+
+		replyText(b, chatID, messageID, fmt.Sprintf(messageInvitationWelcome, r))
+		handleHelpCommand(ctx, b, conf, update)
+	}
+}
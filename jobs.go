@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// in-progress render jobs of users
+type renderJobs struct {
+	sync.Mutex
+
+	lastID  uint64
+	cancels map[int64]map[uint64]context.CancelFunc // user id => job id => cancel function
+}
+
+// in-progress render jobs of this bot
+var jobs = &renderJobs{
+	cancels: map[int64]map[uint64]context.CancelFunc{},
+}
+
+// start tracks a new render job (derived from `parent`) for given `userID`.
+//
+// Returned `done` function should be called when the job is finished.
+func (j *renderJobs) start(parent context.Context, userID int64) (ctx context.Context, done func()) {
+	j.Lock()
+	defer j.Unlock()
+
+	ctx, cancel := context.WithCancel(parent)
+
+	j.lastID++
+	jobID := j.lastID
+
+	if _, exists := j.cancels[userID]; !exists {
+		j.cancels[userID] = map[uint64]context.CancelFunc{}
+	}
+	j.cancels[userID][jobID] = cancel
+
+	return ctx, func() {
+		j.Lock()
+		defer j.Unlock()
+
+		cancel()
+
+		delete(j.cancels[userID], jobID)
+		if len(j.cancels[userID]) == 0 {
+			delete(j.cancels, userID)
+		}
+	}
+}
+
+// cancel cancels all render jobs of given `userID`, and returns the number of canceled ones.
+func (j *renderJobs) cancel(userID int64) (canceled int) {
+	j.Lock()
+	defer j.Unlock()
+
+	for _, cancel := range j.cancels[userID] {
+		cancel()
+		canceled++
+	}
+	delete(j.cancels, userID)
+
+	return canceled
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// constants for the job journal
+const (
+	journalFilename = "jobs.json"
+
+	messageResumingJob = "Sorry for the delay: resuming your render which was interrupted by a restart of the bot."
+)
+
+// a render job journaled until it is finished, so that it can be resumed after a crash or a restart
+type journaledJob struct {
+	UserID    int64         `json:"user_id"`
+	ChatID    int64         `json:"chat_id"`
+	MessageID int64         `json:"message_id"`
+	Sources   []string      `json:"sources"`
+	Options   renderOptions `json:"options"`
+	Quotable  string        `json:"quotable,omitempty"`
+	QueuedAt  time.Time     `json:"queued_at"`
+	DueAt     *time.Time    `json:"due_at,omitempty"` // when a job deferred out of access hours is due
+}
+
+// unfinished render jobs, saved in a small file of their own
+//
+// (so that the whole store is not rewritten on every render)
+type jobJournal struct {
+	sync.Mutex
+
+	filepath string
+
+	jobs map[string]journaledJob // id => unfinished render job
+}
+
+// journal of render jobs (initialized on start)
+var jobsJournal *jobJournal
+
+// opens (or creates) the journal file of render jobs at given `path`
+func openJobJournal(path string) (j *jobJournal, err error) {
+	j = &jobJournal{
+		filepath: path,
+		jobs:     map[string]journaledJob{},
+	}
+
+	var bytes []byte
+	if bytes, err = os.ReadFile(path); err == nil {
+		if err = json.Unmarshal(bytes, &j.jobs); err != nil {
+			return nil, fmt.Errorf("failed to read journal file '%s': %w", path, err)
+		}
+		if j.jobs == nil {
+			j.jobs = map[string]journaledJob{}
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to open journal file '%s': %w", path, err)
+	}
+
+	return j, nil
+}
+
+// saves an unfinished render job with `id`
+func (j *jobJournal) journal(id string, job journaledJob) error {
+	j.Lock()
+	defer j.Unlock()
+
+	j.jobs[id] = job
+
+	return j.persist()
+}
+
+// removes the render job with `id`
+func (j *jobJournal) finish(id string) error {
+	j.Lock()
+	defer j.Unlock()
+
+	if _, exists := j.jobs[id]; !exists {
+		return nil
+	}
+	delete(j.jobs, id)
+
+	return j.persist()
+}
+
+// returns a copy of unfinished render jobs (keyed by ids)
+func (j *jobJournal) unfinished() map[string]journaledJob {
+	j.Lock()
+	defer j.Unlock()
+
+	jobs := map[string]journaledJob{}
+	for id, job := range j.jobs {
+		jobs[id] = job
+	}
+	return jobs
+}
+
+// saves unfinished render jobs to the file (should be called while locked)
+func (j *jobJournal) persist() (err error) {
+	var bytes []byte
+	if bytes, err = json.Marshal(j.jobs); err == nil {
+		// write to a temporary file first, then replace the original one
+		tmp := j.filepath + ".tmp"
+		if err = os.WriteFile(tmp, bytes, 0600); err == nil {
+			err = os.Rename(tmp, j.filepath)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save journal file '%s': %w", j.filepath, err)
+	}
+	return nil
+}
+
+// for resuming unfinished jobs only once (not on every reload)
+var resumeJobsOnce sync.Once
+
+// returns a new id for a journaled job
+func newJournalID(chatID, messageID int64) string {
+	return fmt.Sprintf("%d:%d:%s", chatID, messageID, newRequestID())
+}
+
+// resumes jobs which were left unfinished in the journal by the previous run of this bot
+//
+// (should be called before any new job is journaled)
+func resumeUnfinishedJobs(b *tg.Bot, conf config) {
+	resumeJobsOnce.Do(func() {
+		unfinished := jobsJournal.unfinished()
+		if len(unfinished) == 0 {
+			return
+		}
+
+		// deferred jobs are scheduled again (in the order they were queued), and run when they are due
+		deferred := []string{}
+		for id, job := range unfinished {
+			if job.DueAt != nil {
+				deferred = append(deferred, id)
+			}
+		}
+		sort.Slice(deferred, func(i, j int) bool {
+			return unfinished[deferred[i]].QueuedAt.Before(unfinished[deferred[j]].QueuedAt)
+		})
+		for _, id := range deferred {
+			deferredJobs.schedule(b, conf, id, unfinished[id])
+			delete(unfinished, id)
+		}
+		if len(deferred) > 0 {
+			log.Printf("scheduled %d deferred render job(s)", len(deferred))
+		}
+		if len(unfinished) == 0 {
+			return
+		}
+
+		log.Printf("resuming %d unfinished render job(s)", len(unfinished))
+
+		go func() {
+			for id, job := range unfinished {
+				ctx := withRequestID(context.Background(), newRequestID())
+
+				// NOTE: remove it first, as it will be journaled again as a new job
+				if err := jobsJournal.finish(id); err != nil {
+					logf(ctx, "failed to remove unfinished job: %s", err)
+				}
+
+				replyText(b, job.ChatID, job.MessageID, messageResumingJob)
+				replyRenderedWithOptions(ctx, b, conf, job.UserID, job.ChatID, job.MessageID, job.Sources, job.Options, job.Quotable)
+			}
+		}()
+	})
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// constants for re-rendering the last source
+const (
+	commandLast = "/last"
+
+	messageLastUsage = "Usage: /last [theme=ID] [sketch=true|false] [layout=dagre|elk] [format=png|photo|svg|pdf] [scale=N] [preset=print] [direction=right|down|left|up|auto]"
+	messageNoLast    = "You have not rendered any diagram yet."
+)
+
+// handle last command
+func handleLastCommand(ctx context.Context, b *tg.Bot, conf config, update tg.Update, args string) {
+	if message, _ := update.GetMessage(); message != nil {
+		userID := message.From.ID
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		values, others := parseKeyValues(splitArgs(args))
+		if len(others) > 0 {
+			replyError(b, chatID, messageID, messageLastUsage)
+			return
+		}
+
+		opts, err := applyRenderArgs(renderOptionsForChat(conf, userID, chatID), values)
+		if err != nil {
+			replyError(b, chatID, messageID, err.Error())
+			return
+		}
+
+		if entries := storage.recentHistory(userID, 1); len(entries) > 0 {
+			replyRenderedWithOptions(ctx, b, conf, userID, chatID, messageID, []string{entries[0].Source}, opts, "")
+		} else {
+			replyText(b, chatID, messageID, messageNoLast)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	// d2
+	"oss.terrastruct.com/d2/d2graph"
+)
+
+// constants for LaTeX blocks
+const (
+	languageLaTeX = "latex" // NOTE: `|tex ...|` blocks are also compiled into this language
+
+	latexPixelsPerEx = 8 // d2 measures LaTeX blocks with 8 pixels per ex
+)
+
+// error returned when a diagram has LaTeX blocks, but they are disabled in the config
+var errLaTeXDisabled = errors.New("LaTeX blocks (eg. `|latex ...|`) are disabled in this bot: remove them, or ask the operator to enable them (`disable_latex` in the config)")
+
+// sizes (in ex) of svg elements of LaTeX blocks rendered by MathJax
+var latexSize = regexp.MustCompile(`(<svg[^>]*?) width="([0-9.]+)ex" height="([0-9.]+)ex"`)
+
+// checks if given board has any LaTeX block
+func hasLaTeX(graph *d2graph.Graph) bool {
+	for _, obj := range graph.Objects {
+		if obj.Language == languageLaTeX {
+			return true
+		}
+	}
+	return false
+}
+
+// converts sizes of LaTeX blocks in given svg file from ex to pixels, as d2 measured them
+//
+// (sizes in ex are resolved with the font of the browser, so LaTeX blocks are scaled out of their shapes in .png and .pdf files)
+func fixLaTeXSizes(svg []byte) []byte {
+	return latexSize.ReplaceAllFunc(svg, func(match []byte) []byte {
+		groups := latexSize.FindSubmatch(match)
+		width, _ := strconv.ParseFloat(string(groups[2]), 64)
+		height, _ := strconv.ParseFloat(string(groups[3]), 64)
+
+		return []byte(fmt.Sprintf(`%s width="%g" height="%g"`, groups[1], width*latexPixelsPerEx, height*latexPixelsPerEx))
+	})
+}
+
+// returns an error of LaTeX blocks in given board, with a hint
+func latexError(err error) error {
+	return fmt.Errorf("failed to render LaTeX block (check its syntax, eg. unbalanced braces or unknown commands): %w", err)
+}
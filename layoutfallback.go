@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	// d2
+	"oss.terrastruct.com/d2/d2graph"
+	d2log "oss.terrastruct.com/d2/lib/log"
+)
+
+// constants for falling back to another layout engine
+const (
+	defaultLayoutFallbackEngine  = layoutDagre
+	defaultLayoutFallbackTimeout = 30 // in seconds
+
+	messageLayoutFallback = "📐 Laid out with %s, as %s failed: %s"
+)
+
+// notes of fallbacks in warnings of renders
+var layoutFallbackNote = regexp.MustCompile(`^📐 Laid out with \S+, as \S+ failed: `)
+
+// settings of the fallback layout engine
+type layoutFallbackConfig struct {
+	Engine   string `json:"engine,omitempty"`  // layout engine to fall back to (= `dagre` for default)
+	Timeout  int    `json:"timeout,omitempty"` // in seconds, time budget of other layout engines (= 30 for default)
+	Disabled bool   `json:"disabled,omitempty"`
+}
+
+// fallback of layout engines (nil if disabled)
+type layoutFallback struct {
+	engine  string
+	timeout time.Duration
+}
+
+// fallback with current settings (replaced when the bot is served with a new config)
+var layoutFallbacks = newLayoutFallback(nil)
+
+// returns a new fallback with given settings (or the default settings if `conf` is nil, or nil if it is disabled)
+func newLayoutFallback(conf *layoutFallbackConfig) *layoutFallback {
+	f := &layoutFallback{
+		engine:  defaultLayoutFallbackEngine,
+		timeout: defaultLayoutFallbackTimeout * time.Second,
+	}
+	if conf != nil {
+		if conf.Disabled {
+			return nil
+		}
+		if conf.Engine != "" {
+			f.engine = conf.Engine
+		}
+		if conf.Timeout > 0 {
+			f.timeout = time.Duration(conf.Timeout) * time.Second
+		}
+	}
+	return f
+}
+
+// an error of a layout engine which failed (or exceeded its time budget) on a graph
+type layoutError struct {
+	engine string
+	err    error
+}
+
+// returns the message of the error
+func (e layoutError) Error() string {
+	return fmt.Sprintf("failed to lay out with %s: %s", e.engine, e.err)
+}
+
+// returns the wrapped error
+func (e layoutError) Unwrap() error {
+	return e.err
+}
+
+// lays out given graph with the layout engine of `layout`, in the time budget unless it is the fallback engine
+//
+// (returns a `layoutError` if the engine fails, or exceeds its time budget)
+func (f *layoutFallback) layout(ctx context.Context, graph *d2graph.Graph, layout string) (err error) {
+	if f == nil || layout == f.engine {
+		return layoutGraph(ctx, graph, layout)
+	}
+
+	budget, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+
+	// NOTE: some engines (eg. elk) do not stop when canceled, so they are left running on the graph (which is dropped) after the time budget
+	laidOut := make(chan error, 1)
+	go func() {
+		laidOut <- layoutGraph(budget, graph, layout)
+	}()
+
+	select {
+	case err = <-laidOut:
+	case <-budget.Done():
+		err = fmt.Errorf("exceeded the time budget of %s", f.timeout)
+	}
+	if err != nil && ctx.Err() == nil {
+		return layoutError{engine: layout, err: err}
+	}
+	return err
+}
+
+// runs `fn` (a render) with `opts`, and runs it again with the fallback engine if its layout engine failed
+//
+// (the fallback is noted as a warning of the render)
+func withLayoutFallback[T any](ctx context.Context, opts renderOptions, fn func(opts renderOptions) (T, error)) (T, error) {
+	result, err := fn(opts)
+
+	var layoutErr layoutError
+	if f := layoutFallbacks; f != nil && opts.Layout != f.engine && errors.As(err, &layoutErr) {
+		logf(ctx, "falling back to %s: %s", f.engine, err)
+
+		d2log.Warn(ctx, fmt.Sprintf(messageLayoutFallback, f.engine, layoutErr.engine, layoutErr.err))
+
+		opts.Layout = f.engine
+		return fn(opts)
+	}
+	return result, err
+}
+
+// splits notes of layout fallbacks from given warnings of a render
+func splitLayoutFallbackNotes(warnings []string) (notes, others []string) {
+	for _, warning := range warnings {
+		if layoutFallbackNote.MatchString(warning) {
+			notes = append(notes, warning)
+		} else {
+			others = append(others, warning)
+		}
+	}
+	return notes, others
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// constants for the library
+const (
+	commandSave    = "/save"
+	commandLibrary = "/library"
+	commandDelete  = "/delete"
+
+	deepLinkPrefixLibrary = "lib_"
+
+	messageSaveUsage       = "Usage: reply to a D2 message with /save <name> (or /publish <name>), or send it followed by a D2 source in the next line."
+	messageInvalidName     = "Invalid name: '%s' (only alphanumerics, '_', and '-' are allowed, up to 60 characters)"
+	messageSaved           = "Saved '%s' to your library:\n%s"
+	messageLibraryEmpty    = "Your library is empty. Save a diagram with /save first."
+	messageLibraryHeader   = "Your library:\n\n"
+	messageNoSuchDiagram   = "There is no diagram named '%s' in your library."
+	messageDeleted         = "Deleted '%s' from your library."
+	messageDeleteUsage     = "Usage: /delete <name>"
+	messageMultipleSources = "The message has multiple diagrams, save them one by one."
+)
+
+// names of saved diagrams are also used in deep links,
+// (`start` parameter: up to 64 characters of [A-Za-z0-9_-], including the prefix)
+var libraryName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,60}$`)
+
+// returns a deep link (to the bot with `botUsername`) for rendering the saved diagram with `name`
+func libraryDeepLink(botUsername, name string) string {
+	if botUsername != "" {
+		return fmt.Sprintf("https://t.me/%s?start=%s%s", botUsername, deepLinkPrefixLibrary, name)
+	}
+	return fmt.Sprintf("%s %s", commandStart, deepLinkPrefixLibrary+name)
+}
+
+// returns the name and the d2 source of a command like `/save <name>`,
+// where the source is given in the following lines or from the replied message.
+func nameAndSourceOfCommand(b *tg.Bot, message *tg.Message, args string) (name, source string, err error) {
+	name, source, _ = strings.Cut(args, "\n")
+	name = strings.TrimSpace(name)
+	source = strings.TrimSpace(source)
+
+	if name == "" {
+		return "", "", fmt.Errorf("%s", messageSaveUsage)
+	}
+	if !libraryName.MatchString(name) {
+		return "", "", fmt.Errorf(messageInvalidName, name)
+	}
+
+	if source == "" && message.ReplyToMessage != nil {
+		var sources []string
+		if sources, err = sourcesOfMessage(b, message.ReplyToMessage); err != nil {
+			return "", "", err
+		}
+		if len(sources) > 1 {
+			return "", "", fmt.Errorf("%s", messageMultipleSources)
+		}
+		source = sources[0]
+	}
+	if source == "" {
+		return "", "", fmt.Errorf("%s", messageSaveUsage)
+	}
+
+	return name, source, nil
+}
+
+// handle save command
+func handleSaveCommand(ctx context.Context, b *tg.Bot, conf config, update tg.Update, botUsername, args string) {
+	if message, _ := update.GetMessage(); message != nil {
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		name, source, err := nameAndSourceOfCommand(b, message, args)
+		if err != nil {
+			replyError(b, chatID, messageID, err.Error())
+			return
+		}
+
+		previous, existed := storage.loadFromLibrary(message.From.ID, name)
+
+		if err = storage.saveToLibrary(message.From.ID, name, source); err == nil {
+			replyText(b, chatID, messageID, fmt.Sprintf(messageSaved, name, libraryDeepLink(botUsername, name)))
+
+			// reply before/after images for updated diagrams, if the user wants
+			if existed && previous.Source != source && storage.loadSettings(message.From.ID).CompareEdits {
+				opts := renderOptionsForChat(conf, message.From.ID, chatID)
+				replyCompared(ctx, b, conf, message.From.ID, chatID, messageID, [2]string{previous.Source, source}, [2]renderOptions{opts, opts}, labelsBeforeAfter)
+			}
+		} else {
+			logf(ctx, "failed to save diagram: %s", err)
+
+			replyError(b, chatID, messageID, fmt.Sprintf("Failed to save diagram: %s", err))
+		}
+	}
+}
+
+// handle library command
+func handleLibraryCommand(ctx context.Context, b *tg.Bot, conf config, update tg.Update, botUsername string) {
+	if message, _ := update.GetMessage(); message != nil {
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		names := storage.libraryNames(message.From.ID)
+		if len(names) == 0 {
+			replyText(b, chatID, messageID, messageLibraryEmpty)
+			return
+		}
+
+		lines := []string{}
+		for _, name := range names {
+			lines = append(lines, fmt.Sprintf("• %s: %s", name, libraryDeepLink(botUsername, name)))
+		}
+
+		text := messageLibraryHeader + strings.Join(lines, "\n")
+		if pages := splitPages(text); len(pages) > 1 {
+			replyPages(b, chatID, messageID, pages)
+			return
+		}
+
+		if sent := b.SendMessage(
+			chatID,
+			text,
+			tg.OptionsSendMessage{}.
+				SetLinkPreviewOptions(tg.LinkPreviewOptions{IsDisabled: toPointer(true)}).
+				SetReplyParameters(tg.NewReplyParameters(messageID))); !sent.Ok {
+			logf(ctx, "failed to send library: %s", *sent.Description)
+		}
+	}
+}
+
+// handle delete command
+func handleDeleteCommand(ctx context.Context, b *tg.Bot, conf config, update tg.Update, args string) {
+	if message, _ := update.GetMessage(); message != nil {
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		name := strings.TrimSpace(args)
+		if name == "" {
+			replyError(b, chatID, messageID, messageDeleteUsage)
+			return
+		}
+
+		if deleted, err := storage.deleteFromLibrary(message.From.ID, name); err != nil {
+			logf(ctx, "failed to delete diagram: %s", err)
+
+			replyError(b, chatID, messageID, fmt.Sprintf("Failed to delete diagram: %s", err))
+		} else if !deleted {
+			replyError(b, chatID, messageID, fmt.Sprintf(messageNoSuchDiagram, name))
+		} else {
+			replyText(b, chatID, messageID, fmt.Sprintf(messageDeleted, name))
+		}
+	}
+}
+
+// handle deep link for rendering a saved diagram (`/start lib_<name>`)
+func handleLibraryDeepLink(ctx context.Context, b *tg.Bot, conf config, update tg.Update, name string) {
+	if message, _ := update.GetMessage(); message != nil {
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		if entry, exists := storage.loadFromLibrary(message.From.ID, name); exists {
+			replyRendered(ctx, b, conf, message.From.ID, chatID, messageID, []string{entry.Source})
+		} else {
+			replyError(b, chatID, messageID, fmt.Sprintf(messageNoSuchDiagram, name))
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// constants for log files
+const (
+	defaultLogFileMaxSizeMB  = 10
+	defaultLogFileMaxBackups = 5
+
+	logFileTimestampFormat = "20060102T150405"
+)
+
+// configuration of the log file
+type logFileConfig struct {
+	Filepath   string `json:"filepath"`
+	MaxSizeMB  int    `json:"max_size_mb,omitempty"`  // rotate when the file gets larger than this (= 10 for default)
+	MaxAgeDays int    `json:"max_age_days,omitempty"` // rotate when the file gets older than this (0 for no age-based rotation)
+	MaxBackups int    `json:"max_backups,omitempty"`  // number of rotated files to keep (= 5 for default)
+	Compress   bool   `json:"compress,omitempty"`     // whether to gzip rotated files
+}
+
+// a log file which is rotated by its size and age
+type rotatingFile struct {
+	sync.Mutex
+
+	conf logFileConfig
+
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+// openLogFile opens (or creates) a rotating log file with given config.
+func openLogFile(conf logFileConfig) (f *rotatingFile, err error) {
+	if conf.MaxSizeMB <= 0 {
+		conf.MaxSizeMB = defaultLogFileMaxSizeMB
+	}
+	if conf.MaxBackups <= 0 {
+		conf.MaxBackups = defaultLogFileMaxBackups
+	}
+
+	f = &rotatingFile{conf: conf}
+	if err = f.open(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// opens the log file for appending (should be called while locked)
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.conf.Filepath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open log file '%s': %w", f.conf.Filepath, err)
+	}
+
+	var info os.FileInfo
+	if info, err = file.Stat(); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to stat log file '%s': %w", f.conf.Filepath, err)
+	}
+
+	f.file = file
+	f.size = info.Size()
+	f.openedAt = info.ModTime()
+	if f.size == 0 {
+		f.openedAt = time.Now()
+	}
+
+	return nil
+}
+
+// Write writes given bytes to the log file, rotating it first if needed.
+func (f *rotatingFile) Write(p []byte) (n int, err error) {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.needsRotation(len(p)) {
+		if err = f.rotate(); err != nil {
+			// NOTE: keep writing to the current file if rotation fails
+			fmt.Fprintf(os.Stderr, "failed to rotate log file: %s\n", err)
+		}
+	}
+
+	n, err = f.file.Write(p)
+	f.size += int64(n)
+
+	return n, err
+}
+
+// checks if the log file should be rotated before writing `length` bytes (should be called while locked)
+func (f *rotatingFile) needsRotation(length int) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.size+int64(length) > int64(f.conf.MaxSizeMB)*1024*1024 {
+		return true
+	}
+	if f.conf.MaxAgeDays > 0 && time.Since(f.openedAt) > time.Duration(f.conf.MaxAgeDays)*24*time.Hour {
+		return true
+	}
+	return false
+}
+
+// renames the current log file with a timestamp and opens a new one (should be called while locked)
+func (f *rotatingFile) rotate() (err error) {
+	if err = f.file.Close(); err != nil {
+		return err
+	}
+
+	ext := filepath.Ext(f.conf.Filepath)
+	rotated := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(f.conf.Filepath, ext), time.Now().Format(logFileTimestampFormat), ext)
+	if err = os.Rename(f.conf.Filepath, rotated); err != nil {
+		_ = f.open()
+		return err
+	}
+	if err = f.open(); err != nil {
+		return err
+	}
+
+	// compress and prune rotated files in the background
+	go func(conf logFileConfig) {
+		if conf.Compress {
+			if err := gzipFile(rotated); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to compress rotated log file: %s\n", err)
+			}
+		}
+		pruneLogFiles(conf)
+	}(f.conf)
+
+	return nil
+}
+
+// compresses the file at `path` into `path`.gz, and removes the original one
+func gzipFile(path string) (err error) {
+	var src *os.File
+	if src, err = os.Open(path); err != nil {
+		return err
+	}
+	defer src.Close()
+
+	var dst *os.File
+	if dst, err = os.OpenFile(path+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600); err != nil {
+		return err
+	}
+
+	w := gzip.NewWriter(dst)
+	if _, err = io.Copy(w, src); err == nil {
+		err = w.Close()
+	}
+	if e := dst.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		_ = os.Remove(path + ".gz")
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// removes rotated log files except the latest `max_backups` ones
+func pruneLogFiles(conf logFileConfig) {
+	ext := filepath.Ext(conf.Filepath)
+	matches, err := filepath.Glob(strings.TrimSuffix(conf.Filepath, ext) + "-*" + ext + "*")
+	if err != nil {
+		return
+	}
+
+	// NOTE: timestamps in the names sort rotated files from oldest to newest
+	sort.Strings(matches)
+	for len(matches) > conf.MaxBackups {
+		if err := os.Remove(matches[0]); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to remove old log file: %s\n", err)
+		}
+		matches = matches[1:]
+	}
+}
+
+// redirects logs to a rotating log file with given config
+func setupLogFile(conf *logFileConfig) error {
+	if conf == nil || conf.Filepath == "" {
+		return nil
+	}
+
+	c := *conf
+	c.Filepath = resolvePath(dirs.state, c.Filepath)
+
+	f, err := openLogFile(c)
+	if err != nil {
+		return err
+	}
+	log.SetOutput(f)
+
+	return nil
+}
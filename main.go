@@ -1,30 +1,39 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"log"
 	"os"
-
-	// playwright
-	"github.com/playwright-community/playwright-go"
 )
 
 const (
 	messageUsage = `Usage:
 
-	$ %s [CONFIG_FILE_PATH]
+	$ %s [FLAGS] [CONFIG_FILE_PATH or CONFIG_URL]
+
+or without a config file:
+
+	$ BOT_TOKEN=xxxx ALLOWED_IDS=user1,user2 %s [FLAGS]
+	$ echo xxxx | ALLOWED_IDS=user1,user2 %s [FLAGS]
+
+Flags:
 `
 )
 
 func main() {
-	// install playwright browsers
-	if err := playwright.Install(); err != nil {
-		log.Printf("failed to install playwright browsers: %s", err)
-		return
-	}
+	flag.StringVar(&browserFlags.BrowsersPath, "browsers-path", "", "directory where browsers are installed (overrides `browser.browsers_path`)")
+	flag.StringVar(&browserFlags.DriverPath, "driver-path", "", "directory where the playwright driver is installed (overrides `browser.driver_path`)")
+	flag.BoolVar(&browserFlags.SkipInstall, "skip-browser-install", false, "skip installing browsers on startup, and fail if they are missing (overrides `browser.skip_install`)")
+	flag.StringVar(&browserFlags.Channel, "browser-channel", "", "channel of a system browser, eg. chrome or msedge (overrides `browser.channel`)")
+	flag.StringVar(&browserFlags.ExecutablePath, "browser-path", "", "path of a system Chrome/Chromium executable (overrides `browser.executable_path`)")
+	flag.BoolVar(&useTestDC, "test-dc", false, "use the test environment of telegram (with a bot token issued there)")
+	flag.Usage = func() { printUsage(os.Args[0]) }
+	flag.Parse()
 
-	if len(os.Args) > 1 {
-		runBot(os.Args[1])
+	if flag.NArg() > 0 {
+		runBot(flag.Arg(0))
+	} else if canBootstrap() {
+		runBot("")
 	} else {
 		printUsage(os.Args[0])
 	}
@@ -32,5 +41,6 @@ func main() {
 
 // prints usage text to standard out
 func printUsage(progName string) {
-	fmt.Printf(messageUsage, progName)
+	fmt.Printf(messageUsage, progName, progName, progName)
+	flag.PrintDefaults()
 }
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"time"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// constants for middlewares
+const (
+	rateLimitWindow = time.Minute
+
+	messageRateLimited   = "Too many requests: up to %d request(s) per minute are allowed. Please try again later."
+	messageInternalError = "An internal error occurred while handling your request."
+)
+
+// commands which are handled for everyone, even for users who are not allowed
+var publicCommands = []string{commandPrivacy}
+
+// handles an update
+type updateHandlerFunc func(ctx context.Context, b *tg.Bot, update tg.Update)
+
+// wraps an update handler with a cross-cutting concern
+type middleware func(next updateHandlerFunc) updateHandlerFunc
+
+// chains `middlewares` in front of `handler`, so that the first one is run first
+func chainMiddlewares(handler updateHandlerFunc, middlewares ...middleware) updateHandlerFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// returns a middleware which assigns a new request id to each update, so that it can be traced through logs
+func requestIDMiddleware(conf config) middleware {
+	return func(next updateHandlerFunc) updateHandlerFunc {
+		return func(ctx context.Context, b *tg.Bot, update tg.Update) {
+			ctx = withRequestID(ctx, newRequestID())
+
+			if conf.IsVerbose {
+				logf(ctx, "handling update: %d", update.UpdateID)
+			}
+
+			next(ctx, b, update)
+		}
+	}
+}
+
+// returns a middleware which passes only updates from users who are permitted to use them to `next`
+//
+// (channel posts, public commands, and redemptions of invitations are passed regardless of their senders)
+func authMiddleware(conf config, router *updateRouter) middleware {
+	return func(next updateHandlerFunc) updateHandlerFunc {
+		return func(ctx context.Context, b *tg.Bot, update tg.Update) {
+			if update.HasChannelPost() || update.HasEditedChannelPost() {
+				next(ctx, b, update)
+				return
+			}
+			command, args, isCommand := router.commandOf(update)
+			if isCommand && slices.Contains(publicCommands, command) || isInvitationRedemption(router, update) {
+				next(ctx, b, update)
+				return
+			}
+
+			var username *string
+			if from := update.GetFrom(); from != nil {
+				username = from.Username
+			}
+			r := roleOf(conf, username)
+
+			capability := capabilityOf(command, args, isCommand)
+			if isPermitted(conf, username, capability) {
+				next(ctx, b, update)
+			} else {
+				auditor.writeUpdate(ctx, update, kindOfUpdate(router, update), auditOutcomeDenied)
+
+				// tell users with roles why they are denied
+				if message, _ := update.GetMessage(); message != nil {
+					if r == roleReadonly && capability == capabilityRender {
+						replyError(b, message.Chat.ID, message.MessageID, messageReadonly)
+					} else if r != roleNone && isCommand {
+						replyError(b, message.Chat.ID, message.MessageID, messageNotPermitted)
+					}
+				}
+
+				if conf.IsVerbose {
+					logf(ctx, "update not allowed (role: '%s'): %+v", r, update)
+				}
+			}
+		}
+	}
+}
+
+// limits requests of each user in a time window
+type rateLimiter struct {
+	sync.Mutex
+
+	limit    int
+	window   time.Duration
+	requests map[int64][]time.Time // user id => times of requests in the window (oldest first)
+	sweptAt  time.Time             // when requests of all users were pruned last time
+}
+
+// checks if a request of `userID` at `now` is allowed, and records it if so
+func (l *rateLimiter) allow(userID int64, now time.Time) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	// NOTE: users who have no requests in the window are removed once in a window, so that the map doesn't grow with every user ever seen
+	if now.Sub(l.sweptAt) >= l.window {
+		for id := range l.requests {
+			if requests := l.prune(id, now); len(requests) == 0 {
+				delete(l.requests, id)
+			}
+		}
+		l.sweptAt = now
+	}
+
+	requests := l.prune(userID, now)
+	if len(requests) >= l.limit {
+		return false
+	}
+	l.requests[userID] = append(requests, now)
+
+	return true
+}
+
+// removes requests of `userID` out of the window at `now`, and returns the remaining ones (should be called while locked)
+func (l *rateLimiter) prune(userID int64, now time.Time) []time.Time {
+	requests := l.requests[userID]
+	for len(requests) > 0 && now.Sub(requests[0]) >= l.window {
+		requests = requests[1:]
+	}
+	if len(requests) > 0 {
+		l.requests[userID] = requests
+	}
+	return requests
+}
+
+// returns a middleware which drops updates from users who sent more than `rate_limit` requests in a minute
+func rateLimitMiddleware(conf config, router *updateRouter) middleware {
+	return func(next updateHandlerFunc) updateHandlerFunc {
+		if conf.RateLimit <= 0 { // unlimited
+			return next
+		}
+
+		limiter := &rateLimiter{
+			limit:    conf.RateLimit,
+			window:   rateLimitWindow,
+			requests: map[int64][]time.Time{},
+		}
+
+		return func(ctx context.Context, b *tg.Bot, update tg.Update) {
+			from := update.GetFrom()
+			if from == nil || limiter.allow(from.ID, time.Now()) {
+				next(ctx, b, update)
+				return
+			}
+
+			auditor.writeUpdate(ctx, update, kindOfUpdate(router, update), auditOutcomeRateLimited)
+
+			if conf.IsVerbose {
+				logf(ctx, "update rate limited: %+v", update)
+			}
+
+			text := fmt.Sprintf(messageRateLimited, conf.RateLimit)
+			if message, _ := update.GetMessage(); message != nil {
+				replyError(b, message.Chat.ID, message.MessageID, text)
+			} else if update.HasCallbackQuery() {
+				answerCallbackQuery(b, *update.CallbackQuery, &text)
+			}
+		}
+	}
+}
+
+// statistics of handled updates
+type updateMetrics struct {
+	sync.Mutex
+
+	StartedAt time.Time
+	Counts    map[string]int64         // kind => number of handled updates
+	Durations map[string]time.Duration // kind => total time spent on handling updates
+	Panics    int64
+}
+
+// statistics of handled updates (since the bot started)
+var metrics = &updateMetrics{
+	StartedAt: time.Now(),
+	Counts:    map[string]int64{},
+	Durations: map[string]time.Duration{},
+}
+
+// records an update of `kind` which was handled in `elapsed`
+func (m *updateMetrics) record(kind string, elapsed time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.Counts[kind]++
+	m.Durations[kind] += elapsed
+}
+
+// records a panic
+func (m *updateMetrics) recordPanic() {
+	m.Lock()
+	defer m.Unlock()
+
+	m.Panics++
+}
+
+// returns the kind of given update for metrics
+func kindOfUpdate(router *updateRouter, update tg.Update) string {
+	if command, _, isCommand := router.commandOf(update); isCommand {
+		return command
+	}
+
+	switch {
+	case update.HasMessage():
+		return "message"
+	case update.HasEditedMessage():
+		return "edited_message"
+	case update.HasChannelPost(), update.HasEditedChannelPost():
+		return "channel_post"
+	case update.HasCallbackQuery():
+		return "callback_query"
+	case update.HasInlineQuery():
+		return "inline_query"
+	default:
+		return "other"
+	}
+}
+
+// returns a middleware which records the number of updates and the time spent on handling them
+func metricsMiddleware(router *updateRouter) middleware {
+	return func(next updateHandlerFunc) updateHandlerFunc {
+		return func(ctx context.Context, b *tg.Bot, update tg.Update) {
+			started := time.Now()
+			defer func() {
+				metrics.record(kindOfUpdate(router, update), time.Since(started))
+			}()
+
+			next(ctx, b, update)
+		}
+	}
+}
+
+// returns a middleware which recovers from panics in `next`, so that one failing update doesn't crash the bot
+func recoverMiddleware() middleware {
+	return func(next updateHandlerFunc) updateHandlerFunc {
+		return func(ctx context.Context, b *tg.Bot, update tg.Update) {
+			defer func() {
+				if r := recover(); r != nil {
+					metrics.recordPanic()
+
+					logf(ctx, "panic while handling update: %v\n%s", r, debug.Stack())
+
+					if message, _ := update.GetMessage(); message != nil {
+						replyError(b, message.Chat.ID, message.MessageID, messageInternalError)
+					} else if update.HasCallbackQuery() {
+						answerCallbackQuery(b, *update.CallbackQuery, toPointer(messageInternalError))
+					}
+				}
+			}()
+
+			next(ctx, b, update)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+
+	// d2
+	"oss.terrastruct.com/d2/d2themes/d2themescatalog"
+)
+
+// constants for night mode
+const (
+	commandNightMode = "/nightmode"
+
+	nightModeOn   = "on"
+	nightModeOff  = "off"
+	nightModeAuto = "auto" // follows `night_mode.hours` of the config
+
+	messageNightModeUsage = "Usage: /nightmode [on|off|auto]"
+	messageNightMode      = "Night mode of this chat: %s (now: %s)"
+	messageNightModeSaved = "Saved night mode of this chat: %s"
+)
+
+// settings of night mode, where diagrams are rendered with a dark theme
+type nightModeConfig struct {
+	ThemeID  int64  `json:"theme_id,omitempty"` // dark theme for night mode (= 200, Dark Mauve, for default)
+	Hours    string `json:"hours,omitempty"`    // eg. 19:00-07:00 (night mode is applied automatically in these hours)
+	Timezone string `json:"timezone,omitempty"` // eg. Asia/Seoul (= local timezone for default)
+}
+
+// settings of a chat
+type chatSettings struct {
+	NightMode string `json:"night_mode,omitempty"` // on, off, or auto (= auto for default)
+}
+
+// returns the id of the dark theme for night mode
+func nightModeThemeID(conf config) int64 {
+	if conf.NightMode != nil && conf.NightMode.ThemeID != 0 {
+		return conf.NightMode.ThemeID
+	}
+	return d2themescatalog.DarkMauve.ID
+}
+
+// checks if night mode is applied to `chatID` at `now`
+func isNightMode(conf config, chatID int64, now time.Time) bool {
+	switch storage.loadChatSettings(chatID).NightMode {
+	case nightModeOn:
+		return true
+	case nightModeOff:
+		return false
+	}
+
+	if conf.NightMode == nil || conf.NightMode.Hours == "" {
+		return false
+	}
+
+	if conf.NightMode.Timezone != "" {
+		if loc, err := time.LoadLocation(conf.NightMode.Timezone); err == nil {
+			now = now.In(loc)
+		} else {
+			log.Printf("failed to load timezone of night mode: %s", err)
+		}
+	}
+	w, err := parseTimeWindow(conf.NightMode.Hours)
+	if err != nil {
+		log.Printf("failed to parse hours of night mode: %s", err)
+		return false
+	}
+	return w.contains(now)
+}
+
+// returns render options for `userID` in `chatID`, with the dark theme applied in night mode
+func renderOptionsForChat(conf config, userID, chatID int64) renderOptions {
+	opts := renderOptionsForUser(conf, userID)
+	if isNightMode(conf, chatID, time.Now()) {
+		opts.ThemeID = nightModeThemeID(conf)
+	}
+	return opts
+}
+
+// handle nightmode command
+func handleNightModeCommand(b *tg.Bot, conf config, update tg.Update, args string) {
+	if message, _ := update.GetMessage(); message != nil {
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		// show current night mode
+		mode := strings.ToLower(strings.TrimSpace(args))
+		if mode == "" {
+			current := storage.loadChatSettings(chatID).NightMode
+			if current == "" {
+				current = nightModeAuto
+			}
+			replyText(b, chatID, messageID, fmt.Sprintf(messageNightMode, current, onOff(isNightMode(conf, chatID, time.Now()))))
+			return
+		}
+
+		switch mode {
+		case nightModeOn, nightModeOff, nightModeAuto:
+			if err := storage.updateChatSettings(chatID, func(settings *chatSettings) {
+				settings.NightMode = mode
+			}); err == nil {
+				replyText(b, chatID, messageID, fmt.Sprintf(messageNightModeSaved, mode))
+			} else {
+				log.Printf("failed to save chat settings: %s", err)
+
+				replyError(b, chatID, messageID, fmt.Sprintf("Failed to save night mode: %s", err))
+			}
+		default:
+			replyError(b, chatID, messageID, messageNightModeUsage)
+		}
+	}
+}
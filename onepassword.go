@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// constants for 1Password Connect
+const (
+	onePasswordRequestTimeout = 10 * time.Second
+	onePasswordRefPrefix      = "op://"
+)
+
+// 1Password Connect settings
+type onePasswordConfig struct {
+	ConnectHost  string `json:"connect_host"`  // eg. http://localhost:8080
+	ConnectToken string `json:"connect_token"` // access token of the Connect server
+
+	BotTokenReference string `json:"bot_token_reference,omitempty"` // eg. op://vault/item/field
+}
+
+// a client of a 1Password Connect server
+type onePasswordClient struct {
+	conf   onePasswordConfig
+	client *http.Client
+}
+
+// returns a new client for given 1Password Connect settings
+func newOnePasswordClient(conf onePasswordConfig) *onePasswordClient {
+	conf.ConnectHost = strings.TrimSuffix(conf.ConnectHost, "/")
+
+	return &onePasswordClient{
+		conf:   conf,
+		client: &http.Client{Timeout: onePasswordRequestTimeout},
+	}
+}
+
+// sends a GET request to the Connect api at `apiPath`, and decodes its response into `result`
+func (c *onePasswordClient) get(apiPath string, result any) (err error) {
+	var req *http.Request
+	if req, err = http.NewRequest(http.MethodGet, c.conf.ConnectHost+"/v1/"+apiPath, nil); err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.conf.ConnectToken)
+
+	var res *http.Response
+	if res, err = c.client.Do(req); err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		var e struct {
+			Message string `json:"message"`
+		}
+		_ = json.NewDecoder(res.Body).Decode(&e)
+		return fmt.Errorf("1Password Connect responded with %d: %s", res.StatusCode, e.Message)
+	}
+
+	return json.NewDecoder(res.Body).Decode(result)
+}
+
+// returns the id of a vault or an item with given `name` (or id) from `apiPath` (eg. `vaults`)
+func (c *onePasswordClient) findID(apiPath, attribute, name string) (id string, err error) {
+	var found []struct {
+		ID string `json:"id"`
+	}
+	if err = c.get(fmt.Sprintf("%s?filter=%s", apiPath, url.QueryEscape(fmt.Sprintf(`%s eq "%s"`, attribute, name))), &found); err != nil {
+		return "", err
+	}
+	if len(found) == 0 {
+		return name, nil // NOTE: `name` might be an id
+	}
+	return found[0].ID, nil
+}
+
+// retrieves the value of a field with `reference` (eg. `op://vault/item/field`, or `vault/item/field`)
+func (c *onePasswordClient) retrieve(reference string) (value string, err error) {
+	parts := strings.Split(strings.TrimPrefix(reference, onePasswordRefPrefix), "/")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("not a valid 1Password reference: '%s' (should be like 'op://vault/item/field')", reference)
+	}
+	vaultName, itemName, fieldName := parts[0], parts[1], parts[2]
+
+	var vaultID, itemID string
+	if vaultID, err = c.findID("vaults", "name", vaultName); err != nil {
+		return "", fmt.Errorf("failed to find 1Password vault '%s': %s", vaultName, err)
+	}
+	if itemID, err = c.findID("vaults/"+vaultID+"/items", "title", itemName); err != nil {
+		return "", fmt.Errorf("failed to find 1Password item '%s': %s", itemName, err)
+	}
+
+	var item struct {
+		Fields []struct {
+			ID    string `json:"id"`
+			Label string `json:"label"`
+			Value string `json:"value"`
+		} `json:"fields"`
+	}
+	if err = c.get("vaults/"+vaultID+"/items/"+itemID, &item); err != nil {
+		return "", fmt.Errorf("failed to get 1Password item '%s': %s", itemName, err)
+	}
+	for _, field := range item.Fields {
+		if field.Label == fieldName || field.ID == fieldName {
+			return field.Value, nil
+		}
+	}
+
+	return "", fmt.Errorf("there is no field '%s' in 1Password item '%s'", fieldName, itemName)
+}
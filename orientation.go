@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	// d2
+	"oss.terrastruct.com/d2/d2exporter"
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/lib/textmeasure"
+)
+
+// constants for orientations
+const (
+	defaultTargetAspectRatio = 0.75 // 3:4 (portrait, for phone screens)
+
+	messageOrientationChosen = "↻ direction: %s (chosen to fit the screen)"
+)
+
+// candidate directions for choosing orientations
+var orientationCandidates = []string{"right", "down"}
+
+// settings of choosing orientations of diagrams
+type orientationConfig struct {
+	Auto              bool    `json:"auto,omitempty"`                // choose orientations of all diagrams without directions (not only ones with `#auto` or `direction=auto`)
+	TargetAspectRatio float64 `json:"target_aspect_ratio,omitempty"` // width / height (= 0.75 for default)
+}
+
+// returns the direction (among `right` and `down`) whose layout of given d2 source has an aspect ratio closest to the target,
+// if the orientation should be chosen automatically (`chosen` is false if not)
+//
+// Sources with their own directions (`direction: ...`) are not changed unless `direction=auto` or `#auto` is given.
+func autoOrientation(ctx context.Context, conf config, source string, opts renderOptions) (direction string, chosen bool) {
+	explicit := opts.Direction == directionAuto || (opts.Direction == "" && directionDirective(source) == directionAuto)
+	if !explicit && (opts.Direction != "" || directionDirective(source) != "" || conf.Orientation == nil || !conf.Orientation.Auto) {
+		return "", false
+	}
+
+	target := defaultTargetAspectRatio
+	if conf.Orientation != nil && conf.Orientation.TargetAspectRatio > 0 {
+		target = conf.Orientation.TargetAspectRatio
+	}
+
+	opts = applyLayoutDirective(ctx, source, opts)
+
+	best := math.Inf(1)
+	for _, candidate := range orientationCandidates {
+		width, height, declared, err := layoutSize(ctx, source, candidate, opts.Layout)
+		if err != nil || (declared && !explicit) {
+			return "", false
+		}
+
+		// NOTE: compare in log scale, so that 2:1 and 1:2 are equally far from 1:1
+		if diff := math.Abs(math.Log(width/height) - math.Log(target)); diff < best {
+			best, direction = diff, candidate
+		}
+	}
+	return direction, direction != ""
+}
+
+// lays out given d2 source in `direction`, and returns the size of its diagram
+// (`declared` is true if the source has its own direction)
+func layoutSize(ctx context.Context, source, direction, layout string) (width, height float64, declared bool, err error) {
+	var graph *d2graph.Graph
+	if graph, err = compileGraph(source); err == nil {
+		declared = graph.Root.Direction.Value != ""
+		graph.Root.Direction.Value = direction
+
+		var ruler *textmeasure.Ruler
+		if ruler, err = textmeasure.NewRuler(); err == nil {
+			if err = graph.SetDimensions(nil, ruler, nil); err == nil { // fontFamily = nil: use default
+				if err = layoutGraph(ctx, graph, layout); err == nil {
+					var diagram *d2target.Diagram
+					if diagram, err = d2exporter.Export(ctx, graph, nil); err == nil { // fontFamily = nil: use default
+						topLeft, bottomRight := diagram.BoundingBox()
+						width, height = float64(bottomRight.X-topLeft.X), float64(bottomRight.Y-topLeft.Y)
+						if width <= 0 || height <= 0 {
+							err = fmt.Errorf("empty diagram")
+						}
+					}
+				}
+			}
+		}
+	}
+	return width, height, declared, err
+}
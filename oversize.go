@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	_ "image/png" // for decoding sizes of .png files
+	"math"
+)
+
+// constants for upload limits of telegram
+const (
+	maxPhotoFileSize    = 10 * 1024 * 1024 // max size of a photo
+	maxPhotoDimensions  = 10000            // max sum of width and height of a photo
+	maxDocumentFileSize = 50 * 1024 * 1024 // max size of a document
+
+	minShrunkScale    = 0.1
+	maxShrinkAttempts = 3
+	shrinkMargin      = 0.9 // shrink a little more than calculated, as sizes of compressed files are not proportional to their areas
+
+	messageRenderShrunk     = "⚠️ The render (%.1f MB) was too large to upload, so it was scaled down to %.2f (get the full-size version with /svg or /pdf)."
+	messageRenderAsDocument = "⚠️ The render was too large to be sent as a photo, so it was sent as a file."
+	messageRenderAsSVG      = "⚠️ The render (%.1f MB) was too large to upload, so it was sent as an .svg file."
+	messageRenderTooLarge   = "the render is too large to upload (%.1f MB, even as an .svg file): try splitting the diagram into smaller ones"
+)
+
+// returns the size of given file in megabytes
+func megabytes(bs []byte) float64 {
+	return float64(len(bs)) / 1024 / 1024
+}
+
+// returns the ratio of scale which makes rendered `bs` in `format` fit in upload limits (>= 1.0 if it already fits)
+func uploadFitRatio(bs []byte, format outputFormat) (ratio float64) {
+	maxSize := maxDocumentFileSize
+	if format == formatPhoto {
+		maxSize = maxPhotoFileSize
+	}
+
+	// NOTE: file sizes are roughly proportional to areas (= square of scales)
+	ratio = math.Sqrt(float64(maxSize) / float64(max(len(bs), 1)))
+
+	if format == formatPhoto {
+		if conf, _, err := image.DecodeConfig(bytes.NewReader(bs)); err == nil && conf.Width+conf.Height > 0 {
+			ratio = min(ratio, float64(maxPhotoDimensions)/float64(conf.Width+conf.Height))
+		}
+	}
+	return ratio
+}
+
+// makes rendered `bs` of given d2 source fit in upload limits of telegram,
+// by rendering it again at lower scales, as a document instead of a photo, or as an .svg file.
+//
+// Returns the fitted file with its format, and a note which explains what happened (empty if `bs` already fits).
+func fitUploadLimits(ctx context.Context, source string, opts renderOptions, bs []byte) (fitted []byte, format outputFormat, note string, err error) {
+	ratio := uploadFitRatio(bs, opts.Format)
+	if ratio >= 1.0 {
+		return bs, opts.Format, "", nil
+	}
+	original := megabytes(bs)
+
+	// retry rasterized ones at lower scales
+	if opts.Format == formatPNG || opts.Format == formatPhoto {
+		shrunk := opts
+		if shrunk.Scale <= 0 {
+			shrunk.Scale = 1.0
+		}
+		for attempt := 0; attempt < maxShrinkAttempts && ratio < 1.0; attempt++ {
+			if shrunk.Scale *= ratio * shrinkMargin; shrunk.Scale < minShrunkScale {
+				break
+			}
+			if fitted, _, err = renderDiagram(ctx, source, shrunk, nil); err != nil {
+				return nil, "", "", err
+			}
+			if ratio = uploadFitRatio(fitted, shrunk.Format); ratio >= 1.0 {
+				return fitted, shrunk.Format, fmt.Sprintf(messageRenderShrunk, original, shrunk.Scale), nil
+			}
+		}
+
+		// send photos as documents, which have higher limits
+		if opts.Format == formatPhoto && uploadFitRatio(bs, formatPNG) >= 1.0 {
+			return bs, formatPNG, messageRenderAsDocument, nil
+		}
+	}
+
+	// fall back to .svg files
+	svgOpts := opts
+	svgOpts.Format = formatSVG
+	if fitted, _, err = renderDiagram(ctx, source, svgOpts, nil); err != nil {
+		return nil, "", "", err
+	}
+	if uploadFitRatio(fitted, formatSVG) >= 1.0 {
+		return fitted, formatSVG, fmt.Sprintf(messageRenderAsSVG, original), nil
+	}
+
+	return nil, "", "", fmt.Errorf(messageRenderTooLarge, megabytes(fitted))
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// constants for paginated messages
+const (
+	callbackPrefixPage = "page:"
+
+	maxMessageLength   = 4096 // max length of a message (in UTF-16 code units)
+	maxPaginatedTexts  = 100  // max number of paginated texts kept in memory (older ones' buttons stop working)
+	paginationPrevious = "« prev"
+	paginationNext     = "next »"
+
+	messagePageExpired = "This message is too old to be paginated."
+)
+
+// texts split into pages, for browsing them with buttons
+type paginatedTexts struct {
+	sync.Mutex
+
+	lastID int64
+	texts  map[int64][]string // id => pages
+}
+
+// paginated texts of this bot
+var paginations = &paginatedTexts{
+	texts: map[int64][]string{},
+}
+
+// keeps given pages, and returns their id (evicting the oldest ones over the limit)
+func (p *paginatedTexts) keep(pages []string) (id int64) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.lastID++
+	p.texts[p.lastID] = pages
+	delete(p.texts, p.lastID-maxPaginatedTexts)
+
+	return p.lastID
+}
+
+// returns the pages with `id`
+func (p *paginatedTexts) get(id int64) (pages []string, exists bool) {
+	p.Lock()
+	defer p.Unlock()
+
+	pages, exists = p.texts[id]
+	return pages, exists
+}
+
+// splits given text into pages which fit in a message, at line breaks if possible
+func splitPages(text string) (pages []string) {
+	if utf16Length(text) <= maxMessageLength {
+		return []string{text}
+	}
+
+	var page strings.Builder
+	length := 0
+	flush := func() {
+		if page.Len() > 0 {
+			pages = append(pages, strings.TrimRight(page.String(), "\n"))
+			page.Reset()
+			length = 0
+		}
+	}
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if length+utf16Length(line) > maxMessageLength {
+			flush()
+		}
+
+		// split lines which are too long by themselves
+		for utf16Length(line) > maxMessageLength {
+			var head strings.Builder
+			n := 0
+			for i, r := range line {
+				if n += utf16Length(string(r)); n > maxMessageLength {
+					pages = append(pages, head.String())
+					line = line[i:]
+					break
+				}
+				head.WriteRune(r)
+			}
+		}
+
+		page.WriteString(line)
+		length += utf16Length(line)
+	}
+	flush()
+
+	return pages
+}
+
+// returns callback data for page `page` of paginated text with `id` (eg. `page:3:1`)
+func pageCallbackData(id int64, page int) string {
+	return fmt.Sprintf("%s%d:%d", callbackPrefixPage, id, page)
+}
+
+// returns an inline keyboard for browsing pages, on page `page` of `total`
+func paginationKeyboard(id int64, page, total int) tg.InlineKeyboardMarkup {
+	row := []tg.InlineKeyboardButton{}
+	if page > 0 {
+		row = append(row, tg.InlineKeyboardButton{
+			Text:         paginationPrevious,
+			CallbackData: toPointer(pageCallbackData(id, page-1)),
+		})
+	}
+	row = append(row, tg.InlineKeyboardButton{
+		Text:         fmt.Sprintf("%d/%d", page+1, total),
+		CallbackData: toPointer(pageCallbackData(id, page)),
+	})
+	if page < total-1 {
+		row = append(row, tg.InlineKeyboardButton{
+			Text:         paginationNext,
+			CallbackData: toPointer(pageCallbackData(id, page+1)),
+		})
+	}
+
+	return tg.NewInlineKeyboardMarkup([][]tg.InlineKeyboardButton{row})
+}
+
+// replies to `messageID` with the first page of `pages`, with buttons for browsing the others
+func replyPages(bot *tg.Bot, chatID, messageID int64, pages []string) {
+	id := paginations.keep(pages)
+
+	if sent := bot.SendMessage(
+		chatID,
+		pages[0],
+		tg.OptionsSendMessage{}.
+			SetLinkPreviewOptions(tg.LinkPreviewOptions{IsDisabled: toPointer(true)}).
+			SetReplyParameters(tg.NewReplyParameters(messageID)).
+			SetReplyMarkup(paginationKeyboard(id, 0, len(pages)))); !sent.Ok {
+		log.Printf("failed to send paginated message: %s", *sent.Description)
+	}
+}
+
+// handles a callback query on the buttons of a paginated message with `data` (without the prefix)
+func handlePageCallback(b *tg.Bot, query tg.CallbackQuery, data string) {
+	idStr, pageStr, _ := strings.Cut(data, ":")
+	id, err1 := strconv.ParseInt(idStr, 10, 64)
+	page, err2 := strconv.Atoi(pageStr)
+	if err1 != nil || err2 != nil || query.Message == nil {
+		answerCallbackQuery(b, query, nil)
+		return
+	}
+
+	pages, exists := paginations.get(id)
+	if !exists {
+		answerCallbackQuery(b, query, toPointer(messagePageExpired))
+		return
+	}
+	answerCallbackQuery(b, query, nil)
+
+	if page < 0 || page >= len(pages) || (query.Message.Text != nil && *query.Message.Text == pages[page]) {
+		return // NOTE: nothing to change (eg. the current page's button)
+	}
+
+	if edited := b.EditMessageText(
+		pages[page],
+		tg.OptionsEditMessageText{}.
+			SetIDs(query.Message.Chat.ID, query.Message.MessageID).
+			SetLinkPreviewOptions(tg.LinkPreviewOptions{IsDisabled: toPointer(true)}).
+			SetReplyMarkup(paginationKeyboard(id, page, len(pages)))); !edited.Ok {
+		log.Printf("failed to update paginated message: %s", *edited.Description)
+	}
+}
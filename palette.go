@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	// d2
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/d2themes/d2themescatalog"
+)
+
+// color-blind-safe palettes
+const (
+	paletteNone         = "none"
+	paletteDeuteranopia = "deuteranopia"
+	paletteProtanopia   = "protanopia"
+)
+
+// all available palettes
+var palettes = []string{paletteNone, paletteDeuteranopia, paletteProtanopia}
+
+// theme colors of a palette, for light and dark themes
+type paletteOverrides struct {
+	light, dark *d2target.ThemeOverrides
+}
+
+// theme colors of color-blind-safe palettes, which remap accent colors of themes (neutrals are kept as they are)
+var paletteThemeOverrides = map[string]paletteOverrides{
+	// blues and oranges, distinguishable without green-sensitive cones
+	paletteDeuteranopia: {
+		light: &d2target.ThemeOverrides{
+			B1:  toPointer("#004A8F"),
+			B2:  toPointer("#0072B2"),
+			B3:  toPointer("#56B4E9"),
+			B4:  toPointer("#C6E2F5"),
+			B5:  toPointer("#E1F0FA"),
+			B6:  toPointer("#F2F8FD"),
+			AA2: toPointer("#D55E00"),
+			AA4: toPointer("#F6CFAE"),
+			AA5: toPointer("#FBE8D8"),
+			AB4: toPointer("#F7EE9E"),
+			AB5: toPointer("#FBF6CF"),
+		},
+		dark: &d2target.ThemeOverrides{
+			B1:  toPointer("#56B4E9"),
+			B2:  toPointer("#56B4E9"),
+			B3:  toPointer("#4A6F8A"),
+			B4:  toPointer("#2E4A63"),
+			B5:  toPointer("#22384B"),
+			B6:  toPointer("#182836"),
+			AA2: toPointer("#E69F00"),
+			AA4: toPointer("#4D3A1F"),
+			AA5: toPointer("#3A2C17"),
+			AB4: toPointer("#4A4620"),
+			AB5: toPointer("#37341A"),
+		},
+	},
+	// blues and yellows, avoiding reds (which look dark without red-sensitive cones)
+	paletteProtanopia: {
+		light: &d2target.ThemeOverrides{
+			B1:  toPointer("#1F3F99"),
+			B2:  toPointer("#3366CC"),
+			B3:  toPointer("#7FA6E6"),
+			B4:  toPointer("#CCDAF5"),
+			B5:  toPointer("#E3EBFA"),
+			B6:  toPointer("#F3F6FD"),
+			AA2: toPointer("#E69F00"),
+			AA4: toPointer("#F8DFA6"),
+			AA5: toPointer("#FCEFD2"),
+			AB4: toPointer("#CCE6E0"),
+			AB5: toPointer("#E6F3F0"),
+		},
+		dark: &d2target.ThemeOverrides{
+			B1:  toPointer("#8FB3F0"),
+			B2:  toPointer("#8FB3F0"),
+			B3:  toPointer("#4F6490"),
+			B4:  toPointer("#2F3E63"),
+			B5:  toPointer("#242F4B"),
+			B6:  toPointer("#1A2236"),
+			AA2: toPointer("#F0E442"),
+			AA4: toPointer("#4A4420"),
+			AA5: toPointer("#37331A"),
+			AB4: toPointer("#1F4A42"),
+			AB5: toPointer("#173731"),
+		},
+	},
+}
+
+// parses given string into a name of palettes
+func parsePalette(str string) (string, error) {
+	if palette := strings.ToLower(str); slices.Contains(palettes, palette) {
+		return palette, nil
+	}
+	return "", fmt.Errorf("not a supported palette: '%s' (should be one of: %s)", str, strings.Join(palettes, ", "))
+}
+
+// returns the name of given palette for displaying
+func paletteName(palette string) string {
+	if palette == "" {
+		return paletteNone
+	}
+	return palette
+}
+
+// returns theme overrides for rendering with `opts` (nil if there is none)
+//
+// (the high-contrast mode takes precedence over palettes)
+func themeOverrides(opts renderOptions) *d2target.ThemeOverrides {
+	if opts.HighContrast {
+		return highContrastOverrides
+	}
+
+	if overrides, exists := paletteThemeOverrides[opts.Palette]; exists {
+		if theme := d2themescatalog.Find(opts.ThemeID); theme.IsDark() {
+			return overrides.dark
+		}
+		return overrides.light
+	}
+	return nil
+}
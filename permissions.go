@@ -0,0 +1,59 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// constants for permissions
+const (
+	capabilityRender = "render" // sending D2 sources (messages, files) or pressing buttons, not commands
+
+	messageNotPermitted = "You are not permitted to use this command."
+)
+
+// commands which are permitted only for admins by default
+var adminCommands = []string{commandReload, commandRole, commandInvite, commandStats, commandPurgeCache}
+
+// returns the capability of an update with `command` and its `args` (or of a non-command update if `isCommand` is false)
+//
+// (deep links to shared diagrams, eg. `/start lib_<name>`, render them, so they need the render capability)
+func capabilityOf(command, args string, isCommand bool) string {
+	if isCommand && !(command == commandStart && strings.HasPrefix(strings.TrimSpace(args), deepLinkPrefixLibrary)) {
+		return command
+	}
+	return capabilityRender
+}
+
+// returns the roles which are permitted to use `capability` by default
+func defaultRolesFor(capability string) []role {
+	switch {
+	case slices.Contains(adminCommands, capability):
+		return []role{roleAdmin}
+	case slices.Contains(readonlyCommands, capability):
+		return []role{roleAdmin, roleUser, roleReadonly}
+	default:
+		return []role{roleAdmin, roleUser}
+	}
+}
+
+// checks if given username is permitted to use `capability` (a command like `/publish`, or `render`)
+//
+// Entries of `permissions` in the config (role names, usernames, or their patterns) replace the default roles of the capability.
+func isPermitted(conf config, username *string, capability string) bool {
+	r := roleOf(conf, username)
+
+	if entries, exists := conf.Permissions[capability]; exists {
+		for _, entry := range entries {
+			if r != roleNone && role(entry) == r {
+				return true
+			}
+			if matchesAnyAllowedID([]string{entry}, username) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return slices.Contains(defaultRolesFor(capability), r)
+}
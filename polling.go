@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// constants for polling updates
+const (
+	defaultPollingLimit = 100
+	maxPollingLimit     = 100
+	maxPollingTimeout   = 60 // seconds
+
+	pollingRequestMargin = 10 * time.Second // extra time for http requests of long polling
+
+	telegramAPIURLFormat = "https://api.telegram.org/bot%s/%s"
+)
+
+// parameters of getUpdates
+type pollingParams struct {
+	Offset  int64 `json:"offset"`
+	Limit   int   `json:"limit"`
+	Timeout int   `json:"timeout"`
+}
+
+// returns the polling timeout and limit from the config
+func pollingTimeoutAndLimit(conf config) (timeout, limit int) {
+	timeout = min(max(conf.PollingTimeout, 0), maxPollingTimeout)
+
+	limit = conf.PollingLimit
+	if limit <= 0 || limit > maxPollingLimit {
+		limit = defaultPollingLimit
+	}
+
+	return timeout, limit
+}
+
+// pollUpdates polls updates with the polling parameters of `conf` from `offset`, and handles them with `handler`.
+//
+// With a positive `polling_timeout`, updates are fetched with long polling,
+// otherwise they are fetched every `interval` seconds.
+//
+// Polling stops when `ctx` is canceled, and the offset for the next polling is returned.
+func pollUpdates(ctx context.Context, b *tg.Bot, conf config, handler updateHandlerFunc, interval int, offset int64) int64 {
+	timeout, limit := pollingTimeoutAndLimit(conf)
+
+	// NOTE: http client of the bot api library times out in 10 seconds, so use a separate one for long polling
+	client := &http.Client{
+		Timeout: time.Duration(timeout)*time.Second + pollingRequestMargin,
+	}
+
+	log.Printf("polling updates (timeout: %ds, limit: %d, interval: %ds)", timeout, limit, interval)
+
+	params := pollingParams{Offset: offset, Limit: limit, Timeout: timeout}
+	for {
+		updates, err := getUpdates(ctx, client, apiToken(conf.BotToken), params)
+		if ctx.Err() != nil {
+			log.Printf("stopped polling updates")
+			return params.Offset
+		}
+		if err != nil {
+			log.Printf("failed to poll updates: %s", err)
+
+			sleep(ctx, time.Duration(interval)*time.Second)
+			continue
+		}
+
+		for _, update := range updates {
+			if params.Offset <= update.UpdateID {
+				params.Offset = update.UpdateID + 1
+			}
+		}
+
+		// skip updates which were already processed (eg. before a restart with a stale offset)
+		deduplicated, err := updateIDs.mark(updates)
+		if err != nil {
+			log.Printf("failed to remember processed updates: %s", err)
+		}
+		if conf.IsVerbose && len(deduplicated) < len(updates) {
+			log.Printf("skipping %d already processed update(s)", len(updates)-len(deduplicated))
+		}
+		updates = deduplicated
+
+		// handle updates of the same chat in order, and those of different chats in parallel
+		for _, update := range updates {
+			if chatID, ordered := chatKeyOf(update); ordered {
+				chatQueue.dispatch(chatID, func() {
+					handler(context.Background(), b, update)
+				})
+			} else {
+				go handler(context.Background(), b, update)
+			}
+		}
+
+		// long polling returns as soon as updates arrive, so no need to wait
+		if timeout <= 0 {
+			sleep(ctx, time.Duration(interval)*time.Second)
+		}
+	}
+}
+
+// sleeps for `duration`, or until `ctx` is canceled
+func sleep(ctx context.Context, duration time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(duration):
+	}
+}
+
+// fetches updates with given parameters
+func getUpdates(ctx context.Context, client *http.Client, token string, params pollingParams) (updates []tg.Update, err error) {
+	var body []byte
+	if body, err = json.Marshal(params); err != nil {
+		return nil, err
+	}
+
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf(telegramAPIURLFormat, token, "getUpdates"), bytes.NewReader(body)); err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var res *http.Response
+	if res, err = client.Do(req); err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var response tg.APIResponse[[]tg.Update]
+	if err = json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode updates: %w", err)
+	}
+	if !response.Ok {
+		if response.Description != nil {
+			return nil, fmt.Errorf("%s", *response.Description)
+		}
+		return nil, fmt.Errorf("failed to get updates: %s", res.Status)
+	}
+	if response.Result == nil {
+		return nil, nil
+	}
+
+	return *response.Result, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// constants for previews
+const (
+	messagePreviewCaption = "Preview (rendering in full quality...)"
+)
+
+// checks if a quick preview should be sent before the full-quality render of `sources`
+// (a single large diagram with `preview_min_shapes` or more shapes, rendered as a .png file)
+func shouldPreview(conf config, sources []string, opts renderOptions) bool {
+	if conf.PreviewMinShapes <= 0 || len(sources) != 1 || opts.Format != formatPNG {
+		return false
+	}
+
+	if graph, err := compileGraph(sources[0]); err == nil {
+		return len(graph.Objects) >= conf.PreviewMinShapes
+	}
+	return false
+}
+
+// renders given d2 source into .svg and sends it as a preview right away,
+// then converts it to .png and replaces the preview with it.
+//
+// The replaced message has `caption` (in HTML) if it is given.
+//
+// Returns the id of the replaced message, or the converted bytes (with `previewID` = 0) if the preview could not be replaced.
+func renderWithPreview(ctx context.Context, bot *tg.Bot, chatID, messageID int64, source string, opts renderOptions, caption *string, progress func(stage string)) (bs []byte, previewID int64, warnings []string, err error) {
+	svgOpts := opts
+	svgOpts.Format = formatSVG
+
+	var svg []byte
+	if svg, warnings, err = renderDiagram(ctx, source, svgOpts, progress); err != nil {
+		return nil, 0, warnings, err
+	}
+
+	sentID, previewed := sendRenderedFile(ctx, bot, chatID, messageID, svg, formatSVG, toPointer(messagePreviewCaption))
+
+	progress(fmt.Sprintf(stageConverting, "PNG"))
+	if bs, err = convertSVG(ctx, svg); err != nil {
+		if previewed {
+			// NOTE: remove the preview, as the render failed
+			_ = bot.DeleteMessage(chatID, sentID)
+		}
+		return nil, 0, warnings, err
+	}
+
+	if previewed {
+		if editRenderedFile(ctx, bot, chatID, sentID, bs, formatPNG, caption) {
+			previewID = sentID
+		}
+
+		// NOTE: remove the preview if it could not be replaced, as the render will be sent as a new message
+		if previewID == 0 {
+			_ = bot.DeleteMessage(chatID, sentID)
+		}
+	}
+
+	return bs, previewID, warnings, nil
+}
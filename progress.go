@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// constants for progress updates
+const (
+	defaultProgressThresholdSeconds = 5
+	progressUpdateInterval          = 3 * time.Second
+
+	messageProgressFormat = "⏳ %s… %ds"
+)
+
+// progress reporter which sends and keeps editing a status message for long renders
+type progressReporter struct {
+	sync.Mutex
+
+	ctx       context.Context
+	bot       *tg.Bot
+	verbose   bool
+	chatID    int64
+	messageID int64 // id of the message being rendered
+
+	stage           string
+	started         time.Time
+	statusMessageID int64 // id of the status message (0 if not sent yet)
+
+	early chan struct{} // for sending the status message before the threshold
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// startProgress starts reporting the progress of a render with a status message
+// when the render takes longer than the configured threshold.
+//
+// Returned reporter should be finished with `finish()`.
+func startProgress(ctx context.Context, bot *tg.Bot, conf config, chatID, messageID int64) *progressReporter {
+	threshold := conf.ProgressThreshold
+	if threshold <= 0 {
+		threshold = defaultProgressThresholdSeconds
+	}
+
+	p := &progressReporter{
+		ctx:       ctx,
+		bot:       bot,
+		verbose:   conf.IsVerbose,
+		chatID:    chatID,
+		messageID: messageID,
+		stage:     stageCompiling,
+		started:   time.Now(),
+		early:     make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	go p.loop(time.Duration(threshold) * time.Second)
+
+	return p
+}
+
+// report updates the current stage of the render.
+func (p *progressReporter) report(stage string) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.stage = stage
+
+	if p.verbose {
+		logf(p.ctx, "render stage: %s", stage)
+	}
+}
+
+// show sends the status message now, if it was not sent yet (eg. when retrying a failed render).
+func (p *progressReporter) show() {
+	select {
+	case p.early <- struct{}{}:
+	default:
+	}
+}
+
+// finish stops reporting, and deletes the status message if it was sent.
+func (p *progressReporter) finish() {
+	close(p.stop)
+	<-p.done
+
+	if p.statusMessageID != 0 {
+		if deleted := p.bot.DeleteMessage(p.chatID, p.statusMessageID); !deleted.Ok {
+			logf(p.ctx, "failed to delete status message: %s", *deleted.Description)
+		}
+	}
+}
+
+// keeps the status message up-to-date until stopped
+func (p *progressReporter) loop(threshold time.Duration) {
+	defer close(p.done)
+
+	select {
+	case <-p.stop:
+		return
+	case <-time.After(threshold):
+	case <-p.early:
+	}
+
+	if sent := p.bot.SendMessage(
+		p.chatID,
+		p.text(),
+		tg.OptionsSendMessage{}.
+			SetDisableNotification(true).
+			SetReplyParameters(tg.NewReplyParameters(p.messageID))); sent.Ok {
+		p.statusMessageID = sent.Result.MessageID
+	} else {
+		logf(p.ctx, "failed to send status message: %s", *sent.Description)
+		return
+	}
+
+	ticker := time.NewTicker(progressUpdateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			// typing...
+			_ = p.bot.SendChatAction(p.chatID, tg.ChatActionTyping, nil)
+
+			if edited := p.bot.EditMessageText(
+				p.text(),
+				tg.OptionsEditMessageText{}.
+					SetIDs(p.chatID, p.statusMessageID)); !edited.Ok {
+				if p.verbose {
+					logf(p.ctx, "failed to edit status message: %s", *edited.Description)
+				}
+			}
+		}
+	}
+}
+
+// returns the text of the status message
+func (p *progressReporter) text() string {
+	p.Lock()
+	defer p.Unlock()
+
+	return fmt.Sprintf(messageProgressFormat, p.stage, int(time.Since(p.started).Seconds()))
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"sync"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// commands which are handled immediately, without waiting for the preceding updates of the chat
+var unorderedCommands = []string{commandCancel}
+
+// runs functions of the same key in submission order, and those of different keys in parallel
+type keyedQueue struct {
+	sync.Mutex
+
+	pending map[int64][]func() // key => functions waiting for their turn (a key exists while its worker is running)
+}
+
+// queue of updates keyed by their chats
+var chatQueue = &keyedQueue{
+	pending: map[int64][]func(){},
+}
+
+// runs `fn` after all the preceding functions of `key` are finished
+func (q *keyedQueue) dispatch(key int64, fn func()) {
+	q.Lock()
+	defer q.Unlock()
+
+	if _, running := q.pending[key]; running {
+		q.pending[key] = append(q.pending[key], fn)
+		return
+	}
+	q.pending[key] = []func(){}
+
+	go q.run(key, fn)
+}
+
+// runs `fn` and the following functions of `key`, until there is none left
+func (q *keyedQueue) run(key int64, fn func()) {
+	for fn != nil {
+		fn()
+
+		q.Lock()
+		if len(q.pending[key]) == 0 {
+			delete(q.pending, key)
+			fn = nil
+		} else {
+			fn, q.pending[key] = q.pending[key][0], q.pending[key][1:]
+		}
+		q.Unlock()
+	}
+}
+
+// returns the id of the chat of given update, for ordering its handling with other updates of the chat
+//
+// (`ordered` is false for updates which should be handled immediately, eg. /cancel)
+func chatKeyOf(update tg.Update) (chatID int64, ordered bool) {
+	if message, _ := update.GetMessage(); message != nil {
+		if message.Text != nil {
+			if fields := strings.Fields(*message.Text); len(fields) > 0 {
+				if command, _, _ := strings.Cut(fields[0], "@"); slices.Contains(unorderedCommands, command) {
+					return 0, false
+				}
+			}
+		}
+		return message.Chat.ID, true
+	} else if update.HasCallbackQuery() && update.CallbackQuery.Message != nil {
+		return update.CallbackQuery.Message.Chat.ID, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	// d2
+	"oss.terrastruct.com/d2/d2parser"
+)
+
+// constants for quoting error locations
+const (
+	maxQuoteLength = 1024 // max length of a quote in UTF-16 code units
+)
+
+// returns the quote of lines in `text` where the compile error `err` of `source` occurred,
+// and its position in `text` (in UTF-16 code units).
+//
+// Returns false if the location cannot be quoted (eg. `source` is not a part of `text`).
+func errorQuote(text, source string, err error) (quote string, position int, ok bool) {
+	var parseErr *d2parser.ParseError
+	if !errors.As(err, &parseErr) || len(parseErr.Errors) == 0 {
+		return "", 0, false
+	}
+
+	offset := strings.Index(text, source)
+	if offset < 0 {
+		return "", 0, false
+	}
+
+	lines := strings.Split(source, "\n")
+	start, end := parseErr.Errors[0].Range.Start.Line, parseErr.Errors[0].Range.End.Line
+	if start < 0 || start >= len(lines) {
+		return "", 0, false
+	}
+	if end < start || end >= len(lines) {
+		end = start
+	}
+
+	quote = strings.TrimSpace(strings.Join(lines[start:end+1], "\n"))
+	if utf16Length(quote) > maxQuoteLength {
+		quote = strings.TrimSpace(lines[start])
+	}
+	if quote == "" || utf16Length(quote) > maxQuoteLength {
+		return "", 0, false
+	}
+
+	// byte offset of the quote in `text`
+	lineOffset := 0
+	for _, line := range lines[:start] {
+		lineOffset += len(line) + 1 // + "\n"
+	}
+	offset += lineOffset + strings.Index(source[lineOffset:], quote)
+
+	return quote, utf16Length(text[:offset]), true
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// constants for reloading
+const (
+	commandReload = "/reload"
+
+	messageReloading        = "Reloading the config, and restarting the bot..."
+	messageAlreadyReloading = "The bot is already reloading."
+)
+
+// keys of the config which are applied only when the process starts (not on reloads)
+var restartRequiredConfigKeys = []string{"data_dir", "log_file", "store_filepath", "audit_log_filepath", "browser", "cache", "inline", "fonts", "imports", "viewer"}
+
+// requests for reloading the config (by /reload command)
+var reloadRequests = make(chan struct{}, 1)
+
+// watches reload requests (`/reload` command, SIGHUP, a rotated bot token in the secret backend, or a changed remote config),
+// and calls `stop` on one of them
+func watchReloads(ctx context.Context, stop context.CancelFunc, conf config, confFilepath string, hangups <-chan os.Signal) {
+	var polls <-chan time.Time
+	if conf.SecretPollInterval > 0 {
+		ticker := time.NewTicker(time.Duration(conf.SecretPollInterval) * time.Second)
+		defer ticker.Stop()
+
+		polls = ticker.C
+	}
+
+	var refreshes <-chan time.Time
+	if conf.ConfigRefreshInterval > 0 && isRefreshableConfig(conf, confFilepath) {
+		ticker := time.NewTicker(time.Duration(conf.ConfigRefreshInterval) * time.Second)
+		defer ticker.Stop()
+
+		refreshes = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangups:
+			log.Printf("received SIGHUP, reloading...")
+
+			stop()
+			return
+		case <-reloadRequests:
+			log.Printf("received reload request, reloading...")
+
+			stop()
+			return
+		case <-polls:
+			if reloaded, err := loadConfig(confFilepath); err == nil {
+				if reloaded.BotToken != conf.BotToken {
+					log.Printf("bot token was rotated, reloading...")
+
+					stop()
+					return
+				}
+			} else {
+				log.Printf("failed to check rotated bot token: %s", err)
+			}
+		case <-refreshes:
+			if refreshed, err := loadConfig(confFilepath); err == nil {
+				applied, restartRequired := splitRestartRequiredKeys(changedConfigKeys(conf, refreshed))
+				if len(restartRequired) > 0 {
+					log.Printf("config was changed (%s), but it requires a restart of the process", strings.Join(restartRequired, ", "))
+				}
+				if len(applied) > 0 {
+					log.Printf("config was changed (%s), reloading...", strings.Join(applied, ", "))
+
+					stop()
+					return
+				}
+
+				// NOTE: not to log the same changes again on next refreshes
+				conf = refreshed
+			} else {
+				log.Printf("failed to refresh config: %s", err)
+			}
+		}
+	}
+}
+
+// checks if given config can be changed without editing the local config file
+// (fetched from a remote URL, or has references to values in secret backends)
+func isRefreshableConfig(conf config, confFilepath string) bool {
+	return isRemoteConfig(confFilepath) || conf.hasSecretReferences
+}
+
+// splits changed keys of the config into ones applied on reloads, and ones which require a restart of the process
+func splitRestartRequiredKeys(changed []string) (applied, restartRequired []string) {
+	for _, key := range changed {
+		if slices.Contains(restartRequiredConfigKeys, key) {
+			restartRequired = append(restartRequired, key)
+		} else {
+			applied = append(applied, key)
+		}
+	}
+	return applied, restartRequired
+}
+
+// returns keys of values which differ between `current` and `refreshed` configs (values are not returned, as they may be secrets)
+func changedConfigKeys(current, refreshed config) (changed []string) {
+	var before, after map[string]any
+	if bs, err := json.Marshal(current); err == nil {
+		_ = json.Unmarshal(bs, &before)
+	}
+	if bs, err := json.Marshal(refreshed); err == nil {
+		_ = json.Unmarshal(bs, &after)
+	}
+
+	for key, value := range after {
+		if !reflect.DeepEqual(before[key], value) {
+			changed = append(changed, key)
+		}
+	}
+	for key := range before {
+		if _, exists := after[key]; !exists {
+			changed = append(changed, key)
+		}
+	}
+	slices.Sort(changed)
+
+	return changed
+}
+
+// handle reload command
+func handleReloadCommand(b *tg.Bot, conf config, update tg.Update) {
+	if message, _ := update.GetMessage(); message != nil {
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		select {
+		case reloadRequests <- struct{}{}:
+			replyText(b, chatID, messageID, messageReloading)
+		default:
+			replyText(b, chatID, messageID, messageAlreadyReloading)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// constants for remote config
+const (
+	envConfigBearerToken = "CONFIG_BEARER_TOKEN" // bearer token for fetching a remote config (optional)
+
+	remoteConfigTimeout = 30 * time.Second
+	remoteConfigMaxSize = 1024 * 1024 // 1MB
+)
+
+// checks if given config path is a remote URL
+func isRemoteConfig(path string) bool {
+	return strings.HasPrefix(path, "https://")
+}
+
+// fetches the config at given https `url`, with the bearer token in `CONFIG_BEARER_TOKEN` (if any)
+func fetchRemoteConfig(url string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token := os.Getenv(envConfigBearerToken); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := &http.Client{Timeout: remoteConfigTimeout}
+
+	var res *http.Response
+	if res, err = client.Do(req); err != nil {
+		return nil, fmt.Errorf("failed to fetch remote config: %s", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch remote config: %s", res.Status)
+	}
+
+	var bs []byte
+	if bs, err = io.ReadAll(io.LimitReader(res.Body, remoteConfigMaxSize+1)); err != nil {
+		return nil, fmt.Errorf("failed to read remote config: %s", err)
+	}
+	if len(bs) > remoteConfigMaxSize {
+		return nil, fmt.Errorf("remote config is too large (> %d bytes)", remoteConfigMaxSize)
+	}
+
+	return bs, nil
+}
@@ -0,0 +1,627 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+
+	// d2
+	"oss.terrastruct.com/d2/d2compiler"
+	"oss.terrastruct.com/d2/d2exporter"
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2layouts/d2dagrelayout"
+	"oss.terrastruct.com/d2/d2layouts/d2elklayout"
+	"oss.terrastruct.com/d2/d2renderers/d2svg"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/d2themes/d2themescatalog"
+	d2log "oss.terrastruct.com/d2/lib/log"
+	"oss.terrastruct.com/d2/lib/textmeasure"
+)
+
+// stages of rendering
+const (
+	stageCompiling  = "compiling"
+	stageLayingOut  = "laying out"
+	stageRendering  = "rendering svg"
+	stageConverting = "converting to %s"
+)
+
+// output format of rendered diagrams
+type outputFormat string
+
+// output formats
+const (
+	formatPNG   outputFormat = "png"   // .png file as a document
+	formatPhoto outputFormat = "photo" // .png file as a photo
+	formatSVG   outputFormat = "svg"   // .svg file as a document
+	formatPDF   outputFormat = "pdf"   // .pdf file as a document
+)
+
+// all supported output formats
+var outputFormats = []outputFormat{formatPNG, formatPhoto, formatSVG, formatPDF}
+
+// returns the file extension of the output format
+func (f outputFormat) extension() string {
+	switch f {
+	case formatSVG:
+		return "svg"
+	case formatPDF:
+		return "pdf"
+	default:
+		return "png"
+	}
+}
+
+// parses given string into an output format
+func parseOutputFormat(str string) (outputFormat, error) {
+	for _, format := range outputFormats {
+		if strings.EqualFold(str, string(format)) {
+			if format.needsBrowser() && !browserAvailable {
+				return "", errNoBrowser
+			}
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("not a supported output format: '%s'", str)
+}
+
+// constants for render options
+const (
+	renderOptionScale     = "scale"
+	renderOptionTheme     = "theme"
+	renderOptionSketch    = "sketch"
+	renderOptionLayout    = "layout"
+	renderOptionFormat    = "format"
+	renderOptionPreset    = "preset"
+	renderOptionDirection = "direction"
+	renderOptionRTL       = "rtl"
+
+	maxRenderScale = 4.0
+)
+
+// diagram directions
+var directions = []string{"right", "down", "left", "up"}
+
+// direction for choosing the orientation of diagrams automatically
+const directionAuto = "auto"
+
+// constants for the print preset
+const (
+	presetPrint = "print"
+
+	printDPI     = 300.0
+	screenDPI    = 96.0 // dpi of css pixels
+	rasterScale  = 2.0  // .png files are scaled 2x when converted
+	printPadding = int64(100)
+
+	maxPrintPixels     = 100_000_000 // max number of pixels (eg. 10000 x 10000) of a print
+	maxPrintSideLength = 16_384      // max width or height (in pixels) of a print
+)
+
+// layout engines
+const (
+	layoutDagre = "dagre"
+	layoutELK   = "elk"
+)
+
+// layout engines bundled with this bot
+var layoutEngines = []string{layoutDagre, layoutELK}
+
+// checks the default layout engine in the config, and logs it if it is not available
+func checkDefaultLayoutEngine(conf config) {
+	if conf.Layout != "" {
+		if _, err := parseLayoutEngine(conf.Layout); err != nil {
+			log.Printf("ignoring `layout` in the config: %s", err)
+		}
+	}
+}
+
+// returns all available layout engines (with TALA, if it is installed)
+func availableLayoutEngines() []string {
+	if talaPlugin() != nil {
+		return append(slices.Clone(layoutEngines), layoutTALA)
+	}
+	return layoutEngines
+}
+
+// parses given string into an available layout engine
+func parseLayoutEngine(str string) (string, error) {
+	layout := strings.ToLower(strings.TrimSpace(str))
+	if layout == layoutTALA && talaPlugin() == nil {
+		return "", errTALANotInstalled
+	}
+	if !slices.Contains(availableLayoutEngines(), layout) {
+		return "", fmt.Errorf("not a supported layout: '%s' (should be one of: %s)", str, strings.Join(availableLayoutEngines(), ", "))
+	}
+	return layout, nil
+}
+
+// options for rendering a diagram
+type renderOptions struct {
+	ThemeID int64        `json:"theme_id"`
+	Sketch  bool         `json:"sketch"`
+	Format  outputFormat `json:"format"`
+	Scale   float64      `json:"scale"`
+	Layout  string       `json:"layout"`
+	Preset  string       `json:"preset,omitempty"`
+
+	Direction string `json:"direction,omitempty"` // overrides the direction of diagrams (eg. `right`)
+	Padding   *int64 `json:"padding,omitempty"`   // padding (in pixels) around diagrams (= 40 for default)
+
+	HighContrast bool   `json:"high_contrast,omitempty"` // high-contrast colors, thicker strokes, and larger fonts (regardless of theme)
+	Palette      string `json:"palette,omitempty"`       // color-blind-safe palette which remaps theme colors (eg. `deuteranopia`)
+	RTL          bool   `json:"rtl,omitempty"`           // lay out labels from right to left (eg. arabic or hebrew)
+	DisableLaTeX bool   `json:"disable_latex,omitempty"` // reject diagrams with LaTeX blocks
+}
+
+// returns render options for `userID`, from environment variables of the d2 cli, the config, and the user's settings
+func renderOptionsForUser(conf config, userID int64) renderOptions {
+	opts := d2EnvDefaults()
+	opts.Format = defaultOutputFormat(conf)
+	opts.Scale = 1.0 // 1:1
+	if conf.Layout != "" {
+		if layout, err := parseLayoutEngine(conf.Layout); err == nil {
+			opts.Layout = layout
+		}
+	}
+	if conf.ThemeID != 0 {
+		opts.ThemeID = conf.ThemeID
+	}
+	if conf.Sketch {
+		opts.Sketch = true
+	}
+	opts.Palette = conf.Palette
+	opts.DisableLaTeX = conf.DisableLaTeX
+
+	settings := storage.loadSettings(userID)
+	if settings.Format != "" {
+		opts.Format = settings.Format
+	}
+	if settings.ThemeID != nil {
+		opts.ThemeID = *settings.ThemeID
+	}
+	opts.HighContrast = settings.HighContrast
+	if settings.Palette != "" {
+		opts.Palette = settings.Palette
+	}
+
+	// NOTE: fall back to .svg files in a build without a browser
+	if opts.Format.needsBrowser() && !browserAvailable {
+		opts.Format = formatSVG
+	}
+
+	return opts
+}
+
+// applies `key=value` arguments (eg. `scale=2`) to given render options
+func applyRenderArgs(opts renderOptions, values map[string]string) (renderOptions, error) {
+	for key, value := range values {
+		switch key {
+		case renderOptionScale:
+			scale, err := strconv.ParseFloat(value, 64)
+			if err != nil || scale <= 0 || scale > maxRenderScale {
+				return opts, fmt.Errorf("not a valid scale: '%s' (should be > 0 and <= %g)", value, maxRenderScale)
+			}
+			opts.Scale = scale
+		case renderOptionTheme:
+			themeID, err := parseThemeID(value)
+			if err != nil {
+				return opts, err
+			}
+			opts.ThemeID = themeID
+		case renderOptionSketch:
+			sketch, err := strconv.ParseBool(value)
+			if err != nil {
+				return opts, fmt.Errorf("not a valid sketch value: '%s' (should be true or false)", value)
+			}
+			opts.Sketch = sketch
+		case renderOptionLayout:
+			layout, err := parseLayoutEngine(value)
+			if err != nil {
+				return opts, err
+			}
+			opts.Layout = layout
+		case renderOptionFormat:
+			format, err := parseOutputFormat(value)
+			if err != nil {
+				return opts, err
+			}
+			opts.Format = format
+		case renderOptionPreset:
+			if !strings.EqualFold(value, presetPrint) {
+				return opts, fmt.Errorf("not a supported preset: '%s' (should be: %s)", value, presetPrint)
+			}
+			opts.Preset = presetPrint
+		case renderOptionDirection:
+			if !slices.Contains(directions, strings.ToLower(value)) && !strings.EqualFold(value, directionAuto) {
+				return opts, fmt.Errorf("not a supported direction: '%s' (should be one of: %s, or %s)", value, strings.Join(directions, ", "), directionAuto)
+			}
+			opts.Direction = strings.ToLower(value)
+		case renderOptionRTL:
+			rtl, err := strconv.ParseBool(value)
+			if err != nil {
+				return opts, fmt.Errorf("not a valid rtl value: '%s' (should be true or false)", value)
+			}
+			opts.RTL = rtl
+		default:
+			return opts, fmt.Errorf("not a supported option: '%s'", key)
+		}
+	}
+
+	// NOTE: prints are returned as .png (or .pdf) files only
+	if opts.Preset == presetPrint && opts.Format != formatPDF {
+		opts.Format = formatPNG
+	}
+
+	return opts, nil
+}
+
+// returns the direction in a directive line of given d2 source (eg. `#right`, or `#auto`), or an empty string if there is none
+//
+// (directives are comments for d2, so they do not affect the source itself)
+func directionDirective(source string) string {
+	for _, line := range strings.Split(source, "\n") {
+		if directive, found := strings.CutPrefix(strings.TrimSpace(line), "#"); found {
+			if directive = strings.ToLower(directive); slices.Contains(directions, directive) || directive == directionAuto {
+				return directive
+			}
+		}
+	}
+	return ""
+}
+
+// returns the layout engine in a directive line of given d2 source (eg. `# layout: elk`), or an empty string if there is none
+//
+// (directives are comments for d2, so they do not affect the source itself)
+func layoutDirective(source string) string {
+	for _, line := range strings.Split(source, "\n") {
+		if directive, found := strings.CutPrefix(strings.TrimSpace(line), "#"); found {
+			if key, value, found := strings.Cut(directive, ":"); found && strings.EqualFold(strings.TrimSpace(key), renderOptionLayout) {
+				return strings.TrimSpace(value)
+			}
+		}
+	}
+	return ""
+}
+
+// applies the layout engine in a directive line of given d2 source (eg. `# layout: elk`) to `opts`
+//
+// (an unavailable layout engine in the directive is ignored with a warning)
+func applyLayoutDirective(ctx context.Context, source string, opts renderOptions) renderOptions {
+	if directive := layoutDirective(source); directive != "" {
+		if layout, err := parseLayoutEngine(directive); err == nil {
+			opts.Layout = layout
+		} else {
+			d2log.Warn(ctx, fmt.Sprintf("ignoring layout directive: %s", err))
+		}
+	}
+	return opts
+}
+
+// returns the padding and scale of a render with `opts`
+func paddingAndScale(opts renderOptions) (padding int64, scale float64) {
+	if opts.Preset == presetPrint {
+		scale = opts.Scale
+		if opts.Format != formatPDF {
+			// NOTE: scale up .png files to 300 dpi
+			scale *= printDPI / screenDPI / rasterScale
+		}
+		return printPadding, scale
+	}
+	if opts.Padding != nil {
+		return *opts.Padding, opts.Scale
+	}
+	return renderPadding, opts.Scale
+}
+
+// checks if a print of given size (in css pixels, with padding) is not too large
+func checkPrintSize(width, height int64) error {
+	// size at 300 dpi
+	w := int64(float64(width) * printDPI / screenDPI)
+	h := int64(float64(height) * printDPI / screenDPI)
+
+	if w*h > maxPrintPixels || w > maxPrintSideLength || h > maxPrintSideLength {
+		return fmt.Errorf("diagram is too large for printing: %d x %d pixels (max: %d pixels, %d pixels for each side)", w, h, maxPrintPixels, maxPrintSideLength)
+	}
+	return nil
+}
+
+// parses given string into an id of d2 themes
+func parseThemeID(str string) (int64, error) {
+	if themeID, err := strconv.ParseInt(str, 10, 64); err == nil {
+		if d2themescatalog.Find(themeID).Name != "" {
+			return themeID, nil
+		}
+	}
+	return 0, fmt.Errorf("not a valid theme id: '%s'", str)
+}
+
+// compiles given d2 source into a graph
+//
+// Imports in the source are resolved against the base url of `imports` in the config (if configured).
+func compileGraph(str string) (graph *d2graph.Graph, err error) {
+	graph, _, err = d2compiler.Compile("", strings.NewReader(str), &d2compiler.CompileOptions{UTF16Pos: true, FS: importFS})
+	return graph, err
+}
+
+// validateSource checks if given d2 source compiles.
+func validateSource(str string) (err error) {
+	_, err = compileGraph(str)
+	return err
+}
+
+// renderDiagram returns a bytes array of the rendered svg diagram in the format of `opts`,
+// with warnings emitted by d2 while rendering it.
+//
+// Rendering is aborted when given `ctx` is canceled,
+// and each stage of rendering is reported to `progress` if it is not nil.
+//
+// Renders are cached with the hash of `str` and `opts`, in memory and on disk (if configured).
+func renderDiagram(ctx context.Context, str string, opts renderOptions, progress func(stage string)) (bs []byte, warnings []string, err error) {
+	if err = checkAccessHours(ctx); err != nil {
+		return nil, nil, err
+	}
+	if progress == nil {
+		progress = func(string) {}
+	}
+	if opts.Scale <= 0 {
+		opts.Scale = 1.0
+	}
+
+	key := renderCacheKey(str, opts)
+	if bs, warnings, exists := renders.get(key); exists {
+		return bs, warnings, nil
+	}
+	if bs, exists := renderFiles.get(key); exists {
+		renders.put(key, bs, nil)
+		return bs, nil, nil
+	}
+
+	ctx, collector := withWarningCollector(ctx)
+	if bs, err = render(ctx, str, opts, progress); err == nil {
+		renders.put(key, bs, collector.collected())
+
+		// NOTE: renders with warnings are not cached on disk, so that their warnings are shown again after restarts
+		if len(collector.collected()) == 0 {
+			renderFiles.put(key, bs)
+		}
+	}
+
+	return bs, collector.collected(), err
+}
+
+// renders given d2 source with `opts`, reporting each stage to `progress`
+// (rendered again with the fallback layout engine, if the layout engine of `opts` fails)
+func render(ctx context.Context, str string, opts renderOptions, progress func(stage string)) (bs []byte, err error) {
+	opts = applyLayoutDirective(ctx, str, opts)
+
+	return withRenderWorker(ctx, progress, func() ([]byte, error) {
+		return withLayoutFallback(ctx, opts, func(opts renderOptions) ([]byte, error) {
+			return renderWithLayout(ctx, str, opts, progress)
+		})
+	})
+}
+
+// renders given d2 source with `opts` (and its layout engine), reporting each stage to `progress`
+//
+// For .pdf files, all boards (layers, scenarios, and steps) are rendered as pages in order.
+func renderWithLayout(ctx context.Context, str string, opts renderOptions, progress func(stage string)) (bs []byte, err error) {
+	progress(stageCompiling)
+
+	// NOTE: a directive in the source (eg. `#down`) is applied unless the direction is given in `opts`
+	if opts.Direction == "" {
+		opts.Direction = directionDirective(str)
+	}
+	if !opts.RTL {
+		opts.RTL = rtlDirective(str)
+	}
+
+	var graph *d2graph.Graph
+	if graph, err = compileGraph(str); err == nil {
+		var ruler *textmeasure.Ruler
+		if ruler, err = textmeasure.NewRuler(); err == nil {
+			var page renderedBoard
+			if page, err = renderBoard(ctx, graph, ruler, opts, progress); err == nil {
+				switch opts.Format {
+				case formatSVG:
+					return page.svg, nil
+				case formatPDF:
+					pages := []renderedBoard{page}
+					if pages, err = renderChildBoards(ctx, graph, ruler, opts, pages); err == nil {
+						progress(fmt.Sprintf(stageConverting, "PDF"))
+
+						bs, err = convertSVGToPDF(ctx, pages)
+						return bs, transient(err)
+					}
+				default:
+					progress(fmt.Sprintf(stageConverting, "PNG"))
+
+					return convertSVG(ctx, page.svg)
+				}
+			}
+		}
+	}
+	return nil, err
+}
+
+// a board rendered into svg, with its size (in pixels)
+type renderedBoard struct {
+	name          string // empty for the root board
+	svg           []byte
+	width, height int64
+}
+
+// lays out a board (the root, a layer, a scenario, or a step) of a graph with `opts`, and exports it into a diagram
+// (`latex` is true if it has LaTeX blocks)
+func layoutBoard(ctx context.Context, graph *d2graph.Graph, ruler *textmeasure.Ruler, opts renderOptions, progress func(stage string)) (diagram *d2target.Diagram, latex bool, err error) {
+	if slices.Contains(directions, opts.Direction) {
+		graph.Root.Direction.Value = opts.Direction
+	}
+	if opts.HighContrast {
+		enlargeFonts(graph)
+	}
+
+	latex = hasLaTeX(graph)
+	if latex && opts.DisableLaTeX {
+		return nil, latex, errLaTeXDisabled
+	}
+
+	// NOTE: labels with glyphs missing in the default font (eg. emoji) are measured with fallback fonts
+	if err = graph.SetDimensions(fontFallbacks.measure(graph, ruler), ruler, nil); err != nil && latex { // fontFamily = nil: use default
+		err = latexError(err) // NOTE: LaTeX blocks are rendered (and fail) while being measured
+	}
+	if err == nil {
+		progress(stageLayingOut)
+
+		if err = layoutFallbacks.layout(ctx, graph, opts.Layout); err == nil {
+			progress(stageRendering)
+
+			if diagram, err = d2exporter.Export(ctx, graph, nil); err == nil { // fontFamily = nil: use default
+				if opts.HighContrast {
+					applyHighContrast(diagram)
+				}
+				return diagram, latex, nil
+			}
+		}
+	}
+	return nil, latex, err
+}
+
+// renders a board (the root, a layer, a scenario, or a step) of a graph into svg with `opts`
+func renderBoard(ctx context.Context, graph *d2graph.Graph, ruler *textmeasure.Ruler, opts renderOptions, progress func(stage string)) (board renderedBoard, err error) {
+	var diagram *d2target.Diagram
+	var latex bool
+	if diagram, latex, err = layoutBoard(ctx, graph, ruler, opts, progress); err == nil {
+		padding, scale := paddingAndScale(opts)
+
+		topLeft, bottomRight := diagram.BoundingBox()
+		board.width = int64(float64(int64(bottomRight.X-topLeft.X)+padding*2) * opts.Scale)
+		board.height = int64(float64(int64(bottomRight.Y-topLeft.Y)+padding*2) * opts.Scale)
+
+		if opts.Preset == presetPrint {
+			if err = checkPrintSize(board.width, board.height); err != nil {
+				return board, err
+			}
+		}
+
+		board.svg, err = d2svg.Render(diagram, &d2svg.RenderOpts{
+			Pad:            toPointer(padding),
+			Sketch:         toPointer(opts.Sketch),
+			ThemeID:        toPointer(opts.ThemeID),
+			DarkThemeID:    d2svg.DEFAULT_DARK_THEME,
+			ThemeOverrides: themeOverrides(opts),
+			Scale:          toPointer(scale),
+		})
+		if err == nil && opts.Layout == layoutTALA {
+			board.svg, err = postProcessTALA(ctx, board.svg)
+		}
+		if err == nil {
+			board.svg = fontFallbacks.apply(board.svg)
+			theme := d2themescatalog.Find(opts.ThemeID)
+			board.svg = highlightMarkdownCode(board.svg, theme.IsDark())
+			if latex {
+				board.svg = fixLaTeXSizes(board.svg)
+			}
+			if opts.RTL {
+				board.svg = applyRTL(board.svg)
+			}
+		}
+	}
+	return board, err
+}
+
+// renders all boards under `graph` recursively (in order of layers, scenarios, and steps), and appends them to `rendered`
+//
+// (boards without any shape, eg. folders of other boards, are skipped)
+func renderChildBoards(ctx context.Context, graph *d2graph.Graph, ruler *textmeasure.Ruler, opts renderOptions, rendered []renderedBoard) ([]renderedBoard, error) {
+	for _, boards := range [][]*d2graph.Graph{graph.Layers, graph.Scenarios, graph.Steps} {
+		for _, board := range boards {
+			if len(board.Objects) > 0 {
+				page, err := renderBoard(ctx, board, ruler, opts, func(string) {})
+				if err != nil {
+					return nil, fmt.Errorf("failed to render board '%s': %w", board.Name, err)
+				}
+				page.name = board.Name
+				rendered = append(rendered, page)
+			}
+
+			var err error
+			if rendered, err = renderChildBoards(ctx, board, ruler, opts, rendered); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return rendered, nil
+}
+
+// renders all boards of given d2 source into svg with `opts` (the root first, then layers, scenarios, and steps in order)
+//
+// (rendered again with the fallback layout engine, if the layout engine of `opts` fails)
+func renderBoards(ctx context.Context, str string, opts renderOptions) (boards []renderedBoard, err error) {
+	if err = checkAccessHours(ctx); err != nil {
+		return nil, err
+	}
+	opts = applyLayoutDirective(ctx, str, opts)
+
+	return withRenderWorker(ctx, nil, func() ([]renderedBoard, error) {
+		return withLayoutFallback(ctx, opts, func(opts renderOptions) ([]renderedBoard, error) {
+			return renderBoardsWithLayout(ctx, str, opts)
+		})
+	})
+}
+
+// renders all boards of given d2 source into svg with `opts` (and its layout engine)
+func renderBoardsWithLayout(ctx context.Context, str string, opts renderOptions) (boards []renderedBoard, err error) {
+	if opts.Direction == "" {
+		opts.Direction = directionDirective(str)
+	}
+	if !opts.RTL {
+		opts.RTL = rtlDirective(str)
+	}
+	if opts.Scale <= 0 {
+		opts.Scale = 1.0
+	}
+
+	var graph *d2graph.Graph
+	if graph, err = compileGraph(str); err == nil {
+		var ruler *textmeasure.Ruler
+		if ruler, err = textmeasure.NewRuler(); err == nil {
+			var root renderedBoard
+			if root, err = renderBoard(ctx, graph, ruler, opts, func(string) {}); err == nil {
+				return renderChildBoards(ctx, graph, ruler, opts, []renderedBoard{root})
+			}
+		}
+	}
+	return nil, err
+}
+
+// lays out given graph with the layout engine of `layout`
+func layoutGraph(ctx context.Context, graph *d2graph.Graph, layout string) error {
+	switch layout {
+	case layoutELK:
+		return d2elklayout.Layout(ctx, graph, nil) // opts = nil: use default
+	case layoutTALA:
+		return layoutWithTALA(ctx, graph)
+	default:
+		return d2dagrelayout.Layout(ctx, graph, nil) // opts = nil: use default
+	}
+}
+
+// convertSVG converts given svg bytes into .png format with a browser.
+//
+// Converted files are cached on disk (if configured), so that identical diagrams are not converted again.
+func convertSVG(ctx context.Context, svg []byte) ([]byte, error) {
+	key := hashKey(svg)
+	if bs, exists := conversions.get(key); exists {
+		return bs, nil
+	}
+
+	bs, err := rasterizeSVG(ctx, svg)
+	if err == nil {
+		conversions.put(key, bs)
+	}
+	return bs, transient(err) // NOTE: conversions fail with crashes or timeouts of the browser, which may not happen again
+}
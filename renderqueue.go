@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// constants for the render queue
+const (
+	defaultMaxRenderWorkers = 2
+
+	stageQueued = "waiting for other renders (%d in queue)"
+)
+
+var errRenderQueueFull = errors.New("too many renders are waiting in the queue, please try again later")
+
+// settings of the render queue
+type renderQueueConfig struct {
+	MaxWorkers int `json:"max_workers,omitempty"` // max number of concurrent renders (= 2 for default)
+	MaxQueued  int `json:"max_queued,omitempty"`  // max number of renders waiting for workers (0 for unlimited)
+}
+
+// a queue which bounds the number of concurrent renders, as conversions with the browser are memory heavy
+type renderQueue struct {
+	workers   chan struct{} // a token for each render in progress
+	maxQueued int
+
+	waiting atomic.Int64 // number of renders waiting for workers
+}
+
+// render queue with current settings (replaced when the bot is served with a new config)
+var renderWorkers = newRenderQueue(nil)
+
+// returns a new render queue with given settings (or the default settings if `conf` is nil)
+func newRenderQueue(conf *renderQueueConfig) *renderQueue {
+	maxWorkers, maxQueued := defaultMaxRenderWorkers, 0
+	if conf != nil {
+		if conf.MaxWorkers > 0 {
+			maxWorkers = conf.MaxWorkers
+		}
+		maxQueued = max(conf.MaxQueued, 0)
+	}
+
+	return &renderQueue{
+		workers:   make(chan struct{}, maxWorkers),
+		maxQueued: maxQueued,
+	}
+}
+
+// waits for a free worker (reporting the number of waiting renders to `progress`), and returns a function which frees it
+//
+// (returns a transient error if the queue is full, or an error of `ctx` if it is done while waiting)
+func (q *renderQueue) acquire(ctx context.Context, progress func(stage string)) (release func(), err error) {
+	select {
+	case q.workers <- struct{}{}:
+		return q.release, nil
+	default:
+	}
+
+	waiting := q.waiting.Add(1)
+	defer q.waiting.Add(-1)
+
+	if q.maxQueued > 0 && waiting > int64(q.maxQueued) {
+		return nil, transient(errRenderQueueFull)
+	}
+	if progress != nil {
+		progress(fmt.Sprintf(stageQueued, waiting))
+	}
+
+	select {
+	case q.workers <- struct{}{}:
+		return q.release, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// frees a worker
+func (q *renderQueue) release() {
+	<-q.workers
+}
+
+// returns the number of renders in progress and waiting for workers
+func (q *renderQueue) statistics() (running, waiting int) {
+	return len(q.workers), int(q.waiting.Load())
+}
+
+// runs `fn` with a worker of the render queue
+func withRenderWorker[T any](ctx context.Context, progress func(stage string), fn func() (T, error)) (result T, err error) {
+	var release func()
+	if release, err = renderWorkers.acquire(ctx, progress); err != nil {
+		return result, err
+	}
+	defer release()
+
+	return fn()
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+)
+
+// constants for request ids
+const (
+	requestIDLength = 4 // in bytes (8 hex characters)
+)
+
+// key for the request id in contexts
+type requestIDKey struct{}
+
+// returns a new random request id
+func newRequestID() string {
+	bs := make([]byte, requestIDLength)
+	if _, err := rand.Read(bs); err != nil {
+		return "????????"
+	}
+	return hex.EncodeToString(bs)
+}
+
+// returns a copy of `ctx` with given request id
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// returns the request id in `ctx` (or an empty string if there is none)
+func requestIDOf(ctx context.Context) string {
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok {
+		return id
+	}
+	return ""
+}
+
+// logs with the request id in `ctx` as a prefix, so that logs of concurrent requests can be told apart
+func logf(ctx context.Context, format string, v ...any) {
+	if id := requestIDOf(ctx); id != "" {
+		log.Printf("[%s] %s", id, fmt.Sprintf(format, v...))
+	} else {
+		log.Printf(format, v...)
+	}
+}
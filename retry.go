@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// constants for retries
+const (
+	defaultMaxRetries   = 2
+	defaultRetryBackoff = 2 // in seconds
+
+	stageRetrying = "retrying after a failure (%d/%d)"
+)
+
+// descriptions of telegram errors which will fail again when retried
+var permanentSendErrors = []string{"Bad Request", "Forbidden", "Unauthorized"}
+
+// retry settings
+type retriesConfig struct {
+	Max     int `json:"max,omitempty"`     // max number of retries (= 2 for default, negative for no retries)
+	Backoff int `json:"backoff,omitempty"` // in seconds, before the first retry (doubled after each retry)
+}
+
+// retrier of renders and sends failed with transient errors
+type retrier struct {
+	max     int
+	backoff time.Duration
+}
+
+// retrier with current settings (replaced when the bot is served with a new config)
+var retries = newRetrier(nil)
+
+// returns a new retrier with given settings (or the default settings if `conf` is nil)
+func newRetrier(conf *retriesConfig) *retrier {
+	r := &retrier{
+		max:     defaultMaxRetries,
+		backoff: defaultRetryBackoff * time.Second,
+	}
+	if conf != nil {
+		if conf.Max != 0 {
+			r.max = max(conf.Max, 0)
+		}
+		if conf.Backoff > 0 {
+			r.backoff = time.Duration(conf.Backoff) * time.Second
+		}
+	}
+	return r
+}
+
+// an error which may not happen again when retried (eg. a crash of the browser, or a hiccup of telegram)
+type transientError struct {
+	err   error
+	after time.Duration // min duration to wait before retrying (eg. `retry_after` of telegram)
+}
+
+// returns the message of the error
+func (e transientError) Error() string {
+	return e.err.Error()
+}
+
+// returns the wrapped error
+func (e transientError) Unwrap() error {
+	return e.err
+}
+
+// marks given error as transient (unless it is nil, canceled, or will happen again, eg. no browser in the build)
+func transient(err error) error {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, errNoBrowser) {
+		return err
+	}
+	return transientError{err: err}
+}
+
+// checks if given error is transient
+func isTransient(err error) bool {
+	var t transientError
+	return errors.As(err, &t)
+}
+
+// returns an error of a failed request to telegram (transient unless it is rejected for good, eg. a bad request)
+func sendError(description *string, params *tg.APIResponseParameters) error {
+	err := errors.New("unknown error")
+	if description != nil {
+		err = errors.New(*description)
+	}
+	for _, permanent := range permanentSendErrors {
+		if strings.HasPrefix(err.Error(), permanent) {
+			return err
+		}
+	}
+
+	t := transientError{err: err}
+	if params != nil && params.RetryAfter != nil {
+		t.after = time.Duration(*params.RetryAfter) * time.Second
+	}
+	return t
+}
+
+// runs `fn` until it succeeds or fails with an error which is not transient, retrying it with backoff up to the max number of retries
+//
+// (each retry is reported to `retrying`, if it is not nil)
+func (r *retrier) do(ctx context.Context, retrying func(retry, max int), fn func() error) (err error) {
+	backoff := r.backoff
+	for retry := 1; ; retry++ {
+		if err = fn(); err == nil || !isTransient(err) {
+			return err
+		}
+		if retry > r.max {
+			if r.max > 0 {
+				err = fmt.Errorf("%w (gave up after %d retries)", err, r.max)
+			}
+			return err
+		}
+
+		logf(ctx, "retrying after a transient failure (%d/%d): %s", retry, r.max, err)
+		if retrying != nil {
+			retrying(retry, r.max)
+		}
+
+		wait := backoff
+		var t transientError
+		if errors.As(err, &t) {
+			wait = max(wait, t.after)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+}
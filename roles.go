@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// roles of users
+type role string
+
+// roles of users
+const (
+	roleNone     role = ""
+	roleAdmin    role = "admin"    // can manage the bot (eg. /reload, /role) and render
+	roleUser     role = "user"     // can render
+	roleReadonly role = "readonly" // can only fetch diagrams from the shared library
+)
+
+// constants for roles
+const (
+	commandRole = "/role"
+
+	messageUsageRole    = "Usage: /role <username> [admin|user|readonly|none]"
+	messageRoleOf       = "Role of @%s: %s"
+	messageRoleSet      = "Set role of @%s to: %s"
+	messageRoleNotValid = "Not a valid role: %s (should be one of admin, user, readonly, or none)"
+	messageReadonly     = "You can only fetch diagrams from the shared library (/get)."
+	messageNoRole       = "none"
+)
+
+// commands which are permitted for readonly users by default
+var readonlyCommands = []string{commandStart, commandHelp, commandPrivacy, commandVersion, commandGet}
+
+// returns the role of given username
+//
+// Admins in the config are always admins, then roles assigned with `/role` take precedence over the config.
+func roleOf(conf config, username *string) role {
+	if username != nil {
+		if slices.Contains(conf.AdminIDs, *username) {
+			return roleAdmin
+		}
+		if storage != nil {
+			if r, exists := storage.loadRole(*username); exists {
+				return r
+			}
+		}
+	}
+
+	if matchesAnyAllowedID(conf.AllowedIDs, username) {
+		return roleUser
+	}
+	if matchesAnyAllowedID(conf.ReadonlyIDs, username) {
+		return roleReadonly
+	}
+
+	return roleNone
+}
+
+// checks if given username matches any of `allowedIDs` (or `allowedIDs` has "*")
+func matchesAnyAllowedID(allowedIDs []string, username *string) bool {
+	if slices.Contains(allowedIDs, allowedIDEveryone) {
+		return true
+	}
+	if username == nil {
+		return false
+	}
+
+	for _, v := range allowedIDs {
+		if matchesAllowedID(v, *username) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// handle role command
+func handleRoleCommand(b *tg.Bot, conf config, update tg.Update, args string) {
+	if message, _ := update.GetMessage(); message != nil {
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		fields := strings.Fields(args)
+		if len(fields) < 1 || len(fields) > 2 {
+			replyError(b, chatID, messageID, messageUsageRole)
+			return
+		}
+		username := strings.TrimPrefix(fields[0], "@")
+
+		// show the role
+		if len(fields) == 1 {
+			r := roleOf(conf, &username)
+			if r == roleNone {
+				r = messageNoRole
+			}
+			replyText(b, chatID, messageID, fmt.Sprintf(messageRoleOf, username, r))
+			return
+		}
+
+		// or assign a role
+		r := role(fields[1])
+		if r == messageNoRole {
+			r = roleNone
+		}
+		if !slices.Contains([]role{roleAdmin, roleUser, roleReadonly, roleNone}, r) {
+			replyError(b, chatID, messageID, fmt.Sprintf(messageRoleNotValid, fields[1]))
+			return
+		}
+		if err := storage.saveRole(username, r); err != nil {
+			replyError(b, chatID, messageID, err.Error())
+			return
+		}
+		replyText(b, chatID, messageID, fmt.Sprintf(messageRoleSet, username, fields[1]))
+	}
+}
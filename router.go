@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// routes updates to their handlers by commands and types
+type updateRouter struct {
+	botUsername string
+
+	commandHandlers          map[string]func(ctx context.Context, b *tg.Bot, update tg.Update, args string)
+	noMatchingCommandHandler func(ctx context.Context, b *tg.Bot, update tg.Update, cmd, args string)
+
+	messageHandler       func(ctx context.Context, b *tg.Bot, update tg.Update, message tg.Message, edited bool)
+	channelPostHandler   func(ctx context.Context, b *tg.Bot, update tg.Update, post tg.Message, edited bool)
+	callbackQueryHandler func(ctx context.Context, b *tg.Bot, update tg.Update, query tg.CallbackQuery)
+	inlineQueryHandler   func(ctx context.Context, b *tg.Bot, update tg.Update, query tg.InlineQuery)
+
+	// handler for updates which are not handled by any of the above
+	updateHandler func(ctx context.Context, b *tg.Bot, update tg.Update)
+}
+
+// returns a new router for the bot with `botUsername`
+func newUpdateRouter(botUsername string) *updateRouter {
+	return &updateRouter{
+		botUsername:     botUsername,
+		commandHandlers: map[string]func(ctx context.Context, b *tg.Bot, update tg.Update, args string){},
+	}
+}
+
+// adds a handler for `command`
+func (r *updateRouter) addCommandHandler(command string, handler func(ctx context.Context, b *tg.Bot, update tg.Update, args string)) {
+	r.commandHandlers[command] = handler
+}
+
+// sets a handler for commands without matching handlers
+func (r *updateRouter) setNoMatchingCommandHandler(handler func(ctx context.Context, b *tg.Bot, update tg.Update, cmd, args string)) {
+	r.noMatchingCommandHandler = handler
+}
+
+// sets a handler for (edited) messages
+func (r *updateRouter) setMessageHandler(handler func(ctx context.Context, b *tg.Bot, update tg.Update, message tg.Message, edited bool)) {
+	r.messageHandler = handler
+}
+
+// sets a handler for (edited) channel posts
+func (r *updateRouter) setChannelPostHandler(handler func(ctx context.Context, b *tg.Bot, update tg.Update, post tg.Message, edited bool)) {
+	r.channelPostHandler = handler
+}
+
+// sets a handler for callback queries
+func (r *updateRouter) setCallbackQueryHandler(handler func(ctx context.Context, b *tg.Bot, update tg.Update, query tg.CallbackQuery)) {
+	r.callbackQueryHandler = handler
+}
+
+// sets a handler for inline queries
+func (r *updateRouter) setInlineQueryHandler(handler func(ctx context.Context, b *tg.Bot, update tg.Update, query tg.InlineQuery)) {
+	r.inlineQueryHandler = handler
+}
+
+// sets a handler for updates which are not handled by other handlers
+func (r *updateRouter) setUpdateHandler(handler func(ctx context.Context, b *tg.Bot, update tg.Update)) {
+	r.updateHandler = handler
+}
+
+// routes given update to its handler
+func (r *updateRouter) route(ctx context.Context, b *tg.Bot, update tg.Update) {
+	if r.routeCommand(ctx, b, update) {
+		return
+	}
+
+	switch {
+	case r.messageHandler != nil && (update.HasMessage() || update.HasEditedMessage()):
+		message, edited := update.GetMessage()
+		r.messageHandler(ctx, b, update, *message, edited)
+	case r.channelPostHandler != nil && update.HasChannelPost():
+		r.channelPostHandler(ctx, b, update, *update.ChannelPost, false)
+	case r.channelPostHandler != nil && update.HasEditedChannelPost():
+		r.channelPostHandler(ctx, b, update, *update.EditedChannelPost, true)
+	case r.callbackQueryHandler != nil && update.HasCallbackQuery():
+		r.callbackQueryHandler(ctx, b, update, *update.CallbackQuery)
+	case r.inlineQueryHandler != nil && update.HasInlineQuery():
+		r.inlineQueryHandler(ctx, b, update, *update.InlineQuery)
+	case r.updateHandler != nil:
+		r.updateHandler(ctx, b, update)
+	}
+}
+
+// routes given update to its command handler, and returns true if it was a command
+func (r *updateRouter) routeCommand(ctx context.Context, b *tg.Bot, update tg.Update) bool {
+	command, args, isCommand := r.commandOf(update)
+	if !isCommand {
+		return false
+	}
+
+	if handler, exists := r.commandHandlers[command]; exists {
+		handler(ctx, b, update, args)
+		return true
+	}
+	if r.noMatchingCommandHandler != nil {
+		r.noMatchingCommandHandler(ctx, b, update, command, args)
+		return true
+	}
+	return false
+}
+
+// returns the command (without the bot's username) and its arguments in given update,
+// and whether it is a command for this bot
+func (r *updateRouter) commandOf(update tg.Update) (command, args string, isCommand bool) {
+	message, _ := update.GetMessage()
+	if message == nil || !message.HasText() || !strings.HasPrefix(*message.Text, "/") {
+		return "", "", false
+	}
+
+	command, args, _ = strings.Cut(*message.Text, " ")
+	args = strings.TrimSpace(args)
+
+	// strip bot's username from the command (eg. `/help@some_bot` => `/help`)
+	if cmd, username, found := strings.Cut(command, "@"); found {
+		if !strings.EqualFold(username, r.botUsername) {
+			return "", "", false // command for other bots
+		}
+		command = cmd
+	}
+
+	return command, args, true
+}
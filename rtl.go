@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+)
+
+// constants for right-to-left labels
+const (
+	directiveRTL = "rtl"
+
+	// NOTE: labels are laid out from right to left (and aligned to the right, when they are aligned to the start),
+	// and shaped by the browser (eg. joined arabic letters)
+	rtlStyle = `
+.text, .text-bold, .text-italic, .text-mono, text, tspan, .md {
+	direction: rtl;
+	unicode-bidi: embed;
+}`
+)
+
+// checks if given d2 source has a `#rtl` directive line
+//
+// (directives are comments for d2, so they do not affect the source itself)
+func rtlDirective(source string) bool {
+	for _, line := range strings.Split(source, "\n") {
+		if directive, found := strings.CutPrefix(strings.TrimSpace(line), "#"); found && strings.EqualFold(directive, directiveRTL) {
+			return true
+		}
+	}
+	return false
+}
+
+// applies right-to-left direction to labels in given svg file rendered by d2
+func applyRTL(svg []byte) []byte {
+	// NOTE: append the style to the stylesheet of d2 (or prepend a new one, if there is none)
+	if i := bytes.Index(svg, []byte("]]></style>")); i >= 0 {
+		return append(svg[:i:i], append([]byte(rtlStyle), svg[i:]...)...)
+	}
+	if i := bytes.Index(svg, []byte("<svg")); i >= 0 {
+		if end := bytes.IndexByte(svg[i:], '>'); end >= 0 {
+			at := i + end + 1
+			return append(svg[:at:at], append([]byte(`<style type="text/css"><![CDATA[`+rtlStyle+`]]></style>`), svg[at:]...)...)
+		}
+	}
+	return svg
+}
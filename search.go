@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"unicode"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// constants for searching saved diagrams
+const (
+	commandFind = "/find"
+
+	callbackPrefixLibrary = "lib:"
+
+	minSearchTokenLen = 2
+	maxSearchResults  = 10
+
+	messageFindUsage    = "Usage: /find <keywords>"
+	messageFindNotFound = "No diagram in your library matches '%s'."
+	messageFindHeader   = "Diagrams matching '%s':"
+	messageFindMore     = "(showing %d of %d matches)"
+)
+
+// full-text index of saved diagrams: user id => token => names
+type searchIndex map[int64]map[string]map[string]bool
+
+// splits given text into lowercased tokens of letters and digits
+func tokenize(text string) (tokens []string) {
+	for _, token := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len([]rune(token)) >= minSearchTokenLen {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// indexes the diagram with `name` and `source` of `userID`
+func (idx searchIndex) add(userID int64, name, source string) {
+	if _, exists := idx[userID]; !exists {
+		idx[userID] = map[string]map[string]bool{}
+	}
+	for _, token := range append(tokenize(name), tokenize(source)...) {
+		if _, exists := idx[userID][token]; !exists {
+			idx[userID][token] = map[string]bool{}
+		}
+		idx[userID][token][name] = true
+	}
+}
+
+// removes the diagram with `name` of `userID` from the index
+func (idx searchIndex) remove(userID int64, name string) {
+	for token, names := range idx[userID] {
+		delete(names, name)
+		if len(names) == 0 {
+			delete(idx[userID], token)
+		}
+	}
+}
+
+// returns sorted names of diagrams of `userID` which have all terms of `query` (as prefixes of their tokens)
+func (idx searchIndex) search(userID int64, query string) (names []string) {
+	terms := tokenize(query)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	var matches map[string]bool
+	for _, term := range terms {
+		found := map[string]bool{}
+		for token, tokenNames := range idx[userID] {
+			if strings.HasPrefix(token, term) {
+				for name := range tokenNames {
+					if matches == nil || matches[name] {
+						found[name] = true
+					}
+				}
+			}
+		}
+		if matches = found; len(matches) == 0 {
+			return nil
+		}
+	}
+
+	for name := range matches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// handle find command
+func handleFindCommand(b *tg.Bot, conf config, update tg.Update, args string) {
+	if message, _ := update.GetMessage(); message != nil {
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		query := strings.TrimSpace(args)
+		if len(tokenize(query)) == 0 {
+			replyError(b, chatID, messageID, messageFindUsage)
+			return
+		}
+
+		names := storage.searchLibrary(message.From.ID, query)
+		if len(names) == 0 {
+			replyText(b, chatID, messageID, fmt.Sprintf(messageFindNotFound, query))
+			return
+		}
+
+		text := fmt.Sprintf(messageFindHeader, query)
+		if len(names) > maxSearchResults {
+			text += "\n" + fmt.Sprintf(messageFindMore, maxSearchResults, len(names))
+			names = names[:maxSearchResults]
+		}
+
+		keyboard := [][]tg.InlineKeyboardButton{}
+		for _, name := range names {
+			keyboard = append(keyboard, []tg.InlineKeyboardButton{
+				{
+					Text:         "🖼 " + name,
+					CallbackData: toPointer(callbackPrefixLibrary + name),
+				},
+			})
+		}
+
+		if sent := b.SendMessage(
+			chatID,
+			text,
+			tg.OptionsSendMessage{}.
+				SetReplyParameters(tg.NewReplyParameters(messageID)).
+				SetReplyMarkup(tg.NewInlineKeyboardMarkup(keyboard))); !sent.Ok {
+			log.Printf("failed to send search results: %s", *sent.Description)
+		}
+	}
+}
+
+// handles a callback query for rendering a saved diagram with `name`
+func handleLibraryCallback(ctx context.Context, b *tg.Bot, conf config, query tg.CallbackQuery, name string) {
+	if query.Message == nil {
+		answerCallbackQuery(b, query, nil)
+		return
+	}
+
+	entry, exists := storage.loadFromLibrary(query.From.ID, name)
+	if !exists {
+		answerCallbackQuery(b, query, toPointer(fmt.Sprintf(messageNoSuchDiagram, name)))
+		return
+	}
+	answerCallbackQuery(b, query, nil)
+
+	replyRendered(ctx, b, conf, query.From.ID, query.Message.Chat.ID, query.Message.MessageID, []string{entry.Source})
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// names of secret providers
+const (
+	secretProviderPlain             = "plain"
+	secretProviderEnv               = "env"
+	secretProviderFile              = "file"
+	secretProviderCredential        = "credential"
+	secretProviderInfisical         = "infisical"
+	secretProviderVault             = "vault"
+	secretProviderAWSSecretsManager = "aws-secretsmanager"
+	secretProviderAWSSSM            = "aws-ssm"
+	secretProviderOnePassword       = "onepassword"
+
+	defaultBotTokenEnv = "TELEGRAM_BOT_TOKEN"
+
+	envCredentialsDirectory = "CREDENTIALS_DIRECTORY" // set by systemd for `LoadCredential=` / `LoadCredentialEncrypted=`
+	credentialBotToken      = "bot_token"             // name of the systemd credential with the bot token
+)
+
+// provides secrets (eg. the bot token) from a backend
+type secretProvider interface {
+	// retrieves the value of a secret with `key` (eg. a path, or a name in the backend)
+	retrieve(key string) (string, error)
+}
+
+// adapts a function to a secret provider
+type secretProviderFunc func(key string) (string, error)
+
+func (f secretProviderFunc) retrieve(key string) (string, error) {
+	return f(key)
+}
+
+// a registered secret provider
+type registeredSecretProvider struct {
+	// returns the provider for `conf` (or nil if it is not configured)
+	provider func(conf config) secretProvider
+
+	// returns the key of the bot token in the provider for `conf` (or an empty string if it is not configured)
+	botTokenKey func(conf config) string
+}
+
+// registered secret providers, by their names
+var secretProviders = map[string]registeredSecretProvider{
+	secretProviderPlain: {
+		provider: func(conf config) secretProvider {
+			return secretProviderFunc(func(string) (string, error) { return conf.BotToken, nil })
+		},
+		botTokenKey: func(conf config) string {
+			if conf.BotToken != "" {
+				return "bot_token"
+			}
+			return ""
+		},
+	},
+	secretProviderEnv: {
+		provider: func(conf config) secretProvider {
+			return secretProviderFunc(retrieveEnv)
+		},
+		botTokenKey: func(conf config) string {
+			if conf.BotTokenEnv == "" && conf.SecretProvider == secretProviderEnv {
+				return defaultBotTokenEnv
+			}
+			return conf.BotTokenEnv
+		},
+	},
+	secretProviderFile: {
+		provider: func(conf config) secretProvider {
+			return secretProviderFunc(retrieveFile)
+		},
+		botTokenKey: func(conf config) string {
+			return conf.BotTokenFile
+		},
+	},
+	secretProviderCredential: {
+		provider: func(conf config) secretProvider {
+			if os.Getenv(envCredentialsDirectory) == "" {
+				return nil
+			}
+			return secretProviderFunc(retrieveCredential)
+		},
+		botTokenKey: func(conf config) string {
+			if dir := os.Getenv(envCredentialsDirectory); dir != "" {
+				if _, err := os.Stat(filepath.Join(dir, credentialBotToken)); err == nil {
+					return credentialBotToken
+				}
+			}
+			return ""
+		},
+	},
+	secretProviderInfisical: {
+		provider: func(conf config) secretProvider {
+			if conf.Infisical == nil {
+				return nil
+			}
+			return infisicalSessionFor(*conf.Infisical)
+		},
+		botTokenKey: func(conf config) string {
+			if conf.Infisical == nil {
+				return ""
+			}
+			return conf.Infisical.BotTokenKeyPath
+		},
+	},
+	secretProviderVault: {
+		provider: func(conf config) secretProvider {
+			if conf.Vault == nil {
+				return nil
+			}
+			return vaultSessionFor(*conf.Vault)
+		},
+		botTokenKey: func(conf config) string {
+			if conf.Vault == nil {
+				return ""
+			}
+			return conf.Vault.BotTokenKeyPath
+		},
+	},
+	secretProviderAWSSecretsManager: {
+		provider: func(conf config) secretProvider {
+			if conf.AWS == nil {
+				return nil
+			}
+			return secretProviderFunc(awsSessionFor(*conf.AWS).retrieveSecret)
+		},
+		botTokenKey: func(conf config) string {
+			if conf.AWS == nil {
+				return ""
+			}
+			return conf.AWS.BotTokenSecretID
+		},
+	},
+	secretProviderAWSSSM: {
+		provider: func(conf config) secretProvider {
+			if conf.AWS == nil {
+				return nil
+			}
+			return secretProviderFunc(awsSessionFor(*conf.AWS).retrieveParameter)
+		},
+		botTokenKey: func(conf config) string {
+			if conf.AWS == nil {
+				return ""
+			}
+			return conf.AWS.BotTokenParameter
+		},
+	},
+	secretProviderOnePassword: {
+		provider: func(conf config) secretProvider {
+			if conf.OnePassword == nil {
+				return nil
+			}
+			return newOnePasswordClient(*conf.OnePassword)
+		},
+		botTokenKey: func(conf config) string {
+			if conf.OnePassword == nil {
+				return ""
+			}
+			return conf.OnePassword.BotTokenReference
+		},
+	},
+}
+
+// order of secret providers to try, when `secret_provider` is not given
+var secretProvidersOrder = []string{
+	secretProviderPlain,
+	secretProviderEnv,
+	secretProviderFile,
+	secretProviderCredential,
+	secretProviderInfisical,
+	secretProviderVault,
+	secretProviderAWSSecretsManager,
+	secretProviderAWSSSM,
+	secretProviderOnePassword,
+}
+
+// secret providers which can be referenced in config values (eg. `${vault:path/to/secret#KEY}`)
+var secretReferenceProviders = []string{
+	secretProviderEnv,
+	secretProviderFile,
+	secretProviderCredential,
+	secretProviderInfisical,
+	secretProviderVault,
+	secretProviderAWSSecretsManager,
+	secretProviderAWSSSM,
+	secretProviderOnePassword,
+}
+
+// secret providers which are available without any settings (and can be referenced in settings of other providers)
+var localSecretProviders = []string{
+	secretProviderEnv,
+	secretProviderFile,
+	secretProviderCredential,
+}
+
+// keys of secret providers' settings in the config, where only references to local secrets are resolved
+var secretProviderConfigKeys = []string{"infisical", "vault", "aws", "onepassword"}
+
+// references to secrets in config values (eg. `${env:NAME}`)
+var secretReference = regexp.MustCompile(`\$\{(` + strings.Join(secretReferenceProviders, "|") + `):([^}]+)\}`)
+
+// retrieves the value of an environment variable with `name`
+func retrieveEnv(name string) (string, error) {
+	if value, exists := os.LookupEnv(name); exists {
+		return value, nil
+	}
+	return "", fmt.Errorf("environment variable '%s' is not set", name)
+}
+
+// retrieves the (trimmed) content of a file at `path`
+func retrieveFile(path string) (string, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(bs)), nil
+}
+
+// retrieves the (trimmed) content of a systemd credential with `name` in `$CREDENTIALS_DIRECTORY`
+func retrieveCredential(name string) (string, error) {
+	dir := os.Getenv(envCredentialsDirectory)
+	if dir == "" {
+		return "", fmt.Errorf("$%s is not set (not running with systemd credentials?)", envCredentialsDirectory)
+	}
+	return retrieveFile(filepath.Join(dir, name))
+}
+
+// retrieves the bot token from the secret provider of `conf`
+// (`secret_provider`, or the first configured one in `secretProvidersOrder`)
+func botTokenFromSecretProvider(conf config) (string, error) {
+	name := conf.SecretProvider
+	if name == "" {
+		for _, candidate := range secretProvidersOrder {
+			if secretProviders[candidate].botTokenKey(conf) != "" {
+				name = candidate
+				break
+			}
+		}
+		if name == "" {
+			return "", fmt.Errorf("no bot token or secret provider is configured")
+		}
+	}
+
+	registered, exists := secretProviders[name]
+	if !exists {
+		return "", fmt.Errorf("not a supported secret provider: '%s'", name)
+	}
+	provider, key := registered.provider(conf), registered.botTokenKey(conf)
+	if provider == nil || key == "" {
+		return "", fmt.Errorf("secret provider '%s' is not configured for the bot token", name)
+	}
+
+	return provider.retrieve(key)
+}
+
+// returns secret providers which can resolve references in the config values of `conf`
+func secretResolvers(conf config) map[string]secretProvider {
+	resolvers := map[string]secretProvider{}
+	for _, name := range secretReferenceProviders {
+		if provider := secretProviders[name].provider(conf); provider != nil {
+			resolvers[name] = provider
+		}
+	}
+	return resolvers
+}
+
+// returns secret providers which can resolve references in settings of other secret providers
+// (eg. `"client_secret": "${file:/run/secrets/infisical_client_secret}"`)
+func localSecretResolvers() map[string]secretProvider {
+	resolvers := map[string]secretProvider{}
+	for _, name := range localSecretProviders {
+		if provider := secretProviders[name].provider(config{}); provider != nil {
+			resolvers[name] = provider
+		}
+	}
+	return resolvers
+}
+
+// replaces references to secrets (eg. `${infisical:/path/to/KEY}`) in string values of given JSON config
+// with the values retrieved by `resolvers` (keyed by provider names).
+//
+// Only settings of the secret providers (eg. the `infisical` object) are resolved if `inProviderSettings` is true,
+// or only the others if false.
+func resolveSecretReferences(bs []byte, resolvers map[string]secretProvider, inProviderSettings bool) ([]byte, error) {
+	if !secretReference.Match(bs) {
+		return bs, nil
+	}
+
+	var values map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(bs))
+	decoder.UseNumber() // NOTE: keep large numbers (eg. channel ids) intact
+	if err := decoder.Decode(&values); err != nil {
+		return nil, err
+	}
+
+	for key, value := range values {
+		if slices.Contains(secretProviderConfigKeys, key) != inProviderSettings {
+			continue
+		}
+
+		resolved, err := resolveSecretValue(resolvers, value)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = resolved
+	}
+
+	return json.Marshal(values)
+}
+
+// resolves references to secrets in given (decoded) JSON value recursively
+func resolveSecretValue(resolvers map[string]secretProvider, value any) (resolved any, err error) {
+	switch v := value.(type) {
+	case string:
+		return replaceSecretReferences(resolvers, v)
+	case []any:
+		for i, elem := range v {
+			if v[i], err = resolveSecretValue(resolvers, elem); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	case map[string]any:
+		for key, elem := range v {
+			if v[key], err = resolveSecretValue(resolvers, elem); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	default:
+		return value, nil
+	}
+}
+
+// replaces references to secrets in given string with their values
+func replaceSecretReferences(resolvers map[string]secretProvider, str string) (replaced string, err error) {
+	replaced = secretReference.ReplaceAllStringFunc(str, func(ref string) string {
+		if err != nil {
+			return ref
+		}
+
+		matches := secretReference.FindStringSubmatch(ref)
+		name, key := matches[1], matches[2]
+
+		provider, exists := resolvers[name]
+		if !exists {
+			err = fmt.Errorf("config has a reference to %s secrets, but %s is not configured", name, name)
+			return ref
+		}
+
+		var value string
+		if value, err = provider.retrieve(key); err != nil {
+			return ref
+		}
+		return value
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return replaced, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// constants for sessions
+const (
+	sessionTimeout = 30 * time.Minute
+)
+
+// a multi-step conversation with a user in a chat
+type session struct {
+	Kind      string
+	Step      int
+	Source    string // d2 source built in the session so far
+	UpdatedAt time.Time
+}
+
+// handles a message in a session, and returns the updated session (or nil if the session is over)
+type sessionHandler func(ctx context.Context, b *tg.Bot, conf config, s session, message tg.Message) *session
+
+// handlers of sessions, by their kinds
+var sessionHandlers = map[string]sessionHandler{
+	sessionKindTutorial: handleTutorialMessage,
+}
+
+// key of a session
+type sessionKey struct {
+	chatID int64
+	userID int64
+}
+
+// active sessions of users
+type userSessions struct {
+	sync.Mutex
+
+	sessions map[sessionKey]session
+}
+
+// active sessions of this bot
+var sessions = &userSessions{
+	sessions: map[sessionKey]session{},
+}
+
+// start starts a new session of `kind` for `userID` in `chatID`, replacing the existing one.
+func (s *userSessions) start(chatID, userID int64, kind string) session {
+	s.Lock()
+	defer s.Unlock()
+
+	started := session{
+		Kind:      kind,
+		UpdatedAt: time.Now(),
+	}
+	s.sessions[sessionKey{chatID, userID}] = started
+
+	return started
+}
+
+// get returns the active session of `userID` in `chatID`, if it is not expired yet.
+func (s *userSessions) get(chatID, userID int64) (active session, exists bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	key := sessionKey{chatID, userID}
+	if active, exists = s.sessions[key]; exists && time.Since(active.UpdatedAt) > sessionTimeout {
+		delete(s.sessions, key)
+		return session{}, false
+	}
+	return active, exists
+}
+
+// save saves the updated session of `userID` in `chatID`.
+func (s *userSessions) save(chatID, userID int64, updated session) {
+	s.Lock()
+	defer s.Unlock()
+
+	updated.UpdatedAt = time.Now()
+	s.sessions[sessionKey{chatID, userID}] = updated
+}
+
+// end ends the session of `userID` in `chatID`, and returns true if there was one.
+func (s *userSessions) end(chatID, userID int64) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	key := sessionKey{chatID, userID}
+	if _, exists := s.sessions[key]; exists {
+		delete(s.sessions, key)
+		return true
+	}
+	return false
+}
+
+// handles given message with the active session of its sender, and returns true if it was handled.
+func handleSessionMessage(ctx context.Context, b *tg.Bot, conf config, message tg.Message) bool {
+	chatID, userID := message.Chat.ID, message.From.ID
+
+	active, exists := sessions.get(chatID, userID)
+	if !exists {
+		return false
+	}
+
+	handler, exists := sessionHandlers[active.Kind]
+	if !exists {
+		sessions.end(chatID, userID)
+		return false
+	}
+
+	if updated := handler(ctx, b, conf, active, message); updated != nil {
+		sessions.save(chatID, userID, *updated)
+	} else {
+		sessions.end(chatID, userID)
+	}
+	return true
+}
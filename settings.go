@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+
+	// d2
+	"oss.terrastruct.com/d2/d2themes/d2themescatalog"
+)
+
+// constants for user settings
+const (
+	commandSettings = "/settings"
+
+	settingFormat        = "format"
+	settingCompareEdits  = "compare_edits"
+	settingSourceCaption = "source_caption"
+	settingHighContrast  = "high_contrast"
+	settingPalette       = "palette"
+	settingTheme         = "theme"
+
+	messageSettingsUsage = "Usage:\n/settings\n/settings format <png|photo|svg|pdf|default>\n/settings compare_edits <on|off>\n/settings source_caption <on|off>\n/settings high_contrast <on|off>\n/settings palette <none|deuteranopia|protanopia>\n/settings theme [id]"
+	messageSettings      = "Your settings:\n\n• format: %s\n• theme: %s\n• compare_edits: %s\n• source_caption: %s\n• high_contrast: %s\n• palette: %s"
+	messageSettingSaved  = "Saved your setting: %s = %s"
+)
+
+// parses given string into a on/off value
+func parseOnOff(str string) (bool, error) {
+	switch strings.ToLower(str) {
+	case "on", "true", "yes":
+		return true, nil
+	case "off", "false", "no":
+		return false, nil
+	}
+	return false, fmt.Errorf("not a valid value: '%s' (should be on or off)", str)
+}
+
+// returns given bool value as on/off
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+// handle settings command
+func handleSettingsCommand(ctx context.Context, b *tg.Bot, conf config, update tg.Update, args string) {
+	if message, _ := update.GetMessage(); message != nil {
+		userID := message.From.ID
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		tokens := splitArgs(args)
+
+		// show current settings
+		if len(tokens) == 0 {
+			opts := renderOptionsForUser(conf, userID)
+			settings := storage.loadSettings(userID)
+
+			replyText(b, chatID, messageID, fmt.Sprintf(messageSettings, opts.Format, d2themescatalog.Find(opts.ThemeID).Name, onOff(settings.CompareEdits), onOff(settings.SourceCaption), onOff(settings.HighContrast), paletteName(opts.Palette)))
+			return
+		}
+
+		// pick a theme with previews
+		if len(tokens) == 1 && strings.EqualFold(tokens[0], settingTheme) {
+			replyThemePicker(ctx, b, chatID, messageID)
+			return
+		}
+
+		if len(tokens) != 2 {
+			replyError(b, chatID, messageID, messageSettingsUsage)
+			return
+		}
+
+		saveSetting(ctx, b, conf, userID, chatID, messageID, strings.ToLower(tokens[0]), tokens[1])
+	}
+}
+
+// parses `arg` for the setting with `key`,
+// and returns its value (for replies) and a function for updating user settings with it
+func parseSetting(conf config, key, arg string) (value string, update func(settings *userSettings), err error) {
+	switch key {
+	case settingFormat:
+		if strings.EqualFold(arg, formatDefault) {
+			value = fmt.Sprintf("%s (%s)", formatDefault, defaultOutputFormat(conf))
+			update = func(settings *userSettings) {
+				settings.Format = ""
+			}
+		} else {
+			var format outputFormat
+			if format, err = parseOutputFormat(arg); err != nil {
+				return "", nil, err
+			}
+			value = string(format)
+			update = func(settings *userSettings) {
+				settings.Format = format
+			}
+		}
+	case settingCompareEdits:
+		var on bool
+		if on, err = parseOnOff(arg); err != nil {
+			return "", nil, err
+		}
+		value = onOff(on)
+		update = func(settings *userSettings) {
+			settings.CompareEdits = on
+		}
+	case settingSourceCaption:
+		var on bool
+		if on, err = parseOnOff(arg); err != nil {
+			return "", nil, err
+		}
+		value = onOff(on)
+		update = func(settings *userSettings) {
+			settings.SourceCaption = on
+		}
+	case settingHighContrast:
+		var on bool
+		if on, err = parseOnOff(arg); err != nil {
+			return "", nil, err
+		}
+		value = onOff(on)
+		update = func(settings *userSettings) {
+			settings.HighContrast = on
+		}
+	case settingPalette:
+		var palette string
+		if palette, err = parsePalette(arg); err != nil {
+			return "", nil, err
+		}
+		value = palette
+		update = func(settings *userSettings) {
+			settings.Palette = palette
+		}
+	case settingTheme:
+		var themeID int64
+		if themeID, err = parseThemeID(arg); err != nil {
+			return "", nil, err
+		}
+		value = d2themescatalog.Find(themeID).Name
+		update = func(settings *userSettings) {
+			settings.ThemeID = toPointer(themeID)
+		}
+	default:
+		return "", nil, fmt.Errorf("%s", messageSettingsUsage)
+	}
+
+	return value, update, nil
+}
+
+// saves the setting with `key` (parsed from `arg`) of `userID`, and replies to `messageID` with the result
+func saveSetting(ctx context.Context, b *tg.Bot, conf config, userID, chatID, messageID int64, key, arg string) {
+	value, update, err := parseSetting(conf, key, arg)
+	if err != nil {
+		replyError(b, chatID, messageID, err.Error())
+		return
+	}
+
+	if err = storage.updateSettings(userID, update); err == nil {
+		replyText(b, chatID, messageID, fmt.Sprintf(messageSettingSaved, key, value))
+	} else {
+		logf(ctx, "failed to save settings: %s", err)
+
+		replyError(b, chatID, messageID, fmt.Sprintf("Failed to save settings: %s", err))
+	}
+}
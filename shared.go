@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// constants for the shared library
+const (
+	commandPublish = "/publish"
+	commandGet     = "/get"
+
+	messageNoSharedChannel = "Shared channel is not configured."
+	messagePublished       = "Published '%s' to the shared channel."
+	messageSharedEmpty     = "No diagram was published to the shared channel yet."
+	messageSharedHeader    = "Shared diagrams (render with /get <name>):\n\n"
+	messageNoSuchShared    = "There is no shared diagram named '%s'."
+)
+
+// handle publish command
+func handlePublishCommand(ctx context.Context, b *tg.Bot, conf config, update tg.Update, args string) {
+	if message, _ := update.GetMessage(); message != nil {
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		// publish as a telegraph article
+		if rest, found := strings.CutPrefix(strings.TrimLeft(args, " "), telegraphFlag); found {
+			handlePublishTelegraphCommand(ctx, b, conf, message, rest)
+			return
+		}
+
+		if conf.SharedChannelID == 0 {
+			replyError(b, chatID, messageID, messageNoSharedChannel)
+			return
+		}
+
+		name, source, err := nameAndSourceOfCommand(b, message, args)
+		if err != nil {
+			replyError(b, chatID, messageID, err.Error())
+			return
+		}
+
+		var publisher string
+		if message.From.Username != nil {
+			publisher = *message.From.Username
+		}
+
+		// post the source to the channel as a .d2 file
+		var sent tg.APIResponse[tg.Message]
+		if err = withNamedFile(name+".d2", []byte(source), func(file tg.InputFile) {
+			sent = b.SendDocument(
+				conf.SharedChannelID,
+				file,
+				tg.OptionsSendDocument{}.
+					SetCaption(fmt.Sprintf("#%s (by @%s)", name, publisher)))
+		}); err != nil {
+			logf(ctx, "failed to prepare shared diagram: %s", err)
+
+			replyError(b, chatID, messageID, fmt.Sprintf("Failed to publish diagram: %s", err))
+			return
+		}
+		if !sent.Ok {
+			logf(ctx, "failed to post shared diagram: %s", *sent.Description)
+
+			replyError(b, chatID, messageID, fmt.Sprintf("Failed to publish diagram: %s", *sent.Description))
+			return
+		}
+
+		if err = storage.saveShared(name, sharedEntry{
+			FileID:      sent.Result.Document.FileID,
+			MessageID:   sent.Result.MessageID,
+			PublishedBy: publisher,
+			PublishedAt: time.Now(),
+		}); err == nil {
+			replyText(b, chatID, messageID, fmt.Sprintf(messagePublished, name))
+		} else {
+			logf(ctx, "failed to index shared diagram: %s", err)
+
+			replyError(b, chatID, messageID, fmt.Sprintf("Failed to publish diagram: %s", err))
+		}
+	}
+}
+
+// handle get command
+func handleGetCommand(ctx context.Context, b *tg.Bot, conf config, update tg.Update, args string) {
+	if message, _ := update.GetMessage(); message != nil {
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		if conf.SharedChannelID == 0 {
+			replyError(b, chatID, messageID, messageNoSharedChannel)
+			return
+		}
+
+		// list shared diagrams if no name is given
+		name := strings.TrimSpace(args)
+		if name == "" {
+			names := storage.sharedNames()
+			if len(names) == 0 {
+				replyText(b, chatID, messageID, messageSharedEmpty)
+			} else {
+				replyText(b, chatID, messageID, messageSharedHeader+"• "+strings.Join(names, "\n• "))
+			}
+			return
+		}
+
+		if entry, exists := storage.loadShared(name); exists {
+			if content, err := fetchFile(b, entry.FileID); err == nil {
+				replyRendered(ctx, b, conf, message.From.ID, chatID, messageID, []string{string(content)})
+			} else {
+				logf(ctx, "failed to fetch shared diagram: %s", err)
+
+				replyError(b, chatID, messageID, err.Error())
+			}
+		} else {
+			replyError(b, chatID, messageID, fmt.Sprintf(messageNoSuchShared, name))
+		}
+	}
+}
+
+// handles a post in the shared channel, indexing .d2 files posted by others
+func handleSharedChannelPost(ctx context.Context, conf config, post tg.Message) {
+	if conf.SharedChannelID == 0 || post.Chat.ID != conf.SharedChannelID {
+		return
+	}
+
+	if post.HasDocument() && post.Document.FileName != nil && isD2File(*post.Document.FileName) {
+		name := strings.TrimSuffix(*post.Document.FileName, ".d2")
+		if !libraryName.MatchString(name) {
+			if conf.IsVerbose {
+				logf(ctx, "ignoring shared diagram with invalid name: %s", name)
+			}
+			return
+		}
+
+		if err := storage.saveShared(name, sharedEntry{
+			FileID:      post.Document.FileID,
+			MessageID:   post.MessageID,
+			PublishedAt: time.Now(),
+		}); err != nil {
+			logf(ctx, "failed to index shared diagram: %s", err)
+		}
+	}
+}
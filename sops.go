@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// constants for SOPS
+const (
+	envSOPSPath     = "SOPS_PATH" // path of the sops binary (= sops in $PATH for default)
+	defaultSOPSPath = "sops"
+)
+
+// checks if given (standardized) JSON bytes are of a SOPS-encrypted file
+func isSOPSEncrypted(bs []byte) bool {
+	var encrypted struct {
+		SOPS *struct {
+			Version string `json:"version"`
+			MAC     string `json:"mac"`
+		} `json:"sops"`
+	}
+	if err := json.Unmarshal(bs, &encrypted); err == nil && encrypted.SOPS != nil {
+		return encrypted.SOPS.Version != "" && encrypted.SOPS.MAC != ""
+	}
+	return false
+}
+
+// decrypts given SOPS-encrypted JSON bytes in memory with the sops binary
+// (which uses age, PGP, or KMS keys configured in its standard way, eg. `SOPS_AGE_KEY_FILE`)
+func decryptSOPS(bs []byte) ([]byte, error) {
+	sopsPath := os.Getenv(envSOPSPath)
+	if sopsPath == "" {
+		sopsPath = defaultSOPSPath
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(sopsPath, "--decrypt", "--input-type", "json", "--output-type", "json", "/dev/stdin")
+	cmd.Stdin = bytes.NewReader(bs)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("failed to decrypt config with sops: %s (%s)", err, msg)
+		}
+		return nil, fmt.Errorf("failed to decrypt config with sops: %s", err)
+	}
+
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// constants for fetching sources of renders
+const (
+	commandSource = "/source"
+
+	messageSourceUsage    = "Usage: reply to a rendered diagram with /source"
+	messageSourceNotFound = "The source of the replied message was not found (it is not a diagram rendered by this bot, or its source is not kept anymore)."
+)
+
+// handle source command
+func handleSourceCommand(b *tg.Bot, update tg.Update) {
+	if message, _ := update.GetMessage(); message != nil {
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		if message.ReplyToMessage == nil {
+			replyError(b, chatID, messageID, messageSourceUsage)
+			return
+		}
+
+		if source, exists := storage.loadRender(chatID, message.ReplyToMessage.MessageID); exists {
+			if len(source) <= maxSourceMessageLength {
+				sendSourceMessage(b, chatID, messageID, source)
+			}
+			sendSourceFile(b, chatID, messageID, source)
+		} else {
+			replyError(b, chatID, messageID, messageSourceNotFound)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// constants for statistics
+const (
+	commandStats      = "/stats"
+	commandPurgeCache = "/purgecache"
+
+	messageStats = `• uptime: %s
+• updates:
+%s
+• panics: %d
+• renders: %d in progress, %d in queue
+• render cache: %d entries (%.1f MB), %d hits, %d misses, %d evictions
+• disk cache: %d entries (%.1f MB), %d hits, %d misses, %d evictions
+• disk render cache: %d entries (%.1f MB), %d hits, %d misses, %d evictions
+• file ids: %d`
+	messageNoUpdates   = "  (none)"
+	messageCachePurged = "Purged %d cached render(s), %d cached conversion(s) and %d cached render(s) on disk, and %d file id(s)."
+)
+
+// handle stats command
+func handleStatsCommand(b *tg.Bot, update tg.Update) {
+	if message, _ := update.GetMessage(); message != nil {
+		metrics.Lock()
+		startedAt := metrics.StartedAt
+		kinds := []string{}
+		for kind := range metrics.Counts {
+			kinds = append(kinds, kind)
+		}
+		slices.Sort(kinds)
+		lines := []string{}
+		for _, kind := range kinds {
+			count := metrics.Counts[kind]
+			lines = append(lines, fmt.Sprintf("  %s: %d (avg. %s)", kind, count, (metrics.Durations[kind]/time.Duration(count)).Round(time.Millisecond)))
+		}
+		panics := metrics.Panics
+		metrics.Unlock()
+
+		if len(lines) == 0 {
+			lines = append(lines, messageNoUpdates)
+		}
+
+		cache, disk, diskRenders := renders.statistics(), conversions.statistics(), renderFiles.statistics()
+		running, waiting := renderWorkers.statistics()
+
+		replyText(b, message.Chat.ID, message.MessageID, fmt.Sprintf(messageStats,
+			time.Since(startedAt).Round(time.Second),
+			strings.Join(lines, "\n"),
+			panics,
+			running, waiting,
+			cache.Entries, float64(cache.Size)/1024/1024, cache.Hits, cache.Misses, cache.Evictions,
+			disk.Entries, float64(disk.Size)/1024/1024, disk.Hits, disk.Misses, disk.Evictions,
+			diskRenders.Entries, float64(diskRenders.Size)/1024/1024, diskRenders.Hits, diskRenders.Misses, diskRenders.Evictions,
+			storage.fileIDsCount()))
+	}
+}
+
+// handle purgecache command
+func handlePurgeCacheCommand(b *tg.Bot, update tg.Update) {
+	if message, _ := update.GetMessage(); message != nil {
+		replyText(b, message.Chat.ID, message.MessageID, fmt.Sprintf(messageCachePurged, renders.purge(), conversions.purge(), renderFiles.purge(), storage.purgeFileIDs()))
+	}
+}
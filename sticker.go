@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"strings"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// constants for stickers
+const (
+	commandSticker = "/sticker"
+
+	stickerArgAdd = "add"
+
+	stickerSize         = 512 // one side of a sticker should be 512 pixels, and the other one 512 pixels or less
+	minStickerScale     = 0.2 // diagrams which should be scaled down more than this are not readable as stickers
+	defaultStickerEmoji = "📊"
+	stickerSetTitle     = "D2 Diagrams"
+
+	messageStickerUsage    = "Usage: reply to a rendered diagram (or a D2 message) with /sticker [add] [emoji], or send it followed by a D2 source in the next lines.\n\n(With 'add', the sticker is also added to your own sticker set.)"
+	messageStickerTooLarge = "The diagram is too large to be readable as a sticker (it should be shrunk to %.0f%%): try a smaller one."
+	messageStickerAdded    = "Added the sticker to your sticker set: %s"
+)
+
+// handle sticker command
+func handleStickerCommand(ctx context.Context, b *tg.Bot, conf config, update tg.Update, args string) {
+	if message, _ := update.GetMessage(); message != nil {
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+		userID := message.From.ID
+
+		params, source, _ := strings.Cut(args, "\n")
+		add, emoji := false, defaultStickerEmoji
+		for _, param := range strings.Fields(params) {
+			if param == stickerArgAdd {
+				add = true
+			} else {
+				emoji = param
+			}
+		}
+
+		if source = strings.TrimSpace(source); source == "" && message.ReplyToMessage != nil {
+			sources, err := sourcesOfRepliedMessage(b, message.ReplyToMessage)
+			if err != nil {
+				replyError(b, chatID, messageID, err.Error())
+				return
+			}
+			if len(sources) > 1 {
+				replyError(b, chatID, messageID, messageMultipleSources)
+				return
+			}
+			source = sources[0]
+		}
+		if source == "" {
+			replyError(b, chatID, messageID, messageStickerUsage)
+			return
+		}
+
+		_ = b.SendChatAction(chatID, tg.ChatActionChooseSticker, nil)
+
+		bs, err := renderSticker(ctx, source, renderOptionsForChat(conf, userID, chatID))
+		if err != nil {
+			logf(ctx, "failed to render sticker: %s", err)
+
+			replyError(b, chatID, messageID, err.Error())
+			return
+		}
+
+		// NOTE: .png stickers should be uploaded first, as `sendSticker` accepts only .webp files
+		var uploaded tg.APIResponse[tg.File]
+		if err = withNamedFile("sticker.png", bs, func(file tg.InputFile) {
+			uploaded = b.UploadStickerFile(userID, file, tg.StickerFormatStatic)
+		}); err != nil {
+			logf(ctx, "failed to prepare sticker file: %s", err)
+
+			replyError(b, chatID, messageID, fmt.Sprintf("Failed to send sticker: %s", err))
+			return
+		}
+		if !uploaded.Ok {
+			logf(ctx, "failed to upload sticker file: %s", *uploaded.Description)
+
+			replyError(b, chatID, messageID, fmt.Sprintf("Failed to send sticker: %s", *uploaded.Description))
+			return
+		}
+		fileID := uploaded.Result.FileID
+
+		if sent := b.SendSticker(
+			chatID,
+			tg.NewInputFileFromFileID(fileID),
+			tg.OptionsSendSticker{}.
+				SetEmoji(emoji).
+				SetReplyParameters(tg.NewReplyParameters(messageID))); !sent.Ok {
+			logf(ctx, "failed to send sticker: %s", *sent.Description)
+
+			replyError(b, chatID, messageID, fmt.Sprintf("Failed to send sticker: %s", *sent.Description))
+			return
+		}
+
+		if add {
+			if link, err := addToStickerSet(b, userID, fileID, emoji); err == nil {
+				replyText(b, chatID, messageID, fmt.Sprintf(messageStickerAdded, link))
+			} else {
+				logf(ctx, "failed to add sticker to set: %s", err)
+
+				replyError(b, chatID, messageID, fmt.Sprintf("Failed to add sticker to your sticker set: %s", err))
+			}
+		}
+	}
+}
+
+// renders given d2 source into a .png file which fits sticker constraints
+// (scaled to be 512 pixels on its longer side, and cropped or padded to be exact)
+func renderSticker(ctx context.Context, source string, opts renderOptions) (bs []byte, err error) {
+	opts.Format = formatPNG
+	opts.Preset = ""
+	opts.Scale = 1.0
+
+	// measure the size of the root board
+	var boards []renderedBoard
+	if boards, err = renderBoards(ctx, source, opts); err != nil {
+		return nil, err
+	}
+	longer := float64(max(boards[0].width, boards[0].height)) * rasterScale
+	if opts.Scale = stickerSize / longer; opts.Scale < minStickerScale {
+		return nil, fmt.Errorf(messageStickerTooLarge, opts.Scale*100)
+	}
+
+	if bs, _, err = renderDiagram(ctx, source, opts, nil); err != nil {
+		return nil, err
+	}
+	return fitStickerSize(bs)
+}
+
+// crops or pads given .png file to be exactly 512 pixels on its longer side (and 512 pixels or less on the other)
+func fitStickerSize(bs []byte) ([]byte, error) {
+	img, err := png.Decode(bytes.NewReader(bs))
+	if err != nil {
+		return nil, err
+	}
+
+	width, height := min(img.Bounds().Dx(), stickerSize), min(img.Bounds().Dy(), stickerSize)
+	if width >= height {
+		width = stickerSize
+	} else {
+		height = stickerSize
+	}
+	if width == img.Bounds().Dx() && height == img.Bounds().Dy() {
+		return bs, nil
+	}
+
+	fitted := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(fitted, fitted.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	var buf bytes.Buffer
+	if err = png.Encode(&buf, fitted); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// adds an uploaded sticker file to the sticker set of `userID` (creating the set if it does not exist), and returns the link of the set
+func addToStickerSet(b *tg.Bot, userID int64, fileID, emoji string) (link string, err error) {
+	me := b.GetMe()
+	if !me.Ok || me.Result.Username == nil {
+		return "", fmt.Errorf("failed to get the username of this bot")
+	}
+
+	// NOTE: names of sticker sets created by bots should end with `_by_<bot username>`
+	name := fmt.Sprintf("d2_%d_by_%s", userID, *me.Result.Username)
+	sticker := tg.NewInputSticker(fileID, tg.StickerFormatStatic, []string{emoji})
+
+	var added tg.APIResponse[bool]
+	if set := b.GetStickerSet(name); set.Ok {
+		added = b.AddStickerToSet(userID, name, sticker, nil)
+	} else {
+		added = b.CreateNewStickerSet(userID, name, stickerSetTitle, []tg.InputSticker{sticker}, nil)
+	}
+	if !added.Ok {
+		return "", fmt.Errorf("%s", *added.Description)
+	}
+
+	return "https://t.me/addstickers/" + name, nil
+}
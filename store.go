@@ -0,0 +1,681 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// constants for the persistent store
+const (
+	defaultStoreFilename = "data.json"
+
+	maxRendersPerChat = 100  // number of rendered messages to remember in each chat
+	maxHistoryPerUser = 50   // number of render requests to remember for each user
+	maxFileIDs        = 1000 // number of file ids of sent renders to remember (for sending identical renders without uploading them again)
+)
+
+// persistent storage of this bot, saved as a JSON file
+type store struct {
+	sync.RWMutex
+
+	filepath string
+	index    searchIndex
+
+	Libraries   map[int64]map[string]libraryEntry `json:"libraries"`   // user id => name => entry
+	Shared      map[string]sharedEntry            `json:"shared"`      // name => entry
+	Settings    map[int64]userSettings            `json:"settings"`    // user id => settings
+	Chats       map[int64]chatSettings            `json:"chats"`       // chat id => settings
+	Renders     map[int64][]renderEntry           `json:"renders"`     // chat id => entries (oldest first)
+	History     map[int64][]historyEntry          `json:"history"`     // user id => entries (oldest first)
+	Roles       map[string]role                   `json:"roles"`       // username => role (assigned with /role, or invitations)
+	Invitations map[string]invitation             `json:"invitations"` // code => invitation
+	URLWatches  map[int64][]urlWatch              `json:"url_watches"` // chat id => watched urls
+	FileIDs     map[string]cachedFileID           `json:"file_ids"`    // hash of a rendered file => its file id
+}
+
+// a diagram saved in a user's library
+type libraryEntry struct {
+	Source    string    `json:"source"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// a diagram published to the shared channel
+type sharedEntry struct {
+	FileID      string    `json:"file_id"`
+	MessageID   int64     `json:"message_id"`
+	PublishedBy string    `json:"published_by,omitempty"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+// a file id of a render uploaded to telegram
+type cachedFileID struct {
+	FileID  string    `json:"file_id"`
+	SavedAt time.Time `json:"saved_at"`
+}
+
+// source of a diagram rendered and sent by this bot
+type renderEntry struct {
+	MessageID        int64     `json:"message_id"`
+	RequestMessageID int64     `json:"request_message_id,omitempty"` // id of the message which requested the render
+	Source           string    `json:"source"`
+	RenderedAt       time.Time `json:"rendered_at"`
+}
+
+// statuses of render requests
+const (
+	historyStatusSucceeded = "succeeded"
+	historyStatusFailed    = "failed"
+	historyStatusCanceled  = "canceled"
+)
+
+// a render request of a user
+type historyEntry struct {
+	ID          int64     `json:"id"`
+	Source      string    `json:"source"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	RequestedAt time.Time `json:"requested_at"`
+}
+
+// settings of a user
+type userSettings struct {
+	Format        outputFormat `json:"format,omitempty"`
+	CompareEdits  bool         `json:"compare_edits,omitempty"`  // reply before/after images on edits
+	SourceCaption bool         `json:"source_caption,omitempty"` // echo sources in captions of rendered files
+	ThemeID       *int64       `json:"theme_id,omitempty"`       // default theme (overrides `theme_id` of the config)
+	HighContrast  bool         `json:"high_contrast,omitempty"`  // render with high-contrast colors, thicker strokes, and larger fonts
+	Palette       string       `json:"palette,omitempty"`        // color-blind-safe palette (overrides `palette` of the config; `none` for disabling it)
+}
+
+// persistent storage of this bot (initialized on start)
+var storage *store
+
+// returns the filepath of the store file for given config
+func storeFilepath(conf config, confFilepath string) string {
+	if conf.StoreFilepath != "" {
+		return conf.StoreFilepath
+	}
+
+	// NOTE: a store file created by older versions (in the config file's directory, or the working directory) is kept being used
+	legacy := defaultStoreFilename
+	if confFilepath != "" && !isRemoteConfig(confFilepath) {
+		legacy = filepath.Join(filepath.Dir(confFilepath), defaultStoreFilename)
+	}
+	if _, err := os.Stat(legacy); err == nil && conf.DataDir == "" {
+		return legacy
+	}
+
+	return filepath.Join(dirs.data, defaultStoreFilename)
+}
+
+// openStore opens (or creates) a store at given `path`.
+func openStore(path string) (s *store, err error) {
+	s = &store{
+		filepath:    path,
+		Libraries:   map[int64]map[string]libraryEntry{},
+		Shared:      map[string]sharedEntry{},
+		Settings:    map[int64]userSettings{},
+		Chats:       map[int64]chatSettings{},
+		Renders:     map[int64][]renderEntry{},
+		History:     map[int64][]historyEntry{},
+		Roles:       map[string]role{},
+		Invitations: map[string]invitation{},
+		URLWatches:  map[int64][]urlWatch{},
+		FileIDs:     map[string]cachedFileID{},
+		index:       searchIndex{},
+	}
+
+	var bytes []byte
+	if bytes, err = os.ReadFile(path); err == nil {
+		if err = json.Unmarshal(bytes, s); err != nil {
+			return nil, fmt.Errorf("failed to read store file '%s': %w", path, err)
+		}
+		if s.Libraries == nil {
+			s.Libraries = map[int64]map[string]libraryEntry{}
+		}
+		if s.Shared == nil {
+			s.Shared = map[string]sharedEntry{}
+		}
+		if s.Settings == nil {
+			s.Settings = map[int64]userSettings{}
+		}
+		if s.Chats == nil {
+			s.Chats = map[int64]chatSettings{}
+		}
+		if s.Renders == nil {
+			s.Renders = map[int64][]renderEntry{}
+		}
+		if s.History == nil {
+			s.History = map[int64][]historyEntry{}
+		}
+		if s.Roles == nil {
+			s.Roles = map[string]role{}
+		}
+		if s.Invitations == nil {
+			s.Invitations = map[string]invitation{}
+		}
+		if s.URLWatches == nil {
+			s.URLWatches = map[int64][]urlWatch{}
+		}
+		if s.FileIDs == nil {
+			s.FileIDs = map[string]cachedFileID{}
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to open store file '%s': %w", path, err)
+	}
+
+	// index saved diagrams
+	for userID, entries := range s.Libraries {
+		for name, entry := range entries {
+			s.index.add(userID, name, entry.Source)
+		}
+	}
+
+	return s, nil
+}
+
+// saves the store to its file (should be called while locked)
+func (s *store) persist() (err error) {
+	var bytes []byte
+	if bytes, err = json.MarshalIndent(s, "", "  "); err == nil {
+		// write to a temporary file first, then replace the original one
+		tmp := s.filepath + ".tmp"
+		if err = os.WriteFile(tmp, bytes, 0600); err == nil {
+			err = os.Rename(tmp, s.filepath)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save store file '%s': %w", s.filepath, err)
+	}
+	return nil
+}
+
+// saveToLibrary saves given `source` with `name` to the library of `userID`.
+func (s *store) saveToLibrary(userID int64, name, source string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, exists := s.Libraries[userID]; !exists {
+		s.Libraries[userID] = map[string]libraryEntry{}
+	}
+	s.Libraries[userID][name] = libraryEntry{
+		Source:    source,
+		UpdatedAt: time.Now(),
+	}
+	s.index.remove(userID, name)
+	s.index.add(userID, name, source)
+
+	return s.persist()
+}
+
+// saveAllToLibrary saves given `sources` (keyed by names) to the library of `userID` at once.
+func (s *store) saveAllToLibrary(userID int64, sources map[string]string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, exists := s.Libraries[userID]; !exists {
+		s.Libraries[userID] = map[string]libraryEntry{}
+	}
+	now := time.Now()
+	for name, source := range sources {
+		s.Libraries[userID][name] = libraryEntry{
+			Source:    source,
+			UpdatedAt: now,
+		}
+		s.index.remove(userID, name)
+		s.index.add(userID, name, source)
+	}
+
+	return s.persist()
+}
+
+// loadFromLibrary loads a diagram with `name` from the library of `userID`.
+func (s *store) loadFromLibrary(userID int64, name string) (entry libraryEntry, exists bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	entry, exists = s.Libraries[userID][name]
+	return entry, exists
+}
+
+// deleteFromLibrary deletes a diagram with `name` from the library of `userID`.
+func (s *store) deleteFromLibrary(userID int64, name string) (deleted bool, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, exists := s.Libraries[userID][name]; !exists {
+		return false, nil
+	}
+	delete(s.Libraries[userID], name)
+	s.index.remove(userID, name)
+
+	return true, s.persist()
+}
+
+// libraryNames returns sorted names of diagrams in the library of `userID`.
+func (s *store) libraryNames(userID int64) (names []string) {
+	s.RLock()
+	defer s.RUnlock()
+
+	for name := range s.Libraries[userID] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// searchLibrary returns sorted names of diagrams in the library of `userID` which match given `query`.
+func (s *store) searchLibrary(userID int64, query string) []string {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.index.search(userID, query)
+}
+
+// saveShared indexes a diagram published to the shared channel with `name`.
+func (s *store) saveShared(name string, entry sharedEntry) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.Shared[name] = entry
+
+	return s.persist()
+}
+
+// loadShared loads the index of a shared diagram with `name`.
+func (s *store) loadShared(name string) (entry sharedEntry, exists bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	entry, exists = s.Shared[name]
+	return entry, exists
+}
+
+// sharedNames returns sorted names of shared diagrams.
+func (s *store) sharedNames() (names []string) {
+	s.RLock()
+	defer s.RUnlock()
+
+	for name := range s.Shared {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// loadSettings loads the settings of `userID`.
+func (s *store) loadSettings(userID int64) userSettings {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.Settings[userID]
+}
+
+// updateSettings updates the settings of `userID` with `fn`.
+func (s *store) updateSettings(userID int64, fn func(settings *userSettings)) error {
+	s.Lock()
+	defer s.Unlock()
+
+	settings := s.Settings[userID]
+	fn(&settings)
+	s.Settings[userID] = settings
+
+	return s.persist()
+}
+
+// loadChatSettings loads the settings of `chatID`.
+func (s *store) loadChatSettings(chatID int64) chatSettings {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.Chats[chatID]
+}
+
+// updateChatSettings updates the settings of `chatID` with `fn`.
+func (s *store) updateChatSettings(chatID int64, fn func(settings *chatSettings)) error {
+	s.Lock()
+	defer s.Unlock()
+
+	settings := s.Chats[chatID]
+	fn(&settings)
+	s.Chats[chatID] = settings
+
+	return s.persist()
+}
+
+// loadRole loads the role assigned to `username`.
+func (s *store) loadRole(username string) (r role, exists bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	r, exists = s.Roles[username]
+	return r, exists
+}
+
+// saveRole assigns role `r` to `username`.
+func (s *store) saveRole(username string, r role) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.Roles[username] = r
+
+	return s.persist()
+}
+
+// saveInvitation saves a one-time invitation with `code`, removing expired ones.
+func (s *store) saveInvitation(code string, inv invitation) error {
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now()
+	for c, existing := range s.Invitations {
+		if now.After(existing.ExpiresAt) {
+			delete(s.Invitations, c)
+		}
+	}
+	s.Invitations[code] = inv
+
+	return s.persist()
+}
+
+// redeemInvitation consumes the invitation with `code`, and assigns its role to `username`.
+func (s *store) redeemInvitation(code, username string) (r role, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	inv, exists := s.Invitations[code]
+	if !exists {
+		return roleNone, fmt.Errorf("no such invitation: %s", code)
+	}
+	delete(s.Invitations, code)
+	if time.Now().After(inv.ExpiresAt) {
+		return roleNone, fmt.Errorf("invitation %s expired at %s", code, inv.ExpiresAt.Format(time.RFC3339))
+	}
+
+	// NOTE: don't downgrade existing roles (eg. an admin who redeemed a readonly invitation)
+	if existing, exists := s.Roles[username]; !exists || existing == roleNone {
+		s.Roles[username] = inv.Role
+	} else {
+		inv.Role = existing
+	}
+
+	return inv.Role, s.persist()
+}
+
+// saveRenders remembers sources of messages rendered and sent to `chatID` (keyed by message ids)
+// as replies to `requestMessageID`, keeping only the latest `maxRendersPerChat` ones.
+func (s *store) saveRenders(chatID, requestMessageID int64, sources map[int64]string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now()
+	messageIDs := []int64{}
+	for messageID := range sources {
+		messageIDs = append(messageIDs, messageID)
+	}
+	sort.Slice(messageIDs, func(i, j int) bool { return messageIDs[i] < messageIDs[j] })
+	for _, messageID := range messageIDs {
+		s.Renders[chatID] = append(s.Renders[chatID], renderEntry{
+			MessageID:        messageID,
+			RequestMessageID: requestMessageID,
+			Source:           sources[messageID],
+			RenderedAt:       now,
+		})
+	}
+	if len(s.Renders[chatID]) > maxRendersPerChat {
+		s.Renders[chatID] = s.Renders[chatID][len(s.Renders[chatID])-maxRendersPerChat:]
+	}
+
+	return s.persist()
+}
+
+// loadRender loads the source of a message with `messageID` rendered and sent to `chatID`.
+func (s *store) loadRender(chatID, messageID int64) (source string, exists bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	for _, entry := range s.Renders[chatID] {
+		if entry.MessageID == messageID {
+			return entry.Source, true
+		}
+	}
+	return "", false
+}
+
+// addHistory appends given `entries` to the history of `userID` with new ids,
+// keeping only the latest `maxHistoryPerUser` ones.
+func (s *store) addHistory(userID int64, entries []historyEntry) error {
+	s.Lock()
+	defer s.Unlock()
+
+	var lastID int64
+	if history := s.History[userID]; len(history) > 0 {
+		lastID = history[len(history)-1].ID
+	}
+	for _, entry := range entries {
+		lastID++
+		entry.ID = lastID
+		s.History[userID] = append(s.History[userID], entry)
+	}
+	if len(s.History[userID]) > maxHistoryPerUser {
+		s.History[userID] = s.History[userID][len(s.History[userID])-maxHistoryPerUser:]
+	}
+
+	return s.persist()
+}
+
+// recentHistory returns up to `n` latest entries in the history of `userID` (newest first).
+func (s *store) recentHistory(userID int64, n int) (entries []historyEntry) {
+	s.RLock()
+	defer s.RUnlock()
+
+	history := s.History[userID]
+	for i := len(history) - 1; i >= 0 && len(entries) < n; i-- {
+		entries = append(entries, history[i])
+	}
+
+	return entries
+}
+
+// loadHistory loads an entry with `id` from the history of `userID`.
+func (s *store) loadHistory(userID, id int64) (entry historyEntry, exists bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	for _, entry := range s.History[userID] {
+		if entry.ID == id {
+			return entry, true
+		}
+	}
+	return historyEntry{}, false
+}
+
+// loadRenderOfRequest loads the source of the latest render requested by a message with `requestMessageID` in `chatID`.
+func (s *store) loadRenderOfRequest(chatID, requestMessageID int64) (source string, exists bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	renders := s.Renders[chatID]
+	for i := len(renders) - 1; i >= 0; i-- {
+		if renders[i].RequestMessageID == requestMessageID {
+			return renders[i].Source, true
+		}
+	}
+	return "", false
+}
+
+// rendersOfRequest returns the latest renders (one for each sent message, ordered by their message ids) requested by a message with `requestMessageID` in `chatID`.
+func (s *store) rendersOfRequest(chatID, requestMessageID int64) (entries []renderEntry) {
+	s.RLock()
+	defer s.RUnlock()
+
+	latest := map[int64]renderEntry{}
+	for _, entry := range s.Renders[chatID] {
+		if entry.RequestMessageID == requestMessageID {
+			latest[entry.MessageID] = entry
+		}
+	}
+	for _, entry := range latest {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].MessageID < entries[j].MessageID })
+
+	return entries
+}
+
+// updateRender replaces the source of a message with `messageID` rendered and sent to `chatID` (eg. after the message is edited with a new render).
+func (s *store) updateRender(chatID, messageID int64, source string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	for i, entry := range s.Renders[chatID] {
+		if entry.MessageID == messageID {
+			s.Renders[chatID][i].Source = source
+			s.Renders[chatID][i].RenderedAt = time.Now()
+		}
+	}
+
+	return s.persist()
+}
+
+// addURLWatch adds a watched url to `chatID`, and returns false if it is already watched there.
+func (s *store) addURLWatch(chatID int64, w urlWatch) (added bool, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, watch := range s.URLWatches[chatID] {
+		if watch.URL == w.URL {
+			return false, nil
+		}
+	}
+	s.URLWatches[chatID] = append(s.URLWatches[chatID], w)
+
+	return true, s.persist()
+}
+
+// removeURLWatch removes the watched url from `chatID`.
+func (s *store) removeURLWatch(chatID int64, url string) (removed bool, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	watches := s.URLWatches[chatID]
+	for i, watch := range watches {
+		if watch.URL == url {
+			if watches = slices.Delete(watches, i, i+1); len(watches) > 0 {
+				s.URLWatches[chatID] = watches
+			} else {
+				delete(s.URLWatches, chatID)
+			}
+			return true, s.persist()
+		}
+	}
+	return false, nil
+}
+
+// updateURLWatch updates the watched url in `chatID` with `fn`. (does nothing if it is not watched there)
+func (s *store) updateURLWatch(chatID int64, url string, fn func(w *urlWatch)) error {
+	s.Lock()
+	defer s.Unlock()
+
+	for i := range s.URLWatches[chatID] {
+		if s.URLWatches[chatID][i].URL == url {
+			fn(&s.URLWatches[chatID][i])
+			return s.persist()
+		}
+	}
+	return nil
+}
+
+// urlWatches returns a copy of watched urls in `chatID`.
+func (s *store) urlWatches(chatID int64) []urlWatch {
+	s.RLock()
+	defer s.RUnlock()
+
+	return slices.Clone(s.URLWatches[chatID])
+}
+
+// allURLWatches returns a copy of all watched urls (keyed by chat ids).
+func (s *store) allURLWatches() map[int64][]urlWatch {
+	s.RLock()
+	defer s.RUnlock()
+
+	watches := map[int64][]urlWatch{}
+	for chatID, ws := range s.URLWatches {
+		watches[chatID] = slices.Clone(ws)
+	}
+	return watches
+}
+
+// saveFileID saves the file id of a render uploaded to telegram with `key`,
+// keeping only the latest `maxFileIDs` ones.
+func (s *store) saveFileID(key, fileID string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.FileIDs[key] = cachedFileID{FileID: fileID, SavedAt: time.Now()}
+	for len(s.FileIDs) > maxFileIDs {
+		oldest := ""
+		for k, cached := range s.FileIDs {
+			if oldest == "" || cached.SavedAt.Before(s.FileIDs[oldest].SavedAt) {
+				oldest = k
+			}
+		}
+		delete(s.FileIDs, oldest)
+	}
+
+	return s.persist()
+}
+
+// loadFileID loads the file id of a render uploaded to telegram with `key`.
+func (s *store) loadFileID(key string) (fileID string, exists bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	cached, exists := s.FileIDs[key]
+	return cached.FileID, exists
+}
+
+// deleteFileID deletes the file id with `key` (eg. when it is not valid anymore).
+func (s *store) deleteFileID(key string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, exists := s.FileIDs[key]; !exists {
+		return nil
+	}
+	delete(s.FileIDs, key)
+
+	return s.persist()
+}
+
+// fileIDsCount returns the number of saved file ids.
+func (s *store) fileIDsCount() int {
+	s.RLock()
+	defer s.RUnlock()
+
+	return len(s.FileIDs)
+}
+
+// purgeFileIDs deletes all saved file ids, and returns the number of deleted ones.
+func (s *store) purgeFileIDs() (purged int) {
+	s.Lock()
+	defer s.Unlock()
+
+	purged = len(s.FileIDs)
+	s.FileIDs = map[string]cachedFileID{}
+	if err := s.persist(); err != nil {
+		log.Printf("failed to purge file ids: %s", err)
+	}
+
+	return purged
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// constants for suggestions on compile errors
+const (
+	maxSuggestionDistance = 2
+	maxSuggestions        = 3
+)
+
+var (
+	// quoted names in compile error messages (eg. `"db_primry"`)
+	quotedName = regexp.MustCompile(`"([^"\s]+)"`)
+
+	// identifiers in d2 sources
+	identifier = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_-]*`)
+
+	// a line which opens a block without a colon (eg. `server {`)
+	blockWithoutColon = regexp.MustCompile(`^\s*([A-Za-z0-9_.\- ]+?)\s*\{\s*$`)
+
+	// a line which sets a reserved keyword without a colon (eg. `shape cylinder`)
+	keywordWithoutColon = regexp.MustCompile(`^\s*((?:[A-Za-z0-9_\-]+\.)*(?:shape|label|icon|near|width|height|direction|class|tooltip|link))\s+([^:{}]+)$`)
+)
+
+// reserved keywords of d2, which are not suggested as names
+var d2Keywords = map[string]bool{
+	"shape": true, "label": true, "icon": true, "near": true, "width": true, "height": true,
+	"direction": true, "class": true, "classes": true, "tooltip": true, "link": true,
+	"style": true, "constraint": true, "vars": true, "layers": true, "scenarios": true, "steps": true,
+	"source-arrowhead": true, "target-arrowhead": true, "grid-rows": true, "grid-columns": true,
+	"true": true, "false": true, "null": true,
+}
+
+// returns heuristic suggestions for fixing the compile error `err` of `source`
+func suggestFixes(source string, err error) (suggestions []string) {
+	suggestions = append(suggestions, unbalancedBraces(source)...)
+	suggestions = append(suggestions, missingColons(source)...)
+	suggestions = append(suggestions, nearMatches(source, err.Error())...)
+
+	return suggestions
+}
+
+// returns the error message of `err` on `source`, with suggestions (if any)
+func explainError(source string, err error) string {
+	if suggestions := suggestFixes(source, err); len(suggestions) > 0 {
+		return fmt.Sprintf("%s\n\n💡 %s", err, strings.Join(suggestions, "\n💡 "))
+	}
+	return err.Error()
+}
+
+// checks if braces in `source` are balanced (ignoring ones in strings and comments)
+func unbalancedBraces(source string) (suggestions []string) {
+	opened := []int{} // line numbers of unclosed braces
+	for i, line := range strings.Split(source, "\n") {
+		var quote rune
+	chars:
+		for _, r := range line {
+			if quote != 0 {
+				if r == quote {
+					quote = 0
+				}
+				continue
+			}
+
+			switch r {
+			case '"', '\'':
+				quote = r
+			case '#':
+				break chars
+			case '{':
+				opened = append(opened, i+1)
+			case '}':
+				if len(opened) == 0 {
+					suggestions = append(suggestions, fmt.Sprintf("line %d has a closing brace `}` without its opening one.", i+1))
+				} else {
+					opened = opened[:len(opened)-1]
+				}
+			}
+		}
+	}
+	for _, line := range opened {
+		suggestions = append(suggestions, fmt.Sprintf("the opening brace `{` at line %d is never closed.", line))
+	}
+	return suggestions
+}
+
+// finds lines which seem to miss colons
+func missingColons(source string) (suggestions []string) {
+	for i, line := range strings.Split(source, "\n") {
+		if strings.Contains(line, ":") || strings.Contains(line, "->") || strings.Contains(line, "<-") || strings.Contains(line, "--") {
+			continue
+		}
+
+		if matches := blockWithoutColon.FindStringSubmatch(line); matches != nil {
+			suggestions = append(suggestions, fmt.Sprintf("line %d misses a colon: did you mean `%s: {`?", i+1, matches[1]))
+		} else if matches := keywordWithoutColon.FindStringSubmatch(line); matches != nil {
+			suggestions = append(suggestions, fmt.Sprintf("line %d misses a colon: did you mean `%s: %s`?", i+1, matches[1], strings.TrimSpace(matches[2])))
+		}
+	}
+	return suggestions
+}
+
+// finds names in `source` which are similar to the ones quoted in `message`
+func nearMatches(source, message string) (suggestions []string) {
+	names := map[string]bool{}
+	for _, name := range identifier.FindAllString(source, -1) {
+		if !d2Keywords[name] {
+			names[name] = true
+		}
+	}
+
+	for _, match := range quotedName.FindAllStringSubmatch(message, -1) {
+		// (eg. `"a.b.db_primry"` => `db_primry`)
+		path := strings.Split(match[1], ".")
+		unknown := path[len(path)-1]
+
+		candidates := []string{}
+		for name := range names {
+			if name != unknown && levenshtein(strings.ToLower(name), strings.ToLower(unknown)) <= maxSuggestionDistance {
+				candidates = append(candidates, name)
+			}
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			return levenshtein(candidates[i], unknown) < levenshtein(candidates[j], unknown)
+		})
+		if len(candidates) > maxSuggestions {
+			candidates = candidates[:maxSuggestions]
+		}
+
+		if len(candidates) > 0 {
+			suggestions = append(suggestions, fmt.Sprintf("did you mean `%s` instead of `%s`?", strings.Join(candidates, "` or `"), unknown))
+		}
+	}
+	return suggestions
+}
+
+// returns the levenshtein distance between given strings
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
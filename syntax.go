@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// constants for syntax quick-references
+const (
+	commandSyntax = "/syntax"
+
+	messageSyntaxHeader  = "D2 syntax quick-references (see more at https://d2lang.com/tour/intro):\n\n"
+	messageNoSuchTopic   = "There is no quick-reference for '%s'."
+	messageSyntaxExample = "<b>%s</b>\n\n%s\n\n<pre><code class=\"language-d2\">%s</code></pre>"
+)
+
+// a quick-reference of d2 syntax
+type syntaxTopic struct {
+	Name        string
+	Description string
+	Example     string
+}
+
+// quick-references of d2 syntax, in order
+var syntaxTopics = []syntaxTopic{
+	{
+		Name: "shapes",
+		Description: `• declare a shape with its key: <code>server</code>
+• give it a label: <code>server: Web Server</code>
+• change its shape: <code>server.shape: cylinder</code>
+• (eg. rectangle, square, circle, oval, cylinder, queue, cloud, person, hexagon, ...)`,
+		Example: `server: Web Server
+db: Database {
+  shape: cylinder
+}
+user: {
+  shape: person
+}`,
+	},
+	{
+		Name: "connections",
+		Description: `• connect shapes with <code>--</code>, <code>-&gt;</code>, <code>&lt;-</code>, or <code>&lt;-&gt;</code>
+• label a connection: <code>a -&gt; b: calls</code>
+• chain connections: <code>a -&gt; b -&gt; c</code>
+• style it: <code>(a -&gt; b)[0].style.stroke-dash: 3</code>`,
+		Example: `client -> api: request
+api -> db: query
+db -> api: rows {
+  style.stroke-dash: 3
+}
+api <-> cache`,
+	},
+	{
+		Name: "containers",
+		Description: `• nest shapes with dots: <code>cloud.vpc.server</code>
+• or with blocks: <code>cloud: { vpc: { server } }</code>
+• connect across containers: <code>cloud.vpc.server -&gt; office.pc</code>
+• refer to the parent with <code>_</code>: <code>_.office</code>`,
+		Example: `cloud: {
+  vpc: {
+    server
+    db
+    server -> db
+  }
+}
+office.pc -> cloud.vpc.server`,
+	},
+	{
+		Name: "sql_table",
+		Description: `• set <code>shape: sql_table</code> to a shape
+• declare columns as <code>name: type</code>
+• add constraints: <code>id: int {constraint: primary_key}</code>
+• connect columns to draw foreign keys: <code>posts.user_id -&gt; users.id</code>`,
+		Example: `users: {
+  shape: sql_table
+  id: int {constraint: primary_key}
+  name: varchar
+}
+posts: {
+  shape: sql_table
+  id: int {constraint: primary_key}
+  user_id: int {constraint: foreign_key}
+}
+posts.user_id -> users.id`,
+	},
+	{
+		Name: "classes",
+		Description: `• set <code>shape: class</code> to a shape
+• declare fields as <code>name: type</code>, methods as <code>method(args): return</code>
+• prefix with <code>+</code> (public), <code>-</code> (private), or <code>#</code> (protected)`,
+		Example: `Animal: {
+  shape: class
+  +name: string
+  -age: int
+  +speak(): string
+}
+Dog: {
+  shape: class
+  +fetch(): void
+}
+Dog -> Animal: extends`,
+	},
+	{
+		Name: "sequence",
+		Description: `• set <code>shape: sequence_diagram</code> to a container
+• actors are declared in order of appearance
+• connections become messages, in order
+• group messages with nested containers`,
+		Example: `shape: sequence_diagram
+alice: Alice
+bob: Bob
+alice -> bob: Hello
+bob -> alice: Hi!
+handshake: {
+  alice -> bob: How are you?
+  bob -> alice: Fine.
+}`,
+	},
+}
+
+// returns the quick-reference of given `name`
+func findSyntaxTopic(name string) (topic syntaxTopic, exists bool) {
+	for _, topic := range syntaxTopics {
+		if strings.EqualFold(topic.Name, name) {
+			return topic, true
+		}
+	}
+	return syntaxTopic{}, false
+}
+
+// handle syntax command
+func handleSyntaxCommand(ctx context.Context, b *tg.Bot, conf config, update tg.Update, args string) {
+	if message, _ := update.GetMessage(); message != nil {
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		// list topics if no topic is given
+		name := strings.TrimSpace(args)
+		if name == "" {
+			lines := []string{}
+			for _, topic := range syntaxTopics {
+				lines = append(lines, fmt.Sprintf("• %s %s", commandSyntax, topic.Name))
+			}
+
+			if sent := b.SendMessage(
+				chatID,
+				messageSyntaxHeader+strings.Join(lines, "\n"),
+				tg.OptionsSendMessage{}.
+					SetLinkPreviewOptions(tg.LinkPreviewOptions{IsDisabled: toPointer(true)}).
+					SetReplyParameters(tg.NewReplyParameters(messageID))); !sent.Ok {
+				logf(ctx, "failed to send syntax topics: %s", *sent.Description)
+			}
+			return
+		}
+
+		topic, exists := findSyntaxTopic(name)
+		if !exists {
+			replyError(b, chatID, messageID, fmt.Sprintf(messageNoSuchTopic, name))
+			return
+		}
+
+		if sent := b.SendMessage(
+			chatID,
+			fmt.Sprintf(messageSyntaxExample, topic.Name, topic.Description, html.EscapeString(topic.Example)),
+			tg.OptionsSendMessage{}.
+				SetParseMode(tg.ParseModeHTML).
+				SetReplyParameters(tg.NewReplyParameters(messageID))); !sent.Ok {
+			logf(ctx, "failed to send syntax quick-reference: %s", *sent.Description)
+			return
+		}
+
+		// render the example
+		replyRendered(ctx, b, conf, message.From.ID, chatID, messageID, []string{topic.Example})
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os/exec"
+	"sync"
+	"time"
+
+	// d2
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2plugin"
+)
+
+// constants for the TALA layout engine
+const (
+	layoutTALA = "tala"
+
+	talaPluginBinary  = "d2plugin-tala" // NOTE: d2 finds layout plugins in $PATH with this prefix
+	talaLookupTimeout = 10 * time.Second
+)
+
+var errTALANotInstalled = errors.New("TALA layout engine is not installed in this bot (`d2plugin-tala` is not in $PATH)")
+
+// TALA layout plugin, looked up in $PATH on its first use (nil if it is not installed)
+//
+// https://github.com/terrastruct/tala
+var talaPlugin = sync.OnceValue(func() d2plugin.Plugin {
+	if _, err := exec.LookPath(talaPluginBinary); err != nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), talaLookupTimeout)
+	defer cancel()
+
+	plugins, err := d2plugin.ListPlugins(ctx)
+	if err == nil {
+		var plugin d2plugin.Plugin
+		if plugin, err = d2plugin.FindPlugin(ctx, plugins, layoutTALA); err == nil {
+			return plugin
+		}
+	}
+	log.Printf("failed to load TALA plugin: %s", err)
+
+	return nil
+})
+
+// lays out given graph with TALA
+func layoutWithTALA(ctx context.Context, graph *d2graph.Graph) error {
+	if plugin := talaPlugin(); plugin != nil {
+		return plugin.Layout(ctx, graph)
+	}
+	return errTALANotInstalled
+}
+
+// post-processes given svg laid out with TALA (eg. adding a watermark for unlicensed copies)
+func postProcessTALA(ctx context.Context, svg []byte) ([]byte, error) {
+	if plugin := talaPlugin(); plugin != nil {
+		return plugin.PostProcess(ctx, svg)
+	}
+	return nil, errTALANotInstalled
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// constants for telegraph
+const (
+	telegraphFlag = "--telegraph"
+
+	telegraphAPIURL        = "https://api.telegra.ph/"
+	telegraphUploadURL     = "https://telegra.ph/upload"
+	telegraphFileURLPrefix = "https://telegra.ph"
+	telegraphTimeout       = 30 * time.Second
+
+	defaultTelegraphShortName  = "d2bot"
+	defaultTelegraphAuthorName = "D2 Bot"
+	defaultTelegraphTitle      = "Diagram"
+
+	maxTelegraphTitleLength  = 256
+	maxTelegraphSourceLength = 32 * 1024 // NOTE: the whole content of a page should be smaller than 64KB
+
+	messageTelegraphUsage     = "Usage: reply to a D2 message with /publish --telegraph [title], or send it followed by D2 sources in the next lines."
+	messageTelegraphPublished = "Published as a Telegraph article: %s"
+	messageTelegraphTruncated = "... (truncated)"
+)
+
+// telegraph settings
+type telegraphConfig struct {
+	AccessToken string `json:"access_token,omitempty"` // access token of a telegraph account (a new account is created on the first publish if empty)
+	AuthorName  string `json:"author_name,omitempty"`  // (= "D2 Bot" for default)
+	AuthorURL   string `json:"author_url,omitempty"`
+}
+
+// a node of telegraph contents (a string, or an element)
+type telegraphNode struct {
+	Tag      string            `json:"tag"`
+	Attrs    map[string]string `json:"attrs,omitempty"`
+	Children []any             `json:"children,omitempty"`
+}
+
+// a response of the telegraph api
+type telegraphResponse[T any] struct {
+	Ok     bool   `json:"ok"`
+	Result T      `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// access token of the telegraph account created on the first publish (when not configured)
+var telegraphAccount struct {
+	sync.Mutex
+	accessToken string
+}
+
+// http client for telegraph
+var telegraphClient = &http.Client{Timeout: telegraphTimeout}
+
+// handle publish command with `--telegraph`
+func handlePublishTelegraphCommand(ctx context.Context, b *tg.Bot, conf config, message *tg.Message, args string) {
+	chatID := message.Chat.ID
+	messageID := message.MessageID
+
+	title, source, _ := strings.Cut(args, "\n")
+	title = strings.TrimSpace(title)
+	if title == "" {
+		title = defaultTelegraphTitle
+	}
+	if len([]rune(title)) > maxTelegraphTitleLength {
+		title = string([]rune(title)[:maxTelegraphTitleLength])
+	}
+
+	var sources []string
+	if source = strings.TrimSpace(source); source != "" {
+		sources = []string{source}
+	} else if message.ReplyToMessage != nil {
+		var err error
+		if sources, err = sourcesOfRepliedMessage(b, message.ReplyToMessage); err != nil {
+			replyError(b, chatID, messageID, err.Error())
+			return
+		}
+	}
+	if len(sources) == 0 {
+		replyError(b, chatID, messageID, messageTelegraphUsage)
+		return
+	}
+
+	_ = b.SendChatAction(chatID, tg.ChatActionUploadPhoto, nil)
+
+	opts := renderOptionsForChat(conf, message.From.ID, chatID)
+	if pageURL, err := publishToTelegraph(ctx, conf, title, sources, opts); err == nil {
+		replyText(b, chatID, messageID, fmt.Sprintf(messageTelegraphPublished, pageURL))
+	} else {
+		logf(ctx, "failed to publish to telegraph: %s", err)
+
+		replyError(b, chatID, messageID, fmt.Sprintf("Failed to publish to Telegraph: %s", err))
+	}
+}
+
+// renders all boards of given d2 sources, uploads them with the sources to a new telegraph page, and returns its url
+func publishToTelegraph(ctx context.Context, conf config, title string, sources []string, opts renderOptions) (pageURL string, err error) {
+	opts.Format = formatPNG
+
+	content := []telegraphNode{}
+	for i, source := range sources {
+		if len(sources) > 1 {
+			content = append(content, telegraphNode{Tag: "h3", Children: []any{fmt.Sprintf("%d/%d", i+1, len(sources))}})
+		}
+
+		var boards []renderedBoard
+		if boards, err = renderBoards(ctx, source, opts); err != nil {
+			return "", err
+		}
+		for _, board := range boards {
+			var png []byte
+			if png, err = convertSVG(ctx, board.svg); err != nil {
+				return "", err
+			}
+
+			var src string
+			if src, err = uploadToTelegraph(ctx, png); err != nil {
+				return "", err
+			}
+
+			figure := telegraphNode{Tag: "figure", Children: []any{telegraphNode{Tag: "img", Attrs: map[string]string{"src": src}}}}
+			if board.name != "" {
+				figure.Children = append(figure.Children, telegraphNode{Tag: "figcaption", Children: []any{board.name}})
+			}
+			content = append(content, figure)
+		}
+
+		if len(source) > maxTelegraphSourceLength {
+			source = strings.ToValidUTF8(source[:maxTelegraphSourceLength], "") + "\n" + messageTelegraphTruncated
+		}
+		content = append(content, telegraphNode{Tag: "pre", Children: []any{source}})
+	}
+
+	return createTelegraphPage(ctx, conf, title, content)
+}
+
+// returns the access token of the configured telegraph account, or creates a new account for it
+func telegraphAccessToken(ctx context.Context, conf config) (token string, err error) {
+	if conf.Telegraph != nil && conf.Telegraph.AccessToken != "" {
+		return conf.Telegraph.AccessToken, nil
+	}
+
+	telegraphAccount.Lock()
+	defer telegraphAccount.Unlock()
+
+	if telegraphAccount.accessToken == "" {
+		var created telegraphResponse[struct {
+			AccessToken string `json:"access_token"`
+		}]
+		if err = callTelegraphAPI(ctx, "createAccount", url.Values{
+			"short_name":  {defaultTelegraphShortName},
+			"author_name": {telegraphAuthorName(conf)},
+		}, &created); err != nil {
+			return "", err
+		}
+		telegraphAccount.accessToken = created.Result.AccessToken
+	}
+	return telegraphAccount.accessToken, nil
+}
+
+// returns the author name of telegraph pages
+func telegraphAuthorName(conf config) string {
+	if conf.Telegraph != nil && conf.Telegraph.AuthorName != "" {
+		return conf.Telegraph.AuthorName
+	}
+	return defaultTelegraphAuthorName
+}
+
+// creates a telegraph page with given title and content, and returns its url
+func createTelegraphPage(ctx context.Context, conf config, title string, content []telegraphNode) (pageURL string, err error) {
+	var token string
+	if token, err = telegraphAccessToken(ctx, conf); err != nil {
+		return "", err
+	}
+
+	var bs []byte
+	if bs, err = json.Marshal(content); err != nil {
+		return "", err
+	}
+
+	params := url.Values{
+		"access_token": {token},
+		"title":        {title},
+		"author_name":  {telegraphAuthorName(conf)},
+		"content":      {string(bs)},
+	}
+	if conf.Telegraph != nil && conf.Telegraph.AuthorURL != "" {
+		params.Set("author_url", conf.Telegraph.AuthorURL)
+	}
+
+	var created telegraphResponse[struct {
+		URL string `json:"url"`
+	}]
+	if err = callTelegraphAPI(ctx, "createPage", params, &created); err != nil {
+		return "", err
+	}
+	return created.Result.URL, nil
+}
+
+// calls a telegraph api `method` with `params`, and decodes its response into `result`
+func callTelegraphAPI[T any](ctx context.Context, method string, params url.Values, result *telegraphResponse[T]) (err error) {
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodPost, telegraphAPIURL+method, strings.NewReader(params.Encode())); err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var res *http.Response
+	if res, err = telegraphClient.Do(req); err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if err = json.NewDecoder(res.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to decode telegraph response: %w", err)
+	}
+	if !result.Ok {
+		return fmt.Errorf("telegraph responded with an error: %s", result.Error)
+	}
+	return nil
+}
+
+// uploads a .png file to telegraph, and returns its url
+func uploadToTelegraph(ctx context.Context, png []byte) (src string, err error) {
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	var part io.Writer
+	if part, err = w.CreateFormFile("file", "diagram.png"); err != nil {
+		return "", err
+	}
+	if _, err = part.Write(png); err != nil {
+		return "", err
+	}
+	if err = w.Close(); err != nil {
+		return "", err
+	}
+
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodPost, telegraphUploadURL, body); err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+
+	var res *http.Response
+	if res, err = telegraphClient.Do(req); err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	// NOTE: responds with `[{"src": "/file/..."}]` on success, or `{"error": "..."}` on failure
+	var bs []byte
+	if bs, err = io.ReadAll(res.Body); err != nil {
+		return "", err
+	}
+	var uploaded []struct {
+		Src string `json:"src"`
+	}
+	if err = json.Unmarshal(bs, &uploaded); err != nil || len(uploaded) == 0 {
+		var failed struct {
+			Error string `json:"error"`
+		}
+		_ = json.Unmarshal(bs, &failed)
+		return "", fmt.Errorf("failed to upload image to telegraph (%d): %s", res.StatusCode, failed.Error)
+	}
+	return telegraphFileURLPrefix + uploaded[0].Src, nil
+}
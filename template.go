@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// constants for templates
+const (
+	commandTemplate = "/template"
+
+	templateActionList = "list"
+	templateActionUse  = "use"
+
+	messageTemplateUsage      = "Usage:\n/template list\n/template use <name> key1=value1 key2=\"value 2\" ..."
+	messageNoTemplates        = "There is no template configured."
+	messageTemplatesHeader    = "Available templates:\n\n"
+	messageNoSuchTemplate     = "There is no template named '%s'."
+	messageMissingPlaceholder = "Missing values for template '%s': %s"
+)
+
+// a d2 template with `${placeholders}`
+type templateConfig struct {
+	Parameters []string `json:"parameters"` // names of placeholders (others, eg. d2 vars like `${color}`, are left as they are)
+	Source     string   `json:"source"`
+}
+
+// placeholders in templates: `${name}`
+var templatePlaceholder = regexp.MustCompile(`\$\{([A-Za-z0-9_]+)\}`)
+
+// fills placeholders of declared parameters in given `template` with `values`.
+//
+// Returns names of parameters without values, if any.
+func fillTemplate(template templateConfig, values map[string]string) (filled string, missing []string) {
+	for _, name := range template.Parameters {
+		if _, exists := values[name]; !exists {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return "", missing
+	}
+
+	return templatePlaceholder.ReplaceAllStringFunc(template.Source, func(placeholder string) string {
+		name := templatePlaceholder.FindStringSubmatch(placeholder)[1]
+		if slices.Contains(template.Parameters, name) {
+			return values[name]
+		}
+		return placeholder
+	}), nil
+}
+
+// handle template command
+func handleTemplateCommand(ctx context.Context, b *tg.Bot, conf config, update tg.Update, args string) {
+	if message, _ := update.GetMessage(); message != nil {
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		tokens := splitArgs(args)
+		if len(tokens) == 0 {
+			replyError(b, chatID, messageID, messageTemplateUsage)
+			return
+		}
+
+		switch tokens[0] {
+		case templateActionList:
+			if len(conf.Templates) == 0 {
+				replyText(b, chatID, messageID, messageNoTemplates)
+				return
+			}
+
+			names := []string{}
+			for name := range conf.Templates {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			lines := []string{}
+			for _, name := range names {
+				if parameters := conf.Templates[name].Parameters; len(parameters) > 0 {
+					lines = append(lines, fmt.Sprintf("• %s: %s", name, strings.Join(parameters, ", ")))
+				} else {
+					lines = append(lines, fmt.Sprintf("• %s", name))
+				}
+			}
+
+			replyText(b, chatID, messageID, messageTemplatesHeader+strings.Join(lines, "\n"))
+		case templateActionUse:
+			if len(tokens) < 2 {
+				replyError(b, chatID, messageID, messageTemplateUsage)
+				return
+			}
+
+			name := tokens[1]
+			template, exists := conf.Templates[name]
+			if !exists {
+				replyError(b, chatID, messageID, fmt.Sprintf(messageNoSuchTemplate, name))
+				return
+			}
+
+			values, _ := parseKeyValues(tokens[2:])
+			if source, missing := fillTemplate(template, values); len(missing) > 0 {
+				replyError(b, chatID, messageID, fmt.Sprintf(messageMissingPlaceholder, name, strings.Join(missing, ", ")))
+			} else {
+				replyRendered(ctx, b, conf, message.From.ID, chatID, messageID, []string{source})
+			}
+		default:
+			replyError(b, chatID, messageID, messageTemplateUsage)
+		}
+	}
+}
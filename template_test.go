@@ -0,0 +1,28 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// tests filling templates which have d2 vars in them
+func TestFillTemplate(t *testing.T) {
+	template := templateConfig{
+		Parameters: []string{"service", "db"},
+		Source:     "vars: {\n  color: blue\n}\n${service}.style.fill: ${color}\n${service} -> ${db}: query",
+	}
+
+	// d2 vars (not declared as parameters) are left as they are
+	filled, missing := fillTemplate(template, map[string]string{"service": "api", "db": "users", "color": "red"})
+	if len(missing) > 0 {
+		t.Fatalf("unexpected missing parameters: %v", missing)
+	}
+	if expected := "vars: {\n  color: blue\n}\napi.style.fill: ${color}\napi -> users: query"; filled != expected {
+		t.Errorf("expected '%s', got '%s'", expected, filled)
+	}
+
+	// declared parameters without values are reported
+	if _, missing = fillTemplate(template, map[string]string{"service": "api"}); !slices.Equal(missing, []string{"db"}) {
+		t.Errorf("expected missing parameters [db], got %v", missing)
+	}
+}
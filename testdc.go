@@ -0,0 +1,28 @@
+package main
+
+import (
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// constants for the test environment of telegram
+const (
+	// NOTE: bot api of the test environment is served at `/bot<token>/test/METHOD` (and files at `/file/bot<token>/test/PATH`)
+	testDCTokenSuffix = "/test"
+)
+
+// whether to use the test environment of telegram (set with `--test-dc`)
+var useTestDC bool
+
+// returns the token for requests to bot api (of the test environment, with `--test-dc`)
+func apiToken(token string) string {
+	if useTestDC {
+		return token + testDCTokenSuffix
+	}
+	return token
+}
+
+// returns a new client of bot api with given token (of the test environment, with `--test-dc`)
+func newClient(token string) *tg.Bot {
+	return tg.NewClient(apiToken(token))
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+
+	// d2
+	"oss.terrastruct.com/d2/d2themes"
+	"oss.terrastruct.com/d2/d2themes/d2themescatalog"
+)
+
+// constants for restyling renders
+const (
+	commandTheme = "/theme"
+
+	messageThemeUsage   = "Usage: reply to a rendered diagram (or a D2 message) with /theme <id>"
+	messageThemesHeader = "Available themes (reply to a rendered diagram with /theme <id>):\n\n"
+
+	callbackPrefixTheme = "theme:"
+	themeActionPreview  = "preview"
+	themeActionDefault  = "default"
+
+	themePickerColumns = 2
+
+	messageThemePicker        = "Pick a theme to preview it with your last diagram (or a sample):"
+	messageThemePreviewing    = "Previewing theme: %s"
+	messageThemeDefaultSaved  = "Saved your default theme: %s"
+	messageThemeSetAsDefault  = "✅ Set %s as default"
+	messageThemeSettingFailed = "Failed to save your default theme."
+
+	// a sample diagram for previewing themes
+	themeSampleSource = `direction: right
+user: User {shape: person}
+bot: Bot {
+  renderer: Renderer
+  store: Store {shape: cylinder}
+  renderer -> store: save
+}
+user -> bot.renderer: D2 source
+bot.renderer -> user: diagram`
+)
+
+// handle theme command
+func handleThemeCommand(ctx context.Context, b *tg.Bot, conf config, update tg.Update, args string) {
+	if message, _ := update.GetMessage(); message != nil {
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		// list themes if no id is given
+		args = strings.TrimSpace(args)
+		if args == "" {
+			replyText(b, chatID, messageID, messageThemesHeader+themesList())
+			return
+		}
+
+		if message.ReplyToMessage == nil {
+			replyError(b, chatID, messageID, messageThemeUsage)
+			return
+		}
+
+		themeID, err := parseThemeID(args)
+		if err != nil {
+			replyError(b, chatID, messageID, err.Error())
+			return
+		}
+
+		opts := renderOptionsForChat(conf, message.From.ID, chatID)
+		opts.ThemeID = themeID
+
+		if sources, err := sourcesOfRepliedMessage(b, message.ReplyToMessage); err == nil {
+			replyRenderedWithOptions(ctx, b, conf, message.From.ID, chatID, messageID, sources, opts, "")
+		} else {
+			replyError(b, chatID, messageID, err.Error())
+		}
+	}
+}
+
+// returns a list of available themes
+func themesList() string {
+	lines := []string{}
+	for _, theme := range allThemes() {
+		lines = append(lines, fmt.Sprintf("• %d: %s", theme.ID, theme.Name))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// returns all available themes (light ones first)
+func allThemes() (themes []d2themes.Theme) {
+	for _, catalog := range [][]d2themes.Theme{d2themescatalog.LightCatalog, d2themescatalog.DarkCatalog} {
+		themes = append(themes, catalog...)
+	}
+	return themes
+}
+
+// returns callback data for given theme action and id (eg. `theme:preview:3`)
+func themeCallbackData(action string, themeID int64) string {
+	return fmt.Sprintf("%s%s:%d", callbackPrefixTheme, action, themeID)
+}
+
+// returns an inline keyboard for picking a theme,
+// with a button for setting `previewed` (if not nil) as the default theme on top
+func themePickerKeyboard(previewed *d2themes.Theme) tg.InlineKeyboardMarkup {
+	keyboard := [][]tg.InlineKeyboardButton{}
+	if previewed != nil {
+		keyboard = append(keyboard, []tg.InlineKeyboardButton{
+			{
+				Text:         fmt.Sprintf(messageThemeSetAsDefault, previewed.Name),
+				CallbackData: toPointer(themeCallbackData(themeActionDefault, previewed.ID)),
+			},
+		})
+	}
+
+	row := []tg.InlineKeyboardButton{}
+	for _, theme := range allThemes() {
+		row = append(row, tg.InlineKeyboardButton{
+			Text:         theme.Name,
+			CallbackData: toPointer(themeCallbackData(themeActionPreview, theme.ID)),
+		})
+		if len(row) == themePickerColumns {
+			keyboard = append(keyboard, row)
+			row = []tg.InlineKeyboardButton{}
+		}
+	}
+	if len(row) > 0 {
+		keyboard = append(keyboard, row)
+	}
+
+	return tg.NewInlineKeyboardMarkup(keyboard)
+}
+
+// sends a theme picker as a reply to `messageID`
+func replyThemePicker(ctx context.Context, b *tg.Bot, chatID, messageID int64) {
+	if sent := b.SendMessage(
+		chatID,
+		messageThemePicker,
+		tg.OptionsSendMessage{}.
+			SetReplyParameters(tg.NewReplyParameters(messageID)).
+			SetReplyMarkup(themePickerKeyboard(nil))); !sent.Ok {
+		logf(ctx, "failed to send theme picker: %s", *sent.Description)
+	}
+}
+
+// handles a callback query on the theme picker with `data` (without the prefix)
+func handleThemeCallback(ctx context.Context, b *tg.Bot, conf config, query tg.CallbackQuery, data string) {
+	action, idStr, _ := strings.Cut(data, ":")
+	themeID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil || query.Message == nil {
+		answerCallbackQuery(b, query, nil)
+		return
+	}
+	theme := d2themescatalog.Find(themeID)
+	if theme.Name == "" {
+		answerCallbackQuery(b, query, nil)
+		return
+	}
+
+	userID := query.From.ID
+	chatID := query.Message.Chat.ID
+	messageID := query.Message.MessageID
+
+	switch action {
+	case themeActionPreview:
+		answerCallbackQuery(b, query, nil)
+
+		// offer setting the previewed theme as default
+		if edited := b.EditMessageText(
+			fmt.Sprintf(messageThemePreviewing, theme.Name),
+			tg.OptionsEditMessageText{}.
+				SetIDs(chatID, messageID).
+				SetReplyMarkup(themePickerKeyboard(&theme))); !edited.Ok {
+			logf(ctx, "failed to update theme picker: %s", *edited.Description)
+		}
+
+		source := themeSampleSource
+		if entries := storage.recentHistory(userID, 1); len(entries) > 0 {
+			source = entries[0].Source
+		}
+
+		opts := renderOptionsForUser(conf, userID)
+		opts.ThemeID = theme.ID
+
+		replyRenderedWithOptions(ctx, b, conf, userID, chatID, messageID, []string{source}, opts, "")
+	case themeActionDefault:
+		if err := storage.updateSettings(userID, func(settings *userSettings) {
+			settings.ThemeID = toPointer(theme.ID)
+		}); err == nil {
+			answerCallbackQuery(b, query, toPointer(fmt.Sprintf(messageThemeDefaultSaved, theme.Name)))
+
+			if edited := b.EditMessageText(
+				fmt.Sprintf(messageThemeDefaultSaved, theme.Name),
+				tg.OptionsEditMessageText{}.
+					SetIDs(chatID, messageID).
+					SetReplyMarkup(themePickerKeyboard(nil))); !edited.Ok {
+				logf(ctx, "failed to update theme picker: %s", *edited.Description)
+			}
+		} else {
+			logf(ctx, "failed to save settings: %s", err)
+
+			answerCallbackQuery(b, query, toPointer(messageThemeSettingFailed))
+		}
+	default:
+		answerCallbackQuery(b, query, nil)
+	}
+}
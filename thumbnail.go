@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+)
+
+// constants for thumbnails
+const (
+	maxThumbnailSide  = 320 // max width and height of a thumbnail of documents
+	thumbnailQuality  = 80
+	thumbnailFilename = "thumbnail.jpg"
+)
+
+// returns a .jpg thumbnail of given .png file, scaled down to fit in 320 x 320 pixels
+// (transparent pixels are filled with white, as .jpg files have no alpha channel)
+func pngThumbnail(bs []byte) ([]byte, error) {
+	src, err := png.Decode(bytes.NewReader(bs))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := src.Bounds()
+	ratio := min(1.0, float64(maxThumbnailSide)/float64(max(bounds.Dx(), bounds.Dy())))
+	width, height := max(1, int(float64(bounds.Dx())*ratio)), max(1, int(float64(bounds.Dy())*ratio))
+
+	// NOTE: average source pixels in each box of a thumbnail pixel
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := max(bounds.Min.Y+(y+1)*bounds.Dy()/height, y0+1)
+
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := max(bounds.Min.X+(x+1)*bounds.Dx()/width, x0+1)
+
+			var r, g, b, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					// (premultiplied by alpha, so add the rest of alpha for blending with white)
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += uint64(cr + 0xffff - ca)
+					g += uint64(cg + 0xffff - ca)
+					b += uint64(cb + 0xffff - ca)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: 0xffff})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
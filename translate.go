@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+
+	// d2
+	"oss.terrastruct.com/d2/d2format"
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2oracle"
+)
+
+// constants for translations
+const (
+	commandTranslate = "/translate"
+
+	translationProviderLibreTranslate = "libretranslate" // LibreTranslate-compatible api
+	translationProviderOpenAI         = "openai"         // OpenAI-compatible chat completions api
+
+	defaultOpenAIURL   = "https://api.openai.com/v1"
+	defaultOpenAIModel = "gpt-4o-mini"
+
+	translationTimeout  = 60 * time.Second
+	maxTranslatedLabels = 200
+
+	messageTranslateUsage         = "Usage: reply to a rendered diagram (or a D2 message) with /translate <language> (eg. 'ko', 'Japanese'), or /translate <language> <name> for a saved diagram.\n\n(Your last source is used when not replying.)"
+	messageTranslationUnavailable = "Translation is not configured in this bot."
+	messageNoLabelsToTranslate    = "There are no labels to translate in the diagram."
+	messageTooManyLabels          = "Too many labels to translate (%d > %d)."
+	messageTranslatedLabels       = "Translated %d label(s) into '%s' (reply to the render with /source for the translated source)."
+	messageNothingTranslated      = "No labels were translated into '%s' (they may be in the language already)."
+
+	translationPrompt = `Translate each string of the JSON array given by the user into the language '%s'.
+Keep code, identifiers, urls, numbers, and markup as they are.
+Reply with a JSON array of the translated strings only, in the same order and of the same length.`
+)
+
+// settings of the translation backend
+type translationConfig struct {
+	Provider string `json:"provider"`          // `libretranslate` or `openai`
+	URL      string `json:"url,omitempty"`     // base url of the api (= `https://api.openai.com/v1` for `openai`)
+	APIKey   string `json:"api_key,omitempty"` // (optional for self-hosted LibreTranslate servers)
+	Model    string `json:"model,omitempty"`   // model of `openai` (= `gpt-4o-mini` for default)
+}
+
+// http client for translation backends
+var translationClient = &http.Client{Timeout: translationTimeout}
+
+// a label to translate
+type translatedLabel struct {
+	key  string // key of the shape or connection (eg. `a.b`, or `(a -> b)[0]`)
+	text string
+}
+
+// handle translate command
+func handleTranslateCommand(ctx context.Context, b *tg.Bot, conf config, update tg.Update, args string) {
+	if message, _ := update.GetMessage(); message != nil {
+		userID := message.From.ID
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		if conf.Translation == nil {
+			replyError(b, chatID, messageID, messageTranslationUnavailable)
+			return
+		}
+
+		language, name, _ := strings.Cut(strings.TrimSpace(args), " ")
+		if language == "" {
+			replyError(b, chatID, messageID, messageTranslateUsage)
+			return
+		}
+
+		// source from the saved diagram, the replied message, or the last one
+		var source string
+		if name = strings.TrimSpace(name); name != "" {
+			entry, exists := storage.loadFromLibrary(userID, name)
+			if !exists {
+				replyError(b, chatID, messageID, fmt.Sprintf(messageNoSuchDiagram, name))
+				return
+			}
+			source = entry.Source
+		} else if message.ReplyToMessage != nil {
+			sources, err := sourcesOfRepliedMessage(b, message.ReplyToMessage)
+			if err != nil {
+				replyError(b, chatID, messageID, err.Error())
+				return
+			}
+			if len(sources) > 1 {
+				replyError(b, chatID, messageID, messageMultipleSources)
+				return
+			}
+			source = sources[0]
+		} else if entries := storage.recentHistory(userID, 1); len(entries) > 0 {
+			source = entries[0].Source
+		} else {
+			replyError(b, chatID, messageID, messageTranslateUsage)
+			return
+		}
+
+		_ = b.SendChatAction(chatID, tg.ChatActionTyping, nil)
+
+		translated, count, err := translateSource(ctx, *conf.Translation, source, language)
+		if err != nil {
+			logf(ctx, "failed to translate labels: %s", err)
+
+			replyError(b, chatID, messageID, fmt.Sprintf("Failed to translate labels: %s", err))
+			return
+		}
+
+		if count == 0 {
+			replyError(b, chatID, messageID, fmt.Sprintf(messageNothingTranslated, language))
+			return
+		}
+
+		replyText(b, chatID, messageID, fmt.Sprintf(messageTranslatedLabels, count, language))
+		replyRendered(ctx, b, conf, userID, chatID, messageID, []string{translated})
+	}
+}
+
+// translates labels of shapes and connections in given d2 source into `language`, keeping their keys and the structure of the source
+//
+// (labels of the root board only; code blocks and LaTeX blocks are not translated)
+func translateSource(ctx context.Context, conf translationConfig, source, language string) (translated string, count int, err error) {
+	var graph *d2graph.Graph
+	if graph, err = compileGraph(source); err != nil {
+		return "", 0, err
+	}
+
+	labels := labelsToTranslate(graph)
+	if len(labels) == 0 {
+		return "", 0, errors.New(messageNoLabelsToTranslate)
+	}
+	if len(labels) > maxTranslatedLabels {
+		return "", 0, fmt.Errorf(messageTooManyLabels, len(labels), maxTranslatedLabels)
+	}
+
+	// translate each distinct text once
+	texts := []string{}
+	indices := map[string]int{}
+	for _, label := range labels {
+		if _, exists := indices[label.text]; !exists {
+			indices[label.text] = len(texts)
+			texts = append(texts, label.text)
+		}
+	}
+	var results []string
+	if results, err = translateTexts(ctx, conf, texts, language); err != nil {
+		return "", 0, err
+	}
+
+	// NOTE: labels are set with d2oracle, so that keys (and references to them) stay the same
+	for _, label := range labels {
+		text := results[indices[label.text]]
+		if text == "" || text == label.text {
+			continue
+		}
+		if updated, err := d2oracle.Set(graph, nil, label.key+".label", nil, &text); err == nil {
+			graph = updated
+			count++
+		} else {
+			logf(ctx, "failed to set translated label of '%s': %s", label.key, err)
+		}
+	}
+	return d2format.Format(graph.AST), count, nil
+}
+
+// returns labels of shapes and connections (in the root board) to translate
+func labelsToTranslate(graph *d2graph.Graph) (labels []translatedLabel) {
+	for _, obj := range graph.Objects {
+		if obj.Class != nil || obj.SQLTable != nil || obj.Language != "" { // NOTE: code, markdown, and LaTeX blocks
+			continue
+		}
+		if text := strings.TrimSpace(obj.Label.Value); text != "" {
+			labels = append(labels, translatedLabel{key: obj.AbsID(), text: obj.Label.Value})
+		}
+	}
+	for _, edge := range graph.Edges {
+		if text := strings.TrimSpace(edge.Label.Value); text != "" {
+			labels = append(labels, translatedLabel{key: edge.AbsID(), text: edge.Label.Value})
+		}
+	}
+	return labels
+}
+
+// translates given texts into `language` with the configured backend
+func translateTexts(ctx context.Context, conf translationConfig, texts []string, language string) (translated []string, err error) {
+	switch conf.Provider {
+	case translationProviderLibreTranslate:
+		translated, err = translateWithLibreTranslate(ctx, conf, texts, language)
+	case translationProviderOpenAI:
+		translated, err = translateWithOpenAI(ctx, conf, texts, language)
+	default:
+		return nil, fmt.Errorf("not a supported translation provider: '%s'", conf.Provider)
+	}
+
+	if err == nil && len(translated) != len(texts) {
+		return nil, fmt.Errorf("translation backend returned %d text(s) for %d", len(translated), len(texts))
+	}
+	return translated, err
+}
+
+// translates given texts with a LibreTranslate-compatible api
+//
+// https://libretranslate.com/docs
+func translateWithLibreTranslate(ctx context.Context, conf translationConfig, texts []string, language string) (translated []string, err error) {
+	var result struct {
+		TranslatedText []string `json:"translatedText"`
+		Error          string   `json:"error,omitempty"`
+	}
+	if err = postTranslationJSON(ctx, strings.TrimSuffix(conf.URL, "/")+"/translate", "", map[string]any{
+		"q":       texts,
+		"source":  "auto",
+		"target":  language,
+		"format":  "text",
+		"api_key": conf.APIKey,
+	}, &result); err != nil {
+		return nil, err
+	}
+	if result.Error != "" {
+		return nil, errors.New(result.Error)
+	}
+	return result.TranslatedText, nil
+}
+
+// translates given texts with an OpenAI-compatible chat completions api
+//
+// https://platform.openai.com/docs/api-reference/chat
+func translateWithOpenAI(ctx context.Context, conf translationConfig, texts []string, language string) (translated []string, err error) {
+	baseURL, model := conf.URL, conf.Model
+	if baseURL == "" {
+		baseURL = defaultOpenAIURL
+	}
+	if model == "" {
+		model = defaultOpenAIModel
+	}
+
+	var bs []byte
+	if bs, err = json.Marshal(texts); err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+		Error *struct {
+			Message string `json:"message"`
+		} `json:"error,omitempty"`
+	}
+	if err = postTranslationJSON(ctx, strings.TrimSuffix(baseURL, "/")+"/chat/completions", conf.APIKey, map[string]any{
+		"model": model,
+		"messages": []map[string]string{
+			{"role": "system", "content": fmt.Sprintf(translationPrompt, language)},
+			{"role": "user", "content": string(bs)},
+		},
+		"temperature": 0,
+	}, &result); err != nil {
+		return nil, err
+	}
+	if result.Error != nil {
+		return nil, errors.New(result.Error.Message)
+	}
+	if len(result.Choices) == 0 {
+		return nil, errors.New("no translation in the response")
+	}
+
+	// NOTE: models often wrap their replies in code blocks
+	content := strings.TrimSpace(result.Choices[0].Message.Content)
+	content = strings.TrimPrefix(strings.TrimPrefix(content, "```json"), "```")
+	content = strings.TrimSuffix(content, "```")
+	if err = json.Unmarshal([]byte(content), &translated); err != nil {
+		return nil, fmt.Errorf("failed to parse translated texts: %w", err)
+	}
+	return translated, nil
+}
+
+// posts `body` as JSON to `url` (with a bearer `token`, if any), and decodes its response into `result`
+func postTranslationJSON(ctx context.Context, url, token string, body any, result any) (err error) {
+	var bs []byte
+	if bs, err = json.Marshal(body); err != nil {
+		return err
+	}
+
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(bs)); err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	var res *http.Response
+	if res, err = translationClient.Do(req); err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if err = json.NewDecoder(res.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to decode translation response (%s): %w", res.Status, err)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// constants for the tutorial
+const (
+	commandTutorial = "/tutorial"
+
+	sessionKindTutorial = "tutorial"
+
+	messageTutorialStart    = "Let's build a diagram step by step! Reply with D2 snippets, and I'll render the result so far.\n(Send /cancel to quit anytime.)"
+	messageTutorialStep     = "Step %d/%d: %s"
+	messageTutorialHint     = "Not quite. Hint: %s"
+	messageTutorialError    = "That snippet does not compile: %s\n\nHint: %s"
+	messageTutorialFinished = "🎉 You finished the tutorial! Here is your final source, try /save <name> with it:"
+)
+
+// a step of the tutorial
+type tutorialStep struct {
+	Prompt string
+	Hint   string
+	Check  func(snippet string) bool
+}
+
+// steps of the tutorial, in order
+var tutorialSteps = []tutorialStep{
+	{
+		Prompt: "Declare a shape by sending its name, eg. `server`",
+		Hint:   "send just a name like `server` in a line.",
+		Check: func(snippet string) bool {
+			return strings.TrimSpace(snippet) != ""
+		},
+	},
+	{
+		Prompt: "Connect it to another shape with an arrow, eg. `server -> db`",
+		Hint:   "use `->` between two names, like `server -> db`.",
+		Check: func(snippet string) bool {
+			return strings.Contains(snippet, "->") || strings.Contains(snippet, "<-") || strings.Contains(snippet, "--")
+		},
+	},
+	{
+		Prompt: "Label a shape or a connection, eg. `db: Database` or `server -> db: queries`",
+		Hint:   "put a label after a colon, like `db: Database`.",
+		Check: func(snippet string) bool {
+			return strings.Contains(snippet, ":")
+		},
+	},
+	{
+		Prompt: "Change the shape of something, eg. `db.shape: cylinder`",
+		Hint:   "set `.shape` of a shape, like `db.shape: cylinder` (or `person`, `cloud`, `queue`, ...).",
+		Check: func(snippet string) bool {
+			return strings.Contains(snippet, "shape:")
+		},
+	},
+	{
+		Prompt: "Group shapes in a container, eg. `backend: { server; db }`",
+		Hint:   "wrap shapes with a block, like `backend: { server; db }`.",
+		Check: func(snippet string) bool {
+			return strings.Contains(snippet, "{") && strings.Contains(snippet, "}")
+		},
+	},
+}
+
+// handle tutorial command
+func handleTutorialCommand(ctx context.Context, b *tg.Bot, conf config, update tg.Update) {
+	if message, _ := update.GetMessage(); message != nil {
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		sessions.start(chatID, message.From.ID, sessionKindTutorial)
+
+		replyText(b, chatID, messageID, messageTutorialStart+"\n\n"+tutorialPrompt(0))
+	}
+}
+
+// returns the prompt of the tutorial step at `index`
+func tutorialPrompt(index int) string {
+	return fmt.Sprintf(messageTutorialStep, index+1, len(tutorialSteps), tutorialSteps[index].Prompt)
+}
+
+// handles a message in a tutorial session
+func handleTutorialMessage(ctx context.Context, b *tg.Bot, conf config, s session, message tg.Message) *session {
+	chatID := message.Chat.ID
+	messageID := message.MessageID
+
+	if !message.HasText() || s.Step >= len(tutorialSteps) {
+		return &s
+	}
+	snippet := *message.Text
+	step := tutorialSteps[s.Step]
+
+	if !step.Check(snippet) {
+		replyText(b, chatID, messageID, fmt.Sprintf(messageTutorialHint, step.Hint))
+		return &s
+	}
+
+	source := strings.TrimSpace(s.Source + "\n" + snippet)
+	if err := validateSource(source); err != nil {
+		replyError(b, chatID, messageID, fmt.Sprintf(messageTutorialError, explainError(source, err), step.Hint))
+		return &s
+	}
+	s.Source = source
+	s.Step++
+
+	replyRendered(ctx, b, conf, message.From.ID, chatID, messageID, []string{source})
+
+	if s.Step >= len(tutorialSteps) {
+		replyText(b, chatID, messageID, messageTutorialFinished)
+		replySource(b, chatID, messageID, source)
+		return nil
+	}
+
+	replyText(b, chatID, messageID, tutorialPrompt(s.Step))
+	return &s
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// constants for processed updates
+const (
+	processedUpdatesFilename = "updates.json"
+
+	maxUpdateIDs = 1000 // number of processed update ids to remember (for deduplication after restarts)
+)
+
+// ids of recently processed updates (for deduplication after restarts), saved in a small file of their own
+//
+// (so that the whole store is not rewritten on every poll)
+type processedUpdates struct {
+	sync.Mutex
+
+	filepath string
+
+	ids  []int64            // oldest first
+	seen map[int64]struct{} // for looking up `ids`
+}
+
+// processed updates of this bot (initialized on start)
+var updateIDs *processedUpdates
+
+// opens (or creates) the file of processed updates at given `path`
+func openProcessedUpdates(path string) (p *processedUpdates, err error) {
+	p = &processedUpdates{
+		filepath: path,
+		seen:     map[int64]struct{}{},
+	}
+
+	var bytes []byte
+	if bytes, err = os.ReadFile(path); err == nil {
+		if err = json.Unmarshal(bytes, &p.ids); err != nil {
+			return nil, fmt.Errorf("failed to read processed updates file '%s': %w", path, err)
+		}
+		for _, id := range p.ids {
+			p.seen[id] = struct{}{}
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to open processed updates file '%s': %w", path, err)
+	}
+
+	return p, nil
+}
+
+// marks given `updates` as processed (keeping only the latest `maxUpdateIDs` ones),
+// and returns the ones which were not processed yet
+func (p *processedUpdates) mark(updates []tg.Update) (unprocessed []tg.Update, err error) {
+	p.Lock()
+	defer p.Unlock()
+
+	for _, update := range updates {
+		if _, exists := p.seen[update.UpdateID]; !exists {
+			unprocessed = append(unprocessed, update)
+			p.ids = append(p.ids, update.UpdateID)
+			p.seen[update.UpdateID] = struct{}{}
+		}
+	}
+	if len(unprocessed) == 0 {
+		return nil, nil
+	}
+	if len(p.ids) > maxUpdateIDs {
+		for _, id := range p.ids[:len(p.ids)-maxUpdateIDs] {
+			delete(p.seen, id)
+		}
+		p.ids = p.ids[len(p.ids)-maxUpdateIDs:]
+	}
+
+	return unprocessed, p.persist()
+}
+
+// saves processed updates to the file (should be called while locked)
+func (p *processedUpdates) persist() (err error) {
+	var bytes []byte
+	if bytes, err = json.Marshal(p.ids); err == nil {
+		// write to a temporary file first, then replace the original one
+		tmp := p.filepath + ".tmp"
+		if err = os.WriteFile(tmp, bytes, 0600); err == nil {
+			err = os.Rename(tmp, p.filepath)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save processed updates file '%s': %w", p.filepath, err)
+	}
+	return nil
+}
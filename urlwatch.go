@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// constants for watching remote urls
+const (
+	commandWatch   = "/watch"
+	commandUnwatch = "/unwatch"
+
+	defaultURLWatchInterval     = 10 * time.Minute
+	minURLWatchInterval         = 1 * time.Minute
+	maxURLWatchInterval         = 24 * time.Hour
+	defaultMaxURLWatchesPerChat = 5
+
+	urlWatchCheckInterval = 30 * time.Second // interval of checking which watches are due
+	urlWatchTimeout       = 10 * time.Second
+
+	messageWatchUsage     = "Usage: /watch <url> [interval] (eg. '/watch https://example.com/diagram.d2 30m') to post re-renders of a remote .d2 file to this chat whenever it changes (checked every 10 minutes for default), and /unwatch <url> to stop."
+	messageWatchStarted   = "Watching %s (every %s)."
+	messageWatchList      = "Watched urls in this chat:\n\n"
+	messageWatchNone      = "No url is watched in this chat."
+	messageWatchLimit     = "Too many watched urls in this chat (max: %d): /unwatch some of them first."
+	messageWatchExists    = "%s is already watched in this chat."
+	messageWatchStopped   = "Stopped watching %s."
+	messageWatchNotFound  = "%s is not watched in this chat."
+	messageWatchCaption   = "🔄 %s"
+	messageWatchFailed    = "⚠️ Failed to render %s:\n\n%s"
+	messageWatchNotPublic = "only public addresses can be watched"
+)
+
+// a remote .d2 file watched in a chat
+type urlWatch struct {
+	URL       string        `json:"url"`
+	Interval  time.Duration `json:"interval"`
+	Hash      string        `json:"hash,omitempty"` // sha256 hash of the last fetched content
+	WatchedBy int64         `json:"watched_by"`     // id of the user who started watching
+	WatchedAt time.Time     `json:"watched_at"`
+}
+
+// times when watched urls were checked last, keyed by chat ids and urls
+var urlWatchChecks = struct {
+	sync.Mutex
+
+	checkedAt map[string]time.Time
+}{
+	checkedAt: map[string]time.Time{},
+}
+
+// http client for fetching watched urls, which does not connect to non-public addresses
+var urlWatchClient = &http.Client{
+	Timeout: urlWatchTimeout,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: urlWatchTimeout,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || !ip.IsGlobalUnicast() || ip.IsPrivate() {
+					return fmt.Errorf("%s", messageWatchNotPublic)
+				}
+				return nil
+			},
+		}).DialContext,
+	},
+}
+
+// handle watch command
+func handleWatchCommand(ctx context.Context, b *tg.Bot, conf config, update tg.Update, args string) {
+	if message, _ := update.GetMessage(); message != nil {
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		params := strings.Fields(args)
+		if len(params) == 0 {
+			replyURLWatches(b, chatID, messageID)
+			return
+		}
+
+		rawURL := params[0]
+		if parsed, err := url.Parse(rawURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			replyError(b, chatID, messageID, messageWatchUsage)
+			return
+		}
+
+		interval := defaultURLWatchInterval
+		if len(params) > 1 {
+			var err error
+			if interval, err = parseURLWatchInterval(params[1]); err != nil {
+				replyError(b, chatID, messageID, err.Error())
+				return
+			}
+		}
+
+		maxWatches := conf.MaxURLWatchesPerChat
+		if maxWatches <= 0 {
+			maxWatches = defaultMaxURLWatchesPerChat
+		}
+		if watches := storage.urlWatches(chatID); len(watches) >= maxWatches {
+			replyError(b, chatID, messageID, fmt.Sprintf(messageWatchLimit, maxWatches))
+			return
+		}
+
+		// fetch it first, for checking if it is reachable
+		content, err := fetchWatchedURL(ctx, rawURL)
+		if err != nil {
+			replyError(b, chatID, messageID, fmt.Sprintf("Failed to fetch %s: %s", rawURL, err))
+			return
+		}
+
+		w := urlWatch{
+			URL:       rawURL,
+			Interval:  interval,
+			WatchedBy: message.From.ID,
+			WatchedAt: time.Now(),
+		}
+		if added, err := storage.addURLWatch(chatID, w); err != nil {
+			logf(ctx, "failed to save watch: %s", err)
+
+			replyError(b, chatID, messageID, fmt.Sprintf("Failed to watch %s: %s", rawURL, err))
+			return
+		} else if !added {
+			replyError(b, chatID, messageID, fmt.Sprintf(messageWatchExists, rawURL))
+			return
+		}
+		markURLWatchChecked(chatID, rawURL)
+
+		replyText(b, chatID, messageID, fmt.Sprintf(messageWatchStarted, rawURL, interval))
+
+		postURLWatch(ctx, b, conf, chatID, w, content)
+	}
+}
+
+// handle unwatch command
+func handleUnwatchCommand(ctx context.Context, b *tg.Bot, update tg.Update, args string) {
+	if message, _ := update.GetMessage(); message != nil {
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		rawURL := strings.TrimSpace(args)
+		if rawURL == "" {
+			replyURLWatches(b, chatID, messageID)
+			return
+		}
+
+		if removed, err := storage.removeURLWatch(chatID, rawURL); err != nil {
+			logf(ctx, "failed to remove watch: %s", err)
+
+			replyError(b, chatID, messageID, fmt.Sprintf("Failed to unwatch %s: %s", rawURL, err))
+		} else if removed {
+			replyText(b, chatID, messageID, fmt.Sprintf(messageWatchStopped, rawURL))
+		} else {
+			replyError(b, chatID, messageID, fmt.Sprintf(messageWatchNotFound, rawURL))
+		}
+	}
+}
+
+// replies to `messageID` with watched urls in `chatID`
+func replyURLWatches(b *tg.Bot, chatID, messageID int64) {
+	watches := storage.urlWatches(chatID)
+	if len(watches) == 0 {
+		replyText(b, chatID, messageID, messageWatchNone+"\n\n"+messageWatchUsage)
+		return
+	}
+
+	lines := []string{}
+	for _, w := range watches {
+		lines = append(lines, fmt.Sprintf("• %s (every %s)", w.URL, w.Interval))
+	}
+	replyText(b, chatID, messageID, messageWatchList+strings.Join(lines, "\n"))
+}
+
+// parses given interval (eg. `30m`, or `30` in minutes) of watching urls
+func parseURLWatchInterval(str string) (interval time.Duration, err error) {
+	if minutes, e := strconv.Atoi(str); e == nil {
+		interval = time.Duration(minutes) * time.Minute
+	} else if interval, err = time.ParseDuration(str); err != nil {
+		return 0, fmt.Errorf("not a valid interval: '%s' (eg. 30m, or 1h)", str)
+	}
+
+	if interval < minURLWatchInterval || interval > maxURLWatchInterval {
+		return 0, fmt.Errorf("not a valid interval: '%s' (should be between %s and %s)", str, minURLWatchInterval, maxURLWatchInterval)
+	}
+	return interval, nil
+}
+
+// fetches the content of a watched url (only from public addresses, up to 1MB)
+func fetchWatchedURL(ctx context.Context, rawURL string) (content []byte, err error) {
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil); err != nil {
+		return nil, err
+	}
+
+	var res *http.Response
+	if res, err = urlWatchClient.Do(req); err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server responded with %d", res.StatusCode)
+	}
+
+	// NOTE: read one more byte for checking if it exceeds the limit
+	if content, err = io.ReadAll(io.LimitReader(res.Body, maxImportedSourceSize+1)); err != nil {
+		return nil, err
+	}
+	if len(content) > maxImportedSourceSize {
+		return nil, fmt.Errorf("file is too large (over %d KB)", maxImportedSourceSize/1024)
+	}
+	return decompressIfGzipped(content)
+}
+
+// returns the key of a watched url in a chat
+func urlWatchKey(chatID int64, rawURL string) string {
+	return fmt.Sprintf("%d:%s", chatID, rawURL)
+}
+
+// marks the watched url in `chatID` as checked now
+func markURLWatchChecked(chatID int64, rawURL string) {
+	urlWatchChecks.Lock()
+	defer urlWatchChecks.Unlock()
+
+	urlWatchChecks.checkedAt[urlWatchKey(chatID, rawURL)] = time.Now()
+}
+
+// checks if the watched url in `chatID` is due for checking
+func isURLWatchDue(chatID int64, w urlWatch) bool {
+	urlWatchChecks.Lock()
+	defer urlWatchChecks.Unlock()
+
+	return time.Since(urlWatchChecks.checkedAt[urlWatchKey(chatID, w.URL)]) >= w.Interval
+}
+
+// polls watched urls until `ctx` is canceled, and posts re-renders of them to their chats when their contents are changed
+func pollURLWatches(ctx context.Context, bot *tg.Bot, conf config) {
+	ticker := time.NewTicker(urlWatchCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for chatID, watches := range storage.allURLWatches() {
+				for _, w := range watches {
+					if !isURLWatchDue(chatID, w) {
+						continue
+					}
+					markURLWatchChecked(chatID, w.URL)
+
+					if content, err := fetchWatchedURL(ctx, w.URL); err == nil {
+						postURLWatch(ctx, bot, conf, chatID, w, content)
+					} else {
+						logf(ctx, "failed to fetch watched url %s: %s", w.URL, err)
+					}
+				}
+			}
+		}
+	}
+}
+
+// renders the fetched `content` of watched url and posts it to `chatID`, only if it was changed since the last fetch
+func postURLWatch(ctx context.Context, bot *tg.Bot, conf config, chatID int64, w urlWatch, content []byte) {
+	hash := sha256.Sum256(content)
+	hashStr := hex.EncodeToString(hash[:])
+	if hashStr == w.Hash {
+		return
+	}
+	if err := storage.updateURLWatch(chatID, w.URL, func(watch *urlWatch) {
+		watch.Hash = hashStr
+	}); err != nil {
+		logf(ctx, "failed to save watch: %s", err)
+	}
+
+	source := string(content)
+	opts := renderOptionsForChat(conf, w.WatchedBy, chatID)
+	if bs, _, err := renderDiagram(ctx, source, opts, nil); err == nil {
+		sendRenderedFile(ctx, bot, chatID, 0, bs, opts.Format, toPointer(html.EscapeString(fmt.Sprintf(messageWatchCaption, w.URL))))
+	} else {
+		logf(ctx, "failed to render watched url %s: %s", w.URL, err)
+
+		if sent := bot.SendMessage(chatID, fmt.Sprintf(messageWatchFailed, w.URL, explainError(source, err)), nil); !sent.Ok {
+			logf(ctx, "failed to send message: %s", *sent.Description)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// constants for Vault
+const (
+	defaultVaultMount = "secret"
+
+	vaultRequestTimeout    = 10 * time.Second
+	vaultTokenExpiryMargin = 30 * time.Second // re-login a bit earlier than the client token expires
+)
+
+// HashiCorp Vault settings
+type vaultConfig struct {
+	Address   string `json:"address"`             // eg. https://vault.example.com:8200
+	Namespace string `json:"namespace,omitempty"` // for Vault Enterprise namespaces
+	Mount     string `json:"mount,omitempty"`     // mount path of the KV v2 secrets engine (= secret for default)
+
+	// token auth
+	Token string `json:"token,omitempty"`
+
+	// or AppRole auth
+	RoleID   string `json:"role_id,omitempty"`
+	SecretID string `json:"secret_id,omitempty"`
+
+	BotTokenKeyPath string `json:"bot_token_key_path,omitempty"` // eg. path/to/secret#KEY
+}
+
+// a session with Vault, which logs in again with AppRole when needed
+type vaultSession struct {
+	sync.Mutex
+
+	conf   vaultConfig
+	client *http.Client
+
+	token     string
+	expiresAt time.Time // when the client token expires (zero if it does not)
+}
+
+// cached sessions with Vault (address + namespace => session)
+var vaultSessions = struct {
+	sync.Mutex
+	sessions map[string]*vaultSession
+}{
+	sessions: map[string]*vaultSession{},
+}
+
+// returns a cached session for given Vault settings (or a new one if there is none)
+func vaultSessionFor(conf vaultConfig) *vaultSession {
+	if conf.Mount == "" {
+		conf.Mount = defaultVaultMount
+	}
+	conf.Address = strings.TrimSuffix(conf.Address, "/")
+
+	vaultSessions.Lock()
+	defer vaultSessions.Unlock()
+
+	key := conf.Address + "\n" + conf.Namespace
+	if session, exists := vaultSessions.sessions[key]; exists && session.conf == conf {
+		return session
+	}
+
+	session := &vaultSession{
+		conf:   conf,
+		client: &http.Client{Timeout: vaultRequestTimeout},
+		token:  conf.Token,
+	}
+	vaultSessions.sessions[key] = session
+
+	return session
+}
+
+// error response from Vault
+type vaultError struct {
+	StatusCode int
+	Errors     []string `json:"errors"`
+}
+
+func (e *vaultError) Error() string {
+	if len(e.Errors) > 0 {
+		return fmt.Sprintf("vault responded with %d: %s", e.StatusCode, strings.Join(e.Errors, ", "))
+	}
+	return fmt.Sprintf("vault responded with %d", e.StatusCode)
+}
+
+// sends a request to Vault's http api at `apiPath`, and decodes its response into `result`
+func (s *vaultSession) request(method, apiPath string, body, result any) (err error) {
+	var reader io.Reader
+	if body != nil {
+		var bs []byte
+		if bs, err = json.Marshal(body); err != nil {
+			return err
+		}
+		reader = bytes.NewReader(bs)
+	}
+
+	var req *http.Request
+	if req, err = http.NewRequest(method, s.conf.Address+"/v1/"+apiPath, reader); err != nil {
+		return err
+	}
+	if s.token != "" {
+		req.Header.Set("X-Vault-Token", s.token)
+	}
+	if s.conf.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", s.conf.Namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	var res *http.Response
+	if res, err = s.client.Do(req); err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		verr := &vaultError{StatusCode: res.StatusCode}
+		_ = json.NewDecoder(res.Body).Decode(verr)
+		return verr
+	}
+
+	return json.NewDecoder(res.Body).Decode(result)
+}
+
+// checks if the session authenticates with AppRole
+func (s *vaultSession) usesAppRole() bool {
+	return s.conf.RoleID != ""
+}
+
+// logs in with AppRole (should be called while locked)
+func (s *vaultSession) login() error {
+	var res struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int64  `json:"lease_duration"`
+		} `json:"auth"`
+	}
+
+	s.token = ""
+	if err := s.request(http.MethodPost, "auth/approle/login", map[string]string{
+		"role_id":   s.conf.RoleID,
+		"secret_id": s.conf.SecretID,
+	}, &res); err != nil {
+		return fmt.Errorf("failed to authenticate with Vault: %s", err)
+	}
+
+	s.token = res.Auth.ClientToken
+	s.expiresAt = time.Time{}
+	if res.Auth.LeaseDuration > 0 {
+		s.expiresAt = time.Now().Add(time.Duration(res.Auth.LeaseDuration)*time.Second - vaultTokenExpiryMargin)
+	}
+
+	return nil
+}
+
+// retrieves the value of a secret at `keyPath` (eg. `path/to/secret#KEY`) from the KV v2 secrets engine,
+// logging in again and retrying once if the AppRole token was expired or revoked.
+func (s *vaultSession) retrieve(keyPath string) (value string, err error) {
+	secretPath, key, found := strings.Cut(keyPath, "#")
+	if !found || key == "" {
+		return "", fmt.Errorf("not a valid Vault key path: '%s' (should be like 'path/to/secret#KEY')", keyPath)
+	}
+	secretPath = strings.Trim(secretPath, "/")
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.usesAppRole() && (s.token == "" || (!s.expiresAt.IsZero() && time.Now().After(s.expiresAt))) {
+		if err = s.login(); err != nil {
+			return "", err
+		}
+	}
+
+	var data map[string]any
+	if data, err = s.readSecret(secretPath); err != nil && s.usesAppRole() && isVaultAuthError(err) {
+		if err = s.login(); err != nil {
+			return "", err
+		}
+		data, err = s.readSecret(secretPath)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read '%s' from Vault: %s", secretPath, err)
+	}
+
+	switch v := data[key].(type) {
+	case string:
+		return v, nil
+	case nil:
+		return "", fmt.Errorf("there is no key '%s' in Vault secret '%s'", key, secretPath)
+	default:
+		return fmt.Sprintf("%v", v), nil
+	}
+}
+
+// reads data of a secret at `secretPath` (should be called while locked)
+func (s *vaultSession) readSecret(secretPath string) (map[string]any, error) {
+	var res struct {
+		Data struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+	}
+	if err := s.request(http.MethodGet, s.conf.Mount+"/data/"+secretPath, nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Data.Data, nil
+}
+
+// checks if given error from Vault is caused by an invalid (eg. expired) token
+func isVaultAuthError(err error) bool {
+	if verr, ok := err.(*vaultError); ok {
+		return verr.StatusCode == http.StatusForbidden || verr.StatusCode == http.StatusUnauthorized
+	}
+	return false
+}
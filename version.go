@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strings"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+
+	// version string
+	"github.com/meinside/version-go"
+)
+
+// constants for versions
+const (
+	commandVersion = "/version"
+
+	modulePathD2         = "oss.terrastruct.com/d2"
+	modulePathPlaywright = "github.com/playwright-community/playwright-go"
+
+	unknownVersion = "unknown"
+
+	messageVersion = `• bot: %s
+• d2: %s
+• layout engines: %s
+• playwright: %s
+• browser: %s`
+)
+
+// returns the version of a dependency module with given `path` compiled into this binary
+func moduleVersion(path string) string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path == path {
+				if dep.Replace != nil && dep.Replace.Version != "" {
+					return dep.Replace.Version
+				}
+				return dep.Version
+			}
+		}
+	}
+	return unknownVersion
+}
+
+// handle version command
+func handleVersionCommand(b *tg.Bot, conf config, update tg.Update) {
+	if message, _ := update.GetMessage(); message != nil {
+		_ = b.SendChatAction(message.Chat.ID, tg.ChatActionTyping, nil)
+
+		replyText(b, message.Chat.ID, message.MessageID, fmt.Sprintf(messageVersion,
+			version.Minimum(),
+			moduleVersion(modulePathD2),
+			strings.Join(availableLayoutEngines(), ", "),
+			moduleVersion(modulePathPlaywright),
+			browserVersion()))
+	}
+}
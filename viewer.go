@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// constants for the web viewer
+const (
+	defaultViewerTTLMinutes = 24 * 60
+	maxViewerEntries        = 1000
+
+	viewerPathPrefix = "/v/"
+	viewerSVGSuffix  = ".svg"
+
+	messageViewerLink = "🔍 interactive view: %s"
+)
+
+// settings of the web viewer, which hosts interactive .svg files of renders
+type viewerConfig struct {
+	ListenAddr string `json:"listen_addr"`           // eg. `:8080`
+	BaseURL    string `json:"base_url"`              // public url of the viewer, eg. `https://d2.example.com`
+	Secret     string `json:"secret,omitempty"`      // secret for signing urls (random for default, so links are invalidated on restarts)
+	TTLMinutes int    `json:"ttl_minutes,omitempty"` // minutes until links expire (= 1440 for default)
+}
+
+// a render hosted on the web viewer
+type hostedRender struct {
+	svg       []byte
+	expiresAt time.Time
+}
+
+// a web server which hosts interactive .svg files of renders at short-lived signed urls
+type webViewer struct {
+	sync.Mutex
+
+	baseURL string
+	secret  []byte
+	ttl     time.Duration
+
+	renders map[string]hostedRender // id => render
+	ids     []string                // ids in the order of hosting, for evicting the oldest ones
+}
+
+// the web viewer (nil if not configured)
+var viewer *webViewer
+
+// page of the viewer, with zooming (wheel, pinch, or buttons) and panning (dragging)
+var viewerPage = template.Must(template.New("viewer").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>D2 Diagram</title>
+<style>
+html, body { margin: 0; height: 100%; overflow: hidden; background: #fff; font-family: sans-serif; }
+#stage { width: 100%; height: 100%; cursor: grab; touch-action: none; }
+#diagram { transform-origin: 0 0; display: inline-block; }
+#diagram svg { display: block; max-width: none; height: auto; }
+#controls { position: fixed; right: 12px; bottom: 12px; display: flex; gap: 6px; }
+#controls button, #controls a { padding: 6px 10px; border: 1px solid #ccc; border-radius: 6px; background: #fff; color: #333; text-decoration: none; font-size: 14px; }
+</style>
+</head>
+<body>
+<div id="stage"><div id="diagram">{{.SVG}}</div></div>
+<div id="controls">
+<button id="zoom-out">−</button><button id="zoom-reset">1:1</button><button id="zoom-in">+</button><a href="{{.SVGURL}}" download="diagram.svg">.svg</a>
+</div>
+<script nonce="{{.Nonce}}">
+(function() {
+  var stage = document.getElementById('stage'), diagram = document.getElementById('diagram');
+  var scale = 1, x = 0, y = 0, dragging = null;
+  function apply() { diagram.style.transform = 'translate(' + x + 'px,' + y + 'px) scale(' + scale + ')'; }
+  function zoom(factor, cx, cy) {
+    var next = Math.min(Math.max(scale * factor, 0.1), 20);
+    x = cx - (cx - x) * next / scale; y = cy - (cy - y) * next / scale; scale = next; apply();
+  }
+  function fit() {
+    var w = diagram.offsetWidth, h = diagram.offsetHeight;
+    scale = Math.min(1, stage.clientWidth / w, stage.clientHeight / h);
+    x = (stage.clientWidth - w * scale) / 2; y = (stage.clientHeight - h * scale) / 2; apply();
+  }
+  stage.addEventListener('wheel', function(e) { e.preventDefault(); zoom(e.deltaY < 0 ? 1.1 : 1 / 1.1, e.clientX, e.clientY); }, { passive: false });
+  stage.addEventListener('pointerdown', function(e) { if (e.target.closest('a')) return; dragging = { x: e.clientX - x, y: e.clientY - y }; stage.setPointerCapture(e.pointerId); });
+  stage.addEventListener('pointermove', function(e) { if (dragging) { x = e.clientX - dragging.x; y = e.clientY - dragging.y; apply(); } });
+  stage.addEventListener('pointerup', function() { dragging = null; });
+  document.getElementById('zoom-in').onclick = function() { zoom(1.25, stage.clientWidth / 2, stage.clientHeight / 2); };
+  document.getElementById('zoom-out').onclick = function() { zoom(1 / 1.25, stage.clientWidth / 2, stage.clientHeight / 2); };
+  document.getElementById('zoom-reset').onclick = fit;
+  fit();
+})();
+</script>
+</body>
+</html>`))
+
+// starts the web viewer with given settings in the background (returns nil if it is not configured)
+func startViewer(conf *viewerConfig) (*webViewer, error) {
+	if conf == nil || conf.ListenAddr == "" || conf.BaseURL == "" {
+		return nil, nil
+	}
+
+	secret := []byte(conf.Secret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+	}
+	ttl := time.Duration(conf.TTLMinutes) * time.Minute
+	if ttl <= 0 {
+		ttl = defaultViewerTTLMinutes * time.Minute
+	}
+
+	v := &webViewer{
+		baseURL: strings.TrimSuffix(conf.BaseURL, "/"),
+		secret:  secret,
+		ttl:     ttl,
+		renders: map[string]hostedRender{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(viewerPathPrefix, v.serve)
+	server := &http.Server{
+		Addr:              conf.ListenAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := server.ListenAndServe(); err != nil {
+			log.Printf("web viewer stopped: %s", err)
+		}
+	}()
+
+	log.Printf("web viewer listening on %s", conf.ListenAddr)
+
+	return v, nil
+}
+
+// returns the signature of `id` which expires at `expires` (unix time)
+func (v *webViewer) sign(id string, expires int64) string {
+	mac := hmac.New(sha256.New, v.secret)
+	mac.Write([]byte(fmt.Sprintf("%s:%d", id, expires)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// renders given d2 source into .svg with `opts` and hosts it, and returns the signed url of its viewer page
+//
+// (returns an empty url if the viewer is not configured)
+func (v *webViewer) host(ctx context.Context, source string, opts renderOptions) (url string, err error) {
+	if v == nil {
+		return "", nil
+	}
+
+	opts.Format = formatSVG
+	var svg []byte
+	if svg, _, err = renderDiagram(ctx, source, opts, nil); err != nil {
+		return "", err
+	}
+
+	idBytes := make([]byte, 12)
+	if _, err = rand.Read(idBytes); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(idBytes)
+	expiresAt := time.Now().Add(v.ttl)
+
+	v.Lock()
+	defer v.Unlock()
+
+	// evict expired (or the oldest) renders
+	now := time.Now()
+	for len(v.ids) > 0 {
+		oldest := v.ids[0]
+		if hosted, exists := v.renders[oldest]; exists && now.Before(hosted.expiresAt) && len(v.ids) < maxViewerEntries {
+			break
+		}
+		delete(v.renders, oldest)
+		v.ids = v.ids[1:]
+	}
+
+	v.renders[id] = hostedRender{svg: svg, expiresAt: expiresAt}
+	v.ids = append(v.ids, id)
+
+	return v.signedURL(id, "", expiresAt.Unix()), nil
+}
+
+// returns the signed url of hosted render with `id` (and `suffix`, eg. `.svg`)
+func (v *webViewer) signedURL(id, suffix string, expires int64) string {
+	return fmt.Sprintf("%s%s%s%s?exp=%d&sig=%s", v.baseURL, viewerPathPrefix, id, suffix, expires, v.sign(id, expires))
+}
+
+// serves viewer pages (`/v/<id>`) and their .svg files (`/v/<id>.svg`)
+func (v *webViewer) serve(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, viewerPathPrefix)
+	id, isSVG := strings.CutSuffix(path, viewerSVGSuffix)
+
+	// check the signature and expiration
+	expires, err := strconv.ParseInt(r.URL.Query().Get("exp"), 10, 64)
+	if err != nil || !hmac.Equal([]byte(r.URL.Query().Get("sig")), []byte(v.sign(id, expires))) {
+		http.Error(w, "invalid link", http.StatusForbidden)
+		return
+	}
+	if time.Now().Unix() > expires {
+		http.Error(w, "expired link", http.StatusGone)
+		return
+	}
+
+	v.Lock()
+	hosted, exists := v.renders[id]
+	v.Unlock()
+	if !exists {
+		http.Error(w, "expired link", http.StatusGone)
+		return
+	}
+
+	// NOTE: scripts in hosted diagrams are not allowed to run
+	nonceBytes := make([]byte, 16)
+	_, _ = rand.Read(nonceBytes)
+	nonce := hex.EncodeToString(nonceBytes)
+	w.Header().Set("Content-Security-Policy", fmt.Sprintf("default-src 'none'; style-src 'unsafe-inline'; img-src data: https:; font-src data:; script-src 'nonce-%s'", nonce))
+	w.Header().Set("Cache-Control", "private, max-age=300")
+
+	if isSVG {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		_, _ = w.Write(hosted.svg)
+		return
+	}
+
+	// NOTE: strip the xml declaration for embedding the svg in html
+	svg := hosted.svg
+	if bytes.HasPrefix(svg, []byte("<?xml")) {
+		if i := bytes.Index(svg, []byte("?>")); i >= 0 {
+			svg = svg[i+2:]
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := viewerPage.Execute(w, map[string]any{
+		"SVG":    template.HTML(svg), // NOTE: rendered by d2, not given by users as they are
+		"SVGURL": v.signedURL(id, viewerSVGSuffix, expires),
+		"Nonce":  nonce,
+	}); err != nil {
+		log.Printf("failed to serve viewer page: %s", err)
+	}
+}
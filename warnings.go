@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	// d2
+	d2log "oss.terrastruct.com/d2/lib/log"
+)
+
+// slog handler which collects warnings logged by d2 while rendering
+type warningCollector struct {
+	sync.Mutex
+
+	warnings []string
+}
+
+// returns a context which makes d2 log its warnings to a new collector
+func withWarningCollector(ctx context.Context) (context.Context, *warningCollector) {
+	collector := &warningCollector{}
+	return d2log.With(ctx, slog.New(collector)), collector
+}
+
+// Enabled implements slog.Handler (only warnings and errors are collected)
+func (c *warningCollector) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= slog.LevelWarn
+}
+
+// Handle implements slog.Handler
+func (c *warningCollector) Handle(_ context.Context, record slog.Record) error {
+	warning := record.Message
+	record.Attrs(func(attr slog.Attr) bool {
+		warning += fmt.Sprintf(" %s=%s", attr.Key, attr.Value)
+		return true
+	})
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.warnings = append(c.warnings, strings.TrimSpace(warning))
+	return nil
+}
+
+// WithAttrs implements slog.Handler
+func (c *warningCollector) WithAttrs(_ []slog.Attr) slog.Handler {
+	return c
+}
+
+// WithGroup implements slog.Handler
+func (c *warningCollector) WithGroup(_ string) slog.Handler {
+	return c
+}
+
+// returns collected warnings without duplicates
+func (c *warningCollector) collected() (warnings []string) {
+	c.Lock()
+	defer c.Unlock()
+
+	found := map[string]bool{}
+	for _, warning := range c.warnings {
+		if !found[warning] {
+			found[warning] = true
+			warnings = append(warnings, warning)
+		}
+	}
+	return warnings
+}
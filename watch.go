@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+)
+
+// constants for watching local files
+const (
+	defaultWatchInterval = 2 // seconds
+
+	messageWatchedCaption = "%s (updated at %s)"
+	messageWatchedError   = "⚠️ Failed to render %s: %s"
+)
+
+// a local .d2 file watched for changes, and the chat where its renders are posted
+type watchConfig struct {
+	Path   string `json:"path"`
+	ChatID int64  `json:"chat_id"`
+	Edit   bool   `json:"edit,omitempty"` // edit the previously posted render instead of posting a new one
+}
+
+// state of a watched file
+type watchedFile struct {
+	modTime  time.Time
+	size     int64
+	postedID int64 // id of the last posted render
+}
+
+// states of watched files (kept across reloads), keyed by their paths and chats
+var watched = struct {
+	sync.Mutex
+
+	files map[string]watchedFile
+}{
+	files: map[string]watchedFile{},
+}
+
+// returns the key of given watch
+func (w watchConfig) key() string {
+	return fmt.Sprintf("%s:%d", w.Path, w.ChatID)
+}
+
+// watches local .d2 files of `watches` in the config until `ctx` is canceled,
+// and posts (or edits) their renders in mapped chats when they are changed
+//
+// NOTE: files are polled for their modification times and sizes every `watch_interval` seconds.
+func watchFiles(ctx context.Context, bot *tg.Bot, conf config) {
+	if len(conf.Watches) == 0 {
+		return
+	}
+
+	interval := conf.WatchInterval
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+
+	// NOTE: files are not rendered until they are changed after the bot starts
+	for _, w := range conf.Watches {
+		if info, err := os.Stat(w.Path); err == nil {
+			updateWatchedFile(w, func(file *watchedFile) bool {
+				if file.modTime.IsZero() {
+					file.modTime, file.size = info.ModTime(), info.Size()
+				}
+				return false
+			})
+		} else {
+			logf(ctx, "failed to watch file: %s", err)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, w := range conf.Watches {
+				info, err := os.Stat(w.Path)
+				if err != nil {
+					continue // NOTE: editors may remove and recreate files while saving them
+				}
+
+				if updateWatchedFile(w, func(file *watchedFile) bool {
+					changed := !info.ModTime().Equal(file.modTime) || info.Size() != file.size
+					file.modTime, file.size = info.ModTime(), info.Size()
+					return changed
+				}) {
+					postWatchedFile(ctx, bot, conf, w)
+				}
+			}
+		}
+	}
+}
+
+// updates the state of watched file of `w` with `fn`, and returns what `fn` returns
+func updateWatchedFile(w watchConfig, fn func(file *watchedFile) bool) bool {
+	watched.Lock()
+	defer watched.Unlock()
+
+	file := watched.files[w.key()]
+	result := fn(&file)
+	watched.files[w.key()] = file
+
+	return result
+}
+
+// renders the watched file of `w`, and posts it to (or edits the previous one in) its chat
+func postWatchedFile(ctx context.Context, bot *tg.Bot, conf config, w watchConfig) {
+	name := filepath.Base(w.Path)
+
+	source, err := os.ReadFile(w.Path)
+	if err == nil {
+		opts := renderOptionsForChat(conf, 0, w.ChatID)
+
+		var bs []byte
+		if bs, _, err = renderDiagram(ctx, string(source), opts, nil); err == nil {
+			caption := toPointer(html.EscapeString(fmt.Sprintf(messageWatchedCaption, name, time.Now().Format(time.TimeOnly))))
+
+			var postedID int64
+			updateWatchedFile(w, func(file *watchedFile) bool {
+				postedID = file.postedID
+				return false
+			})
+
+			if w.Edit && postedID != 0 && editRenderedFile(ctx, bot, w.ChatID, postedID, bs, opts.Format, caption) {
+				return
+			}
+			if sentID, ok := sendRenderedFile(ctx, bot, w.ChatID, 0, bs, opts.Format, caption); ok {
+				updateWatchedFile(w, func(file *watchedFile) bool {
+					file.postedID = sentID
+					return false
+				})
+			}
+			return
+		}
+	}
+
+	logf(ctx, "failed to render watched file: %s", err)
+
+	if sent := bot.SendMessage(w.ChatID, fmt.Sprintf(messageWatchedError, name, err), nil); !sent.Ok {
+		logf(ctx, "failed to send message: %s", *sent.Description)
+	}
+}
@@ -0,0 +1,599 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"strings"
+
+	// telegram bot
+	tg "github.com/meinside/telegram-bot-go"
+
+	// d2
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/d2themes"
+	"oss.terrastruct.com/d2/d2themes/d2themescatalog"
+	"oss.terrastruct.com/d2/lib/textmeasure"
+)
+
+// constants for exporting diagrams to whiteboard tools
+const (
+	whiteboardExcalidraw = "excalidraw"
+	whiteboardDrawIO     = "drawio"
+
+	excalidrawFilename = "diagram.excalidraw"
+	drawIOFilename     = "diagram.drawio"
+
+	excalidrawSource         = "https://github.com/meinside/telegram-d2-bot"
+	excalidrawFontNormal     = 2 // Helvetica
+	excalidrawFontCode       = 3 // Cascadia
+	excalidrawLineHeight     = 1.25
+	excalidrawLabelIDSuffix  = ":label"
+	excalidrawArrowheadArrow = "arrow"
+
+	messageWhiteboardUsage    = "Usage: /export for your library, or reply to a rendered diagram (or a D2 message) with /export excalidraw or /export drawio.\n\n(Your last source is used when not replying.)"
+	messageWhiteboardExported = "Exported the diagram for %s (only its root board, with shapes, connections, and labels)."
+)
+
+// names of whiteboard tools
+var whiteboardNames = map[string]string{
+	whiteboardExcalidraw: "Excalidraw",
+	whiteboardDrawIO:     "draw.io",
+}
+
+// handle export command for whiteboard tools (eg. `/export excalidraw`)
+func handleWhiteboardExportCommand(ctx context.Context, b *tg.Bot, conf config, update tg.Update, tool string) {
+	if message, _ := update.GetMessage(); message != nil {
+		userID := message.From.ID
+		chatID := message.Chat.ID
+		messageID := message.MessageID
+
+		// source from the replied message, or the last one
+		var source string
+		if message.ReplyToMessage != nil {
+			sources, err := sourcesOfRepliedMessage(b, message.ReplyToMessage)
+			if err != nil {
+				replyError(b, chatID, messageID, err.Error())
+				return
+			}
+			if len(sources) > 1 {
+				replyError(b, chatID, messageID, messageMultipleSources)
+				return
+			}
+			source = sources[0]
+		} else if entries := storage.recentHistory(userID, 1); len(entries) > 0 {
+			source = entries[0].Source
+		} else {
+			replyError(b, chatID, messageID, messageWhiteboardUsage)
+			return
+		}
+
+		ctx, done := jobs.start(ctx, userID)
+		defer done()
+
+		_ = b.SendChatAction(chatID, tg.ChatActionUploadDocument, nil)
+
+		opts := renderOptionsForChat(conf, userID, chatID)
+
+		var filename string
+		var bs []byte
+		diagram, err := layoutDiagram(ctx, source, opts)
+		if err == nil {
+			switch tool {
+			case whiteboardExcalidraw:
+				filename = excalidrawFilename
+				bs, err = toExcalidraw(diagram, opts)
+			default:
+				filename = drawIOFilename
+				bs, err = toDrawIO(diagram, opts)
+			}
+		}
+		if err != nil {
+			logf(ctx, "failed to export diagram for %s: %s", tool, err)
+
+			replyError(b, chatID, messageID, fmt.Sprintf("Failed to export diagram: %s", explainError(source, err)))
+			return
+		}
+
+		if err = withNamedFile(filename, bs, func(file tg.InputFile) {
+			if sent := b.SendDocument(
+				chatID,
+				file,
+				tg.OptionsSendDocument{}.
+					SetCaption(fmt.Sprintf(messageWhiteboardExported, whiteboardNames[tool])).
+					SetReplyParameters(tg.NewReplyParameters(messageID))); !sent.Ok {
+				logf(ctx, "failed to send exported diagram: %s", *sent.Description)
+			}
+		}); err != nil {
+			logf(ctx, "failed to prepare exported diagram: %s", err)
+
+			replyError(b, chatID, messageID, fmt.Sprintf("Failed to export diagram: %s", err))
+		}
+	}
+}
+
+// lays out given d2 source with `opts`, and exports its root board into a diagram with positions of shapes and connections
+func layoutDiagram(ctx context.Context, str string, opts renderOptions) (*d2target.Diagram, error) {
+	if err := checkAccessHours(ctx); err != nil {
+		return nil, err
+	}
+	if opts.Direction == "" {
+		opts.Direction = directionDirective(str)
+	}
+	opts = applyLayoutDirective(ctx, str, opts)
+
+	return withRenderWorker(ctx, nil, func() (*d2target.Diagram, error) {
+		return withLayoutFallback(ctx, opts, func(opts renderOptions) (diagram *d2target.Diagram, err error) {
+			var graph *d2graph.Graph
+			if graph, err = compileGraph(str); err == nil {
+				var ruler *textmeasure.Ruler
+				if ruler, err = textmeasure.NewRuler(); err == nil {
+					diagram, _, err = layoutBoard(ctx, graph, ruler, opts, func(string) {})
+				}
+			}
+			return diagram, err
+		})
+	})
+}
+
+// returns a function which resolves theme colors (eg. `B1`) of diagrams rendered with `opts` into hex colors
+func themeColorResolver(opts renderOptions) func(code string) string {
+	theme := d2themescatalog.Find(opts.ThemeID)
+	if overrides := themeOverrides(opts); overrides != nil {
+		theme.ApplyOverrides(overrides)
+	}
+	return func(code string) string {
+		if code == "" {
+			return ""
+		}
+		return d2themes.ResolveThemeColor(theme, code)
+	}
+}
+
+// an element of excalidraw scenes
+//
+// https://docs.excalidraw.com/docs/codebase/json-schema
+type excalidrawElement struct {
+	ID              string             `json:"id"`
+	Type            string             `json:"type"` // `rectangle`, `ellipse`, `diamond`, `text`, or `arrow`
+	X               float64            `json:"x"`
+	Y               float64            `json:"y"`
+	Width           float64            `json:"width"`
+	Height          float64            `json:"height"`
+	Angle           int                `json:"angle"`
+	StrokeColor     string             `json:"strokeColor"`
+	BackgroundColor string             `json:"backgroundColor"`
+	FillStyle       string             `json:"fillStyle"`
+	StrokeWidth     int                `json:"strokeWidth"`
+	StrokeStyle     string             `json:"strokeStyle"`
+	Roughness       int                `json:"roughness"`
+	Opacity         int                `json:"opacity"`
+	GroupIDs        []string           `json:"groupIds"`
+	Roundness       *excalidrawRound   `json:"roundness"`
+	Seed            int                `json:"seed"`
+	Version         int                `json:"version"`
+	IsDeleted       bool               `json:"isDeleted"`
+	BoundElements   []excalidrawBound  `json:"boundElements"`
+	Link            *string            `json:"link"`
+	Locked          bool               `json:"locked"`
+	Text            string             `json:"text,omitempty"`
+	OriginalText    string             `json:"originalText,omitempty"`
+	FontSize        int                `json:"fontSize,omitempty"`
+	FontFamily      int                `json:"fontFamily,omitempty"`
+	TextAlign       string             `json:"textAlign,omitempty"`
+	VerticalAlign   string             `json:"verticalAlign,omitempty"`
+	ContainerID     *string            `json:"containerId,omitempty"`
+	LineHeight      float64            `json:"lineHeight,omitempty"`
+	Points          [][2]float64       `json:"points,omitempty"`
+	StartBinding    *excalidrawBinding `json:"startBinding,omitempty"`
+	EndBinding      *excalidrawBinding `json:"endBinding,omitempty"`
+	StartArrowhead  *string            `json:"startArrowhead"` // (null for no arrowheads)
+	EndArrowhead    *string            `json:"endArrowhead"`
+}
+
+// roundness of excalidraw elements
+type excalidrawRound struct {
+	Type int `json:"type"`
+}
+
+// an element bound to another excalidraw element (eg. its label, or a connected arrow)
+type excalidrawBound struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+}
+
+// a binding of an excalidraw arrow to a shape
+type excalidrawBinding struct {
+	ElementID string  `json:"elementId"`
+	Focus     float64 `json:"focus"`
+	Gap       float64 `json:"gap"`
+}
+
+// converts given diagram into an excalidraw scene (.excalidraw file)
+func toExcalidraw(diagram *d2target.Diagram, opts renderOptions) ([]byte, error) {
+	resolve := themeColorResolver(opts)
+
+	roughness := 0
+	if opts.Sketch {
+		roughness = 1
+	}
+
+	elements := []*excalidrawElement{}
+	shapes := map[string]*excalidrawElement{}
+	newElement := func(id, typ string, x, y, width, height float64) *excalidrawElement {
+		e := &excalidrawElement{
+			ID:              id,
+			Type:            typ,
+			X:               x,
+			Y:               y,
+			Width:           width,
+			Height:          height,
+			StrokeColor:     "transparent",
+			BackgroundColor: "transparent",
+			FillStyle:       "solid",
+			StrokeWidth:     1,
+			StrokeStyle:     "solid",
+			Roughness:       roughness,
+			Opacity:         100,
+			GroupIDs:        []string{},
+			Seed:            len(elements) + 1,
+			Version:         1,
+			BoundElements:   []excalidrawBound{},
+		}
+		elements = append(elements, e)
+		return e
+	}
+	newText := func(id, text string, x, y, width, height float64, t d2target.Text, color string) *excalidrawElement {
+		e := newElement(id, "text", x, y, width, height)
+		e.StrokeColor = color
+		e.Text, e.OriginalText = text, text
+		e.FontSize = t.FontSize
+		e.FontFamily = excalidrawFontNormal
+		if t.Language != "" {
+			e.FontFamily = excalidrawFontCode
+		}
+		e.TextAlign, e.VerticalAlign = "center", "middle"
+		e.LineHeight = excalidrawLineHeight
+		return e
+	}
+
+	for _, shape := range diagram.Shapes {
+		x, y := float64(shape.Pos.X), float64(shape.Pos.Y)
+		width, height := float64(shape.Width), float64(shape.Height)
+
+		// shapes of `text` are labels without borders
+		if shape.Type == d2target.ShapeText {
+			newText(shape.ID, shape.Label, x, y, width, height, shape.Text, resolve(shape.GetFontColor()))
+			continue
+		}
+
+		e := newElement(shape.ID, excalidrawType(shape.Type), x, y, width, height)
+		e.StrokeColor = resolve(shape.Stroke)
+		e.BackgroundColor = resolve(shape.Fill)
+		e.StrokeWidth = shape.StrokeWidth
+		if shape.StrokeDash > 0 {
+			e.StrokeStyle = "dashed"
+		}
+		e.Opacity = int(shape.Opacity * 100)
+		if shape.BorderRadius > 0 {
+			e.Roundness = &excalidrawRound{Type: 3}
+		}
+		if shape.Link != "" {
+			e.Link = toPointer(shape.Link)
+		}
+		shapes[shape.ID] = e
+
+		if shape.Label != "" {
+			label := newText(shape.ID+excalidrawLabelIDSuffix, shape.Label, x, y, width, float64(shape.LabelHeight), shape.Text, resolve(shape.GetFontColor()))
+			label.ContainerID = toPointer(shape.ID)
+			if strings.Contains(shape.LabelPosition, "TOP") { // NOTE: labels of containers
+				label.VerticalAlign = "top"
+			} else if strings.Contains(shape.LabelPosition, "BOTTOM") {
+				label.VerticalAlign = "bottom"
+			}
+			e.BoundElements = append(e.BoundElements, excalidrawBound{ID: label.ID, Type: "text"})
+		}
+	}
+
+	for _, conn := range diagram.Connections {
+		if len(conn.Route) < 2 {
+			continue
+		}
+
+		start := conn.Route[0]
+		minX, minY, maxX, maxY := start.X, start.Y, start.X, start.Y
+		points := [][2]float64{}
+		for _, point := range conn.Route {
+			points = append(points, [2]float64{point.X - start.X, point.Y - start.Y})
+			minX, minY, maxX, maxY = min(minX, point.X), min(minY, point.Y), max(maxX, point.X), max(maxY, point.Y)
+		}
+
+		e := newElement(conn.ID, "arrow", start.X, start.Y, maxX-minX, maxY-minY)
+		e.StrokeColor = resolve(conn.Stroke)
+		e.StrokeWidth = conn.StrokeWidth
+		if conn.StrokeDash > 0 {
+			e.StrokeStyle = "dashed"
+		}
+		e.Opacity = int(conn.Opacity * 100)
+		if conn.IsCurve {
+			e.Roundness = &excalidrawRound{Type: 2}
+		}
+		e.Points = points
+		if conn.SrcArrow != d2target.NoArrowhead {
+			e.StartArrowhead = toPointer(excalidrawArrowheadArrow)
+		}
+		if conn.DstArrow != d2target.NoArrowhead {
+			e.EndArrowhead = toPointer(excalidrawArrowheadArrow)
+		}
+		if src, exists := shapes[conn.Src]; exists {
+			e.StartBinding = &excalidrawBinding{ElementID: conn.Src}
+			src.BoundElements = append(src.BoundElements, excalidrawBound{ID: conn.ID, Type: "arrow"})
+		}
+		if dst, exists := shapes[conn.Dst]; exists {
+			e.EndBinding = &excalidrawBinding{ElementID: conn.Dst}
+			dst.BoundElements = append(dst.BoundElements, excalidrawBound{ID: conn.ID, Type: "arrow"})
+		}
+
+		if conn.Label != "" {
+			middle := conn.Route[len(conn.Route)/2]
+			width, height := float64(conn.LabelWidth), float64(conn.LabelHeight)
+			label := newText(conn.ID+excalidrawLabelIDSuffix, conn.Label, middle.X-width/2, middle.Y-height/2, width, height, conn.Text, resolve(conn.GetFontColor()))
+			label.ContainerID = toPointer(conn.ID)
+			e.BoundElements = append(e.BoundElements, excalidrawBound{ID: label.ID, Type: "text"})
+		}
+	}
+
+	return json.MarshalIndent(map[string]any{
+		"type":     "excalidraw",
+		"version":  2,
+		"source":   excalidrawSource,
+		"elements": elements,
+		"appState": map[string]any{
+			"viewBackgroundColor": resolve("N7"),
+		},
+		"files": map[string]any{},
+	}, "", "  ")
+}
+
+// returns the type of excalidraw elements for given type of d2 shapes
+func excalidrawType(shapeType string) string {
+	switch shapeType {
+	case d2target.ShapeOval, d2target.ShapeCircle:
+		return "ellipse"
+	case d2target.ShapeDiamond:
+		return "diamond"
+	default:
+		return "rectangle"
+	}
+}
+
+// a draw.io file
+type drawIOFile struct {
+	XMLName xml.Name      `xml:"mxfile"`
+	Host    string        `xml:"host,attr"`
+	Diagram drawIODiagram `xml:"diagram"`
+}
+
+// a page of draw.io files
+type drawIODiagram struct {
+	ID    string      `xml:"id,attr"`
+	Name  string      `xml:"name,attr"`
+	Model drawIOModel `xml:"mxGraphModel"`
+}
+
+// a graph model of draw.io pages
+type drawIOModel struct {
+	Cells []drawIOCell `xml:"root>mxCell"`
+}
+
+// a cell (a vertex, or an edge) of draw.io graphs
+type drawIOCell struct {
+	ID       string          `xml:"id,attr"`
+	Value    string          `xml:"value,attr,omitempty"`
+	Style    string          `xml:"style,attr,omitempty"`
+	Vertex   string          `xml:"vertex,attr,omitempty"`
+	Edge     string          `xml:"edge,attr,omitempty"`
+	Parent   string          `xml:"parent,attr,omitempty"`
+	Source   string          `xml:"source,attr,omitempty"`
+	Target   string          `xml:"target,attr,omitempty"`
+	Geometry *drawIOGeometry `xml:"mxGeometry"`
+}
+
+// a geometry of draw.io cells
+type drawIOGeometry struct {
+	X        float64        `xml:"x,attr,omitempty"`
+	Y        float64        `xml:"y,attr,omitempty"`
+	Width    float64        `xml:"width,attr,omitempty"`
+	Height   float64        `xml:"height,attr,omitempty"`
+	Relative string         `xml:"relative,attr,omitempty"`
+	As       string         `xml:"as,attr"`
+	Points   []drawIOPoint  `xml:"mxPoint"`
+	Waypoint *drawIOPointsA `xml:"Array"`
+}
+
+// a point of draw.io geometries
+type drawIOPoint struct {
+	X  float64 `xml:"x,attr"`
+	Y  float64 `xml:"y,attr"`
+	As string  `xml:"as,attr,omitempty"`
+}
+
+// waypoints of draw.io edges
+type drawIOPointsA struct {
+	As     string        `xml:"as,attr"`
+	Points []drawIOPoint `xml:"mxPoint"`
+}
+
+// styles of draw.io cells for types of d2 shapes
+var drawIOShapeStyles = map[string]string{
+	d2target.ShapeOval:          "ellipse;",
+	d2target.ShapeCircle:        "ellipse;aspect=fixed;",
+	d2target.ShapeDiamond:       "rhombus;",
+	d2target.ShapeHexagon:       "shape=hexagon;perimeter=hexagonPerimeter2;",
+	d2target.ShapeCylinder:      "shape=cylinder3;boundedLbl=1;",
+	d2target.ShapeQueue:         "shape=cylinder3;boundedLbl=1;direction=south;",
+	d2target.ShapeCloud:         "ellipse;shape=cloud;",
+	d2target.ShapeParallelogram: "shape=parallelogram;perimeter=parallelogramPerimeter;",
+	d2target.ShapeDocument:      "shape=document;boundedLbl=1;",
+	d2target.ShapePerson:        "shape=umlActor;verticalLabelPosition=bottom;",
+	d2target.ShapePage:          "shape=note;",
+	d2target.ShapeStep:          "shape=step;perimeter=stepPerimeter;",
+	d2target.ShapePackage:       "shape=folder;",
+	d2target.ShapeCallout:       "shape=callout;",
+	d2target.ShapeStoredData:    "shape=dataStorage;",
+	d2target.ShapeText:          "text;",
+}
+
+// converts given diagram into a draw.io file (.drawio file)
+func toDrawIO(diagram *d2target.Diagram, opts renderOptions) ([]byte, error) {
+	resolve := themeColorResolver(opts)
+
+	ids := map[string]string{} // id of d2 => id of draw.io
+	cells := []drawIOCell{{ID: "0"}, {ID: "1", Parent: "0"}}
+
+	for i, shape := range diagram.Shapes {
+		id := fmt.Sprintf("s%d", i+1)
+		ids[shape.ID] = id
+
+		style := drawIOShapeStyles[shape.Type]
+		if shape.Type == d2target.ShapeImage && shape.Icon != nil {
+			style = fmt.Sprintf("shape=image;image=%s;", shape.Icon.String())
+		}
+		if shape.Type != d2target.ShapeText {
+			style += fmt.Sprintf("fillColor=%s;strokeColor=%s;strokeWidth=%d;", resolve(shape.Fill), resolve(shape.Stroke), shape.StrokeWidth)
+			if shape.BorderRadius > 0 {
+				style += "rounded=1;"
+			}
+			if shape.StrokeDash > 0 {
+				style += "dashed=1;"
+			}
+			if shape.Shadow {
+				style += "shadow=1;"
+			}
+		}
+		if strings.Contains(shape.LabelPosition, "TOP") { // NOTE: labels of containers
+			style += "verticalAlign=top;"
+		}
+		style += drawIOTextStyle(shape.Text, resolve(shape.GetFontColor()), shape.Opacity, opts)
+
+		cells = append(cells, drawIOCell{
+			ID:     id,
+			Value:  drawIOValue(shape.Label),
+			Style:  style,
+			Vertex: "1",
+			Parent: "1",
+			Geometry: &drawIOGeometry{
+				X:      float64(shape.Pos.X),
+				Y:      float64(shape.Pos.Y),
+				Width:  float64(shape.Width),
+				Height: float64(shape.Height),
+				As:     "geometry",
+			},
+		})
+	}
+
+	for i, conn := range diagram.Connections {
+		if len(conn.Route) < 2 {
+			continue
+		}
+
+		style := fmt.Sprintf("endArrow=%s;startArrow=%s;strokeColor=%s;strokeWidth=%d;", drawIOArrow(conn.DstArrow), drawIOArrow(conn.SrcArrow), resolve(conn.Stroke), conn.StrokeWidth)
+		if conn.IsCurve {
+			style += "curved=1;"
+		}
+		if conn.StrokeDash > 0 {
+			style += "dashed=1;"
+		}
+		style += drawIOTextStyle(conn.Text, resolve(conn.GetFontColor()), conn.Opacity, opts)
+
+		first, last := conn.Route[0], conn.Route[len(conn.Route)-1]
+		geometry := &drawIOGeometry{
+			Relative: "1",
+			As:       "geometry",
+			Points: []drawIOPoint{
+				{X: first.X, Y: first.Y, As: "sourcePoint"},
+				{X: last.X, Y: last.Y, As: "targetPoint"},
+			},
+		}
+		if len(conn.Route) > 2 {
+			geometry.Waypoint = &drawIOPointsA{As: "points"}
+			for _, point := range conn.Route[1 : len(conn.Route)-1] {
+				geometry.Waypoint.Points = append(geometry.Waypoint.Points, drawIOPoint{X: point.X, Y: point.Y})
+			}
+		}
+
+		cells = append(cells, drawIOCell{
+			ID:       fmt.Sprintf("c%d", i+1),
+			Value:    drawIOValue(conn.Label),
+			Style:    style,
+			Edge:     "1",
+			Parent:   "1",
+			Source:   ids[conn.Src],
+			Target:   ids[conn.Dst],
+			Geometry: geometry,
+		})
+	}
+
+	bs, err := xml.MarshalIndent(drawIOFile{
+		Host: appDirName,
+		Diagram: drawIODiagram{
+			ID:    "d2",
+			Name:  "Page-1",
+			Model: drawIOModel{Cells: cells},
+		},
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), bs...), nil
+}
+
+// returns the style of texts (and opacity) of draw.io cells
+func drawIOTextStyle(t d2target.Text, color string, opacity float64, opts renderOptions) (style string) {
+	style = fmt.Sprintf("html=1;whiteSpace=wrap;fontSize=%d;fontColor=%s;opacity=%d;", t.FontSize, color, int(opacity*100))
+
+	fontStyle := 0
+	if t.Bold {
+		fontStyle |= 1
+	}
+	if t.Italic {
+		fontStyle |= 2
+	}
+	if t.Underline {
+		fontStyle |= 4
+	}
+	if fontStyle != 0 {
+		style += fmt.Sprintf("fontStyle=%d;", fontStyle)
+	}
+	if t.Language != "" {
+		style += "fontFamily=Courier New;align=left;"
+	}
+	if opts.Sketch {
+		style += "sketch=1;"
+	}
+	return style
+}
+
+// returns the value (html) of draw.io cells for given label
+func drawIOValue(label string) string {
+	return strings.ReplaceAll(html.EscapeString(label), "\n", "<br>")
+}
+
+// returns the arrow of draw.io edges for given arrowhead of d2 connections
+func drawIOArrow(arrowhead d2target.Arrowhead) string {
+	switch arrowhead {
+	case d2target.NoArrowhead:
+		return "none"
+	case d2target.DiamondArrowhead, d2target.FilledDiamondArrowhead:
+		return "diamond"
+	case d2target.CircleArrowhead, d2target.FilledCircleArrowhead:
+		return "oval"
+	case d2target.UnfilledTriangleArrowhead:
+		return "block;endFill=0"
+	default:
+		return "classic"
+	}
+}